@@ -0,0 +1,433 @@
+package gsmail
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+)
+
+// Address is one entry of an address header: a mailbox, or a group of them.
+//
+// A mailbox has an Address and, optionally, a display Name. An Address with
+// no Address is a group (RFC 5322 section 3.4): Name is the group's display
+// name and Group its members, which may be none -- "undisclosed-recipients:;"
+// is a group with no members at all.
+//
+// Names are UTF-8 text, never encoded-words; Encode produces the header form.
+//
+// The Email address fields are strings, and an Address converts to and from
+// them without loss: String gives the form those fields hold, and
+// ParseAddress reads it back.
+//
+//	e.To = append(e.To, gsmail.Address{Name: "Doe, John", Address: "j@example.com"}.String())
+type Address struct {
+	Name    string
+	Address string
+	Group   []Address
+}
+
+// IsGroup reports whether a is a group rather than a mailbox.
+func (a Address) IsGroup() bool {
+	return a.Address == "" && (a.Name != "" || len(a.Group) > 0)
+}
+
+// Mailboxes returns the mailbox a is, or the members of the group it is.
+func (a Address) Mailboxes() []Address {
+	if a.IsGroup() {
+		return a.Group
+	}
+	if a.Address == "" {
+		return nil
+	}
+	return []Address{a}
+}
+
+// String returns a in the form the Email address fields hold: the display
+// name as UTF-8 text, quoted where it needs to be, so that
+// `"Doe, John" <j@example.com>` stays one address. The zero Address is "".
+func (a Address) String() string {
+	if a.IsGroup() {
+		members := make([]string, 0, len(a.Group))
+		for _, m := range a.Group {
+			members = append(members, m.String())
+		}
+		return quotePhrase(a.Name) + ":" + prefixSpace(strings.Join(members, ", ")) + ";"
+	}
+	if a.Address == "" {
+		return ""
+	}
+	if a.Name == "" && strings.Contains(a.Address, "@") {
+		return addrSpec(a.Address)
+	}
+	if a.Name == "" {
+		// net/mail takes "<postmaster>" as an address; bare, it would not be.
+		return "<" + a.Address + ">"
+	}
+	return quoteString(a.Name) + " <" + addrSpec(a.Address) + ">"
+}
+
+// Encode returns a as it is written in a header field: a non-ASCII display
+// name as an RFC 2047 encoded-word and an internationalized domain in its
+// ASCII form. A non-ASCII local part has no ASCII form and stays UTF-8, for
+// delivery over SMTPUTF8.
+//
+// It returns "" for an address carrying a character that cannot appear in a
+// header field, so that untrusted input cannot inject additional headers.
+func (a Address) Encode() string {
+	if strings.ContainsFunc(a.Name, isIllegalHeaderRune) || strings.ContainsFunc(a.Address, isIllegalHeaderRune) {
+		return ""
+	}
+	if a.IsGroup() {
+		members := make([]string, 0, len(a.Group))
+		for _, m := range a.Group {
+			if enc := m.Encode(); enc != "" {
+				members = append(members, enc)
+			}
+		}
+		name := quotePhrase(a.Name)
+		if !isASCIIText(a.Name) {
+			name = mime.QEncoding.Encode("utf-8", a.Name)
+		}
+		return name + ":" + prefixSpace(strings.Join(members, ", ")) + ";"
+	}
+	if a.Address == "" {
+		return ""
+	}
+	addr := asciiDomainAddress(a.Address)
+	if a.Name == "" {
+		return addrSpec(addr)
+	}
+	return (&mail.Address{Name: a.Name, Address: addr}).String()
+}
+
+// ParseAddress parses a single mailbox or group, in the form the Email
+// address fields hold or as it appears in a header. Encoded-words in display
+// names are decoded, in any charset ParseRawEmail knows.
+//
+// Like ParseEmailAddress, it accepts the malformed input real clients send,
+// such as an unquoted comma in a display name, and rejects an address
+// carrying a control character with ErrIllegalAddress.
+func ParseAddress(s string) (Address, error) {
+	if list, err := ParseAddressList(s); err == nil && len(list) == 1 {
+		return list[0], nil
+	}
+	return parseMailbox(s)
+}
+
+// ParseAddressList parses an address header value: mailboxes and groups,
+// separated by commas, in order. Commas inside quoted display names,
+// comments and angle brackets do not separate entries. An empty value is an
+// empty list.
+func ParseAddressList(s string) ([]Address, error) {
+	var list []Address
+	group := -1 // index in list of the group being read, if any
+	err := scanAddressList(s, func(piece string, delim byte) error {
+		piece = trimWSP(piece)
+		if delim == ':' {
+			if group >= 0 {
+				return fmt.Errorf("gsmail: group %q nested in a group", piece)
+			}
+			name, err := parsePhrase(piece)
+			if err != nil {
+				return err
+			}
+			list = append(list, Address{Name: name})
+			group = len(list) - 1
+			return nil
+		}
+		if piece != "" {
+			a, err := parseMailbox(piece)
+			if err != nil {
+				return err
+			}
+			if group >= 0 {
+				list[group].Group = append(list[group].Group, a)
+			} else {
+				list = append(list, a)
+			}
+		}
+		if delim == ';' {
+			if group < 0 {
+				return errors.New("gsmail: ';' outside a group")
+			}
+			group = -1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A group left open at the end of the value is closed there, which is
+	// how clients read the truncated headers some servers produce.
+	return list, nil
+}
+
+// Mailboxes returns the mailboxes an Email address field names, in order,
+// with each group replaced by its members. It is what a provider delivers
+// to: a group is a way of writing a header, not a recipient.
+//
+// An entry holding several addresses, such as "a@example.com,
+// b@example.com", is split into them. One that does not parse at all is
+// returned as the bare Address it holds, trimmed, for the provider to send
+// and the server to accept or refuse, as each provider always has.
+func Mailboxes(list []string) []Address {
+	out := make([]Address, 0, len(list))
+	for _, entry := range list {
+		if mailboxes, ok := parseMailboxes(entry); ok {
+			out = append(out, mailboxes...)
+		} else if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, Address{Address: entry})
+		}
+	}
+	return out
+}
+
+// parseMailboxes parses one entry of an address field into its mailboxes,
+// reporting false when it does not parse.
+func parseMailboxes(entry string) ([]Address, bool) {
+	if a, err := ParseAddress(entry); err == nil {
+		return a.Mailboxes(), true
+	}
+	list, err := ParseAddressList(entry)
+	if err != nil || len(list) == 0 {
+		return nil, false
+	}
+	var out []Address
+	for _, a := range list {
+		out = append(out, a.Mailboxes()...)
+	}
+	return out, true
+}
+
+// FormatMailboxes is Mailboxes in header form, for an API that takes one
+// address per entry: each mailbox encoded as Encode encodes it, and any that
+// cannot be written dropped, as FormatAddressList drops them. An entry that
+// does not parse is passed on as it is, sanitised, never re-quoted: quoting
+// "a@x.com, b" as one local part would name a mailbox nobody has.
+func FormatMailboxes(list []string) []string {
+	out := make([]string, 0, len(list))
+	for _, entry := range list {
+		mailboxes, ok := parseMailboxes(entry)
+		if !ok {
+			if entry = sanitizeHeaderValue(strings.TrimSpace(entry)); entry != "" {
+				out = append(out, entry)
+			}
+			continue
+		}
+		for _, a := range mailboxes {
+			if enc := a.Encode(); enc != "" {
+				out = append(out, enc)
+			}
+		}
+	}
+	return out
+}
+
+// Addresses parses one of the Email address fields -- "From", "Reply-To",
+// "To", "Cc" or "Bcc", in any case -- into Addresses, keeping groups as
+// groups. An entry that does not parse is an error.
+func (e Email) Addresses(field string) ([]Address, error) {
+	var entries []string
+	switch strings.ToLower(field) {
+	case "from":
+		entries = []string{e.From}
+	case "reply-to":
+		entries = []string{e.ReplyTo}
+	case "to":
+		entries = e.To
+	case "cc":
+		entries = e.Cc
+	case "bcc":
+		entries = e.Bcc
+	default:
+		return nil, fmt.Errorf("gsmail: %q is not an address field", field)
+	}
+	out := make([]Address, 0, len(entries))
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		a, err := ParseAddress(entry)
+		if err != nil {
+			return nil, fmt.Errorf("gsmail: %s: %w", field, err)
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// addressParser decodes display names in any charset toUTF8 knows. The
+// net/mail default knows only UTF-8 and ISO-8859-1, and fails the whole list
+// on anything else.
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// errEmptyAddress is "<>", which is a null reverse path, not a mailbox.
+var errEmptyAddress = errors.New("gsmail: empty address")
+
+// parseMailbox parses a single mailbox, leniently.
+//
+// An address carrying a control character is rejected outright. The lenient
+// fallback exists for real headers that net/mail will not accept -- an
+// unquoted comma in a display name, say -- but it must not hand back whatever
+// sat between the angle brackets, so "<0\n0>" would produce an address
+// containing a newline.
+func parseMailbox(s string) (Address, error) {
+	if strings.ContainsFunc(s, isIllegalHeaderRune) {
+		return Address{}, ErrIllegalAddress
+	}
+	s = eightBitText(s)
+	a, err := addressParser.Parse(s)
+	switch {
+	case err == nil && a.Address == "":
+		return Address{}, errEmptyAddress
+	case err == nil:
+		return Address{Name: a.Name, Address: a.Address}, nil
+	}
+
+	s = trimWSP(s)
+	if strings.HasSuffix(s, ">") {
+		if idx := strings.LastIndex(s, "<"); idx >= 0 {
+			// Only the display name is forgiven: the address itself must be
+			// one net/mail reads.
+			spec, specErr := addressParser.Parse(s[idx:])
+			switch {
+			case specErr != nil:
+				return Address{}, err
+			case spec.Address == "":
+				return Address{}, errEmptyAddress
+			}
+			return Address{Name: unquotePhrase(s[:idx]), Address: spec.Address}, nil
+		}
+	}
+	return Address{}, err
+}
+
+// parsePhrase decodes a group's display name.
+func parsePhrase(s string) (string, error) {
+	if strings.ContainsFunc(s, isIllegalHeaderRune) {
+		return "", ErrIllegalAddress
+	}
+	name := unquotePhrase(s)
+	if name == "" {
+		return "", errors.New("gsmail: group has no name")
+	}
+	return name, nil
+}
+
+// unquotePhrase decodes a display name: quoted strings unquoted and
+// encoded-words decoded.
+func unquotePhrase(s string) string {
+	var b strings.Builder
+	quoted, escaped := false, false
+	for _, r := range eightBitText(trimWSP(s)) {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return headerText(b.String())
+}
+
+// scanAddressList splits an address header value at the commas, colons and
+// semicolons that are outside quoted strings, comments, angle brackets and
+// domain literals, calling fn with each piece and the delimiter that ended
+// it, or 0 for the last.
+func scanAddressList(s string, fn func(piece string, delim byte) error) error {
+	start, comment := 0, 0
+	quoted, escaped, angle, literal := false, false, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || comment > 0):
+			escaped = true
+		case quoted:
+			quoted = c != '"'
+		case comment > 0:
+			switch c {
+			case '(':
+				comment++
+			case ')':
+				comment--
+			}
+		case c == '"':
+			quoted = true
+		case c == '(':
+			comment++
+		case angle:
+			angle = c != '>'
+		case literal:
+			literal = c != ']'
+		case c == '<':
+			angle = true
+		case c == '[':
+			literal = true
+		case c == ',' || c == ':' || c == ';':
+			if err := fn(s[start:i], c); err != nil {
+				return err
+			}
+			start = i + 1
+		}
+	}
+	if rest := s[start:]; trimWSP(rest) != "" {
+		return fn(rest, 0)
+	}
+	return nil
+}
+
+// quotePhrase returns a display name as it is, or as a quoted string if it
+// holds anything but atoms and spaces.
+func quotePhrase(s string) string {
+	if s != "" && trimWSP(s) == s && !strings.ContainsFunc(s, func(r rune) bool {
+		return r < 0x80 && r != ' ' && !strings.ContainsRune(atext, r)
+	}) {
+		return s
+	}
+	return quoteString(s)
+}
+
+// atext is the ASCII punctuation an RFC 5322 atom may hold, with the letters
+// and digits.
+const atext = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+-/=?^_`{|}~"
+
+// addrSpec returns an address with its local part quoted again where it
+// needs to be: net/mail unquotes `"John Doe"@example.com` when it parses it.
+func addrSpec(addr string) string {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 {
+		return addr
+	}
+	local := addr[:i]
+	if local != "" && !strings.HasPrefix(local, ".") && !strings.HasSuffix(local, ".") && !strings.Contains(local, "..") &&
+		!strings.ContainsFunc(local, func(r rune) bool { return r < 0x80 && r != '.' && !strings.ContainsRune(atext, r) }) {
+		return addr
+	}
+	return quoteString(local) + addr[i:]
+}
+
+func quoteString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func prefixSpace(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " " + s
+}
+
+// trimWSP trims the whitespace RFC 5322 allows around an address. Unicode
+// spaces are text, and an address must read back with them.
+func trimWSP(s string) string {
+	return strings.Trim(s, " \t\r\n")
+}
@@ -0,0 +1,141 @@
+package gsmail
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	got, err := ParseAddressList(`"Doe, John" <j@example.com>, Team: a@example.com, "B (x)" <b@example.com>;, ` +
+		`undisclosed-recipients:;, =?ISO-8859-1?Q?Andr=E9?= <andre@example.com>, c@[192.0.2.1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Address{
+		{Name: "Doe, John", Address: "j@example.com"},
+		{Name: "Team", Group: []Address{
+			{Address: "a@example.com"},
+			{Name: "B (x)", Address: "b@example.com"},
+		}},
+		{Name: "undisclosed-recipients"},
+		{Name: "André", Address: "andre@example.com"},
+		{Address: "c@[192.0.2.1]"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	for _, bad := range []string{"a@example.com;", "A: B: c@example.com;;", "a@example.com\r\nBcc: evil@example.com"} {
+		if _, err := ParseAddressList(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	if list, err := ParseAddressList("  "); err != nil || len(list) != 0 {
+		t.Errorf("empty value = %v, %v", list, err)
+	}
+}
+
+// String is the form the Email fields hold, and reads back as the same
+// Address; Encode is the header form.
+func TestAddressRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		a            Address
+		str, encoded string
+	}{
+		{Address{Address: "a@example.com"}, "a@example.com", "a@example.com"},
+		{Address{Name: "Doe, John", Address: "j@example.com"}, `"Doe, John" <j@example.com>`, `"Doe, John" <j@example.com>`},
+		{Address{Name: `Say "hi"`, Address: "h@example.com"}, `"Say \"hi\"" <h@example.com>`, `"Say \"hi\"" <h@example.com>`},
+		{Address{Name: "佐藤, 花子", Address: "h@bücher.de"}, `"佐藤, 花子" <h@bücher.de>`, "=?utf-8?b?5L2Q6JekLCDoirHlrZA=?= <h@xn--bcher-kva.de>"},
+		{Address{Name: "undisclosed-recipients"}, "undisclosed-recipients:;", "undisclosed-recipients:;"},
+		{
+			Address{Name: "Sales, EU", Group: []Address{{Address: "a@example.com"}, {Name: "Jörg", Address: "j@example.com"}}},
+			`"Sales, EU": a@example.com, "Jörg" <j@example.com>;`,
+			`"Sales, EU": a@example.com, =?utf-8?q?J=C3=B6rg?= <j@example.com>;`,
+		},
+	} {
+		if got := tc.a.String(); got != tc.str {
+			t.Errorf("String() = %q, want %q", got, tc.str)
+		}
+		if got := tc.a.Encode(); got != tc.encoded {
+			t.Errorf("Encode() = %q, want %q", got, tc.encoded)
+		}
+		for _, form := range []string{tc.str, tc.encoded} {
+			back, err := ParseAddress(form)
+			if err != nil || back.Name != tc.a.Name || len(back.Group) != len(tc.a.Group) {
+				t.Errorf("ParseAddress(%q) = %+v, %v", form, back, err)
+			}
+		}
+	}
+
+	if got := (Address{Name: "x", Address: "a@example.com\r\nBcc: evil@example.com"}).Encode(); got != "" {
+		t.Errorf("Encode() = %q for an address carrying CRLF", got)
+	}
+	if got := (Address{}).String(); got != "" {
+		t.Errorf("zero Address = %q", got)
+	}
+}
+
+func TestEmailAddresses(t *testing.T) {
+	e := Email{
+		From: "Ana <ana@example.com>",
+		To:   []string{"Team: a@example.com, b@example.com;", "c@example.com"},
+		Bcc:  []string{"not an address"},
+	}
+	from, err := e.Addresses("From")
+	if err != nil || len(from) != 1 || from[0].Name != "Ana" {
+		t.Errorf("From = %+v, %v", from, err)
+	}
+	to, err := e.Addresses("to")
+	if err != nil || len(to) != 2 || !to[0].IsGroup() || len(to[0].Mailboxes()) != 2 {
+		t.Errorf("To = %+v, %v", to, err)
+	}
+	if cc, err := e.Addresses("Cc"); err != nil || len(cc) != 0 {
+		t.Errorf("Cc = %+v, %v", cc, err)
+	}
+	if _, err := e.Addresses("Bcc"); err == nil {
+		t.Error("an unparseable Bcc entry was not reported")
+	}
+	if _, err := e.Addresses("Subject"); err == nil {
+		t.Error("Subject accepted as an address field")
+	}
+
+	// Providers deliver to mailboxes; the header keeps the group.
+	var got []string
+	for _, a := range Mailboxes(e.To) {
+		got = append(got, a.Address)
+	}
+	if !reflect.DeepEqual(got, []string{"a@example.com", "b@example.com", "c@example.com"}) {
+		t.Errorf("Mailboxes = %q", got)
+	}
+	if got := FormatAddresses(e.To); got != "Team: a@example.com, b@example.com;, c@example.com" {
+		t.Errorf("To header = %q", got)
+	}
+}
+
+// An entry holding two addresses is both of them, and one that does not parse
+// is passed on as it is rather than quoted into an address nobody has.
+func TestMailboxesOfUnparseableEntries(t *testing.T) {
+	list := []string{"a@x.com, b@x.com", "not an address", "C <c@x.com>"}
+
+	var got []string
+	for _, a := range Mailboxes(list) {
+		got = append(got, a.Address)
+	}
+	if want := []string{"a@x.com", "b@x.com", "not an address", "c@x.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Mailboxes = %q, want %q", got, want)
+	}
+	if got, want := FormatMailboxes(list), []string{"a@x.com", "b@x.com", "not an address", `"C" <c@x.com>`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FormatMailboxes = %q, want %q", got, want)
+	}
+}
+
+func TestParseEmailAddressSharesParser(t *testing.T) {
+	a, err := ParseEmailAddress("=?ISO-2022-JP?B?GyRCOzNFRBsoQg==?= <taro@example.jp>")
+	if err != nil || a.Name != "山田" {
+		t.Errorf("got %+v, %v", a, err)
+	}
+	if _, err := ParseEmailAddress("<0\n0>"); !errors.Is(err, ErrIllegalAddress) {
+		t.Errorf("expected ErrIllegalAddress, got %v", err)
+	}
+}
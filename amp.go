@@ -0,0 +1,199 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidAMP is wrapped by the errors reported for an Email.AMPBody that
+// cannot be sent.
+var ErrInvalidAMP = errors.New("gsmail: invalid AMP for Email body")
+
+// ampRuntime is the script every AMP document loads, and ampMaxCustomCSS the
+// most CSS its amp-custom stylesheet may hold.
+const (
+	ampRuntime      = "https://cdn.ampproject.org/v0.js"
+	ampScriptPrefix = "https://cdn.ampproject.org/"
+	ampMaxCustomCSS = 75000
+)
+
+// ValidateAMP reports the first reason amp is not an AMP for Email document a
+// mail client would render. Gmail, Yahoo and Mail.ru show the text/html
+// alternative instead of an AMP part that fails validation, silently, so a
+// broken template would otherwise go unnoticed until someone opens the mail.
+//
+// It checks the boilerplate the format requires: the doctype, the ⚡4email
+// (or amp4email) attribute on <html>, a <head> with <meta charset="utf-8">,
+// the AMP runtime script and the amp4email-boilerplate style, and a <body>.
+// It also refuses what is never allowed: a script not served by the AMP
+// project, and a stylesheet other than a single amp-custom one of at most
+// 75,000 bytes. It does not know which components and attributes are
+// permitted; the AMP validator remains the authority on those.
+//
+// Every Email with an AMPBody is checked as it is prepared and rendered, so
+// a message carrying an invalid one is refused rather than sent.
+func ValidateAMP(amp []byte) error {
+	invalid := func(format string, args ...any) error {
+		return NonRetryable(fmt.Errorf("%w: "+format, append([]any{ErrInvalidAMP}, args...)...))
+	}
+	rest := bytes.TrimLeft(amp, " \t\r\n\f\ufeff")
+	if !hasPrefixFold(rest, "<!doctype html") {
+		return invalid("it must begin with <!doctype html>")
+	}
+
+	var isAMP, head, body, charset, runtime, boilerplate, custom bool
+	for i := 0; i < len(amp); {
+		lt := bytes.IndexByte(amp[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		switch {
+		case bytes.HasPrefix(amp[i:], []byte("<!--")):
+			end := bytes.Index(amp[i+4:], []byte("-->"))
+			if end < 0 {
+				return invalid("unterminated comment")
+			}
+			i += 4 + end + 3
+			continue
+		case bytes.HasPrefix(amp[i:], []byte("<!")):
+			end := bytes.IndexByte(amp[i:], '>')
+			if end < 0 {
+				break
+			}
+			i += end + 1
+			continue
+		}
+
+		name, attrs, closing, n := parseHTMLTag(amp[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		i += n
+		if closing {
+			continue
+		}
+		_, has := attrs["⚡4email"]
+		_, hasAlt := attrs["amp4email"]
+		switch name {
+		case "html":
+			isAMP = has || hasAlt
+		case "head":
+			head = true
+		case "body":
+			body = true
+		case "meta":
+			charset = charset || strings.EqualFold(attrs["charset"], "utf-8")
+		case "script", "style":
+			end := i + skipHTMLElement(amp[i:], name)
+			content := amp[i:end]
+			if close := lastIndexFold(content, "</"+name); close >= 0 {
+				content = content[:close]
+			}
+			i = end
+			if name == "script" {
+				if err := checkAMPScript(attrs, &runtime); err != nil {
+					return invalid("%s", err)
+				}
+				continue
+			}
+			if _, ok := attrs["amp4email-boilerplate"]; ok {
+				if string(bytes.Join(bytes.Fields(content), nil)) != "body{visibility:hidden}" {
+					return invalid("the amp4email-boilerplate style must be body{visibility:hidden}")
+				}
+				boilerplate = true
+				continue
+			}
+			if _, ok := attrs["amp-custom"]; !ok {
+				return invalid("a <style> must be amp-custom or amp4email-boilerplate")
+			}
+			if custom {
+				return invalid("only one amp-custom style is allowed")
+			}
+			if len(content) > ampMaxCustomCSS {
+				return invalid("the amp-custom style is %d bytes, above the limit of %d", len(content), ampMaxCustomCSS)
+			}
+			custom = true
+		}
+	}
+
+	switch {
+	case !isAMP:
+		return invalid(`<html> must carry the ⚡4email or amp4email attribute`)
+	case !head:
+		return invalid("missing <head>")
+	case !charset:
+		return invalid(`missing <meta charset="utf-8">`)
+	case !runtime:
+		return invalid(`missing <script async src="%s">`, ampRuntime)
+	case !boilerplate:
+		return invalid("missing the amp4email-boilerplate style")
+	case !body:
+		return invalid("missing <body>")
+	}
+	return nil
+}
+
+// checkAMPScript reports whether a <script> is one AMP for Email allows:
+// the runtime, a component, or inline JSON for amp-state. runtime is set when
+// it is the former.
+func checkAMPScript(attrs map[string]string, runtime *bool) error {
+	src, ok := attrs["src"]
+	if !ok {
+		switch strings.ToLower(attrs["type"]) {
+		case "application/json", "application/ld+json":
+			return nil
+		}
+		return errors.New("inline scripts are not allowed")
+	}
+	if _, async := attrs["async"]; !async {
+		return fmt.Errorf("script %q must be async", src)
+	}
+	if src == ampRuntime {
+		*runtime = true
+		return nil
+	}
+	_, element := attrs["custom-element"]
+	_, template := attrs["custom-template"]
+	if !strings.HasPrefix(src, ampScriptPrefix) || (!element && !template) {
+		return fmt.Errorf("script %q is not an AMP component", src)
+	}
+	return nil
+}
+
+// CheckAMP reports whether email's AMPBody, if any, can be sent: it has to be
+// valid, and it needs an HTML body to fall back to, which is what every
+// client without AMP support shows and what Gmail shows once the AMP part
+// expires after 30 days. PrepareMessage and rendering check this; a provider
+// that sends the AMP body through its own API field calls it before building
+// the request.
+//
+// ParseRawEmail sets AMPBody only for a part that passes, so a received
+// message can always be rendered again; one that does not is kept as an
+// attachment, as it arrived.
+func CheckAMP(email Email) error {
+	if len(email.AMPBody) == 0 {
+		return nil
+	}
+	if len(email.HTMLBody) == 0 {
+		return NonRetryable(fmt.Errorf("%w: an AMPBody needs an HTMLBody to fall back to", ErrInvalidAMP))
+	}
+	return ValidateAMP(email.AMPBody)
+}
+
+// ErrAMPUnsupported is returned by providers whose API has no field for an
+// AMP body. Sending only the HTML would deliver something other than what was
+// asked for without anyone noticing.
+var ErrAMPUnsupported = errors.New("gsmail: this provider cannot send an AMP body; Email.AMPBody needs SMTP, SES, SendGrid or Mailgun")
+
+// RejectAMP reports the error a provider should return when a message sets
+// Email.AMPBody and the transport cannot carry it.
+func RejectAMP(provider string, email Email) error {
+	if len(email.AMPBody) == 0 {
+		return nil
+	}
+	return NonRetryable(fmt.Errorf("%s: %w", provider, ErrAMPUnsupported))
+}
@@ -0,0 +1,142 @@
+package gsmail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const ampDocument = `<!doctype html>
+<html ⚡4email data-css-strict>
+<head>
+  <meta charset="utf-8">
+  <script async src="https://cdn.ampproject.org/v0.js"></script>
+  <script async custom-element="amp-form" src="https://cdn.ampproject.org/v0/amp-form-0.1.js"></script>
+  <style amp4email-boilerplate>body { visibility: hidden }</style>
+  <style amp-custom>h1 { margin: 0 }</style>
+</head>
+<body><h1>RSVP</h1></body>
+</html>`
+
+func TestValidateAMP(t *testing.T) {
+	if err := ValidateAMP([]byte(ampDocument)); err != nil {
+		t.Fatalf("valid document refused: %v", err)
+	}
+	if err := ValidateAMP([]byte(strings.Replace(ampDocument, "⚡4email", "amp4email", 1))); err != nil {
+		t.Errorf("amp4email refused: %v", err)
+	}
+
+	for name, doc := range map[string]string{
+		"no doctype":        strings.Replace(ampDocument, "<!doctype html>", "", 1),
+		"not AMP":           strings.Replace(ampDocument, "⚡4email", "", 1),
+		"no charset":        strings.Replace(ampDocument, `<meta charset="utf-8">`, "", 1),
+		"no runtime":        strings.Replace(ampDocument, `<script async src="https://cdn.ampproject.org/v0.js"></script>`, "", 1),
+		"no boilerplate":    strings.Replace(ampDocument, "<style amp4email-boilerplate>body { visibility: hidden }</style>", "", 1),
+		"other boilerplate": strings.Replace(ampDocument, "visibility: hidden", "display: none", 1),
+		"foreign script":    strings.Replace(ampDocument, "</head>", `<script async src="https://x.test/a.js"></script></head>`, 1),
+		"inline script":     strings.Replace(ampDocument, "</head>", `<script>alert(1)</script></head>`, 1),
+		"plain style":       strings.Replace(ampDocument, "<style amp-custom>", "<style>", 1),
+		"large style":       strings.Replace(ampDocument, "margin: 0", strings.Repeat("a", ampMaxCustomCSS), 1),
+		"no body":           strings.Replace(ampDocument, "<body><h1>RSVP</h1></body>", "", 1),
+		// "Ⱥ" is longer in lower case, so folding the whole document moves
+		// every offset after it.
+		"large style after folding": strings.Replace(ampDocument, "margin: 0", "/* "+strings.Repeat("Ⱥ", ampMaxCustomCSS/2)+" */", 1),
+	} {
+		err := ValidateAMP([]byte(doc))
+		if !errors.Is(err, ErrInvalidAMP) || IsRetryable(err) {
+			t.Errorf("%s: expected a permanent ErrInvalidAMP, got %v", name, err)
+		}
+	}
+}
+
+func TestRenderAMPBody(t *testing.T) {
+	email := Email{
+		From:     "events@example.com",
+		To:       []string{"a@example.com"},
+		Subject:  "RSVP",
+		Body:     []byte("Reply to RSVP"),
+		HTMLBody: []byte("<p>Reply to RSVP</p>"),
+		AMPBody:  []byte(ampDocument),
+	}
+	raw, err := RenderMessage(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := strings.Index(string(raw), "Content-Type: text/plain")
+	amp := strings.Index(string(raw), "Content-Type: text/x-amp-html")
+	html := strings.Index(string(raw), "Content-Type: text/html")
+	if !strings.Contains(string(raw), "Content-Type: multipart/alternative") || plain < 0 || !(plain < amp && amp < html) {
+		t.Fatalf("want text/plain, text/x-amp-html, text/html in that order:\n%s", raw)
+	}
+
+	parsed, err := ParseRawEmail(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(parsed.AMPBody) != ampDocument || string(parsed.HTMLBody) != "<p>Reply to RSVP</p>" || len(parsed.Attachments) != 0 {
+		t.Errorf("parsed AMP %q, HTML %q, %d attachments", parsed.AMPBody, parsed.HTMLBody, len(parsed.Attachments))
+	}
+
+	// Clients without AMP show the HTML, so a message without one is refused,
+	// as is a document they would not render.
+	noHTML := email
+	noHTML.HTMLBody = nil
+	if _, err := RenderMessage(noHTML); !errors.Is(err, ErrInvalidAMP) {
+		t.Errorf("AMP without HTML: %v", err)
+	}
+	invalid := email
+	invalid.AMPBody = []byte("<p>not AMP</p>")
+	if _, err := RenderMessage(invalid); !errors.Is(err, ErrInvalidAMP) {
+		t.Errorf("invalid AMP: %v", err)
+	}
+	if _, err := PrepareMessage(invalid); !errors.Is(err, ErrInvalidAMP) {
+		t.Errorf("invalid AMP prepared: %v", err)
+	}
+}
+
+func TestValidateAMPWithCaseChangingText(t *testing.T) {
+	// "ȿ" is longer in upper case, and "Ⱥ" longer in lower case.
+	doc := strings.Replace(ampDocument, "<h1>RSVP</h1>", strings.Repeat("ȿȺ", 50)+"<H1>RSVP</H1>", 1)
+	doc = strings.Replace(doc, "margin: 0", "content: 'ȺȺȺȺ'", 1)
+	doc = strings.Replace(doc, "</style>\n</head>", "</STYLE>\n</head>", 1)
+	if err := ValidateAMP([]byte(strings.ToUpper(doc[:15]) + doc[15:])); err != nil {
+		t.Fatalf("valid document refused: %v", err)
+	}
+}
+
+func TestParseRawEmailInvalidAMP(t *testing.T) {
+	// A received AMP part the validator refuses, here one without a doctype
+	// and without an HTML part beside it, still renders again, as a file.
+	raw := "From: events@example.com\r\n" +
+		"To: a@example.com\r\n" +
+		"Subject: RSVP\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nReply to RSVP\r\n" +
+		"--b\r\nContent-Type: text/x-amp-html\r\n\r\n<html amp4email><body>RSVP</body></html>\r\n" +
+		"--b--\r\n"
+	parsed, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.AMPBody) != 0 || len(parsed.Attachments) != 1 || string(parsed.Attachments[0].Data) != "<html amp4email><body>RSVP</body></html>" {
+		t.Fatalf("AMP %q, attachments %+v", parsed.AMPBody, parsed.Attachments)
+	}
+	out, err := RenderMessage(parsed)
+	if err != nil {
+		t.Fatalf("re-render: %v", err)
+	}
+	if !strings.Contains(string(out), "text/x-amp-html") {
+		t.Errorf("AMP part lost:\n%s", out)
+	}
+}
+
+func TestRejectAMP(t *testing.T) {
+	if err := RejectAMP("p", Email{}); err != nil {
+		t.Errorf("no AMP body: %v", err)
+	}
+	err := RejectAMP("p", Email{AMPBody: []byte(ampDocument)})
+	if !errors.Is(err, ErrAMPUnsupported) || IsRetryable(err) {
+		t.Errorf("expected a permanent ErrAMPUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,394 @@
+package gsmail
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrAttachmentDenied is wrapped by the error an AttachmentPolicy returns for
+// an attachment it refuses.
+var ErrAttachmentDenied = errors.New("gsmail: attachment refused by policy")
+
+// AttachmentPolicy decides which attachments may be sent or received. It
+// judges each by its content rather than by the type it declares, which the
+// sender chose: a Windows program named invoice.pdf and declared as
+// application/pdf is refused as an executable.
+//
+// The zero value allows everything; DefaultAttachmentPolicy refuses what the
+// large mailbox providers refuse. Apply a policy to outgoing mail with
+// AttachmentPolicyInterceptor and to received mail with AttachmentPolicyFilter.
+type AttachmentPolicy struct {
+	// DenyExecutables refuses programs and scripts: Windows, Linux and macOS
+	// binaries and #! scripts by their content, and the types Windows runs
+	// without a signature to recognise, such as .bat, .js, .vbs and .lnk, by
+	// their file name.
+	DenyExecutables bool
+
+	// DenyMacros refuses Office documents carrying VBA macros: the
+	// macro-enabled file types, and documents whose content holds a VBA
+	// project whatever they are named. Excel 4.0 macros in a legacy .xls are
+	// not recognised.
+	DenyMacros bool
+
+	// InspectArchives applies DenyExecutables and DenyMacros to what ZIP,
+	// tar and gzip archives hold, and to archives inside those, to a depth of
+	// three. At most 64 MiB and 10,000 files are unpacked per attachment;
+	// an archive holding more is refused, since what it hides cannot be
+	// judged.
+	InspectArchives bool
+
+	// DenyEncryptedArchives refuses ZIP archives with encrypted entries,
+	// whose content cannot be inspected. Their names still can, and are.
+	DenyEncryptedArchives bool
+
+	// MaxSize caps the size of an attachment by its detected media type,
+	// keyed by the type ("application/pdf"), by its top-level type
+	// ("image/*"), or by "*" for everything else. The most specific key
+	// applies.
+	MaxSize map[string]int64
+
+	// SetContentTypes corrects each attachment's declared type as
+	// SniffContentTypes does, before sending and on receipt.
+	SetContentTypes bool
+
+	// OnDenied is called for each attachment AttachmentPolicyFilter removes
+	// from a received message, with the message as received and the reason.
+	OnDenied func(email Email, attachment Attachment, err error)
+}
+
+// DefaultAttachmentPolicy refuses executables, including those inside
+// archives, and macro-enabled Office documents, and corrects declared content
+// types. Encrypted archives are allowed, since many businesses exchange them;
+// set DenyEncryptedArchives to refuse them as Gmail does.
+func DefaultAttachmentPolicy() AttachmentPolicy {
+	return AttachmentPolicy{
+		DenyExecutables: true,
+		DenyMacros:      true,
+		InspectArchives: true,
+		SetContentTypes: true,
+	}
+}
+
+// Limits on the work InspectArchives does for one attachment.
+const (
+	maxArchiveDepth   = 3
+	maxArchiveBytes   = 64 << 20
+	maxArchiveEntries = 10000
+)
+
+// archiveBudget is what remains of maxArchiveBytes and maxArchiveEntries for
+// one attachment, shared by the archives nested in it.
+type archiveBudget struct {
+	bytes   int64
+	entries int
+}
+
+// Check returns a NonRetryable error wrapping ErrAttachmentDenied if p refuses
+// a, or nil. An attachment backed by Open is read in full.
+func (p AttachmentPolicy) Check(a Attachment) error {
+	data, err := a.Bytes()
+	if err != nil {
+		return err
+	}
+	contentType, _ := sniffContentType(data, a.Filename)
+	deny := func(format string, args ...any) error {
+		return NonRetryable(fmt.Errorf("%w: %q "+format, append([]any{ErrAttachmentDenied, a.Filename}, args...)...))
+	}
+	if limit, ok := p.maxSize(contentType); ok && int64(len(data)) > limit {
+		return deny("is %d bytes, the limit for %s is %d", len(data), contentType, limit)
+	}
+	budget := archiveBudget{bytes: maxArchiveBytes, entries: maxArchiveEntries}
+	if reason := p.refuse(a.Filename, data, contentType, 0, &budget); reason != "" {
+		return deny("%s", reason)
+	}
+	return nil
+}
+
+// CheckEmail is Check for each of email's attachments, returning the first
+// refusal.
+func (p AttachmentPolicy) CheckEmail(email Email) error {
+	for _, a := range email.Attachments {
+		if err := p.Check(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter returns email without the attachments p refuses, reporting each to
+// OnDenied, and with the remaining types corrected when SetContentTypes is
+// set. Raw, when the message was parsed with ParseOptions.RetainRaw, still
+// holds the original, attachments and all.
+func (p AttachmentPolicy) Filter(email Email) Email {
+	if len(email.Attachments) == 0 {
+		return email
+	}
+	kept := make([]Attachment, 0, len(email.Attachments))
+	for _, a := range email.Attachments {
+		if err := p.Check(a); err != nil {
+			if p.OnDenied != nil {
+				p.OnDenied(email, a, err)
+			}
+			continue
+		}
+		if p.SetContentTypes {
+			if data, err := a.Bytes(); err == nil {
+				a.ContentType = correctContentType(a.ContentType, data, a.Filename)
+			}
+		}
+		kept = append(kept, a)
+	}
+	email.Attachments = kept
+	return email
+}
+
+// AttachmentPolicyInterceptor refuses to send a message with an attachment p
+// refuses, after correcting the declared types when p.SetContentTypes is set.
+// A message with Raw set is sent as it is.
+func AttachmentPolicyInterceptor(p AttachmentPolicy) SendInterceptor {
+	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
+		if len(email.Raw) > 0 || len(email.Attachments) == 0 {
+			return next(ctx, email)
+		}
+		if p.SetContentTypes {
+			var err error
+			if email, err = SniffContentTypes(email); err != nil {
+				return err
+			}
+		}
+		if err := p.CheckEmail(email); err != nil {
+			return err
+		}
+		return next(ctx, email)
+	}
+}
+
+// AttachmentPolicyFilter applies p.Filter to every message a Receiver
+// returns, from Receive, Search and Idle alike:
+//
+//	r = gsmail.WrapReceiverWith(r, gsmail.AttachmentPolicyFilter(policy))
+//
+// Refused attachments are removed rather than the message dropped, so the
+// text of a message still arrives; use OnDenied to flag or quarantine it.
+func AttachmentPolicyFilter(p AttachmentPolicy) ReceiverInterceptors {
+	filter := func(emails []Email, err error) ([]Email, error) {
+		for i := range emails {
+			emails[i] = p.Filter(emails[i])
+		}
+		return emails, err
+	}
+	return ReceiverInterceptors{
+		Receive: func(ctx context.Context, limit int, next func(ctx context.Context, limit int) ([]Email, error)) ([]Email, error) {
+			return filter(next(ctx, limit))
+		},
+		Search: func(ctx context.Context, options SearchOptions, limit int, next func(ctx context.Context, options SearchOptions, limit int) ([]Email, error)) ([]Email, error) {
+			return filter(next(ctx, options, limit))
+		},
+		Idle: func(ctx context.Context, next func(ctx context.Context) (<-chan Email, <-chan error)) (<-chan Email, <-chan error) {
+			in, errs := next(ctx)
+			if in == nil {
+				return in, errs
+			}
+			out := make(chan Email)
+			go func() {
+				defer close(out)
+				// Once ctx is done nobody reads out, but the receiver may
+				// still be sending on in until it closes it.
+				for email := range in {
+					if ctx.Err() != nil {
+						continue
+					}
+					select {
+					case out <- p.Filter(email):
+					case <-ctx.Done():
+					}
+				}
+			}()
+			return out, errs
+		},
+	}
+}
+
+// maxSize returns the MaxSize entry that applies to contentType.
+func (p AttachmentPolicy) maxSize(contentType string) (int64, bool) {
+	if len(p.MaxSize) == 0 {
+		return 0, false
+	}
+	contentType = strings.ToLower(contentType)
+	if limit, ok := p.MaxSize[contentType]; ok {
+		return limit, true
+	}
+	if i := strings.IndexByte(contentType, '/'); i > 0 {
+		if limit, ok := p.MaxSize[contentType[:i]+"/*"]; ok {
+			return limit, true
+		}
+	}
+	limit, ok := p.MaxSize["*"]
+	return limit, ok
+}
+
+// refuse returns why p refuses a file, or "". depth counts the archives it is
+// inside.
+func (p AttachmentPolicy) refuse(name string, data []byte, contentType string, depth int, budget *archiveBudget) string {
+	if p.DenyExecutables && isExecutable(name, contentType) {
+		return "is an executable"
+	}
+	if p.DenyMacros && hasMacros(name, data, contentType) {
+		return "contains Office macros"
+	}
+	if !p.InspectArchives && !p.DenyEncryptedArchives {
+		return ""
+	}
+	var reason string
+	complete := walkArchive(data, contentType, depth, budget, func(entry string, content []byte, encrypted bool) bool {
+		switch {
+		case encrypted && p.DenyEncryptedArchives:
+			reason = "is an encrypted archive"
+		case p.InspectArchives:
+			entryType, _ := sniffContentType(content, entry)
+			if inner := p.refuse(entry, content, entryType, depth+1, budget); inner != "" {
+				reason = fmt.Sprintf("holds %q, which %s", entry, inner)
+			}
+		}
+		return reason == ""
+	})
+	if reason == "" && !complete && p.InspectArchives {
+		reason = "holds more than can be inspected"
+	}
+	return reason
+}
+
+// walkArchive calls fn for each file in a ZIP, tar or gzip archive, with as
+// much of its content as the budget allows, until fn returns false. Anything
+// else, and an archive it cannot read, has no files. It returns false when
+// the budget ran out before the last file.
+func walkArchive(data []byte, contentType string, depth int, budget *archiveBudget, fn func(name string, content []byte, encrypted bool) bool) bool {
+	if depth >= maxArchiveDepth {
+		return true
+	}
+	// next takes a file from the budget, or reports that none is left.
+	next := func() bool {
+		if budget.bytes <= 0 || budget.entries <= 0 {
+			return false
+		}
+		budget.entries--
+		return true
+	}
+	read := func(r io.Reader) []byte {
+		content, _ := io.ReadAll(io.LimitReader(r, budget.bytes))
+		budget.bytes -= int64(len(content))
+		return content
+	}
+
+	switch contentType {
+	case "application/zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return true
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if !next() {
+				return false
+			}
+			var content []byte
+			encrypted := f.Flags&0x1 != 0
+			if !encrypted {
+				if rc, err := f.Open(); err == nil {
+					content = read(rc)
+					rc.Close()
+				}
+			}
+			if !fn(f.Name, content, encrypted) {
+				return true
+			}
+		}
+	case "application/x-tar":
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				return true
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			if !next() {
+				return false
+			}
+			if !fn(h.Name, read(tr), false) {
+				return true
+			}
+		}
+	case "application/x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return true
+		}
+		defer zr.Close()
+		name := zr.Name
+		if name == "" {
+			name = "content"
+		}
+		if !next() {
+			return false
+		}
+		fn(name, read(zr), false)
+	}
+	return true
+}
+
+// executableExtensions are the file types Windows or a desktop runs when
+// opened that have no signature sniffContentType recognises, or that are
+// containers for an executable: installers, shortcuts, scripts and the like.
+var executableExtensions = map[string]bool{
+	".ade": true, ".adp": true, ".apk": true, ".app": true, ".appx": true, ".bat": true,
+	".cab": true, ".chm": true, ".cmd": true, ".com": true, ".cpl": true, ".dll": true,
+	".dmg": true, ".exe": true, ".gadget": true, ".hta": true, ".inf": true, ".ins": true,
+	".isp": true, ".jar": true, ".js": true, ".jse": true, ".lib": true, ".lnk": true,
+	".mde": true, ".msc": true, ".msi": true, ".msix": true, ".msp": true, ".mst": true,
+	".nsh": true, ".pif": true, ".ps1": true, ".psm1": true, ".reg": true, ".scr": true,
+	".sct": true, ".sh": true, ".shb": true, ".sys": true, ".vb": true, ".vbe": true,
+	".vbs": true, ".vxd": true, ".wsc": true, ".wsf": true, ".wsh": true,
+}
+
+// macroExtensions are the Office file types that may carry VBA macros.
+var macroExtensions = map[string]bool{
+	".docm": true, ".dotm": true, ".xlsm": true, ".xltm": true, ".xlam": true,
+	".pptm": true, ".potm": true, ".ppam": true, ".ppsm": true, ".sldm": true,
+}
+
+func isExecutable(name, contentType string) bool {
+	switch contentType {
+	case contentTypePE, contentTypeELF, contentTypeMachO, contentTypeScript, "application/vnd.ms-cab-compressed":
+		return true
+	}
+	return executableExtensions[fileExtension(name)]
+}
+
+// vbaProjectName is the name of the stream every VBA project in an OLE
+// compound file has, as the file's directory stores it: UTF-16LE.
+var vbaProjectName = func() []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune("_VBA_PROJECT")) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}()
+
+func hasMacros(name string, data []byte, contentType string) bool {
+	if macroExtensions[fileExtension(name)] || strings.Contains(strings.ToLower(contentType), "macroenabled") {
+		return true
+	}
+	return contentType == contentTypeOLE && bytes.Contains(data, vbaProjectName)
+}
@@ -0,0 +1,201 @@
+package gsmail
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testTarGz(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestAttachmentPolicyCheck(t *testing.T) {
+	ole := append([]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), make([]byte, 64)...)
+	oleMacros := append(append([]byte{}, ole...), vbaProjectName...)
+	inner := testZip(t, map[string]string{"setup.exe": string(testPE())})
+
+	tests := []struct {
+		name   string
+		a      Attachment
+		denied string // part of the reason, or "" when allowed
+	}{
+		{"Image", Attachment{Filename: "logo.png", Data: pngImage}, ""},
+		{"Disguised program", Attachment{Filename: "invoice.pdf", ContentType: "application/pdf", Data: testPE()}, "is an executable"},
+		{"Script by name", Attachment{Filename: "run.BAT.", Data: []byte("echo hi")}, "is an executable"},
+		{"Macros by name", Attachment{Filename: "budget.xlsm", Data: []byte("x")}, "macros"},
+		{"Macros by content", Attachment{Filename: "budget.docx", Data: testZip(t, map[string]string{
+			"[Content_Types].xml": "<Types/>", "word/document.xml": "<w/>", "word/vbaProject.bin": "vba",
+		})}, "macros"},
+		{"Legacy document", Attachment{Filename: "letter.doc", Data: ole}, ""},
+		{"Legacy document with macros", Attachment{Filename: "letter.doc", Data: oleMacros}, "macros"},
+		{"Archive", Attachment{Filename: "docs.zip", Data: testZip(t, map[string]string{"a.txt": "a"})}, ""},
+		{"Archived program", Attachment{Filename: "docs.zip", Data: testZip(t, map[string]string{"a.txt": "a", "b/readme.js": "x"})}, `holds "b/readme.js", which is an executable`},
+		{"Nested archive", Attachment{Filename: "docs.zip", Data: testZip(t, map[string]string{"inner.zip": string(inner)})}, `holds "inner.zip", which holds "setup.exe"`},
+		{"Tarball", Attachment{Filename: "src.tar.gz", Data: testTarGz(t, "src/install", "#!/bin/sh\n")}, "which is an executable"},
+		{"Size cap", Attachment{Filename: "big.png", Data: append(append([]byte{}, pngImage...), make([]byte, 100)...)}, "the limit for image/png is 64"},
+	}
+
+	policy := DefaultAttachmentPolicy()
+	policy.MaxSize = map[string]int64{"image/*": 64, "*": 1 << 20}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.a)
+			if tt.denied == "" {
+				if err != nil {
+					t.Errorf("Check() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrAttachmentDenied) || IsRetryable(err) || !strings.Contains(err.Error(), tt.denied) {
+				t.Errorf("Check() = %v, want a permanent refusal mentioning %q", err, tt.denied)
+			}
+		})
+	}
+
+	if err := (AttachmentPolicy{}).Check(Attachment{Filename: "setup.exe", Data: testPE()}); err != nil {
+		t.Errorf("the zero policy refused an attachment: %v", err)
+	}
+}
+
+func TestAttachmentPolicyInterceptor(t *testing.T) {
+	inner := &recordingSender{}
+	sender := WrapSender(inner, AttachmentPolicyInterceptor(DefaultAttachmentPolicy()))
+
+	err := sender.Send(context.Background(), Email{Attachments: []Attachment{{Filename: "photo.jpg", Data: testPE()}}})
+	if !errors.Is(err, ErrAttachmentDenied) || inner.count() != 0 {
+		t.Fatalf("Send() = %v with %d sent, want a refusal", err, inner.count())
+	}
+
+	if err := sender.Send(context.Background(), Email{Attachments: []Attachment{{Filename: "logo", Data: pngImage}}}); err != nil {
+		t.Fatal(err)
+	}
+	sent, _ := inner.last()
+	if sent.Attachments[0].ContentType != "image/png" {
+		t.Errorf("ContentType = %q, want it sniffed", sent.Attachments[0].ContentType)
+	}
+}
+
+func TestAttachmentPolicyFilter(t *testing.T) {
+	var denied []string
+	policy := DefaultAttachmentPolicy()
+	policy.OnDenied = func(_ Email, a Attachment, _ error) { denied = append(denied, a.Filename) }
+
+	raw, err := RenderMessage(Email{
+		From:    "a@example.com",
+		To:      []string{"b@example.com"},
+		Subject: "Invoice",
+		Body:    []byte("See attached."),
+		Attachments: []Attachment{
+			{Filename: "invoice.pdf", ContentType: "application/pdf", Data: testPE()},
+			{Filename: "logo.png", ContentType: "application/pdf", Data: pngImage},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := ParseRawEmail(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := policy.Filter(received)
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "logo.png" || got.Attachments[0].ContentType != "image/png" {
+		t.Errorf("kept %+v", got.Attachments)
+	}
+	if len(denied) != 1 || denied[0] != "invoice.pdf" {
+		t.Errorf("OnDenied saw %q", denied)
+	}
+	if string(got.Body) != "See attached." {
+		t.Errorf("Body = %q", got.Body)
+	}
+
+	hooks := AttachmentPolicyFilter(policy)
+	emails, _ := hooks.Receive(context.Background(), 1, func(context.Context, int) ([]Email, error) {
+		return []Email{received}, nil
+	})
+	if len(emails) != 1 || len(emails[0].Attachments) != 1 {
+		t.Errorf("Receive kept %d messages", len(emails))
+	}
+
+	in := make(chan Email, 1)
+	in <- received
+	close(in)
+	out, _ := hooks.Idle(context.Background(), func(context.Context) (<-chan Email, <-chan error) { return in, nil })
+	if e := <-out; len(e.Attachments) != 1 {
+		t.Errorf("Idle kept %d attachments", len(e.Attachments))
+	}
+	if _, ok := <-out; ok {
+		t.Error("Idle channel not closed")
+	}
+}
+
+func TestAttachmentPolicyArchiveBudget(t *testing.T) {
+	policy := DefaultAttachmentPolicy()
+
+	files := make(map[string]string, maxArchiveEntries+1)
+	for i := range maxArchiveEntries + 1 {
+		files[fmt.Sprintf("%d.txt", i)] = ""
+	}
+	err := policy.Check(Attachment{Filename: "many.zip", Data: testZip(t, files)})
+	if !errors.Is(err, ErrAttachmentDenied) || !strings.Contains(err.Error(), "more than can be inspected") {
+		t.Errorf("Check() of %d files = %v", len(files), err)
+	}
+
+	// Once the bytes are spent, a file after them is not read but refused.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		w, _ := zw.Create(name)
+		w.Write(bytes.Repeat([]byte("a"), 10))
+	}
+	zw.Close()
+	budget := archiveBudget{bytes: 15, entries: maxArchiveEntries}
+	if reason := policy.refuse("small.zip", buf.Bytes(), "application/zip", 0, &budget); reason != "holds more than can be inspected" {
+		t.Errorf("refuse() = %q", reason)
+	}
+	budget = archiveBudget{bytes: 30, entries: maxArchiveEntries}
+	if reason := policy.refuse("small.zip", buf.Bytes(), "application/zip", 0, &budget); reason != "" {
+		t.Errorf("refuse() within the budget = %q", reason)
+	}
+}
+
+func TestAttachmentPolicyFilterIdleCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Email)
+	out, _ := AttachmentPolicyFilter(DefaultAttachmentPolicy()).Idle(ctx, func(context.Context) (<-chan Email, <-chan error) {
+		return in, nil
+	})
+	cancel()
+
+	// The receiver's sends must not block once nobody reads out.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 3 {
+			in <- Email{}
+		}
+		close(in)
+	}()
+	wg.Wait()
+	for range out {
+	}
+}
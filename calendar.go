@@ -0,0 +1,589 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarMethod is the iTIP method of an invitation (RFC 5546): what the
+// recipient's calendar is asked to do with the event.
+type CalendarMethod string
+
+const (
+	// MethodRequest invites the attendees, or updates an invitation already
+	// sent when it carries the same UID and a higher Sequence.
+	MethodRequest CalendarMethod = "REQUEST"
+	// MethodCancel withdraws the event, or one occurrence of it when
+	// RecurrenceID is set.
+	MethodCancel CalendarMethod = "CANCEL"
+	// MethodReply is an attendee answering an invitation.
+	MethodReply CalendarMethod = "REPLY"
+	// MethodPublish shares an event without asking anyone to attend, as a
+	// downloadable .ics file does.
+	MethodPublish CalendarMethod = "PUBLISH"
+)
+
+// AttendeeRole is the ROLE parameter of an attendee.
+type AttendeeRole string
+
+const (
+	RoleRequired       AttendeeRole = "REQ-PARTICIPANT"
+	RoleOptional       AttendeeRole = "OPT-PARTICIPANT"
+	RoleChair          AttendeeRole = "CHAIR"
+	RoleNonParticipant AttendeeRole = "NON-PARTICIPANT"
+)
+
+// ParticipationStatus is the PARTSTAT parameter of an attendee: their answer
+// to the invitation.
+type ParticipationStatus string
+
+const (
+	StatusNeedsAction ParticipationStatus = "NEEDS-ACTION"
+	StatusAccepted    ParticipationStatus = "ACCEPTED"
+	StatusDeclined    ParticipationStatus = "DECLINED"
+	StatusTentative   ParticipationStatus = "TENTATIVE"
+	StatusDelegated   ParticipationStatus = "DELEGATED"
+)
+
+// Attendee is one participant of a CalendarEvent.
+type Attendee struct {
+	// Address is the attendee's email address, optionally with a display
+	// name ("Bob <bob@example.com>"), which becomes the CN parameter.
+	Address string
+	// Role defaults to RoleRequired.
+	Role AttendeeRole
+	// Status defaults to StatusNeedsAction in an invitation. A REPLY must set
+	// it: it is the answer.
+	Status ParticipationStatus
+	// RSVP asks the attendee's client to send a reply.
+	RSVP bool
+}
+
+// CalendarEvent is a meeting invitation, update, cancellation or reply. Set it
+// on Email.Calendar and the message is rendered the way Outlook and Gmail
+// expect an invitation to look:
+//
+//	multipart/mixed
+//	  multipart/alternative
+//	    text/plain, text/html      the bodies, when set
+//	    text/calendar; method=...  the event, which clients turn into buttons
+//	  application/ics              the same event as invite.ics
+//
+// A calendar attached as an ordinary file is shown as one, which is why
+// building the part by hand as an Attachment does not work.
+//
+// An invitation is updated by sending it again with the same UID and a higher
+// Sequence, and withdrawn by sending it with MethodCancel, the same UID and a
+// Sequence at least as high as the last update. Clients discard a message
+// whose Sequence is lower than one they have seen, so Sequence has to be
+// stored with the UID.
+//
+// Times are written in the location they carry. A time in UTC is written as
+// UTC; a time in a named location such as one from time.LoadLocation is
+// written against that zone, with a VTIMEZONE describing it, so a weekly
+// meeting at 09:00 stays at 09:00 across a daylight-saving change. time.Local
+// has no name a calendar client can use and is written as UTC.
+type CalendarEvent struct {
+	// Method defaults to MethodRequest.
+	Method CalendarMethod
+	// UID identifies the event across updates, cancellations and replies. It
+	// is required, and must be globally unique and stable -- a database key
+	// followed by "@" and your domain is the usual choice. It cannot be
+	// generated for you, because a generated UID could not be repeated by
+	// the update that has to match it.
+	UID string
+	// Sequence is the revision of the event: 0 for the first invitation,
+	// incremented for every change that attendees need to see.
+	Sequence int
+
+	Summary     string
+	Description string
+	Location    string
+
+	// Start and End bound the event. End may be zero for an event with no
+	// duration. With AllDay set only their dates are used, and End, which
+	// is exclusive, defaults to the day after Start.
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+
+	// Recurrence is an RFC 5545 RRULE value, such as
+	// "FREQ=WEEKLY;BYDAY=MO;COUNT=10". Recurrences are expanded in the
+	// location of Start.
+	Recurrence string
+	// ExceptDates are occurrences removed from the recurrence. Each must be
+	// the start time of the occurrence it removes.
+	ExceptDates []time.Time
+	// RecurrenceID, when set, makes the message about one occurrence of a
+	// recurring event -- the one originally starting at this time -- rather
+	// than the whole series.
+	RecurrenceID time.Time
+
+	// Organizer defaults to Email.From.
+	Organizer string
+	// Attendees defaults, for a REQUEST or CANCEL, to everyone in To as a
+	// required participant and everyone in Cc as an optional one, all asked
+	// to reply. A REPLY must list exactly one attendee: the one replying.
+	Attendees []Attendee
+
+	// Stamp is the DTSTAMP of the message, when it was created. Zero means
+	// the time of rendering, fixed by PrepareMessage so retries agree.
+	Stamp time.Time
+}
+
+// ErrInvalidCalendar is wrapped by the errors reported for a CalendarEvent
+// that cannot be rendered.
+var ErrInvalidCalendar = errors.New("gsmail: invalid calendar event")
+
+// calendarFilename is the name the invitation is attached under.
+const calendarFilename = "invite.ics"
+
+// ICS renders the event as an iCalendar object, as it is sent with a message
+// from from. from supplies the organizer when Organizer is empty; to and cc
+// supply the attendees when Attendees is empty.
+func (ev *CalendarEvent) ICS(from string, to, cc []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := ev.writeICS(&buf, from, to, cc, time.Time{}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// calendarFor renders the event carried by email, if any.
+func calendarFor(email Email, stamp time.Time) ([]byte, error) {
+	if email.Calendar == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := email.Calendar.writeICS(&buf, email.From, email.To, email.Cc, stamp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CalendarAsAttachment returns email with its Calendar turned into an
+// ordinary text/calendar attachment, and Calendar cleared.
+//
+// It is for transports that build a message through a vendor API, which can
+// attach files but cannot add a part to multipart/alternative. Gmail and
+// Outlook still recognise an attachment of type text/calendar with a method
+// parameter as an invitation; some other clients only offer it as a file.
+func CalendarAsAttachment(email Email) (Email, error) {
+	if email.Calendar == nil {
+		return email, nil
+	}
+	ics, err := calendarFor(email, time.Time{})
+	if err != nil {
+		return Email{}, err
+	}
+	email.Attachments = append(slices.Clip(email.Attachments), Attachment{
+		Filename:    calendarFilename,
+		ContentType: calendarContentType(email.Calendar),
+		Data:        ics,
+	})
+	email.Calendar = nil
+	return email, nil
+}
+
+// calendarContentType is the media type of the text/calendar part. The method
+// parameter has to repeat the METHOD property; Outlook ignores the part when
+// it is missing.
+func calendarContentType(ev *CalendarEvent) string {
+	return `text/calendar; charset="UTF-8"; method=` + string(ev.method())
+}
+
+func (ev *CalendarEvent) method() CalendarMethod {
+	if ev.Method == "" {
+		return MethodRequest
+	}
+	return ev.Method
+}
+
+// validate reports the first reason the event cannot be rendered. It runs
+// before anything is written, so a streamed render never starts a message it
+// cannot finish.
+func (ev *CalendarEvent) validate(from string) error {
+	invalid := func(format string, args ...any) error {
+		return NonRetryable(fmt.Errorf("%w: "+format, append([]any{ErrInvalidCalendar}, args...)...))
+	}
+	switch ev.method() {
+	case MethodRequest, MethodCancel, MethodReply, MethodPublish:
+	default:
+		return invalid("unsupported method %q", ev.Method)
+	}
+	if strings.TrimSpace(ev.UID) == "" {
+		return invalid("UID is required")
+	}
+	if ev.Sequence < 0 {
+		return invalid("negative sequence %d", ev.Sequence)
+	}
+	if ev.method() == MethodRequest && ev.Start.IsZero() {
+		return invalid("a REQUEST needs a start time")
+	}
+	if !ev.End.IsZero() && ev.End.Before(ev.Start) {
+		return invalid("end %s is before start %s", ev.End, ev.Start)
+	}
+	if strings.ContainsFunc(ev.Recurrence, isIllegalHeaderRune) {
+		return invalid("recurrence rule contains a control character")
+	}
+	organizer := ev.Organizer
+	if organizer == "" {
+		organizer = from
+	}
+	if a, err := ParseEmailAddress(organizer); err != nil || a == nil {
+		return invalid("organizer %q is not an address", organizer)
+	}
+	for _, at := range ev.Attendees {
+		if a, err := ParseEmailAddress(at.Address); err != nil || a == nil {
+			return invalid("attendee %q is not an address", at.Address)
+		}
+	}
+	if ev.method() == MethodReply {
+		if len(ev.Attendees) != 1 {
+			return invalid("a REPLY must name exactly one attendee, got %d", len(ev.Attendees))
+		}
+		if ev.Attendees[0].Status == "" {
+			return invalid("a REPLY must carry the attendee's status")
+		}
+	}
+	return nil
+}
+
+func (ev *CalendarEvent) attendees(to, cc []string) []Attendee {
+	if m := ev.method(); len(ev.Attendees) > 0 || m == MethodReply || m == MethodPublish {
+		return ev.Attendees
+	}
+	list := make([]Attendee, 0, len(to)+len(cc))
+	for _, addr := range to {
+		list = append(list, Attendee{Address: addr, Role: RoleRequired, RSVP: true})
+	}
+	for _, addr := range cc {
+		list = append(list, Attendee{Address: addr, Role: RoleOptional, RSVP: true})
+	}
+	return list
+}
+
+func (ev *CalendarEvent) writeICS(w io.Writer, from string, to, cc []string, stamp time.Time) error {
+	if err := ev.validate(from); err != nil {
+		return err
+	}
+	if !ev.Stamp.IsZero() {
+		stamp = ev.Stamp
+	}
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	c := &icalWriter{w: w}
+	c.line("BEGIN:VCALENDAR")
+	c.line("PRODID:-//gsoultan//gsmail//EN")
+	c.line("VERSION:2.0")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:" + string(ev.method()))
+
+	for _, loc := range ev.zones() {
+		writeTimezone(c, loc, ev.Start)
+	}
+
+	c.line("BEGIN:VEVENT")
+	c.line("UID:" + icalText(ev.UID))
+	c.line("SEQUENCE:" + strconv.Itoa(ev.Sequence))
+	c.line("DTSTAMP:" + stamp.UTC().Format(icalUTC))
+	if !ev.RecurrenceID.IsZero() {
+		c.line("RECURRENCE-ID" + ev.timeValue(ev.RecurrenceID))
+	}
+	if !ev.Start.IsZero() {
+		c.line("DTSTART" + ev.timeValue(ev.Start))
+		end := ev.End
+		if ev.AllDay && end.IsZero() {
+			end = ev.Start.AddDate(0, 0, 1)
+		}
+		if !end.IsZero() {
+			c.line("DTEND" + ev.timeValue(end))
+		}
+	}
+	if ev.Recurrence != "" {
+		c.line("RRULE:" + strings.TrimPrefix(ev.Recurrence, "RRULE:"))
+	}
+	for _, t := range ev.ExceptDates {
+		c.line("EXDATE" + ev.timeValue(t))
+	}
+	if ev.Summary != "" {
+		c.line("SUMMARY:" + icalText(ev.Summary))
+	}
+	if ev.Description != "" {
+		c.line("DESCRIPTION:" + icalText(ev.Description))
+	}
+	if ev.Location != "" {
+		c.line("LOCATION:" + icalText(ev.Location))
+	}
+
+	organizer := ev.Organizer
+	if organizer == "" {
+		organizer = from
+	}
+	c.line("ORGANIZER" + icalAddress(organizer, nil))
+	for _, at := range ev.attendees(to, cc) {
+		role := at.Role
+		if role == "" {
+			role = RoleRequired
+		}
+		status := at.Status
+		if status == "" {
+			status = StatusNeedsAction
+		}
+		params := []string{"ROLE=" + string(role), "PARTSTAT=" + string(status)}
+		if at.RSVP {
+			params = append(params, "RSVP=TRUE")
+		}
+		c.line("ATTENDEE" + icalAddress(at.Address, params))
+	}
+
+	switch ev.method() {
+	case MethodCancel:
+		c.line("STATUS:CANCELLED")
+	case MethodRequest:
+		c.line("STATUS:CONFIRMED")
+	}
+	c.line("END:VEVENT")
+	c.line("END:VCALENDAR")
+	return c.err
+}
+
+const (
+	icalUTC   = "20060102T150405Z"
+	icalLocal = "20060102T150405"
+	icalDate  = "20060102"
+)
+
+// timeValue formats t as the parameters and value of a date-time property,
+// from the semicolon or colon that follows the property name.
+func (ev *CalendarEvent) timeValue(t time.Time) string {
+	if ev.AllDay {
+		return ";VALUE=DATE:" + t.Format(icalDate)
+	}
+	if name, ok := icalZone(t.Location()); ok {
+		return ";TZID=" + icalParam(name) + ":" + t.Format(icalLocal)
+	}
+	return ":" + t.UTC().Format(icalUTC)
+}
+
+// icalZone returns the TZID a location is written under, or false when its
+// times are written as UTC.
+func icalZone(loc *time.Location) (string, bool) {
+	switch name := loc.String(); name {
+	case "", "UTC", "Local":
+		return "", false
+	default:
+		return name, true
+	}
+}
+
+// zones lists the locations that need a VTIMEZONE, in order of first use.
+func (ev *CalendarEvent) zones() []*time.Location {
+	if ev.AllDay {
+		return nil
+	}
+	var zones []*time.Location
+	seen := make(map[string]bool)
+	for _, t := range append([]time.Time{ev.Start, ev.End, ev.RecurrenceID}, ev.ExceptDates...) {
+		if t.IsZero() {
+			continue
+		}
+		if name, ok := icalZone(t.Location()); ok && !seen[name] {
+			seen[name] = true
+			zones = append(zones, t.Location())
+		}
+	}
+	return zones
+}
+
+// writeTimezone writes a VTIMEZONE for loc, derived from the offset changes
+// it makes in the year of ref.
+//
+// Go does not expose a zone's rules, only the offset in force at an instant,
+// so the transitions are found by searching. When there are two -- a zone
+// with daylight saving -- each is generalised to a yearly rule of the form
+// "second Sunday of March", which is how every zone that observes it is
+// defined today, and which keeps a long-running series correct after the
+// first year. Any other pattern is written as the transitions themselves.
+func writeTimezone(c *icalWriter, loc *time.Location, ref time.Time) {
+	year := ref.In(loc).Year()
+	transitions := zoneTransitions(loc, year)
+
+	c.line("BEGIN:VTIMEZONE")
+	c.line("TZID:" + icalText(loc.String()))
+	if len(transitions) != 2 {
+		// The offset in force at the start of the year, from before any
+		// transition listed after it.
+		jan := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		name, offset := jan.Zone()
+		writeObservance(c, jan.IsDST(), "19700101T000000", offset, offset, name, "")
+	}
+	for _, tr := range transitions {
+		rule := ""
+		if len(transitions) == 2 {
+			rule = yearlyRule(tr.wall)
+		}
+		writeObservance(c, tr.dst, tr.wall.Format(icalLocal), tr.from, tr.to, tr.name, rule)
+	}
+	c.line("END:VTIMEZONE")
+}
+
+func writeObservance(c *icalWriter, dst bool, start string, from, to int, name, rule string) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	c.line("BEGIN:" + kind)
+	c.line("DTSTART:" + start)
+	c.line("TZOFFSETFROM:" + icalOffset(from))
+	c.line("TZOFFSETTO:" + icalOffset(to))
+	if name != "" {
+		c.line("TZNAME:" + icalText(name))
+	}
+	if rule != "" {
+		c.line("RRULE:" + rule)
+	}
+	c.line("END:" + kind)
+}
+
+// zoneTransition is one change of offset. wall is the local time at which it
+// happens, read on the clock before the change, which is how a VTIMEZONE
+// observance states its start.
+type zoneTransition struct {
+	wall     time.Time
+	from, to int
+	name     string
+	dst      bool
+}
+
+// zoneTransitions finds the offset changes loc makes during year, by stepping
+// a day at a time and narrowing each change down to the second.
+func zoneTransitions(loc *time.Location, year int) []zoneTransition {
+	var out []zoneTransition
+	t := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := t.AddDate(1, 0, 0)
+	_, offset := t.In(loc).Zone()
+	for t.Before(end) {
+		next := t.Add(24 * time.Hour)
+		if _, o := next.In(loc).Zone(); o != offset {
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.In(loc).Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			after := hi.In(loc)
+			name, to := after.Zone()
+			out = append(out, zoneTransition{
+				wall: hi.Add(time.Duration(offset) * time.Second).UTC(),
+				from: offset,
+				to:   to,
+				name: name,
+				dst:  after.IsDST(),
+			})
+			offset = to
+		}
+		t = next
+	}
+	return out
+}
+
+// yearlyRule describes the date of wall as a weekday of its month: "the
+// second Sunday", or "the last Sunday" when it falls in the final week.
+func yearlyRule(wall time.Time) string {
+	day := wall.Day()
+	daysInMonth := time.Date(wall.Year(), wall.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	n := strconv.Itoa((day-1)/7 + 1)
+	if day+7 > daysInMonth {
+		n = "-1"
+	}
+	weekday := strings.ToUpper(wall.Weekday().String()[:2])
+	return "FREQ=YEARLY;BYMONTH=" + strconv.Itoa(int(wall.Month())) + ";BYDAY=" + n + weekday
+}
+
+func icalOffset(seconds int) string {
+	sign := byte('+')
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if sec := seconds % 60; sec != 0 {
+		s += fmt.Sprintf("%02d", sec)
+	}
+	return s
+}
+
+// icalAddress formats a calendar user as the parameters and value of an
+// ORGANIZER or ATTENDEE property: the display name as CN, then the mailto
+// URI. The address has already been validated.
+func icalAddress(s string, params []string) string {
+	a, _ := ParseEmailAddress(s)
+	var b strings.Builder
+	if a.Name != "" {
+		b.WriteString(";CN=" + icalParam(a.Name))
+	}
+	for _, p := range params {
+		b.WriteString(";" + p)
+	}
+	b.WriteString(":mailto:" + a.Address)
+	return b.String()
+}
+
+// icalParam quotes a parameter value when it holds a character that would
+// otherwise end it. A double quote cannot appear even quoted, so it is
+// dropped.
+func icalParam(s string) string {
+	s = strings.ReplaceAll(sanitizeHeaderValue(s), `"`, "")
+	if strings.ContainsAny(s, ";:,") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// icalText escapes a TEXT value (RFC 5545 section 3.3.11).
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func icalText(s string) string {
+	return sanitizeHeaderValue(icalTextEscaper.Replace(s))
+}
+
+// icalWriter writes content lines, folded at 75 octets as RFC 5545 requires
+// and never inside a UTF-8 sequence. The first error sticks.
+type icalWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *icalWriter) line(s string) {
+	if c.err != nil {
+		return
+	}
+	var b strings.Builder
+	width := 75
+	for len(s) > width {
+		cut := width
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// The leading space of a continuation counts towards its length.
+		width = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, c.err = io.WriteString(c.w, b.String())
+}
@@ -0,0 +1,270 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func testEvent(t *testing.T) *CalendarEvent {
+	t.Helper()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CalendarEvent{
+		UID:        "standup-42@example.com",
+		Summary:    "Standup",
+		Location:   "Room 4, second floor",
+		Start:      time.Date(2026, time.October, 19, 9, 0, 0, 0, ny),
+		End:        time.Date(2026, time.October, 19, 9, 15, 0, 0, ny),
+		Recurrence: "FREQ=WEEKLY;BYDAY=MO",
+		Stamp:      time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// icsLines unfolds an iCalendar object into its content lines.
+func icsLines(t *testing.T, ics []byte) []string {
+	t.Helper()
+	for _, line := range strings.Split(string(ics), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+	unfolded := strings.ReplaceAll(string(ics), "\r\n ", "")
+	return strings.Split(strings.TrimSuffix(unfolded, "\r\n"), "\r\n")
+}
+
+func hasLine(lines []string, want string) bool {
+	for _, l := range lines {
+		if l == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCalendarEventICS(t *testing.T) {
+	ev := testEvent(t)
+	ics, err := ev.ICS("Alice <alice@example.com>", []string{"Bob <bob@example.com>"}, []string{"carol@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := icsLines(t, ics)
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR",
+		"METHOD:REQUEST",
+		"UID:standup-42@example.com",
+		"SEQUENCE:0",
+		"DTSTAMP:20261016T120000Z",
+		"DTSTART;TZID=America/New_York:20261019T090000",
+		"DTEND;TZID=America/New_York:20261019T091500",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO",
+		`LOCATION:Room 4\, second floor`,
+		"ORGANIZER;CN=Alice:mailto:alice@example.com",
+		"ATTENDEE;CN=Bob;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:bob@example.com",
+		"ATTENDEE;ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:carol@example.com",
+		"STATUS:CONFIRMED",
+		// The zone, generalised to yearly rules so the series survives DST.
+		"TZID:America/New_York",
+		"DTSTART:20260308T020000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"DTSTART:20261101T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+		"END:VCALENDAR",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q in:\n%s", want, ics)
+		}
+	}
+}
+
+func TestCalendarEventCancel(t *testing.T) {
+	ev := testEvent(t)
+	ev.Method = MethodCancel
+	ev.Sequence = 2
+	ics, err := ev.ICS("alice@example.com", []string{"bob@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := icsLines(t, ics)
+	for _, want := range []string{"METHOD:CANCEL", "SEQUENCE:2", "STATUS:CANCELLED"} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q in:\n%s", want, ics)
+		}
+	}
+}
+
+func TestCalendarEventAllDayAndUTC(t *testing.T) {
+	ics, err := (&CalendarEvent{
+		UID:    "offsite@example.com",
+		Start:  time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+	}).ICS("alice@example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := icsLines(t, ics)
+	if !hasLine(lines, "DTSTART;VALUE=DATE:20261201") || !hasLine(lines, "DTEND;VALUE=DATE:20261202") {
+		t.Errorf("all-day dates missing in:\n%s", ics)
+	}
+	if bytes.Contains(ics, []byte("VTIMEZONE")) {
+		t.Error("an all-day event needs no VTIMEZONE")
+	}
+
+	ics, err = (&CalendarEvent{
+		UID:   "call@example.com",
+		Start: time.Date(2026, time.December, 1, 15, 30, 0, 0, time.UTC),
+	}).ICS("alice@example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasLine(icsLines(t, ics), "DTSTART:20261201T153000Z") {
+		t.Errorf("UTC start missing in:\n%s", ics)
+	}
+}
+
+func TestCalendarEventEscapesAndFolds(t *testing.T) {
+	ev := testEvent(t)
+	ev.Description = "Agenda; status, blockers\nand a very long line of notes that keeps on going well past the fold — ünïcödé included"
+	ics, err := ev.ICS("alice@example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `DESCRIPTION:Agenda\; status\, blockers\nand a very long line of notes that keeps on going well past the fold — ünïcödé included`
+	if !hasLine(icsLines(t, ics), want) {
+		t.Errorf("missing %q in:\n%s", want, ics)
+	}
+}
+
+func TestCalendarEventValidation(t *testing.T) {
+	start := time.Date(2026, time.December, 1, 15, 30, 0, 0, time.UTC)
+	for name, ev := range map[string]*CalendarEvent{
+		"no UID":            {Start: start},
+		"no start":          {UID: "x@example.com"},
+		"end before start":  {UID: "x@example.com", Start: start, End: start.Add(-time.Hour)},
+		"unknown method":    {UID: "x@example.com", Start: start, Method: "PUBLISH-ALL"},
+		"reply with no one": {UID: "x@example.com", Method: MethodReply},
+		"reply with no answer": {UID: "x@example.com", Method: MethodReply,
+			Attendees: []Attendee{{Address: "bob@example.com"}}},
+		"injected rule": {UID: "x@example.com", Start: start, Recurrence: "FREQ=DAILY\r\nATTENDEE:mailto:eve@example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := RenderMessage(Email{From: "alice@example.com", To: []string{"bob@example.com"}, Body: []byte("hi"), Calendar: ev})
+			if !errors.Is(err, ErrInvalidCalendar) || IsRetryable(err) {
+				t.Errorf("expected a permanent ErrInvalidCalendar, got %v", err)
+			}
+		})
+	}
+}
+
+// The invitation has to be an alternative of the body, not only a file, or
+// clients show an attachment instead of Accept and Decline buttons.
+func TestCalendarMessageStructure(t *testing.T) {
+	msg, err := RenderMessage(Email{
+		From:     "alice@example.com",
+		To:       []string{"bob@example.com"},
+		Subject:  "Standup",
+		Body:     []byte("Weekly standup"),
+		HTMLBody: []byte("<p>Weekly standup</p>"),
+		Calendar: testEvent(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("top level is %s", mediaType)
+	}
+	top := multipart.NewReader(m.Body, params["boundary"])
+
+	alt, err := top.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ = mime.ParseMediaType(alt.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("first part is %s", mediaType)
+	}
+	var types []string
+	ar := multipart.NewReader(alt, params["boundary"])
+	for {
+		p, err := ar.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, p.Header.Get("Content-Type"))
+	}
+	if len(types) != 3 || types[2] != `text/calendar; charset="UTF-8"; method=REQUEST` {
+		t.Errorf("alternatives = %q", types)
+	}
+
+	ics, err := top.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ics.Header.Get("Content-Type") != "application/ics" || ics.FileName() != "invite.ics" {
+		t.Errorf("attachment = %q %q", ics.Header.Get("Content-Type"), ics.FileName())
+	}
+}
+
+// DTSTAMP is generated, so a prepared message has to fix it with the other
+// generated values or a retry would differ from the first attempt.
+func TestPreparedCalendarIsStable(t *testing.T) {
+	ev := testEvent(t)
+	ev.Stamp = time.Time{}
+	pm, err := PrepareMessage(Email{From: "alice@example.com", To: []string{"bob@example.com"}, Calendar: ev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first, second bytes.Buffer
+	if _, err := pm.WriteTo(&first); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := pm.WriteTo(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("two renders of a prepared invitation differ")
+	}
+
+	if _, err := PrepareMessage(Email{From: "alice@example.com", Calendar: &CalendarEvent{}}); !errors.Is(err, ErrInvalidCalendar) {
+		t.Errorf("PrepareMessage should refuse an invalid event, got %v", err)
+	}
+}
+
+func TestCalendarAsAttachment(t *testing.T) {
+	email := Email{From: "alice@example.com", To: []string{"bob@example.com"}, Calendar: testEvent(t)}
+	got, err := CalendarAsAttachment(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calendar != nil || len(got.Attachments) != 1 {
+		t.Fatalf("calendar = %v, attachments = %d", got.Calendar, len(got.Attachments))
+	}
+	att := got.Attachments[0]
+	if att.Filename != "invite.ics" || !strings.HasPrefix(att.ContentType, "text/calendar;") || !strings.Contains(att.ContentType, "method=REQUEST") {
+		t.Errorf("attachment = %q %q", att.Filename, att.ContentType)
+	}
+	if email.Calendar == nil {
+		t.Error("the caller's Email was modified")
+	}
+}
@@ -0,0 +1,325 @@
+package gsmail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// Inbound text is transcoded to UTF-8 at the boundary, like the sanitising in
+// ParseRawEmail: an Email holds UTF-8 however the sender's client encoded it.
+//
+// Mail from Japanese, Chinese and legacy European clients still arrives as
+// ISO-2022-JP, Shift_JIS, GB18030 or windows-1252, and mime only undoes the
+// transfer encoding -- and its WordDecoder refuses any charset but UTF-8 and
+// ISO-8859-1 -- so those bodies and subjects were handed over as bytes in a
+// charset nothing recorded.
+//
+// Charset labels are resolved as browsers resolve them (the WHATWG Encoding
+// Standard), which is also what mail clients do in practice: "iso-8859-1"
+// and "us-ascii" mean windows-1252, "gb2312" means GBK. The declaration is
+// trusted only while the bytes agree with it; see toUTF8.
+
+// wordDecoder decodes RFC 2047 encoded-words in any charset toUTF8 knows.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// charsetReader is the mime.WordDecoder hook.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(io.LimitReader(input, maxHeaderValueLen))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(toUTF8(data, label)), nil
+}
+
+// lookupCharset resolves a charset label, returning the encoding and its
+// canonical name, or nil for a label nobody recognises.
+func lookupCharset(label string) (encoding.Encoding, string) {
+	label = strings.ToLower(strings.Trim(strings.TrimSpace(label), `"'`))
+	if label == "" {
+		return nil, ""
+	}
+	if alias, ok := codePageAliases[label]; ok {
+		label = alias
+	}
+	if enc, err := htmlindex.Get(label); err == nil {
+		name, _ := htmlindex.Name(enc)
+		return enc, name
+	}
+	// Registered names the web never adopted, such as IBM code pages.
+	if enc, err := ianaindex.IANA.Encoding(label); err == nil && enc != nil {
+		name, _ := ianaindex.IANA.Name(enc)
+		return enc, strings.ToLower(name)
+	}
+	return nil, ""
+}
+
+// codePageAliases are the Windows code page names Outlook and older Windows
+// clients put in charset parameters, which neither index knows.
+var codePageAliases = map[string]string{
+	"cp932": "shift_jis",
+	"cp936": "gbk",
+	"cp949": "euc-kr",
+	"cp950": "big5",
+}
+
+// toUTF8 transcodes text declared to be in charset to UTF-8.
+//
+// Declarations are often missing and sometimes wrong, so the bytes have the
+// last word: text that is valid UTF-8 under a single-byte declaration is
+// taken as UTF-8 -- a client labelling UTF-8 as ISO-8859-1 is far commoner
+// than Latin-1 text that happens to be valid UTF-8 -- and text the declared
+// charset cannot decode, or that declares nothing and is not UTF-8, goes to
+// detectCharset.
+func toUTF8(data []byte, charset string) []byte {
+	enc, name := lookupCharset(charset)
+	if isASCII(data) && !bytes.Contains(data, []byte{0x1b}) && !strings.HasPrefix(name, "utf-16") {
+		// ASCII reads the same in every charset mail uses, except UTF-16
+		// and the ISO-2022 family, whose escapes are themselves ASCII.
+		return data
+	}
+	switch {
+	case enc == nil:
+		return decodeWith(data, detectCharset(data))
+	case name == "utf-8":
+		if utf8.Valid(data) {
+			return data
+		}
+		if mostlyUTF8(data) {
+			// A stray byte in UTF-8 text, not text in another charset:
+			// decoding it all as something else would garble every letter
+			// to mend one.
+			return bytes.ToValidUTF8(data, []byte("�"))
+		}
+		return decodeWith(data, detectCharset(data))
+	}
+	if _, singleByte := enc.(*charmap.Charmap); singleByte && utf8.Valid(data) {
+		return data
+	}
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil || (bytes.ContainsRune(out, utf8.RuneError) && !bytes.ContainsRune(data, utf8.RuneError)) {
+		return decodeWith(data, detectCharset(data))
+	}
+	return out
+}
+
+// decodeWith decodes data with enc, falling back to the bytes unchanged --
+// with anything invalid replaced, so the result is UTF-8 either way.
+func decodeWith(data []byte, enc encoding.Encoding) []byte {
+	if enc == nil {
+		return bytes.ToValidUTF8(data, []byte("�"))
+	}
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return bytes.ToValidUTF8(data, []byte("�"))
+	}
+	return out
+}
+
+// candidateCharsets are what detectCharset chooses between, in the order a
+// tie is settled.
+var candidateCharsets = []struct {
+	enc    encoding.Encoding
+	script byte // 'l'atin, 'j'apanese, 'c'hinese or 'k'orean
+}{
+	{charmap.Windows1252, 'l'},
+	{japanese.ShiftJIS, 'j'},
+	{japanese.EUCJP, 'j'},
+	{simplifiedchinese.GB18030, 'c'},
+	{traditionalchinese.Big5, 'c'},
+	{korean.EUCKR, 'k'},
+}
+
+// detectCharset guesses the charset of undeclared or misdeclared text, or
+// returns nil for UTF-8 and for text no candidate decodes plausibly.
+//
+// It is a heuristic and cannot be more. ISO-2022-JP announces itself with
+// escape sequences and UTF-8 is recognised by its structure; for the rest
+// each candidate decodes the text and the most plausible result wins. A
+// decoding that produces invalid sequences is out; otherwise the script a
+// decoding yields is scored for what real text in that charset contains --
+// kana for Japanese, common characters for Chinese and Korean, accented
+// letters for windows-1252 -- because every multibyte charset will happily
+// decode the others' bytes into something.
+func detectCharset(data []byte) encoding.Encoding {
+	if bytes.Contains(data, []byte("\x1b$B")) || bytes.Contains(data, []byte("\x1b$@")) || bytes.Contains(data, []byte("\x1b(J")) {
+		return japanese.ISO2022JP
+	}
+	if utf8.Valid(data) {
+		return nil
+	}
+	var best encoding.Encoding
+	bestScore := 0
+	for _, c := range candidateCharsets {
+		out, err := c.enc.NewDecoder().Bytes(data)
+		if err != nil || bytes.ContainsRune(out, utf8.RuneError) {
+			continue
+		}
+		score := 0
+		for _, r := range string(out) {
+			score += runeScore(r, c.script)
+		}
+		if score > bestScore {
+			best, bestScore = c.enc, score
+		}
+	}
+	return best
+}
+
+// mostlyUTF8 reports whether data holds more well-formed multibyte UTF-8
+// sequences than bytes that are not UTF-8.
+func mostlyUTF8(data []byte) bool {
+	valid, invalid := 0, 0
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			invalid++
+		case size > 1:
+			valid++
+		}
+		data = data[size:]
+	}
+	return valid > invalid
+}
+
+// runeScore is how much a decoded rune suggests the decoding was right.
+func runeScore(r rune, script byte) int {
+	switch {
+	case r < 0x80:
+		return 0
+	case r < 0xa0, r >= 0xe000 && r <= 0xf8ff:
+		// C1 controls and private use: what a wrong guess decodes to.
+		return -5
+	case r >= 0xc0 && r <= 0x24f:
+		if script == 'l' {
+			return 1
+		}
+		return -1
+	case r >= 0x3040 && r <= 0x30ff:
+		if script == 'j' {
+			return 2
+		}
+		return -2
+	case r >= 0xac00 && r <= 0xd7a3:
+		if script != 'k' {
+			return 0
+		}
+		if strings.ContainsRune(commonHangul, r) {
+			return 3
+		}
+		return 1
+	case r >= 0x4e00 && r <= 0x9fff:
+		switch script {
+		case 'j':
+			return 1
+		case 'c':
+			if strings.ContainsRune(commonHanzi, r) {
+				return 3
+			}
+			return 1
+		}
+		return 0
+	case r >= 0xff61 && r <= 0xff9f:
+		// Half-width katakana: rare in real text, and what Shift_JIS makes
+		// of single high bytes.
+		return -1
+	}
+	return 0
+}
+
+// commonHanzi and commonHangul are among the most frequent characters in
+// Chinese (in both simplified and traditional forms) and Korean text. Their
+// presence is what tells a right decoding from a plausible-looking wrong one.
+const (
+	commonHanzi  = "的一是不了在人有我他这這个個们們中来來上大为為和国國地到以说說时時要就出会會可也你对對生能而子那得于着下自之年过過发發后後作里裡用道行所然家种種事成方多经經么麼去法学學如都同现現当當没沒动動面起看定天分还還进進好小部其些主样樣理心她本前开開但因只从從想实實"
+	commonHangul = "이다는의에가을를하고서지기한로으도리자사니아그수나어대전것들요일게보면만있"
+)
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// headerText returns a header value as UTF-8 text: encoded-words decoded in
+// whatever charset they name, and raw 8-bit bytes -- which RFC 5322 forbids
+// but Japanese and Chinese clients send -- transcoded by detection.
+func headerText(v string) string {
+	v = eightBitText(v)
+	if strings.Contains(v, "=?") {
+		if decoded, err := wordDecoder.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+	}
+	return v
+}
+
+// eightBitText transcodes a header value sent as raw 8-bit or ISO-2022
+// bytes, leaving its structure -- and any encoded-words -- as they were.
+func eightBitText(v string) string {
+	if utf8.ValidString(v) && !strings.Contains(v, "\x1b") {
+		return v
+	}
+	return string(toUTF8([]byte(v), ""))
+}
+
+// decodedAddress returns a single address header with an encoded display
+// name decoded. A value without one is returned as it was sent, as
+// ParseRawEmail always has.
+func decodedAddress(v string) string {
+	v = eightBitText(v)
+	if !strings.Contains(v, "=?") {
+		return v
+	}
+	a, err := ParseAddress(v)
+	if err != nil {
+		return v
+	}
+	return a.String()
+}
+
+// rfc2231Charset matches the charset of an RFC 2231 extended parameter:
+// `filename*=iso-2022-jp'ja'...` or the first segment of a continued one.
+var rfc2231Charset = regexp.MustCompile(`(?i)(;\s*([a-z0-9!#$&+.^_|~-]+)\*(?:0\*)?\s*=\s*)([^'";\s]*)'`)
+
+// parseParams is mime.ParseMediaType with the parameter values transcoded to
+// UTF-8.
+//
+// mime decodes an RFC 2231 value only in UTF-8 or US-ASCII and drops the
+// parameter for any other charset, so the charset is swapped for "utf-8"
+// before parsing, which leaves the bytes alone, and the value is transcoded
+// from the real one after. Outlook's RFC 2047 encoded-words inside a quoted
+// filename, which no RFC allows and every client reads, are decoded as well.
+func parseParams(v string) (string, map[string]string, error) {
+	charsets := make(map[string]string)
+	v = rfc2231Charset.ReplaceAllStringFunc(v, func(m string) string {
+		sub := rfc2231Charset.FindStringSubmatch(m)
+		charsets[strings.ToLower(sub[2])] = sub[3]
+		return sub[1] + "utf-8'"
+	})
+	mediaType, params, err := mime.ParseMediaType(v)
+	for k, p := range params {
+		if cs, ok := charsets[k]; ok {
+			params[k] = string(toUTF8([]byte(p), cs))
+			continue
+		}
+		params[k] = headerText(p)
+	}
+	return mediaType, params, err
+}
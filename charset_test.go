@@ -0,0 +1,155 @@
+package gsmail
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encodeIn(t *testing.T, enc encoding.Encoding, s string) string {
+	t.Helper()
+	out, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestParseRawEmailISO2022JP(t *testing.T) {
+	jis := func(s string) string { return encodeIn(t, japanese.ISO2022JP, s) }
+	word := func(s string) string {
+		return "=?ISO-2022-JP?B?" + base64.StdEncoding.EncodeToString([]byte(jis(s))) + "?="
+	}
+	raw := "From: " + word("山田太郎") + " <taro@example.jp>\r\n" +
+		"To: " + word("佐藤, 花子") + " <hanako@example.jp>, bo@example.com\r\n" +
+		"Subject: " + word("会議のお知らせ") + "\r\n" +
+		"Content-Type: text/plain; charset=ISO-2022-JP\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"\r\n" +
+		jis("明日の会議は十時からです。") + "\r\n"
+
+	e, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "会議のお知らせ" {
+		t.Errorf("subject = %q", e.Subject)
+	}
+	if e.From != `"山田太郎" <taro@example.jp>` {
+		t.Errorf("from = %q", e.From)
+	}
+	if len(e.To) != 2 || e.To[0] != `"佐藤, 花子" <hanako@example.jp>` || e.To[1] != "bo@example.com" {
+		t.Errorf("to = %q", e.To)
+	}
+	if string(e.Body) != "明日の会議は十時からです。\r\n" {
+		t.Errorf("body = %q", e.Body)
+	}
+
+	// The decoded names go back out encoded, with the comma kept inside the
+	// display name rather than splitting the address.
+	if got := FormatAddress(e.To[0]); !strings.HasPrefix(got, "=?utf-8?") || !strings.HasSuffix(got, " <hanako@example.jp>") {
+		t.Errorf("formatted = %q", got)
+	}
+}
+
+func TestParseRawEmailMultipartCharsets(t *testing.T) {
+	sjis := encodeIn(t, japanese.ShiftJIS, "お疲れ様です")
+	latin := encodeIn(t, charmap.Windows1252, "Prix : 20 € — café")
+	raw := "From: a@example.com\r\n" +
+		"Subject: " + encodeIn(t, japanese.ShiftJIS, "見積書") + "\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=\"Shift_JIS\"\r\n" +
+		"\r\n" + sjis + "\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html; charset=iso-8859-1\r\n" +
+		"\r\n<p>" + latin + "</p>\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename*=shift_jis''%8C%A9%90%CF.pdf\r\n" +
+		"\r\n%PDF\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"=?ISO-2022-JP?B?" + base64.StdEncoding.EncodeToString([]byte(encodeIn(t, japanese.ISO2022JP, "請求書"))) + "?=.pdf\"\r\n" +
+		"\r\n%PDF\r\n" +
+		"--b--\r\n"
+
+	e, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw 8-bit Shift_JIS in a header, which RFC 5322 forbids and Japanese
+	// clients send anyway.
+	if e.Subject != "見積書" {
+		t.Errorf("subject = %q", e.Subject)
+	}
+	if string(e.Body) != "お疲れ様です" {
+		t.Errorf("body = %q", e.Body)
+	}
+	// iso-8859-1 is read as windows-1252, which is where the euro sign is.
+	if string(e.HTMLBody) != "<p>Prix : 20 € — café</p>" {
+		t.Errorf("html = %q", e.HTMLBody)
+	}
+	if len(e.Attachments) != 2 || e.Attachments[0].Filename != "見積.pdf" || e.Attachments[1].Filename != "請求書.pdf" {
+		t.Errorf("attachments = %+v", e.Attachments)
+	}
+	// Attachment content is data, never transcoded.
+	if string(e.Attachments[0].Data) != "%PDF" {
+		t.Errorf("attachment data = %q", e.Attachments[0].Data)
+	}
+}
+
+func TestToUTF8Declarations(t *testing.T) {
+	sjis := encodeIn(t, japanese.ShiftJIS, "ありがとうございます")
+	for _, tc := range []struct {
+		name, data, charset, want string
+	}{
+		{"ascii untouched", "hello", "shift_jis", "hello"},
+		{"utf-8 mislabelled latin-1", "café", "iso-8859-1", "café"},
+		{"shift_jis mislabelled utf-8", sjis, "utf-8", "ありがとうございます"},
+		{"undeclared shift_jis", sjis, "", "ありがとうございます"},
+		{"unknown label", sjis, "x-nonsense", "ありがとうございます"},
+		{"windows code page", sjis, "cp932", "ありがとうございます"},
+		{"utf-16", encodeIn(t, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "hi"), "utf-16le", "hi"},
+		{"utf-8 with a stray byte", "Grüße aus München, schöne Tage\xff", "utf-8", "Grüße aus München, schöne Tage�"},
+		{"latin-1 mislabelled utf-8", encodeIn(t, charmap.Windows1252, "Grüße aus München"), "utf-8", "Grüße aus München"},
+	} {
+		if got := string(toUTF8([]byte(tc.data), tc.charset)); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Each sample is ordinary text in its charset, undeclared; the detector has
+// to tell them apart although every multibyte charset decodes the others.
+func TestDetectCharset(t *testing.T) {
+	for _, tc := range []struct {
+		enc  encoding.Encoding
+		text string
+	}{
+		{charmap.Windows1252, "Grüße aus Köln, à bientôt"},
+		{japanese.ShiftJIS, "本日はお忙しいところありがとうございました。"},
+		{japanese.EUCJP, "本日はお忙しいところありがとうございました。"},
+		{simplifiedchinese.GB18030, "我们的会议在下午三点开始，请大家准时到达。"},
+		{traditionalchinese.Big5, "我們的會議在下午三點開始，請大家準時到達。"},
+		{korean.EUCKR, "안녕하세요. 내일 회의는 오후 세 시에 있습니다."},
+	} {
+		data := []byte(encodeIn(t, tc.enc, tc.text))
+		if got := string(decodeWith(data, detectCharset(data))); got != tc.text {
+			t.Errorf("%v: got %q", tc.enc, got)
+		}
+	}
+	// No candidate decodes this to anything plausible, so none is guessed.
+	if enc := detectCharset([]byte("abc\x85")); enc != nil {
+		t.Errorf("implausible text: got %v", enc)
+	}
+}
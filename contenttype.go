@@ -0,0 +1,200 @@
+package gsmail
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DetectContentType returns the media type of an attachment from its content,
+// falling back on the file name's extension where the content says nothing
+// more specific than text or bytes. It never returns "".
+//
+// The content is matched against the signatures net/http knows -- images,
+// PDF, audio, video, fonts and the common archives -- and against those of
+// executables (PE, ELF, Mach-O, scripts with a #! line), 7-Zip, CAB, tar and
+// OLE compound files. A ZIP archive holding an Office Open XML document is
+// reported as that document, macro-enabled or not, which needs the whole
+// archive; given less, it is reported as application/zip.
+func DetectContentType(data []byte, filename string) string {
+	ct, _ := sniffContentType(data, filename)
+	return ct
+}
+
+// DetectContentType is DetectContentType for the attachment's content and
+// file name. An attachment backed by Open is read in full.
+func (a Attachment) DetectContentType() (string, error) {
+	data, err := a.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return DetectContentType(data, a.Filename), nil
+}
+
+// SniffContentTypes returns email with the ContentType of each attachment
+// replaced by the detected one where it is missing, is the catch-all
+// application/octet-stream, or contradicts the content: a PNG declared as
+// application/pdf, or an executable declared as image/jpeg. A declared type
+// the content cannot disprove is kept, so application/epub+zip on a ZIP file,
+// or text/csv on text, stays as it is.
+//
+// The attachments are copied; email's own slice is not modified. Inline
+// attachments are corrected too, since a client decides from the type
+// whether a cid: image can be shown.
+func SniffContentTypes(email Email) (Email, error) {
+	if len(email.Attachments) == 0 {
+		return email, nil
+	}
+	attachments := make([]Attachment, len(email.Attachments))
+	for i, a := range email.Attachments {
+		data, err := a.Bytes()
+		if err != nil {
+			return email, err
+		}
+		a.ContentType = correctContentType(a.ContentType, data, a.Filename)
+		attachments[i] = a
+	}
+	email.Attachments = attachments
+	return email, nil
+}
+
+// correctContentType is the type SniffContentTypes gives an attachment
+// declared as declared.
+func correctContentType(declared string, data []byte, filename string) string {
+	sniffed, certain := sniffContentType(data, filename)
+	media, _, err := mime.ParseMediaType(declared)
+	switch {
+	case err != nil || media == "application/octet-stream":
+		return sniffed
+	case certain && !strings.EqualFold(media, sniffed):
+		return sniffed
+	}
+	return declared
+}
+
+// sniffContentType detects the type of data, reporting whether the content
+// alone proves it. Text, generic containers and types taken from the file name
+// prove nothing, since a declared type more specific than them may be right.
+func sniffContentType(data []byte, filename string) (string, bool) {
+	if isPortableExecutable(data) {
+		return contentTypePE, true
+	}
+	for _, sig := range contentSignatures {
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType, sig.certain
+		}
+	}
+
+	sniffed := http.DetectContentType(data)
+	if i := strings.IndexByte(sniffed, ';'); i >= 0 {
+		sniffed = sniffed[:i]
+	}
+	switch sniffed {
+	case "application/zip":
+		if office := ooxmlContentType(data); office != "" {
+			return office, true
+		}
+		return sniffed, false
+	case "application/x-gzip":
+		// A compressed tarball is declared as one of a dozen types.
+		return sniffed, false
+	}
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/") {
+		return sniffed, true
+	}
+	if byExt := mime.TypeByExtension(fileExtension(filename)); byExt != "" {
+		if media, _, err := mime.ParseMediaType(byExt); err == nil {
+			return media, false
+		}
+	}
+	return sniffed, false
+}
+
+// Media types of the executables contentSignatures recognises.
+const (
+	contentTypePE     = "application/vnd.microsoft.portable-executable"
+	contentTypeELF    = "application/x-executable"
+	contentTypeMachO  = "application/x-mach-binary"
+	contentTypeScript = "text/x-shellscript"
+	contentTypeOLE    = "application/x-ole-storage"
+)
+
+// contentSignatures are the signatures net/http does not know, tried before
+// it. An OLE compound file is a legacy Office document, an MSI package or an
+// Outlook message alike, so it proves nothing beyond being one.
+var contentSignatures = []struct {
+	offset      int
+	magic       []byte
+	contentType string
+	certain     bool
+}{
+	{0, []byte("\x7fELF"), contentTypeELF, true},
+	{0, []byte("\xfe\xed\xfa\xce"), contentTypeMachO, true},
+	{0, []byte("\xfe\xed\xfa\xcf"), contentTypeMachO, true},
+	{0, []byte("\xce\xfa\xed\xfe"), contentTypeMachO, true},
+	{0, []byte("\xcf\xfa\xed\xfe"), contentTypeMachO, true},
+	{0, []byte("\xca\xfe\xba\xbe"), contentTypeMachO, true}, // or a Java class, as executable
+	{0, []byte("#!"), contentTypeScript, true},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed", true},
+	{0, []byte("MSCF\x00\x00\x00\x00"), "application/vnd.ms-cab-compressed", true},
+	{257, []byte("ustar"), "application/x-tar", true},
+	{0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), contentTypeOLE, false},
+}
+
+// isPortableExecutable reports whether data is a Windows executable or DLL.
+// "MZ" alone starts too much text to go on, so the PE header the DOS stub
+// points at has to be there as well.
+func isPortableExecutable(data []byte) bool {
+	if len(data) < 0x40 || data[0] != 'M' || data[1] != 'Z' {
+		return false
+	}
+	pe := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	return pe >= 0x40 && pe <= len(data)-4 && string(data[pe:pe+4]) == "PE\x00\x00"
+}
+
+// ooxmlContentType returns the Office Open XML type of a ZIP archive, or ""
+// when it is not one or cannot be read. A document carrying a VBA project is
+// given the macro-enabled type whatever its file name says.
+func ooxmlContentType(data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	var kind string
+	var types, macros bool
+	for _, f := range zr.File {
+		switch name := strings.ToLower(f.Name); {
+		case name == "[content_types].xml":
+			types = true
+		case name == "word/document.xml":
+			kind = "word"
+		case name == "xl/workbook.xml":
+			kind = "xl"
+		case name == "ppt/presentation.xml":
+			kind = "ppt"
+		case strings.HasSuffix(name, "/vbaproject.bin"):
+			macros = true
+		}
+	}
+	if !types {
+		return ""
+	}
+	switch {
+	case kind == "word" && macros:
+		return "application/vnd.ms-word.document.macroEnabled.12"
+	case kind == "word":
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case kind == "xl" && macros:
+		return "application/vnd.ms-excel.sheet.macroEnabled.12"
+	case kind == "xl":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case kind == "ppt" && macros:
+		return "application/vnd.ms-powerpoint.presentation.macroEnabled.12"
+	case kind == "ppt":
+		return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	}
+	return ""
+}
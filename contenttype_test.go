@@ -0,0 +1,99 @@
+package gsmail
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testPE is the smallest prefix isPortableExecutable accepts.
+func testPE() []byte {
+	pe := make([]byte, 0x80)
+	copy(pe, "MZ")
+	binary.LittleEndian.PutUint32(pe[0x3c:], 0x40)
+	copy(pe[0x40:], "PE\x00\x00")
+	return pe
+}
+
+// testZip builds a ZIP archive of the given files.
+func testZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectContentType(t *testing.T) {
+	docx := map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>"}
+	docm := map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>", "word/vbaProject.bin": "vba"}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		want     string
+	}{
+		{"PNG", pngImage, "logo.bin", "image/png"},
+		{"PDF", []byte("%PDF-1.7\n"), "", "application/pdf"},
+		{"PE", testPE(), "report.pdf", "application/vnd.microsoft.portable-executable"},
+		{"MZ text is not a program", append([]byte("MZ Holdings annual report "), make([]byte, 64)...), "a.txt", "text/plain"},
+		{"ELF", []byte("\x7fELF\x02\x01\x01"), "", "application/x-executable"},
+		{"Script", []byte("#!/bin/sh\necho hi\n"), "notes.txt", "text/x-shellscript"},
+		{"Zip", testZip(t, map[string]string{"a.txt": "a"}), "", "application/zip"},
+		{"Word", testZip(t, docx), "x.zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"Word with macros", testZip(t, docm), "x.docx", "application/vnd.ms-word.document.macroEnabled.12"},
+		{"Text by extension", []byte("a,b\n1,2\n"), "data.csv", "text/csv"},
+		{"Unknown", []byte{0, 1, 2, 3}, "", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType(tt.data, tt.filename); got != tt.want {
+				t.Errorf("DetectContentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffContentTypes(t *testing.T) {
+	zipData := testZip(t, map[string]string{"a.txt": "a"})
+	email := Email{Attachments: []Attachment{
+		{Filename: "logo.png", Data: pngImage},
+		{Filename: "logo.png", ContentType: "application/octet-stream", Data: pngImage},
+		{Filename: "photo.jpg", ContentType: "image/jpeg", Data: testPE()},
+		{Filename: "book.epub", ContentType: "application/epub+zip", Data: zipData},
+		{Filename: "data.csv", ContentType: "text/csv; charset=utf-8", Data: []byte("a,b\n")},
+		{Filename: "logo.png", ContentType: "image/PNG", Data: pngImage},
+	}}
+	want := []string{
+		"image/png",
+		"image/png",
+		"application/vnd.microsoft.portable-executable",
+		"application/epub+zip",
+		"text/csv; charset=utf-8",
+		"image/PNG",
+	}
+
+	got, err := SniffContentTypes(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range got.Attachments {
+		if a.ContentType != want[i] {
+			t.Errorf("attachment %d: ContentType = %q, want %q", i, a.ContentType, want[i])
+		}
+	}
+	if email.Attachments[0].ContentType != "" {
+		t.Error("the caller's attachments were modified")
+	}
+}
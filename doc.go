@@ -26,12 +26,24 @@ interchangeable and composable.
 [Email.Body] is text/plain and [Email.HTMLBody] is text/html. [Email.SetBody]
 sniffs a template and routes the result to whichever fits; [Email.SetTextBody]
 and [Email.SetHTMLBody] skip the sniffing when you already know.
+[TemplateRegistry] compiles a directory of templates once, such as an
+embed.FS, with a shared layout and partials, and renders a subject and HTML
+and text bodies into an Email by name.
 
 # Receiving
 
 [Receiver] covers IMAP and POP3, in [gsmail/imap] and [gsmail/pop3]. IMAP also
 supports selecting a mailbox, searching, IDLE, and acting on fetched messages —
-marking them read, moving them, deleting them.
+marking them read, moving them, deleting them. [gsmail/maildir] reads mail
+delivered to a local Maildir through the same interface.
+
+Messages are archived and replayed as files too: [gsmail/mbox] streams them to
+and from an mbox, [gsmail/maildir] stores them one per file, and [ReadEMLFile]
+and [WriteEMLFile] handle a single .eml.
+
+[BuildThreads] arranges fetched messages into conversations by their
+Message-ID, In-Reply-To and References headers, and by subject where those
+are missing.
 
 # Interceptors
 
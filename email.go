@@ -1,26 +1,107 @@
 package gsmail
 
 import (
+	"bytes"
 	"fmt"
 	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
 	"text/template"
 
 	"github.com/gsoultan/gsmail/outlook"
 )
 
 // Attachment represents an email attachment.
+//
+// The content comes either from Data or from Open. Data is simplest, but it
+// holds the whole file for as long as the Email lives, and an Email copied to
+// many recipients or queued for retry keeps every copy alive. Open defers the
+// read to the moment the attachment is rendered and streams it straight into
+// the base64 encoder, so a large file is never held in memory by gsmail.
 type Attachment struct {
 	Filename    string
 	ContentType string
 	ContentID   string
 	Data        []byte
+
+	// Open, when set, supplies the content in place of Data. It is called
+	// once per render, which may be more than once per Send: a retried SMTP
+	// attempt renders the message again. Each call must therefore return a
+	// fresh reader positioned at the start. Received attachments never set it.
+	Open func() (io.ReadCloser, error)
+}
+
+// FileAttachment returns an attachment that streams the named file when the
+// message is rendered. The file is not opened until then, so a missing file
+// surfaces as a send error rather than here.
+//
+// The content type is guessed from the extension and left empty when the
+// extension is unknown, which renders as application/octet-stream.
+func FileAttachment(path string) Attachment {
+	return Attachment{
+		Filename:    filepath.Base(path),
+		ContentType: mime.TypeByExtension(filepath.Ext(path)),
+		Open:        func() (io.ReadCloser, error) { return os.Open(path) },
+	}
+}
+
+// FSAttachment is FileAttachment for a file inside fsys, such as an embed.FS.
+func FSAttachment(fsys fs.FS, name string) Attachment {
+	return Attachment{
+		Filename:    path.Base(name),
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+		Open:        func() (io.ReadCloser, error) { return fsys.Open(name) },
+	}
+}
+
+// Reader returns the attachment content as a stream, from Open when it is set
+// and from Data otherwise. The caller must close it.
+func (a Attachment) Reader() (io.ReadCloser, error) {
+	if a.Open == nil {
+		return io.NopCloser(bytes.NewReader(a.Data)), nil
+	}
+	rc, err := a.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open attachment %q: %w", a.Filename, err)
+	}
+	if rc == nil {
+		return nil, fmt.Errorf("open attachment %q: opener returned no reader", a.Filename)
+	}
+	return rc, nil
+}
+
+// Bytes returns the attachment content in full. It is Data itself when Open is
+// not set, so the result must not be modified.
+//
+// Providers whose API takes the whole attachment in one request body use this;
+// it is the one place a streamed attachment is read into memory.
+func (a Attachment) Bytes() ([]byte, error) {
+	if a.Open == nil {
+		return a.Data, nil
+	}
+	rc, err := a.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read attachment %q: %w", a.Filename, err)
+	}
+	return b, nil
 }
 
 // Email represents an email message.
 //
 // One Email describes both a message you are sending and one you have
 // received, which means it does not round-trip: rendering a parsed message
-// does not reproduce the bytes it was parsed from. ParseRawEmail retains the
+// does not reproduce the bytes it was parsed from, unless it was parsed with
+// ParseOptions.RetainRaw, which keeps them in Raw. ParseRawEmail retains the
 // trace headers that record how a message travelled — Received, Return-Path,
 // DKIM-Signature, Authentication-Results and the ARC set — so an inbound
 // message can be inspected, and BuildMessage drops them again, because a new
@@ -54,12 +135,54 @@ type Email struct {
 	HTMLBody          []byte
 	Attachments       []Attachment
 	OutlookCompatible bool
+	// InlineCSS asks SetHTMLBody and SetBody to copy the rules of the
+	// rendered HTML's <style> blocks into style attributes, with
+	// outlook.InlineCSS, before any Outlook conversion. Gmail and Outlook
+	// drop or ignore <style> in some of their clients, so a template designed
+	// with a stylesheet otherwise needs inlining by hand.
+	InlineCSS bool
+	// AMPBody is an AMP for Email document, sent as a text/x-amp-html part
+	// between the text and HTML alternatives. Clients that support it
+	// (Gmail, Yahoo, Mail.ru) show it instead of HTMLBody; the rest, and
+	// Gmail after 30 days, show HTMLBody, which is therefore required. It is
+	// checked with ValidateAMP as the message is rendered. Providers whose API
+	// has no field for it refuse the message with ErrAMPUnsupported.
+	AMPBody []byte
+	// AutoPlainText asks for a text/plain alternative to be generated from
+	// HTMLBody whenever Body is empty, using HTMLToText.
+	//
+	// HTML-only mail scores worse with spam filters and is unreadable in text
+	// clients, but writing every template twice is rarely done well. With
+	// this set, SetHTMLBody and SetBody fill Body as they render, and every
+	// sender fills it at send time for a message built by hand. A Body you
+	// set yourself is never replaced at send time; call SetTextBody after
+	// SetHTMLBody to supply your own.
+	AutoPlainText bool
 	// Headers holds additional header fields such as List-Unsubscribe,
 	// In-Reply-To, References or any X-* header. Values are sanitised and
 	// RFC 2047 encoded when rendered. Header names that the library generates
 	// itself (From, To, Cc, Bcc, Reply-To, Subject, MIME-Version, Content-Type,
 	// Content-Transfer-Encoding) are ignored.
+	//
+	// Headers is the convenient form, one value per name. Use HeaderFields for
+	// a field that repeats or whose position matters.
 	Headers map[string]string
+	// HeaderFields holds additional header fields in order, any name any
+	// number of times, with the same sanitising and the same reserved names
+	// as Headers. They are rendered after the fields of Headers, and a name
+	// used here replaces the Headers entry of that name rather than adding
+	// to it.
+	//
+	// A parsed message carries here every field its typed fields do not
+	// model, Received included, in the order it arrived; Headers is then the
+	// view of the first value of each. Rendering such a message writes only
+	// the fields that are neither generated nor trace fields.
+	//
+	// On a parsed message, an entry of Headers edited after parsing wins: it
+	// replaces every field of that name here, as SetHeader does. Deleting an
+	// entry from Headers removes nothing; use HeaderFields.Del. Otherwise,
+	// on a message built by hand or decoded from JSON, HeaderFields wins.
+	HeaderFields HeaderFields
 	// Envelope overrides who the message is delivered to, without changing who
 	// it appears to be addressed to.
 	//
@@ -89,18 +212,60 @@ type Email struct {
 	// a vendor API cannot separate the two, and reject a message that sets it
 	// rather than silently delivering to everyone named in the headers.
 	Envelope []string
+	// Raw, when set, is the complete rendered message, and is sent byte for
+	// byte in place of what the other fields would render to.
+	//
+	// It exists for messages whose bytes must not change after they were
+	// produced: a signed or encrypted message (see the smime package) is only
+	// valid as signed. The address fields still supply the envelope, so they
+	// must agree with the headers inside Raw; nothing checks that they do.
+	//
+	// Only transports that carry a rendered message honour it -- SMTP and the
+	// SES raw path. The API providers build their request from the typed
+	// fields and reject a message that sets it rather than quietly sending
+	// something else.
+	//
+	// ParseRawEmailWithOptions sets it, along with Structure, when asked to
+	// retain the message as it arrived.
+	Raw []byte
+	// Structure maps the entities of Raw -- header fields, media type,
+	// transfer encoding and where each one lies -- for a message parsed with
+	// ParseOptions.RetainRaw. It is nil otherwise. Ignored when sending.
+	Structure *MIMEPart
+	// Calendar, when set, makes the message a meeting invitation, update,
+	// cancellation or reply. It is rendered as a text/calendar alternative
+	// to the bodies and attached as invite.ics; see CalendarEvent.
+	//
+	// ParseRawEmail sets it for an event it can render again, and drops the
+	// attachment holding the same event. A calendar part it could not render,
+	// such as a COUNTER, is kept as it arrived instead.
+	Calendar *CalendarEvent
+	// Protections lists the signatures verified and encryptions removed while
+	// the message was parsed by ParseRawEmailWithOptions, outermost first. It
+	// is empty for a message that was not protected, or that was parsed
+	// without the means to unwrap it. Ignored when sending.
+	Protections []Protection
 	// HTMLFuncs holds custom functions for HTML templates used with this email.
 	HTMLFuncs htmltemplate.FuncMap
 	// TextFuncs holds custom functions for text templates used with this email.
 	TextFuncs template.FuncMap
+
+	// parsedHeaders is a copy of Headers as ParseRawEmail filled it, to tell
+	// an edit made since from the view of HeaderFields it started as.
+	parsedHeaders map[string]string
 }
 
-// SetHeader sets a custom header field, allocating the map when needed.
+// SetHeader sets a custom header field, allocating the map when needed. It
+// replaces the field: any fields of the same name in HeaderFields are
+// removed, since they would otherwise take precedence.
 func (e *Email) SetHeader(name, value string) {
 	if e.Headers == nil {
 		e.Headers = make(map[string]string, 4)
 	}
 	e.Headers[name] = value
+	if e.HeaderFields.Has(name) {
+		e.HeaderFields.Del(name)
+	}
 }
 
 // S3Config represents the AWS S3 configuration.
@@ -136,11 +301,25 @@ func (e *Email) SetHTMLBody(tmplStr string, data any) error {
 	if err != nil {
 		return fmt.Errorf("set html body: %w", err)
 	}
+	e.setRenderedHTML(body)
+	return nil
+}
+
+// setRenderedHTML stores rendered HTML in HTMLBody, converting it as
+// OutlookCompatible and InlineCSS ask and regenerating Body when
+// AutoPlainText is set.
+func (e *Email) setRenderedHTML(body []byte) {
 	if e.OutlookCompatible {
-		body = outlook.ToOutlookHTML(body)
+		body = outlook.ToOutlookHTMLWithOptions(body, outlook.Options{InlineCSS: e.InlineCSS})
+	} else if e.InlineCSS {
+		body = outlook.InlineCSS(body)
 	}
 	e.HTMLBody = body
-	return nil
+	if e.AutoPlainText {
+		// Regenerated on every call, so re-rendering the HTML never leaves
+		// the text describing the previous version.
+		e.Body = HTMLToText(body)
+	}
 }
 
 // SetOutlookBody sets the email body using a template and data, and converts it to be Outlook-compatible.
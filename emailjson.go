@@ -0,0 +1,400 @@
+package gsmail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EmailJSONVersion is the version of the JSON encoding of an Email written by
+// MarshalJSON and EmailEncoder.
+//
+// The encoding is a wire format for queues and audit logs, so a producer and
+// a consumer on different gsmail versions must agree on it. Every document
+// carries its version, and a decoder refuses a version it does not know
+// rather than guess. Version 1 may gain fields only where a decoder that
+// ignores them loses nothing; any other change is a new version, and a
+// release that writes it keeps reading the old ones.
+//
+// Version 1 is an object with these members, each omitted when empty:
+//
+//	version              1
+//	uid, mailbox         Email.UID and Email.Mailbox
+//	from, reply_to       strings, in the form the Email fields hold
+//	to, cc, bcc          arrays of strings, likewise
+//	envelope             array of addresses
+//	subject              string
+//	body, html_body, amp_body
+//	                     strings; body_base64, html_body_base64 and
+//	                     amp_body_base64 instead when the content is not
+//	                     valid UTF-8
+//	headers              object of name to value (Email.Headers)
+//	header_fields        array of {"name", "value"}, in order
+//	attachments          array of {"filename", "content_type", "content_id"}
+//	                     with "data" (base64) or "ref", a reference to
+//	                     content stored elsewhere
+//	raw                  base64, Email.Raw
+//	structure            true when Email.Structure is set; it indexes raw and
+//	                     is rebuilt from it on decoding
+//	calendar             the CalendarEvent, with snake_case names; times
+//	                     are RFC 3339 with the zone name appended in brackets
+//	                     as RFC 9557 has it, "2026-03-02T09:00:00+01:00[Europe/Berlin]"
+//	protections          array of {"scheme", "signed", "encrypted", "signer"}
+//	outlook_compatible, inline_css, auto_plain_text
+//	                     booleans
+//
+// HTMLFuncs and TextFuncs are not encoded: they are functions, and they are
+// used only while a body is rendered from a template, before a message is
+// queued.
+const EmailJSONVersion = 1
+
+// ErrUnsupportedVersion is returned when decoding a document whose version
+// this release does not know, or that has none.
+var ErrUnsupportedVersion = errors.New("gsmail: unsupported email encoding version")
+
+// MarshalJSON encodes e as version EmailJSONVersion of the wire format, with
+// attachment content inline. Use EmailEncoder to store attachments elsewhere.
+func (e Email) MarshalJSON() ([]byte, error) {
+	return EmailEncoder{}.Marshal(e)
+}
+
+// UnmarshalJSON decodes a document MarshalJSON or EmailEncoder wrote. An
+// attachment stored by reference needs EmailDecoder.Open and is an error
+// here.
+func (e *Email) UnmarshalJSON(data []byte) error {
+	decoded, err := EmailDecoder{}.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	*e = decoded
+	return nil
+}
+
+// EmailEncoder writes the JSON encoding of an Email, with a choice of where
+// attachment content goes.
+type EmailEncoder struct {
+	// Store, when set, is given each attachment and returns a reference to
+	// the place it put the content, such as an object storage key. The
+	// encoding then carries the reference instead of the data, which keeps
+	// queue messages small. Without Store, content is inline.
+	Store func(Attachment) (ref string, err error)
+}
+
+// Marshal encodes e. An attachment backed by Attachment.Open is read, unless
+// Store takes it.
+func (enc EmailEncoder) Marshal(e Email) ([]byte, error) {
+	w := wireEmail{
+		Version:           EmailJSONVersion,
+		UID:               e.UID,
+		Mailbox:           e.Mailbox,
+		From:              e.From,
+		To:                e.To,
+		Cc:                e.Cc,
+		Bcc:               e.Bcc,
+		ReplyTo:           e.ReplyTo,
+		Envelope:          e.Envelope,
+		Subject:           e.Subject,
+		Headers:           e.Headers,
+		Raw:               e.Raw,
+		Structure:         e.Structure != nil,
+		OutlookCompatible: e.OutlookCompatible,
+		InlineCSS:         e.InlineCSS,
+		AutoPlainText:     e.AutoPlainText,
+	}
+	w.Body, w.BodyBase64 = wireText(e.Body)
+	w.HTMLBody, w.HTMLBodyBase64 = wireText(e.HTMLBody)
+	w.AMPBody, w.AMPBodyBase64 = wireText(e.AMPBody)
+	for _, f := range e.HeaderFields {
+		w.HeaderFields = append(w.HeaderFields, wireHeaderField{Name: f.Name, Value: f.Value})
+	}
+	for _, a := range e.Attachments {
+		wa := wireAttachment{Filename: a.Filename, ContentType: a.ContentType, ContentID: a.ContentID}
+		var err error
+		if enc.Store != nil {
+			wa.Ref, err = enc.Store(a)
+			if err == nil && wa.Ref == "" {
+				err = errors.New("empty reference")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("gsmail: attachment %q: %w", a.Filename, err)
+			}
+		} else if wa.Data, err = a.Bytes(); err != nil {
+			return nil, fmt.Errorf("gsmail: %w", err)
+		}
+		w.Attachments = append(w.Attachments, wa)
+	}
+	if ev := e.Calendar; ev != nil {
+		w.Calendar = &wireCalendar{
+			Method:       string(ev.Method),
+			UID:          ev.UID,
+			Sequence:     ev.Sequence,
+			Summary:      ev.Summary,
+			Description:  ev.Description,
+			Location:     ev.Location,
+			Start:        zonedTime(ev.Start),
+			End:          zonedTime(ev.End),
+			AllDay:       ev.AllDay,
+			Recurrence:   ev.Recurrence,
+			RecurrenceID: zonedTime(ev.RecurrenceID),
+			Organizer:    ev.Organizer,
+			Stamp:        zonedTime(ev.Stamp),
+		}
+		for _, t := range ev.ExceptDates {
+			w.Calendar.ExceptDates = append(w.Calendar.ExceptDates, zonedTime(t))
+		}
+		for _, at := range ev.Attendees {
+			w.Calendar.Attendees = append(w.Calendar.Attendees, wireAttendee{
+				Address: at.Address,
+				Role:    string(at.Role),
+				Status:  string(at.Status),
+				RSVP:    at.RSVP,
+			})
+		}
+	}
+	for _, p := range e.Protections {
+		w.Protections = append(w.Protections, wireProtection(p))
+	}
+	return json.Marshal(w)
+}
+
+// EmailDecoder reads the JSON encoding of an Email.
+type EmailDecoder struct {
+	// Open, when set, resolves the reference of an attachment stored by
+	// EmailEncoder.Store. It becomes the attachment's Attachment.Open, so the
+	// content is fetched when the message is rendered rather than when it is
+	// decoded, and it must return a fresh reader on every call. Without it,
+	// an attachment stored by reference is an error.
+	Open func(ref string) (io.ReadCloser, error)
+}
+
+// Unmarshal decodes a document. It fails with ErrUnsupportedVersion for a
+// version it does not know, and ignores members it does not know within a
+// version it does.
+func (dec EmailDecoder) Unmarshal(data []byte) (Email, error) {
+	var head struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return Email{}, fmt.Errorf("gsmail: decode email: %w", err)
+	}
+	if head.Version != EmailJSONVersion {
+		return Email{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, head.Version)
+	}
+	var w wireEmail
+	if err := json.Unmarshal(data, &w); err != nil {
+		return Email{}, fmt.Errorf("gsmail: decode email: %w", err)
+	}
+
+	e := Email{
+		UID:               w.UID,
+		Mailbox:           w.Mailbox,
+		From:              w.From,
+		To:                w.To,
+		Cc:                w.Cc,
+		Bcc:               w.Bcc,
+		ReplyTo:           w.ReplyTo,
+		Envelope:          w.Envelope,
+		Subject:           w.Subject,
+		Body:              fromWireText(w.Body, w.BodyBase64),
+		HTMLBody:          fromWireText(w.HTMLBody, w.HTMLBodyBase64),
+		AMPBody:           fromWireText(w.AMPBody, w.AMPBodyBase64),
+		Headers:           w.Headers,
+		Raw:               w.Raw,
+		OutlookCompatible: w.OutlookCompatible,
+		InlineCSS:         w.InlineCSS,
+		AutoPlainText:     w.AutoPlainText,
+	}
+	for _, f := range w.HeaderFields {
+		e.HeaderFields = append(e.HeaderFields, HeaderField{Name: f.Name, Value: f.Value})
+	}
+	for _, wa := range w.Attachments {
+		a := Attachment{Filename: wa.Filename, ContentType: wa.ContentType, ContentID: wa.ContentID, Data: wa.Data}
+		if wa.Ref != "" {
+			if dec.Open == nil {
+				return Email{}, fmt.Errorf("gsmail: attachment %q is stored by reference, and the decoder has no Open", wa.Filename)
+			}
+			ref, open := wa.Ref, dec.Open
+			a.Open = func() (io.ReadCloser, error) { return open(ref) }
+		}
+		e.Attachments = append(e.Attachments, a)
+	}
+	if w.Structure && len(e.Raw) > 0 {
+		e.Structure = parseStructure(e.Raw, 0, len(e.Raw), 0)
+	}
+	if wc := w.Calendar; wc != nil {
+		ev := &CalendarEvent{
+			Method:       CalendarMethod(wc.Method),
+			UID:          wc.UID,
+			Sequence:     wc.Sequence,
+			Summary:      wc.Summary,
+			Description:  wc.Description,
+			Location:     wc.Location,
+			Start:        time.Time(wc.Start),
+			End:          time.Time(wc.End),
+			AllDay:       wc.AllDay,
+			Recurrence:   wc.Recurrence,
+			RecurrenceID: time.Time(wc.RecurrenceID),
+			Organizer:    wc.Organizer,
+			Stamp:        time.Time(wc.Stamp),
+		}
+		for _, t := range wc.ExceptDates {
+			ev.ExceptDates = append(ev.ExceptDates, time.Time(t))
+		}
+		for _, at := range wc.Attendees {
+			ev.Attendees = append(ev.Attendees, Attendee{
+				Address: at.Address,
+				Role:    AttendeeRole(at.Role),
+				Status:  ParticipationStatus(at.Status),
+				RSVP:    at.RSVP,
+			})
+		}
+		e.Calendar = ev
+	}
+	for _, p := range w.Protections {
+		e.Protections = append(e.Protections, Protection(p))
+	}
+	return e, nil
+}
+
+// wireEmail is version 1 of the encoding. Its members are documented on
+// EmailJSONVersion, and changing one is a new version.
+type wireEmail struct {
+	Version           int               `json:"version"`
+	UID               uint32            `json:"uid,omitempty"`
+	Mailbox           string            `json:"mailbox,omitempty"`
+	From              string            `json:"from,omitempty"`
+	To                []string          `json:"to,omitempty"`
+	Cc                []string          `json:"cc,omitempty"`
+	Bcc               []string          `json:"bcc,omitempty"`
+	ReplyTo           string            `json:"reply_to,omitempty"`
+	Envelope          []string          `json:"envelope,omitempty"`
+	Subject           string            `json:"subject,omitempty"`
+	Body              string            `json:"body,omitempty"`
+	BodyBase64        []byte            `json:"body_base64,omitempty"`
+	HTMLBody          string            `json:"html_body,omitempty"`
+	HTMLBodyBase64    []byte            `json:"html_body_base64,omitempty"`
+	AMPBody           string            `json:"amp_body,omitempty"`
+	AMPBodyBase64     []byte            `json:"amp_body_base64,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	HeaderFields      []wireHeaderField `json:"header_fields,omitempty"`
+	Attachments       []wireAttachment  `json:"attachments,omitempty"`
+	Raw               []byte            `json:"raw,omitempty"`
+	Structure         bool              `json:"structure,omitempty"`
+	Calendar          *wireCalendar     `json:"calendar,omitempty"`
+	Protections       []wireProtection  `json:"protections,omitempty"`
+	OutlookCompatible bool              `json:"outlook_compatible,omitempty"`
+	InlineCSS         bool              `json:"inline_css,omitempty"`
+	AutoPlainText     bool              `json:"auto_plain_text,omitempty"`
+}
+
+type wireHeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type wireAttachment struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	Ref         string `json:"ref,omitempty"`
+}
+
+type wireCalendar struct {
+	Method       string         `json:"method,omitempty"`
+	UID          string         `json:"uid,omitempty"`
+	Sequence     int            `json:"sequence,omitempty"`
+	Summary      string         `json:"summary,omitempty"`
+	Description  string         `json:"description,omitempty"`
+	Location     string         `json:"location,omitempty"`
+	Start        zonedTime      `json:"start,omitzero"`
+	End          zonedTime      `json:"end,omitzero"`
+	AllDay       bool           `json:"all_day,omitempty"`
+	Recurrence   string         `json:"recurrence,omitempty"`
+	ExceptDates  []zonedTime    `json:"except_dates,omitempty"`
+	RecurrenceID zonedTime      `json:"recurrence_id,omitzero"`
+	Organizer    string         `json:"organizer,omitempty"`
+	Attendees    []wireAttendee `json:"attendees,omitempty"`
+	Stamp        zonedTime      `json:"stamp,omitzero"`
+}
+
+type wireAttendee struct {
+	Address string `json:"address"`
+	Role    string `json:"role,omitempty"`
+	Status  string `json:"status,omitempty"`
+	RSVP    bool   `json:"rsvp,omitempty"`
+}
+
+// wireProtection has Protection's fields, so the two convert directly.
+type wireProtection struct {
+	Scheme    string `json:"scheme"`
+	Signed    bool   `json:"signed,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+}
+
+// wireText splits a body into the text member, for content that is valid
+// UTF-8 and so readable in a log, or the base64 member for anything else.
+func wireText(b []byte) (string, []byte) {
+	if utf8.Valid(b) {
+		return string(b), nil
+	}
+	return "", b
+}
+
+func fromWireText(s string, b []byte) []byte {
+	if b != nil {
+		return b
+	}
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+// zonedTime is a time.Time that keeps its zone across the encoding.
+//
+// RFC 3339 keeps only the offset, and an invitation in Europe/Berlin
+// decoded with a fixed +01:00 would drift an hour at the next
+// daylight-saving change: the calendar writer needs the zone's name. It is
+// appended in brackets, as RFC 9557 extends RFC 3339. A zone the decoding
+// machine does not know leaves the time at its offset, which is still the
+// right instant.
+type zonedTime time.Time
+
+func (t zonedTime) IsZero() bool { return time.Time(t).IsZero() }
+
+func (t zonedTime) MarshalJSON() ([]byte, error) {
+	tt := time.Time(t)
+	s := tt.Format(time.RFC3339Nano)
+	if name, ok := icalZone(tt.Location()); ok {
+		s += "[" + name + "]"
+	}
+	return json.Marshal(s)
+}
+
+func (t *zonedTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	var zone string
+	if i := strings.IndexByte(s, '['); i >= 0 && strings.HasSuffix(s, "]") {
+		s, zone = s[:i], s[i+1:len(s)-1]
+	}
+	tt, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	if zone != "" {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			tt = tt.In(loc)
+		}
+	}
+	*t = zonedTime(tt)
+	return nil
+}
@@ -0,0 +1,157 @@
+package gsmail
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailJSONRoundTrip(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, berlin)
+	in := Email{
+		UID:         7,
+		Mailbox:     "INBOX",
+		From:        `"Doe, John" <j@example.com>`,
+		To:          []string{"a@example.com", "Team: b@example.com;"},
+		Bcc:         []string{"c@example.com"},
+		ReplyTo:     "r@example.com",
+		Envelope:    []string{"a@example.com"},
+		Subject:     "Grüße",
+		Body:        []byte("hello"),
+		HTMLBody:    []byte{0xff, 0xfe, '<'},
+		AMPBody:     []byte("<!doctype html>"),
+		Headers:     map[string]string{"X-Tag": "a"},
+		Attachments: []Attachment{{Filename: "a.bin", ContentType: "application/octet-stream", Data: []byte{0, 1, 2}}},
+		Calendar: &CalendarEvent{
+			Method:      MethodRequest,
+			UID:         "u1",
+			Sequence:    2,
+			Summary:     "Standup",
+			Start:       start,
+			End:         start.Add(15 * time.Minute),
+			Recurrence:  "FREQ=WEEKLY",
+			ExceptDates: []time.Time{start.AddDate(0, 0, 7)},
+			Organizer:   "j@example.com",
+			Attendees:   []Attendee{{Address: "a@example.com", Role: RoleRequired, RSVP: true}},
+		},
+		Protections:       []Protection{{Scheme: "S/MIME", Signed: true, Signer: "j@example.com"}},
+		OutlookCompatible: true,
+		InlineCSS:         true,
+	}
+	in.AddHeader("Comments", "one")
+	in.AddHeader("Comments", "two")
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"version":1`)) || !bytes.Contains(data, []byte(`"body":"hello"`)) ||
+		!bytes.Contains(data, []byte(`"html_body_base64":"//48"`)) || !bytes.Contains(data, []byte(`[Europe/Berlin]"`)) {
+		t.Errorf("unexpected encoding: %s", data)
+	}
+
+	var out Email
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Calendar.Start.Equal(start) || out.Calendar.Start.Location().String() != "Europe/Berlin" {
+		t.Errorf("start = %v", out.Calendar.Start)
+	}
+	// The zone is what matters to the calendar; compare the rest as written.
+	out.Calendar.Start, out.Calendar.End = in.Calendar.Start, in.Calendar.End
+	out.Calendar.ExceptDates = in.Calendar.ExceptDates
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip differs:\n in  %+v\n out %+v", in, out)
+	}
+}
+
+func TestEmailJSONRejectsUnknownVersion(t *testing.T) {
+	for _, doc := range []string{`{"version":2,"subject":"x"}`, `{"subject":"x"}`} {
+		var e Email
+		if err := json.Unmarshal([]byte(doc), &e); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("%s: expected ErrUnsupportedVersion, got %v", doc, err)
+		}
+	}
+	// Members a newer release may add within version 1 are ignored.
+	var e Email
+	if err := json.Unmarshal([]byte(`{"version":1,"subject":"x","priority":"high"}`), &e); err != nil || e.Subject != "x" {
+		t.Errorf("got %+v, %v", e, err)
+	}
+}
+
+func TestEmailJSONAttachmentReferences(t *testing.T) {
+	store := map[string][]byte{}
+	enc := EmailEncoder{Store: func(a Attachment) (string, error) {
+		data, err := a.Bytes()
+		if err != nil {
+			return "", err
+		}
+		ref := "blob/" + a.Filename
+		store[ref] = data
+		return ref, nil
+	}}
+	in := Email{From: "a@example.com", Attachments: []Attachment{{
+		Filename: "r.pdf",
+		Open:     func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("%PDF")), nil },
+	}}}
+	data, err := enc.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"ref":"blob/r.pdf"`)) || bytes.Contains(data, []byte(`"data"`)) {
+		t.Errorf("unexpected encoding: %s", data)
+	}
+
+	if _, err := (EmailDecoder{}).Unmarshal(data); err == nil {
+		t.Error("a reference was accepted without Open")
+	}
+	out, err := EmailDecoder{Open: func(ref string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(store[ref])), nil
+	}}.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := out.Attachments[0].Bytes(); err != nil || string(content) != "%PDF" {
+		t.Errorf("content = %q, %v", content, err)
+	}
+
+	// Without Store, Open is read and the content is inline.
+	data, err = json.Marshal(in)
+	if err != nil || !bytes.Contains(data, []byte(`"data":"JVBERg=="`)) {
+		t.Errorf("inline encoding = %s, %v", data, err)
+	}
+
+	// An opener that returns no reader is an error, not a panic.
+	in.Attachments[0].Open = func() (io.ReadCloser, error) { return nil, nil }
+	if _, err := json.Marshal(in); err == nil || !strings.Contains(err.Error(), `"r.pdf"`) {
+		t.Errorf("nil reader: %v", err)
+	}
+}
+
+// A retained message keeps its raw bytes, and the structure is rebuilt.
+func TestEmailJSONRetainedMessage(t *testing.T) {
+	in, err := ParseRawEmailWithOptions([]byte(retainedRaw), ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Email
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in.Structure, out.Structure) || string(out.Raw) != retainedRaw {
+		t.Error("retained message did not survive the encoding")
+	}
+}
@@ -0,0 +1,67 @@
+package gsmail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ReadEML parses a message stored on its own, as a .eml file holds one,
+// reading r to the end. opts are those of ParseRawEmailWithOptions; set
+// RetainRaw to keep the file's bytes in Email.Raw, so that writing the message
+// out again reproduces it exactly.
+func ReadEML(r io.Reader, opts ParseOptions) (Email, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return Email{}, fmt.Errorf("gsmail: read eml: %w", err)
+	}
+	return ParseRawEmailWithOptions(raw, opts)
+}
+
+// ReadEMLFile is ReadEML for the named file.
+func ReadEMLFile(name string, opts ParseOptions) (Email, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Email{}, fmt.Errorf("gsmail: read eml: %w", err)
+	}
+	defer f.Close()
+	return ReadEML(f, opts)
+}
+
+// WriteEMLFile renders email into the named file, replacing it, as
+// RenderMessageTo renders it: a message with Raw set is written byte for byte.
+//
+// The message is rendered into a temporary file beside the target and renamed
+// over it once complete, so a reader never sees half a message and a render
+// that fails leaves nothing behind. To write a .eml to a stream instead, use
+// RenderMessageTo.
+func WriteEMLFile(name string, email Email) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("gsmail: write eml: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err := RenderMessageTo(f, email); err != nil {
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return fmt.Errorf("gsmail: write eml: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("gsmail: write eml: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("gsmail: write eml: %w", err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("gsmail: write eml: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package gsmail
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEMLFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "message.eml")
+	email := Email{
+		From:        "Alice <alice@example.com>",
+		To:          []string{"bob@example.com"},
+		Subject:     "Minutes",
+		Body:        []byte("See attached."),
+		Attachments: []Attachment{{Filename: "minutes.txt", ContentType: "text/plain", Data: []byte("nothing decided")}},
+	}
+	if err := WriteEMLFile(name, email); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadEMLFile(name, ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "Minutes" || string(got.Body) != "See attached." || len(got.Attachments) != 1 {
+		t.Errorf("read back %q %q with %d attachments", got.Subject, got.Body, len(got.Attachments))
+	}
+
+	// A retained message is written back byte for byte.
+	copyName := filepath.Join(dir, "copy.eml")
+	if err := WriteEMLFile(copyName, got); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(name)
+	written, _ := os.ReadFile(copyName)
+	if !bytes.Equal(original, written) {
+		t.Error("a message read with RetainRaw was not written back unchanged")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d files in the directory, want no temporary files left", len(entries))
+	}
+}
+
+func TestWriteEMLFileFailureLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "bad.eml")
+	bad := Email{From: "a@example.com", AMPBody: []byte("<p>not AMP</p>")}
+	if err := WriteEMLFile(name, bad); err == nil {
+		t.Fatal("want the render error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left %d files behind", len(entries))
+	}
+}
@@ -42,3 +42,26 @@ func TestRejectEnvelope(t *testing.T) {
 		}
 	})
 }
+
+// A pre-rendered message is only worth sending as rendered -- it is usually
+// signed -- so an API provider that would rebuild it from the typed fields
+// must refuse it instead.
+func TestRejectRaw(t *testing.T) {
+	if err := RejectRaw("postmark", Email{To: []string{"a@example.com"}}); err != nil {
+		t.Errorf("expected no error for a message without Raw, got %v", err)
+	}
+
+	err := RejectRaw("postmark", Email{
+		To:  []string{"a@example.com"},
+		Raw: []byte("Subject: hi\r\n\r\nbody\r\n"),
+	})
+	if !errors.Is(err, ErrRawUnsupported) {
+		t.Fatalf("error should wrap ErrRawUnsupported, got %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("the refusal must be non-retryable")
+	}
+	if got := err.Error(); !strings.Contains(got, "postmark") {
+		t.Errorf("the error should name the provider, got %q", got)
+	}
+}
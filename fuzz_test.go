@@ -5,6 +5,7 @@ import (
 	"net/mail"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 // Every parser below reads bytes a stranger sent: ParseRawEmail sees whatever
@@ -34,6 +35,7 @@ func FuzzParseRawEmail(f *testing.F) {
 	f.Add([]byte("\r\n\r\n"))
 	f.Add([]byte("Content-Type: multipart/mixed\r\n\r\nno boundary"))
 	f.Add([]byte("Message-ID: <a@b.test>\r\nIn-Reply-To: <c@d.test>\r\nX-Odd: v\r\n\r\nbody"))
+	f.Add([]byte("Subject: =?ISO-2022-JP?B?GyRCMnE1RBsoQg==?=\r\nContent-Type: text/plain; charset=shift_jis\r\n\r\n\x82\xa0"))
 
 	f.Fuzz(func(t *testing.T, raw []byte) {
 		email, err := ParseRawEmail(raw)
@@ -108,6 +110,30 @@ func FuzzParseThenRender(f *testing.F) {
 	})
 }
 
+// A parsed invitation is re-rendered when it is answered or forwarded, so
+// nothing read from one may break out of its content line on the way back.
+func FuzzParseCalendar(f *testing.F) {
+	f.Add([]byte(outlookInvite))
+	f.Add([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:20260101T000000Z\r\nSUMMARY:a\\nb\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	f.Add([]byte("BEGIN:VEVENT\nATTENDEE;CN=\"a:b\";PARTSTAT=ACCEPTED:mailto:x@y.test\n DURATION:P1D\nEND:VEVENT"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ev, err := ParseCalendar(data)
+		if err != nil {
+			return
+		}
+		ics, err := ev.ICS("organizer@example.com", nil, nil)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(ics), "\r\n"), "\r\n") {
+			if strings.ContainsAny(line, "\r\n") {
+				t.Fatalf("line break inside a content line: %q", line)
+			}
+		}
+	})
+}
+
 // ---------------------------------------------------------------------------
 // Outbound rendering
 // ---------------------------------------------------------------------------
@@ -375,3 +401,76 @@ func FuzzIsHTMLIsTotal(f *testing.F) {
 		}
 	})
 }
+
+// Every entity mapped for a retained message must lie inside it, in order,
+// so reading one back can never index outside Raw.
+func FuzzParseStructure(f *testing.F) {
+	f.Add([]byte(retainedRaw))
+	f.Add([]byte("Content-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\n\r\n--x--"))
+	f.Add([]byte("Content-Type: message/rfc822\n\nContent-Type: message/rfc822\n\n"))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		root := parseStructure(raw, 0, len(raw), 0)
+		root.Walk(func(p *MIMEPart) bool {
+			if !p.within(raw) {
+				t.Fatalf("part %d..%d..%d outside a %d-byte message", p.Start, p.BodyStart, p.End, len(raw))
+			}
+			return true
+		})
+	})
+}
+
+// FuzzAddressRoundTrip checks that every address ParseAddressList accepts
+// survives the trip through the string form the Email fields hold.
+func FuzzAddressRoundTrip(f *testing.F) {
+	f.Add(`"Doe, John" <j@example.com>, Team: a@example.com;`)
+	f.Add("undisclosed-recipients:;")
+	f.Add("=?utf-8?q?J=C3=B6rg?= <j@bücher.de>, (comment) c@[192.0.2.1]")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		list, err := ParseAddressList(s)
+		if err != nil {
+			return
+		}
+		for _, a := range list {
+			back, err := ParseAddress(a.String())
+			if err != nil {
+				t.Fatalf("%+v: String() = %q does not parse: %v", a, a.String(), err)
+			}
+			if back.String() != a.String() {
+				t.Fatalf("%q reads back as %q", a.String(), back.String())
+			}
+		}
+	})
+}
+
+// A winmail.dat is binary a stranger wrote, with lengths and counts that
+// nothing checks but the decoder; none of them may run it past the data or
+// have it expand without bound.
+func FuzzDecodeTNEF(f *testing.F) {
+	f.Add(newTNEF().
+		attr(1, 0x00069003, mapiBlock(uint16(ptBinary), uint16(prRTFCompressed), uncompressedRTF(`{\rtf1\ansi\fromhtml1 {\*\htmltag19 <p>}Hi}`))).
+		attr(2, 0x00069002, nil).
+		attr(2, 0x00018010, []byte("a.txt\x00")).
+		attr(2, 0x0006800F, []byte("data")).
+		Bytes())
+	f.Add(newTNEF().
+		attr(2, 0x00069005, mapiBlock(uint16(ptUnicode), uint16(prAttachLongFilename), utf16z("long name.txt"))).
+		Bytes())
+	f.Add([]byte("\x78\x9f\x3e\x22"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		email, err := DecodeTNEF(data)
+		if err != nil {
+			return
+		}
+		if len(email.HTMLBody) > 4*maxPartSize {
+			t.Fatalf("HTMLBody is %d bytes from %d of input", len(email.HTMLBody), len(data))
+		}
+		for _, a := range email.Attachments {
+			if a.Filename == "" || !utf8.ValidString(a.Filename) {
+				t.Fatalf("attachment name %q", a.Filename)
+			}
+		}
+	})
+}
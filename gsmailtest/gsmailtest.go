@@ -84,12 +84,14 @@ func (s *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	recorded.Bcc = append([]string(nil), email.Bcc...)
 	recorded.Body = append([]byte(nil), email.Body...)
 	recorded.HTMLBody = append([]byte(nil), email.HTMLBody...)
+	recorded.AMPBody = append([]byte(nil), email.AMPBody...)
 	if email.Headers != nil {
 		recorded.Headers = make(map[string]string, len(email.Headers))
 		for k, v := range email.Headers {
 			recorded.Headers[k] = v
 		}
 	}
+	recorded.HeaderFields = append(gsmail.HeaderFields(nil), email.HeaderFields...)
 
 	s.sent = append(s.sent, recorded)
 	hook := s.onSend
@@ -244,8 +246,8 @@ func (s *Sender) summary() string {
 }
 
 func containsAddress(list []string, want string) bool {
-	for _, a := range list {
-		if gsmail.NormalizeAddress(a) == want {
+	for _, a := range gsmail.Mailboxes(list) {
+		if gsmail.NormalizeAddress(a.Address) == want {
 			return true
 		}
 	}
@@ -0,0 +1,197 @@
+package gsmail
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// HeaderField is one header field of a message.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// HeaderFields is a header in the order its fields appear, in which a name may
+// occur any number of times. Names are matched without regard to case.
+//
+// It is what Email.Headers cannot be: a map holds one value per name and no
+// order, so it has no way to send two Comments fields, or to show the
+// Received chain of a parsed message hop by hop.
+type HeaderFields []HeaderField
+
+// Get returns the first value of the named field, or "".
+func (h HeaderFields) Get(name string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns every value of the named field, in order.
+func (h HeaderFields) Values(name string) []string {
+	var out []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			out = append(out, f.Value)
+		}
+	}
+	return out
+}
+
+// Has reports whether the named field occurs at all.
+func (h HeaderFields) Has(name string) bool {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a field, after any existing fields of the same name.
+func (h *HeaderFields) Add(name, value string) {
+	*h = append(*h, HeaderField{Name: name, Value: value})
+}
+
+// Del removes every field with the name.
+func (h *HeaderFields) Del(name string) {
+	out := (*h)[:0]
+	for _, f := range *h {
+		if !strings.EqualFold(f.Name, name) {
+			out = append(out, f)
+		}
+	}
+	clear((*h)[len(out):])
+	*h = out
+}
+
+// Map returns the first value of each name, keyed by the name as first
+// spelled: the view Email.Headers gives of the same header.
+func (h HeaderFields) Map() map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	seen := make(map[string]bool, len(h))
+	for _, f := range h {
+		lower := strings.ToLower(f.Name)
+		if !seen[lower] {
+			seen[lower] = true
+			out[f.Name] = f.Value
+		}
+	}
+	return out
+}
+
+// AddHeader appends a field to HeaderFields, keeping any already there with
+// the same name. Use it for fields that may repeat; SetHeader replaces.
+func (e *Email) AddHeader(name, value string) {
+	e.HeaderFields.Add(name, value)
+}
+
+// HeaderValues returns every value of the named field: from HeaderFields when
+// the name occurs there, and otherwise the single value in Headers.
+func (e Email) HeaderValues(name string) []string {
+	if e.HeaderFields.Has(name) && !e.editedInHeaders(name) {
+		return e.HeaderFields.Values(name)
+	}
+	if v := e.mapHeader(name); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// CustomHeaderFields returns the caller-supplied header fields of email in the
+// order they are rendered, with the same filtering, validation and encoding
+// as CustomHeaders: the fields of Headers whose names HeaderFields does not
+// use, sorted by name, then HeaderFields in order.
+//
+// A provider whose API takes a list of fields uses this rather than
+// CustomHeaders, which can only report one value per name.
+func CustomHeaderFields(email Email) ([]HeaderField, error) {
+	var out []HeaderField
+	add := func(name, value string) error {
+		lower := strings.ToLower(name)
+		if _, reserved := reservedHeaders[lower]; reserved {
+			return nil
+		}
+		if _, trace := traceHeaders[lower]; trace {
+			return nil
+		}
+		if !isValidHeaderName(name) {
+			return NonRetryable(fmt.Errorf("gsmail: invalid header name %q", name))
+		}
+		if v := encodeHeader(value); v != "" {
+			out = append(out, HeaderField{Name: name, Value: v})
+		}
+		return nil
+	}
+	for _, name := range sortedHeaderNames(email.Headers) {
+		if email.HeaderFields.Has(name) && !email.editedInHeaders(name) {
+			continue
+		}
+		if err := add(name, email.Headers[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range email.HeaderFields {
+		if email.editedInHeaders(f.Name) {
+			continue
+		}
+		if err := add(f.Name, f.Value); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// editedInHeaders reports whether the Headers entry for name was changed after
+// the message was parsed. Headers is then a view of HeaderFields, and an edit
+// made through it replaces every field of that name. On a message built by
+// hand HeaderFields wins, as documented.
+func (e Email) editedInHeaders(name string) bool {
+	if e.parsedHeaders == nil || !e.HeaderFields.Has(name) {
+		return false
+	}
+	v := e.mapHeader(name)
+	return v != "" && v != Email{Headers: e.parsedHeaders}.mapHeader(name)
+}
+
+// parsedHeaderFields reads the header block of raw in order, unfolded and
+// sanitised, with the filtering and limits parsedHeaders applies: fields the
+// struct already models are left to it.
+//
+// It reads the bytes itself because net/mail hands back a map, which is
+// where both the order and the repeats were being lost.
+func parsedHeaderFields(raw []byte) HeaderFields {
+	header, _ := mimeentity.Split(raw)
+	return unfoldedFields(header, true)
+}
+
+// unfoldedFields reads a header block into fields, unfolded and sanitised.
+// skipModelled leaves out the fields Email models as typed fields.
+func unfoldedFields(header []byte, skipModelled bool) HeaderFields {
+	var out HeaderFields
+	for _, f := range mimeentity.Fields(header) {
+		if len(out) >= maxParsedHeaders {
+			break
+		}
+		colon := bytes.IndexByte(f.Raw, ':')
+		if colon < 0 || f.Name == "" || len(f.Raw) > maxHeaderValueLen {
+			continue
+		}
+		if _, modelled := reservedHeaders[strings.ToLower(f.Name)]; modelled && skipModelled {
+			continue
+		}
+		value := string(f.Raw[colon+1:])
+		value = strings.ReplaceAll(value, "\r\n", "")
+		value = strings.ReplaceAll(value, "\n", "")
+		out = append(out, HeaderField{Name: f.Name, Value: sanitizeHeaderValue(strings.TrimSpace(value))})
+	}
+	return out
+}
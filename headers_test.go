@@ -0,0 +1,162 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestHeaderFieldsRenderInOrder(t *testing.T) {
+	e := Email{
+		From:    "a@example.com",
+		To:      []string{"b@example.com"},
+		Subject: "x",
+		Body:    []byte("y"),
+		Headers: map[string]string{"X-Tag": "replaced", "X-Campaign": "spring"},
+	}
+	e.AddHeader("Comments", "first")
+	e.AddHeader("X-Tag", "one")
+	e.AddHeader("Comments", "second")
+	e.AddHeader("X-Tag", "two")
+
+	msg, err := RenderMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Header["Comments"]; strings.Join(got, ",") != "first,second" {
+		t.Errorf("Comments = %q", got)
+	}
+	// The name is used in HeaderFields, so the map entry gives way to it.
+	if got := m.Header["X-Tag"]; strings.Join(got, ",") != "one,two" {
+		t.Errorf("X-Tag = %q", got)
+	}
+	if m.Header.Get("X-Campaign") != "spring" {
+		t.Errorf("X-Campaign = %q", m.Header.Get("X-Campaign"))
+	}
+	if got := e.HeaderValues("x-tag"); strings.Join(got, ",") != "one,two" {
+		t.Errorf("HeaderValues = %q", got)
+	}
+	if got := e.Header("X-Tag"); got != "one" {
+		t.Errorf("Header = %q", got)
+	}
+}
+
+func TestSetHeaderReplacesHeaderFields(t *testing.T) {
+	var e Email
+	e.AddHeader("X-Tag", "one")
+	e.AddHeader("X-Tag", "two")
+	e.SetHeader("x-tag", "only")
+	if len(e.HeaderFields) != 0 || e.Header("X-Tag") != "only" {
+		t.Errorf("fields %v, header %q", e.HeaderFields, e.Header("X-Tag"))
+	}
+}
+
+func TestCustomHeaderFields(t *testing.T) {
+	e := Email{
+		Headers: map[string]string{"X-B": "b", "X-A": "a", "Subject": "ignored"},
+		HeaderFields: HeaderFields{
+			{Name: "Received", Value: "from elsewhere"},
+			{Name: "X-C", Value: "1"},
+			{Name: "X-C", Value: "2"},
+		},
+	}
+	fields, err := CustomHeaderFields(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range fields {
+		got = append(got, f.Name+"="+f.Value)
+	}
+	if strings.Join(got, " ") != "X-A=a X-B=b X-C=1 X-C=2" {
+		t.Errorf("fields = %q", got)
+	}
+
+	e.AddHeader("Bad Name", "x")
+	if _, err := CustomHeaderFields(e); err == nil || !errors.Is(err, ErrNonRetryable) {
+		t.Errorf("expected a non-retryable error, got %v", err)
+	}
+}
+
+// A parsed message keeps its Received chain hop by hop, and rendering it
+// again neither repeats the modelled fields nor claims that chain.
+func TestParsedHeaderFields(t *testing.T) {
+	raw := "Received: from b by c\r\n" +
+		"Received: from a\r\n by b\r\n" +
+		"From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: hi\r\n" +
+		"Comments: one\r\n" +
+		"Comments: two\r\n" +
+		"\r\n" +
+		"body\r\n"
+	e, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.HeaderValues("Received"); len(got) != 2 || got[0] != "from b by c" || got[1] != "from a by b" {
+		t.Errorf("Received = %q", got)
+	}
+	if e.HeaderFields.Has("From") || e.HeaderFields.Has("Subject") {
+		t.Errorf("modelled fields retained: %v", e.HeaderFields)
+	}
+
+	msg, err := RenderMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Header["Received"]) != 0 {
+		t.Errorf("trace fields rendered: %q", m.Header["Received"])
+	}
+	if got := m.Header["Comments"]; strings.Join(got, ",") != "one,two" {
+		t.Errorf("Comments = %q", got)
+	}
+	if len(m.Header["Subject"]) != 1 {
+		t.Errorf("Subject = %q", m.Header["Subject"])
+	}
+}
+
+// An edit through Headers to a parsed message reaches the rendered message;
+// an untouched entry keeps the repeated fields it is a view of.
+func TestParsedHeadersEditWins(t *testing.T) {
+	raw := "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Comments: one\r\n" +
+		"Comments: two\r\n" +
+		"X-Ticket: \r\n 41\r\n" +
+		"\r\n" +
+		"body\r\n"
+	e, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Headers["X-Ticket"] = "42"
+
+	if got := e.Header("X-Ticket"); got != "42" {
+		t.Errorf("Header(X-Ticket) = %q", got)
+	}
+	msg, err := RenderMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Header["X-Ticket"]; len(got) != 1 || got[0] != "42" {
+		t.Errorf("X-Ticket = %q", got)
+	}
+	if got := m.Header["Comments"]; strings.Join(got, ",") != "one,two" {
+		t.Errorf("Comments = %q", got)
+	}
+}
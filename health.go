@@ -35,11 +35,13 @@ type HealthChecker struct {
 	Resolver Resolver
 }
 
+// resolver looks names up in their ASCII form, so an internationalized
+// domain is checked under the name DNS holds.
 func (h HealthChecker) resolver() Resolver {
 	if h.Resolver != nil {
-		return h.Resolver
+		return asciiResolver{h.Resolver}
 	}
-	return net.DefaultResolver
+	return asciiResolver{net.DefaultResolver}
 }
 
 // CheckDomainHealth performs comprehensive DNS health checks for the given domain.
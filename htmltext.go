@@ -0,0 +1,609 @@
+package gsmail
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// HTMLToText renders an HTML body as readable plain text, for the text/plain
+// alternative of a message that was written only in HTML.
+//
+// It is a formatter, not a browser. Paragraphs and block elements become line
+// breaks, headings are underlined ("=" under h1, "-" under the rest), list
+// items get a "* " or "1. " marker, and a table is flattened to one line per
+// row. Links keep their text and gain a numbered footnote, so
+//
+//	<a href="https://example.com/reset">Reset your password</a>
+//
+// reads "Reset your password [1]" with "[1] https://example.com/reset" listed
+// at the end. Images are replaced by their alt text.
+//
+// Anything a reader of the HTML would not see is dropped: script, style and
+// head, elements hidden with display:none (such as an inbox preheader), and
+// the Outlook conditional comments the outlook package emits. Content inside
+// <!--[if mso]> is an Outlook-only duplicate of what other clients show, so
+// it is dropped with the comment; content inside <!--[if !mso]><!--> is the
+// version everyone else sees, and is kept.
+//
+// Malformed markup is tolerated rather than rejected: the worst outcome is a
+// plain-text part with a stray character in it, which is still better than
+// sending HTML alone.
+func HTMLToText(src []byte) []byte {
+	var c htmlTextConverter
+	c.run(src)
+	return c.finish()
+}
+
+// WithPlainText returns email with Body generated from HTMLBody when it asks
+// for that: AutoPlainText is set, HTMLBody is not empty and Body is. Any other
+// email is returned unchanged, so calling it twice is harmless.
+//
+// The renderer calls it, and so does every provider, so the generated part
+// goes out whichever transport sends the message.
+func WithPlainText(email Email) Email {
+	if email.AutoPlainText && len(email.Body) == 0 && len(email.HTMLBody) > 0 {
+		email.Body = HTMLToText(email.HTMLBody)
+	}
+	return email
+}
+
+// htmlTextConverter accumulates output. Line breaks are requested rather than
+// written, and only materialise before the next word, so a run of empty
+// block elements -- common in table-based email layouts -- cannot produce a
+// page of blank lines.
+type htmlTextConverter struct {
+	out []byte
+
+	breaks    int  // newlines owed before the next word
+	space     bool // a space is owed before the next word
+	lineStart bool // nothing has been written on the current line yet
+	marker    string
+
+	pre        int
+	blockquote int
+	lists      []htmlList
+	links      []htmlLink
+
+	footnotes []string
+	footnote  map[string]int
+}
+
+type htmlList struct {
+	ordered bool
+	n       int
+}
+
+type htmlLink struct {
+	href  string
+	start int
+}
+
+// htmlVoidElements never have a closing tag.
+var htmlVoidElements = map[string]struct{}{
+	"area": {}, "base": {}, "br": {}, "col": {}, "embed": {}, "hr": {}, "img": {},
+	"input": {}, "link": {}, "meta": {}, "source": {}, "track": {}, "wbr": {},
+}
+
+// htmlParagraphs are separated from their neighbours by a blank line; the
+// other block elements only start a new line.
+var htmlParagraphs = map[string]struct{}{
+	"p": {}, "h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"table": {}, "blockquote": {}, "pre": {}, "dl": {},
+}
+
+var htmlBlocks = map[string]struct{}{
+	"div": {}, "tr": {}, "li": {}, "dt": {}, "dd": {}, "section": {}, "article": {},
+	"header": {}, "footer": {}, "nav": {}, "aside": {}, "main": {}, "figure": {},
+	"figcaption": {}, "address": {}, "center": {}, "form": {}, "caption": {},
+}
+
+func (c *htmlTextConverter) run(src []byte) {
+	c.lineStart = true
+	for i := 0; i < len(src); {
+		if src[i] != '<' {
+			end := bytes.IndexByte(src[i:], '<')
+			if end < 0 {
+				end = len(src) - i
+			}
+			c.text(html.UnescapeString(string(src[i : i+end])))
+			i += end
+			continue
+		}
+
+		rest := src[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			// Every comment is dropped, and with it every Outlook conditional:
+			// the mso-only branch lies inside its comment, while the !mso
+			// branch sits between two comments and is read as ordinary markup.
+			end := bytes.Index(rest[4:], []byte("-->"))
+			if end < 0 {
+				return
+			}
+			i += 4 + end + 3
+			continue
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")):
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				return
+			}
+			i += end + 1
+			continue
+		}
+
+		name, attrs, closing, n := parseHTMLTag(rest)
+		if n == 0 {
+			// Not a tag after all ("a < b"), so the bracket is text.
+			c.text("<")
+			i++
+			continue
+		}
+		i += n
+
+		if closing {
+			c.closeTag(name)
+			continue
+		}
+		if skip := c.openTag(name, attrs); skip {
+			i += skipHTMLElement(src[i:], name)
+		}
+	}
+}
+
+// openTag handles a start tag and reports whether the element's content
+// should be skipped.
+func (c *htmlTextConverter) openTag(name string, attrs map[string]string) bool {
+	switch name {
+	case "script", "style", "head", "title", "template", "noscript":
+		return true
+	}
+	if _, void := htmlVoidElements[name]; !void && isHiddenElement(attrs) {
+		return true
+	}
+
+	switch name {
+	case "br":
+		c.newline()
+	case "hr":
+		c.block(2)
+		c.word("------------------------------")
+		c.block(2)
+	case "img":
+		if alt := strings.TrimSpace(attrs["alt"]); alt != "" {
+			c.text(alt)
+		}
+	case "a":
+		c.links = append(c.links, htmlLink{href: strings.TrimSpace(attrs["href"]), start: len(c.out)})
+	case "ul", "ol":
+		c.block(listBreak(len(c.lists)))
+		c.lists = append(c.lists, htmlList{ordered: name == "ol"})
+	case "li":
+		c.block(1)
+		marker := "* "
+		if n := len(c.lists); n > 0 {
+			l := &c.lists[n-1]
+			l.n++
+			if l.ordered {
+				marker = strconv.Itoa(l.n) + ". "
+			}
+			marker = strings.Repeat("  ", n-1) + marker
+		}
+		c.marker = marker
+	case "td", "th":
+		c.space = true
+	case "pre":
+		c.block(2)
+		c.pre++
+	case "blockquote":
+		c.block(2)
+		c.blockquote++
+	default:
+		c.blockFor(name)
+	}
+	return false
+}
+
+func (c *htmlTextConverter) closeTag(name string) {
+	switch name {
+	case "a":
+		if n := len(c.links); n > 0 {
+			link := c.links[n-1]
+			c.links = c.links[:n-1]
+			c.footnoteFor(link)
+		}
+	case "ul", "ol":
+		if n := len(c.lists); n > 0 {
+			c.lists = c.lists[:n-1]
+		}
+		c.block(listBreak(len(c.lists)))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.underline(name == "h1")
+		c.block(2)
+	case "pre":
+		if c.pre > 0 {
+			c.pre--
+		}
+		c.block(2)
+	case "blockquote":
+		if c.blockquote > 0 {
+			c.blockquote--
+		}
+		c.block(2)
+	case "td", "th":
+		c.space = true
+	default:
+		c.blockFor(name)
+	}
+}
+
+// listBreak is the break around a list: a paragraph for a top-level list, a
+// line for one nested in an item.
+func listBreak(depth int) int {
+	if depth == 0 {
+		return 2
+	}
+	return 1
+}
+
+func (c *htmlTextConverter) blockFor(name string) {
+	if _, ok := htmlParagraphs[name]; ok {
+		c.block(2)
+	} else if _, ok := htmlBlocks[name]; ok {
+		c.block(1)
+	}
+}
+
+// block requests that the next word start n lines further down.
+func (c *htmlTextConverter) block(n int) {
+	if n > c.breaks {
+		c.breaks = n
+	}
+}
+
+// newline writes a line break at once, as <br> does; two in a row make a
+// blank line.
+func (c *htmlTextConverter) newline() {
+	c.trimTrailingSpace()
+	c.out = append(c.out, '\n')
+	c.lineStart = true
+	c.space = false
+}
+
+func (c *htmlTextConverter) text(s string) {
+	if c.pre > 0 {
+		for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+			if i > 0 {
+				c.newline()
+			}
+			if line != "" {
+				c.settleBreaks()
+				c.write(line)
+			}
+		}
+		return
+	}
+	if s == "" {
+		return
+	}
+	if isHTMLSpace(s[0]) {
+		c.space = true
+	}
+	for _, w := range strings.Fields(s) {
+		c.word(w)
+		c.space = true
+	}
+	if !isHTMLSpace(s[len(s)-1]) {
+		c.space = false
+	}
+}
+
+// word writes a word, settling any breaks and spacing owed before it.
+func (c *htmlTextConverter) word(w string) {
+	c.settleBreaks()
+	if c.space && !c.lineStart {
+		c.out = append(c.out, ' ')
+	}
+	c.space = false
+	c.write(w)
+}
+
+// settleBreaks writes the line breaks owed by the block elements passed since
+// the last word.
+func (c *htmlTextConverter) settleBreaks() {
+	if c.breaks > 0 && len(c.out) > 0 {
+		c.trimTrailingSpace()
+		have := len(c.out) - len(bytes.TrimRight(c.out, "\n"))
+		for ; have < c.breaks; have++ {
+			c.out = append(c.out, '\n')
+		}
+		c.lineStart = true
+	}
+	c.breaks = 0
+}
+
+func (c *htmlTextConverter) write(s string) {
+	if c.lineStart {
+		c.out = append(c.out, strings.Repeat("> ", c.blockquote)...)
+		c.out = append(c.out, c.marker...)
+		c.marker = ""
+		c.lineStart = false
+	}
+	c.out = append(c.out, s...)
+}
+
+func (c *htmlTextConverter) trimTrailingSpace() {
+	c.out = bytes.TrimRight(c.out, " \t")
+}
+
+// underline draws a rule under the line just written, as long as the line.
+func (c *htmlTextConverter) underline(major bool) {
+	line := c.out[bytes.LastIndexByte(c.out, '\n')+1:]
+	n := utf8.RuneCount(bytes.TrimSpace(line))
+	if n == 0 || c.lineStart {
+		return
+	}
+	rule := "-"
+	if major {
+		rule = "="
+	}
+	c.newline()
+	c.write(strings.Repeat(rule, n))
+}
+
+// footnoteFor numbers a link's target after its text, unless the text already
+// is the target.
+func (c *htmlTextConverter) footnoteFor(link htmlLink) {
+	href := link.href
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+		return
+	}
+	text := ""
+	if link.start <= len(c.out) {
+		text = strings.TrimSpace(string(c.out[link.start:]))
+	}
+	if sameLinkTarget(text, href) {
+		return
+	}
+
+	if c.footnote == nil {
+		c.footnote = make(map[string]int)
+	}
+	n, ok := c.footnote[href]
+	if !ok {
+		c.footnotes = append(c.footnotes, href)
+		n = len(c.footnotes)
+		c.footnote[href] = n
+	}
+	c.space = text != ""
+	c.word("[" + strconv.Itoa(n) + "]")
+}
+
+// sameLinkTarget reports whether a link's text already spells out where it
+// goes, so a footnote would only repeat it.
+func sameLinkTarget(text, href string) bool {
+	if text == href {
+		return true
+	}
+	for _, scheme := range []string{"mailto:", "tel:", "https://", "http://"} {
+		if len(href) > len(scheme) && strings.EqualFold(href[:len(scheme)], scheme) {
+			return strings.TrimSuffix(text, "/") == strings.TrimSuffix(href[len(scheme):], "/")
+		}
+	}
+	return false
+}
+
+func (c *htmlTextConverter) finish() []byte {
+	lines := strings.Split(string(c.out), "\n")
+	var b strings.Builder
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			blank++
+			continue
+		}
+		if b.Len() > 0 {
+			if blank > 0 {
+				b.WriteString("\n\n")
+			} else {
+				b.WriteByte('\n')
+			}
+		}
+		blank = 0
+		b.WriteString(line)
+	}
+	if len(c.footnotes) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		for i, href := range c.footnotes {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString("[" + strconv.Itoa(i+1) + "] " + href)
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+func isHTMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+// isHiddenElement reports whether an element is hidden from every reader of
+// the HTML, as an inbox preheader is.
+func isHiddenElement(attrs map[string]string) bool {
+	if _, ok := attrs["hidden"]; ok {
+		return true
+	}
+	style := strings.ToLower(strings.Join(strings.Fields(attrs["style"]), ""))
+	return strings.Contains(style, "display:none")
+}
+
+// parseHTMLTag parses the tag at the start of src, returning its lower-case
+// name, its attributes, whether it is an end tag, and its length. A length of
+// zero means src does not start with a tag.
+func parseHTMLTag(src []byte) (name string, attrs map[string]string, closing bool, n int) {
+	i := 1
+	if i < len(src) && src[i] == '/' {
+		closing = true
+		i++
+	}
+	start := i
+	for i < len(src) && (isASCIILetter(src[i]) || (i > start && (src[i] >= '0' && src[i] <= '9' || src[i] == '-' || src[i] == ':'))) {
+		i++
+	}
+	if i == start {
+		return "", nil, false, 0
+	}
+	name = strings.ToLower(string(src[start:i]))
+
+	for i < len(src) {
+		for i < len(src) && (isHTMLSpace(src[i]) || src[i] == '/') {
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			return name, attrs, closing, i + 1
+		}
+
+		keyStart := i
+		for i < len(src) && !isHTMLSpace(src[i]) && src[i] != '=' && src[i] != '>' && src[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(string(src[keyStart:i]))
+		for i < len(src) && isHTMLSpace(src[i]) {
+			i++
+		}
+		value := ""
+		if i < len(src) && src[i] == '=' {
+			i++
+			for i < len(src) && isHTMLSpace(src[i]) {
+				i++
+			}
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+				quote := src[i]
+				end := bytes.IndexByte(src[i+1:], quote)
+				if end < 0 {
+					return "", nil, false, 0
+				}
+				value = string(src[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(src) && !isHTMLSpace(src[i]) && src[i] != '>' {
+					i++
+				}
+				value = string(src[valueStart:i])
+			}
+		}
+		if key == "" {
+			i++
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string, 4)
+		}
+		if _, dup := attrs[key]; !dup {
+			attrs[key] = html.UnescapeString(value)
+		}
+	}
+	// An unterminated tag runs to the end of the input and shows nothing.
+	return name, attrs, closing, len(src)
+}
+
+func isASCIILetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// skipHTMLElement returns how far past an element's content its end tag lies,
+// counting nested elements of the same name so a hidden div holding divs is
+// skipped whole. An element never closed runs to the end of the input.
+func skipHTMLElement(src []byte, name string) int {
+	open := "<" + name
+	end := "</" + name
+	depth := 1
+	for i := 0; i < len(src); {
+		next := bytes.IndexByte(src[i:], '<')
+		if next < 0 {
+			break
+		}
+		i += next
+		switch {
+		case hasPrefixFold(src[i:], end) && htmlNameEnds(src, i+len(end)):
+			depth--
+			if depth == 0 {
+				if close := bytes.IndexByte(src[i:], '>'); close >= 0 {
+					return i + close + 1
+				}
+				return len(src)
+			}
+		case hasPrefixFold(src[i:], open) && htmlNameEnds(src, i+len(open)):
+			// script and style are raw text: a "<script" inside one is not
+			// a nested element.
+			if name != "script" && name != "style" {
+				depth++
+			}
+		}
+		i++
+	}
+	return len(src)
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring ASCII case.
+// Tag names are ASCII, and comparing in place keeps offsets valid in s:
+// lowercasing the input first changes the length of text such as "Ⱥ".
+func hasPrefixFold(s []byte, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if lowerASCII(s[i]) != lowerASCII(prefix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFold returns the offset of the first instance of substr in s, ignoring
+// ASCII case, or -1.
+func indexFold(s []byte, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if hasPrefixFold(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lastIndexFold returns the offset of the last instance of substr in s,
+// ignoring ASCII case, or -1.
+func lastIndexFold(s []byte, substr string) int {
+	for i := len(s) - len(substr); i >= 0; i-- {
+		if hasPrefixFold(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func lowerASCII(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func htmlNameEnds(src []byte, i int) bool {
+	return i >= len(src) || isHTMLSpace(src[i]) || src[i] == '>' || src[i] == '/'
+}
@@ -0,0 +1,149 @@
+package gsmail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gsoultan/gsmail/outlook"
+)
+
+func TestHTMLToText(t *testing.T) {
+	cases := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and line breaks",
+			html: "<p>Hello   <b>Ana</b>,</p><p>Line one<br>Line two</p>",
+			want: "Hello Ana,\n\nLine one\nLine two\n",
+		},
+		{
+			name: "headings are underlined",
+			html: "<h1>Welcome</h1><h2>Next steps</h2><p>Read on.</p>",
+			want: "Welcome\n=======\n\nNext steps\n----------\n\nRead on.\n",
+		},
+		{
+			name: "links become footnotes, repeated targets share one",
+			html: `<p><a href="https://example.com/a">Docs</a> and <a href="https://example.com/b">blog</a>. <a href="https://example.com/a">Docs again</a>.</p>`,
+			want: "Docs [1] and blog [2]. Docs again [1].\n\n[1] https://example.com/a\n[2] https://example.com/b\n",
+		},
+		{
+			name: "a link whose text is its target needs no footnote",
+			html: `<p>Visit <a href="https://example.com/">example.com</a> or <a href="mailto:help@example.com">help@example.com</a>.</p>`,
+			want: "Visit example.com or help@example.com.\n",
+		},
+		{
+			name: "tables are flattened one row per line",
+			html: "<table><tr><td>Item</td><td>Price</td></tr><tr><td>Tea</td><td>&pound;3</td></tr></table>",
+			want: "Item Price\nTea £3\n",
+		},
+		{
+			name: "lists",
+			html: "<ul><li>one</li><li>two<ol><li>a</li><li>b</li></ol></li></ul>",
+			want: "* one\n* two\n  1. a\n  2. b\n",
+		},
+		{
+			name: "invisible content is dropped",
+			html: `<html><head><title>T</title><style>p{color:red}</style></head><body>` +
+				`<div style="display: none">preheader <div>nested</div></div>` +
+				`<script>if (a < b) {}</script><p>Visible</p></body></html>`,
+			want: "Visible\n",
+		},
+		{
+			// "Ⱥ" lowercases to a longer encoding; offsets must stay those
+			// of the original bytes.
+			name: "hidden content holding text whose case changes length",
+			html: `<style>p{content:"` + strings.Repeat("Ⱥ", 40) + `"}</style><SCRIPT>"ȺȺ"</SCRIPT><p>Visible ȺȾ</p>`,
+			want: "Visible ȺȾ\n",
+		},
+		{
+			name: "images become their alt text",
+			html: `<p><img src="logo.png" alt="ACME"> news</p>`,
+			want: "ACME news\n",
+		},
+		{
+			name: "a stray bracket is text",
+			html: "<p>1 < 2</p>",
+			want: "1 < 2\n",
+		},
+		{
+			name: "blockquote and pre",
+			html: "<blockquote>quoted</blockquote><pre>a  b\n  c</pre>",
+			want: "> quoted\n\na  b\n  c\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(HTMLToText([]byte(tc.html))); got != tc.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+// The Outlook helpers emit conditional comments. The mso-only branch is a
+// duplicate for Outlook and must not appear; the !mso branch is what every
+// other client shows and must.
+func TestHTMLToTextStripsMSOConditionals(t *testing.T) {
+	src := outlook.MSOOnly("<p>Outlook copy</p>") + outlook.HideFromMSO("<p>Everyone else</p>")
+	got := string(HTMLToText([]byte(src)))
+	if got != "Everyone else\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestAutoPlainText(t *testing.T) {
+	t.Run("SetHTMLBody fills Body when asked", func(t *testing.T) {
+		e := Email{AutoPlainText: true}
+		if err := e.SetHTMLBody("<p>Hi {{.}}</p>", "Ana"); err != nil {
+			t.Fatal(err)
+		}
+		if string(e.Body) != "Hi Ana\n" {
+			t.Errorf("Body = %q", e.Body)
+		}
+		// Rendering again must not leave the old text behind.
+		if err := e.SetHTMLBody("<p>Bye {{.}}</p>", "Ana"); err != nil {
+			t.Fatal(err)
+		}
+		if string(e.Body) != "Bye Ana\n" {
+			t.Errorf("Body after re-render = %q", e.Body)
+		}
+	})
+
+	t.Run("it is opt-in", func(t *testing.T) {
+		var e Email
+		if err := e.SetBody("<p>Hi</p>", nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(e.Body) != 0 {
+			t.Errorf("Body = %q, want it left empty", e.Body)
+		}
+	})
+
+	t.Run("the renderer fills it for a hand-built message", func(t *testing.T) {
+		msg, err := RenderMessage(Email{
+			From:          "a@example.com",
+			To:            []string{"b@example.com"},
+			HTMLBody:      []byte("<p>Only HTML</p>"),
+			AutoPlainText: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseRawEmail(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(msg), "multipart/alternative") || string(parsed.Body) != "Only HTML\n" {
+			t.Errorf("expected a text alternative, got Body %q in:\n%s", parsed.Body, msg)
+		}
+	})
+
+	t.Run("a Body set by hand is kept", func(t *testing.T) {
+		got := WithPlainText(Email{Body: []byte("mine"), HTMLBody: []byte("<p>x</p>"), AutoPlainText: true})
+		if string(got.Body) != "mine" {
+			t.Errorf("Body = %q", got.Body)
+		}
+	})
+}
@@ -0,0 +1,627 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseCalendar reads the event from an iCalendar object, such as the
+// text/calendar part of an invitation or an .ics attachment. When the object
+// holds several events -- a series and the occurrences it overrides -- the
+// first is returned.
+//
+// Times come back in the zone they were written in. A TZID is resolved
+// through the IANA database when it names a zone there, and otherwise from
+// the VTIMEZONE that must accompany it, which is how Outlook's Windows zone
+// names ("W. Europe Standard Time") are read; such a time carries a fixed
+// offset, the one in force at that moment. A floating time, which belongs to
+// no zone, is returned in UTC. A TZID that neither the database nor the
+// object defines is an error rather than a guess.
+//
+// Attendees and the organizer are returned as addresses in the form the rest
+// of the package uses, with CN as the display name. Properties the
+// CalendarEvent does not model are ignored.
+func ParseCalendar(data []byte) (*CalendarEvent, error) {
+	cal, err := parseICS(data)
+	if err != nil {
+		return nil, err
+	}
+	if cal.event == nil {
+		return nil, fmt.Errorf("%w: no VEVENT", ErrInvalidCalendar)
+	}
+
+	ev := &CalendarEvent{Method: CalendarMethod(strings.ToUpper(cal.method))}
+	for _, p := range cal.event {
+		var err error
+		switch p.name {
+		case "UID":
+			ev.UID = p.text()
+		case "SEQUENCE":
+			ev.Sequence, _ = strconv.Atoi(strings.TrimSpace(p.value))
+		case "SUMMARY":
+			ev.Summary = p.text()
+		case "DESCRIPTION":
+			ev.Description = p.text()
+		case "LOCATION":
+			ev.Location = p.text()
+		case "DTSTAMP":
+			ev.Stamp, err = cal.time(p, p.value)
+		case "DTSTART":
+			ev.Start, err = cal.time(p, p.value)
+			ev.AllDay = p.isDate()
+		case "DTEND":
+			ev.End, err = cal.time(p, p.value)
+		case "RECURRENCE-ID":
+			ev.RecurrenceID, err = cal.time(p, p.value)
+		case "RRULE":
+			ev.Recurrence = p.value
+		case "EXDATE":
+			for _, v := range strings.Split(p.value, ",") {
+				var t time.Time
+				if t, err = cal.time(p, v); err != nil {
+					break
+				}
+				ev.ExceptDates = append(ev.ExceptDates, t)
+			}
+		case "ORGANIZER":
+			ev.Organizer = p.address()
+		case "ATTENDEE":
+			at := Attendee{
+				Address: p.address(),
+				Role:    AttendeeRole(strings.ToUpper(p.params["ROLE"])),
+				Status:  ParticipationStatus(strings.ToUpper(p.params["PARTSTAT"])),
+				RSVP:    strings.EqualFold(p.params["RSVP"], "TRUE"),
+			}
+			if at.Address != "" {
+				ev.Attendees = append(ev.Attendees, at)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCalendar, p.name, err)
+		}
+	}
+	if ev.End.IsZero() && !ev.Start.IsZero() {
+		// DURATION is the other way of stating the end.
+		for _, p := range cal.event {
+			if p.name == "DURATION" {
+				if d, ok := parseICSDuration(p.value); ok {
+					ev.End = ev.Start.Add(d)
+				}
+			}
+		}
+	}
+	return ev, nil
+}
+
+// ErrNotInvited is returned by NewCalendarReply for an address the invitation
+// does not list as an attendee.
+var ErrNotInvited = errors.New("gsmail: address is not an attendee of the invitation")
+
+// NewCalendarReply builds the message with which attendee answers invite: a
+// REPLY to the organizer carrying attendee's status, threaded under the
+// invitation, with a subject in the form clients use ("Accepted: Standup").
+//
+// status must be StatusAccepted, StatusDeclined or StatusTentative. attendee
+// is the From of the reply and must be listed among the invitation's
+// attendees, compared by address alone. The reply repeats the invitation's
+// UID, Sequence and RecurrenceID, which is how the organizer's calendar
+// finds the event -- or the occurrence -- being answered.
+func NewCalendarReply(invite Email, attendee string, status ParticipationStatus) (Email, error) {
+	ev := invite.Calendar
+	if ev == nil {
+		return Email{}, fmt.Errorf("%w: the message carries no invitation", ErrInvalidCalendar)
+	}
+	var verb string
+	switch status {
+	case StatusAccepted:
+		verb = "Accepted"
+	case StatusDeclined:
+		verb = "Declined"
+	case StatusTentative:
+		verb = "Tentative"
+	default:
+		return Email{}, fmt.Errorf("%w: cannot reply with status %q", ErrInvalidCalendar, status)
+	}
+
+	who, err := ParseEmailAddress(attendee)
+	if err != nil || who == nil {
+		return Email{}, fmt.Errorf("%w: attendee %q is not an address", ErrInvalidCalendar, attendee)
+	}
+	var listed *Attendee
+	for i, at := range ev.Attendees {
+		if a, err := ParseEmailAddress(at.Address); err == nil && a != nil && strings.EqualFold(a.Address, who.Address) {
+			listed = &ev.Attendees[i]
+			break
+		}
+	}
+	if listed == nil {
+		return Email{}, fmt.Errorf("%w: %s", ErrNotInvited, who.Address)
+	}
+
+	organizer := ev.Organizer
+	if organizer == "" {
+		organizer = invite.From
+	}
+	summary := ev.Summary
+	if summary == "" {
+		summary = invite.Subject
+	}
+	name := who.Name
+	if name == "" {
+		name = who.Address
+	}
+
+	reply := Email{
+		From:    attendee,
+		To:      []string{organizer},
+		Subject: verb + ": " + summary,
+		Body:    []byte(name + " has " + strings.ToLower(string(status)) + " this invitation.\r\n"),
+		Calendar: &CalendarEvent{
+			Method:       MethodReply,
+			UID:          ev.UID,
+			Sequence:     ev.Sequence,
+			Summary:      ev.Summary,
+			Start:        ev.Start,
+			End:          ev.End,
+			AllDay:       ev.AllDay,
+			RecurrenceID: ev.RecurrenceID,
+			Organizer:    organizer,
+			Attendees:    []Attendee{{Address: listed.Address, Role: listed.Role, Status: status}},
+		},
+	}
+	if id := invite.MessageID(); id != "" {
+		refs := strings.TrimSpace(invite.Header("References"))
+		if refs != "" {
+			refs += " "
+		}
+		reply.SetHeader("In-Reply-To", "<"+id+">")
+		reply.SetHeader("References", refs+"<"+id+">")
+	}
+	return reply, nil
+}
+
+// isCalendarPart reports whether a part holds an iCalendar object, by its
+// media type or, for attachments sent as application/octet-stream, its name.
+func isCalendarPart(contentType, filename string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/calendar", "application/ics":
+		return true
+	}
+	return strings.EqualFold(path.Ext(filename), ".ics")
+}
+
+// receivedCalendar parses the calendar part of a received message, or returns
+// nil when it does not parse or is not an event this package can render again
+// -- a COUNTER or REFRESH, or an event missing what validate requires. The
+// part then stays in the message as it arrived, so the message can still be
+// archived or forwarded.
+func receivedCalendar(data []byte, from string) *CalendarEvent {
+	ev, err := ParseCalendar(data)
+	if err != nil || ev.validate(from) != nil {
+		return nil
+	}
+	return ev
+}
+
+// attachedCalendar parses the first calendar attachment, for a message that
+// carries its invitation only as a file.
+func attachedCalendar(atts []Attachment, from string) *CalendarEvent {
+	for _, att := range atts {
+		if !isCalendarPart(att.ContentType, att.Filename) {
+			continue
+		}
+		if ev := receivedCalendar(att.Data, from); ev != nil {
+			return ev
+		}
+	}
+	return nil
+}
+
+// withoutCalendarFiles drops the attachments holding ev. Rendering an Email
+// with Calendar set attaches the event as invite.ics again, so keeping the
+// received copy would send it twice.
+func withoutCalendarFiles(atts []Attachment, ev *CalendarEvent) []Attachment {
+	var out []Attachment
+	for _, att := range atts {
+		if isCalendarPart(att.ContentType, att.Filename) {
+			if other, err := ParseCalendar(att.Data); err == nil && other.UID == ev.UID {
+				continue
+			}
+		}
+		out = append(out, att)
+	}
+	return out
+}
+
+// icsProperty is one content line: NAME;PARAM=value:value.
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func (p icsProperty) text() string {
+	return unescapeICSText(p.value)
+}
+
+func (p icsProperty) isDate() bool {
+	return strings.EqualFold(p.params["VALUE"], "DATE") || len(strings.TrimSpace(p.value)) == len(icalDate)
+}
+
+// address turns a cal-address and its CN into an address string.
+func (p icsProperty) address() string {
+	v := strings.TrimSpace(p.value)
+	if len(v) >= 7 && strings.EqualFold(v[:7], "mailto:") {
+		v = v[7:]
+	}
+	v = usableAddress(v)
+	if v == "" {
+		return ""
+	}
+	if name := strings.TrimSpace(p.params["CN"]); name != "" && !strings.EqualFold(name, v) {
+		return (&mail.Address{Name: name, Address: v}).String()
+	}
+	return v
+}
+
+// icsTimezone is a VTIMEZONE: the observances that say which offset is in
+// force when.
+type icsTimezone struct {
+	observances []icsObservance
+}
+
+type icsObservance struct {
+	start    time.Time // local wall time, on a UTC clock
+	from, to int
+	month    time.Month
+	week     int // nth weekday of the month; -1 is the last
+	weekday  time.Weekday
+	yearly   bool
+}
+
+type icsCalendar struct {
+	method string
+	event  []icsProperty
+	zones  map[string]*icsTimezone
+}
+
+// maxICSLines bounds how much of a hostile object is read. Real invitations
+// run to a few hundred lines, mostly timezone rules.
+const maxICSLines = 10000
+
+func parseICS(data []byte) (*icsCalendar, error) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n "), nil)
+	data = bytes.ReplaceAll(data, []byte("\n\t"), nil)
+	lines := strings.Split(string(data), "\n")
+	if len(lines) > maxICSLines {
+		return nil, fmt.Errorf("%w: more than %d lines", ErrInvalidCalendar, maxICSLines)
+	}
+
+	cal := &icsCalendar{zones: make(map[string]*icsTimezone)}
+	var (
+		stack    []string
+		events   int
+		tzid     string
+		zone     *icsTimezone
+		observed *icsObservance
+	)
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		p, ok := parseICSLine(line)
+		if !ok {
+			continue
+		}
+		switch p.name {
+		case "BEGIN":
+			kind := strings.ToUpper(p.value)
+			stack = append(stack, kind)
+			switch kind {
+			case "VEVENT":
+				events++
+			case "VTIMEZONE":
+				zone, tzid = &icsTimezone{}, ""
+			case "STANDARD", "DAYLIGHT":
+				observed = &icsObservance{}
+			}
+			continue
+		case "END":
+			if n := len(stack); n > 0 {
+				switch stack[n-1] {
+				case "VTIMEZONE":
+					if zone != nil && tzid != "" {
+						cal.zones[tzid] = zone
+					}
+					zone = nil
+				case "STANDARD", "DAYLIGHT":
+					if zone != nil && observed != nil {
+						zone.observances = append(zone.observances, *observed)
+					}
+					observed = nil
+				}
+				stack = stack[:n-1]
+			}
+			continue
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		switch stack[len(stack)-1] {
+		case "VCALENDAR":
+			if p.name == "METHOD" {
+				cal.method = strings.TrimSpace(p.value)
+			}
+		case "VEVENT":
+			if events == 1 {
+				cal.event = append(cal.event, p)
+			}
+		case "VTIMEZONE":
+			if p.name == "TZID" {
+				tzid = strings.TrimSpace(p.value)
+			}
+		case "STANDARD", "DAYLIGHT":
+			if observed != nil {
+				observed.set(p)
+			}
+		}
+	}
+	return cal, nil
+}
+
+func (o *icsObservance) set(p icsProperty) {
+	switch p.name {
+	case "DTSTART":
+		o.start, _ = time.Parse(icalLocal, strings.TrimSpace(p.value))
+	case "TZOFFSETFROM":
+		o.from, _ = parseICSOffset(p.value)
+	case "TZOFFSETTO":
+		o.to, _ = parseICSOffset(p.value)
+	case "RRULE":
+		o.yearly, o.month, o.week, o.weekday = parseYearlyRule(p.value)
+	}
+}
+
+// parseICSLine splits a content line into its name, parameters and value.
+// A colon inside a quoted parameter value does not end the parameters.
+func parseICSLine(line string) (icsProperty, bool) {
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return icsProperty{}, false
+	}
+	p := icsProperty{name: strings.ToUpper(line[:end])}
+	rest := line[end:]
+	for len(rest) > 0 && rest[0] == ';' {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return icsProperty{}, false
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return icsProperty{}, false
+			}
+			val, rest = rest[1:1+closing], rest[2+closing:]
+		} else {
+			stop := strings.IndexAny(rest, ";:")
+			if stop < 0 {
+				return icsProperty{}, false
+			}
+			val, rest = rest[:stop], rest[stop:]
+		}
+		if p.params == nil {
+			p.params = make(map[string]string, 4)
+		}
+		p.params[key] = val
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return icsProperty{}, false
+	}
+	p.value = rest[1:]
+	return p, true
+}
+
+var icsTextUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func unescapeICSText(s string) string {
+	return icsTextUnescaper.Replace(s)
+}
+
+// time reads a DATE or DATE-TIME value of property p.
+func (cal *icsCalendar) time(p icsProperty, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == len(icalDate) {
+		return time.Parse(icalDate, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icalUTC, value)
+	}
+	tzid := strings.TrimSpace(p.params["TZID"])
+	if tzid == "" {
+		return time.Parse(icalLocal, value)
+	}
+	if loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+		return time.ParseInLocation(icalLocal, value, loc)
+	}
+	zone, ok := cal.zones[tzid]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+	}
+	wall, err := time.Parse(icalLocal, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset := zone.offsetAt(wall)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.FixedZone(tzid, offset)), nil
+}
+
+// offsetAt returns the offset in force at a local wall time: that of the
+// observance which began most recently before it.
+func (z *icsTimezone) offsetAt(wall time.Time) int {
+	var (
+		best   time.Time
+		offset int
+		found  bool
+	)
+	for _, o := range z.observances {
+		onset, ok := o.latestOnset(wall)
+		if ok && (!found || onset.After(best)) {
+			best, offset, found = onset, o.to, true
+		}
+	}
+	if !found && len(z.observances) > 0 {
+		return z.observances[0].from
+	}
+	return offset
+}
+
+// latestOnset is the last time the observance came into force at or before
+// wall.
+func (o icsObservance) latestOnset(wall time.Time) (time.Time, bool) {
+	if o.start.After(wall) {
+		return time.Time{}, false
+	}
+	if !o.yearly {
+		return o.start, true
+	}
+	for year := wall.Year(); year >= wall.Year()-1; year-- {
+		day := nthWeekday(year, o.month, o.week, o.weekday)
+		onset := time.Date(year, o.month, day, o.start.Hour(), o.start.Minute(), o.start.Second(), 0, time.UTC)
+		if !onset.After(wall) && !onset.Before(o.start) {
+			return onset, true
+		}
+	}
+	return o.start, true
+}
+
+// nthWeekday is the day of the month of its nth weekday, counting from the
+// end when n is negative.
+func nthWeekday(year int, month time.Month, n int, wd time.Weekday) int {
+	if n < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.Day() - (int(last.Weekday())-int(wd)+7)%7 + (n+1)*7
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return 1 + (int(wd)-int(first.Weekday())+7)%7 + (n-1)*7
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseYearlyRule reads the one shape of RRULE a VTIMEZONE uses in practice,
+// FREQ=YEARLY;BYMONTH=m;BYDAY=nWD. Any other rule reports false, and the
+// observance is treated as happening once, at its DTSTART.
+func parseYearlyRule(rule string) (ok bool, month time.Month, week int, wd time.Weekday) {
+	var freq string
+	for _, part := range strings.Split(rule, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			freq = strings.ToUpper(val)
+		case "BYMONTH":
+			m, err := strconv.Atoi(val)
+			if err != nil || m < 1 || m > 12 {
+				return false, 0, 0, 0
+			}
+			month = time.Month(m)
+		case "BYDAY":
+			val = strings.ToUpper(val)
+			if len(val) < 3 {
+				return false, 0, 0, 0
+			}
+			day, known := icsWeekdays[val[len(val)-2:]]
+			n, err := strconv.Atoi(strings.TrimPrefix(val[:len(val)-2], "+"))
+			if !known || err != nil || n == 0 || n < -1 || n > 5 {
+				return false, 0, 0, 0
+			}
+			week, wd = n, day
+		}
+	}
+	return freq == "YEARLY" && month != 0 && week != 0, month, week, wd
+}
+
+// parseICSOffset reads a UTC offset such as "-0500" or "+053000".
+func parseICSOffset(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) != 5 && len(s) != 7 {
+		return 0, false
+	}
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return 0, false
+	}
+	if len(s) == 5 {
+		n *= 100
+	}
+	return sign * (n/10000*3600 + n/100%100*60 + n%100), true
+}
+
+// parseICSDuration reads a DURATION value such as "PT1H30M" or "P1D".
+func parseICSDuration(s string) (time.Duration, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, false
+	}
+	var d time.Duration
+	n, parts := 0, 0
+	digits := false
+	for _, r := range s[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			n = n*10 + int(r-'0')
+			digits = true
+			continue
+		case r == 'T':
+			continue
+		}
+		if !digits {
+			return 0, false
+		}
+		switch r {
+		case 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			d += time.Duration(n) * time.Hour
+		case 'M':
+			d += time.Duration(n) * time.Minute
+		case 'S':
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, false
+		}
+		n, digits = 0, false
+		parts++
+	}
+	return sign * d, parts > 0 && !digits
+}
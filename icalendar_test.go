@@ -0,0 +1,264 @@
+package gsmail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outlookInvite is the shape of an invitation from Outlook: a Windows zone
+// name defined only by its VTIMEZONE, folded lines, quoted CN parameters and
+// a DURATION instead of DTEND.
+const outlookInvite = "BEGIN:VCALENDAR\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"PRODID:Microsoft Exchange Server 2010\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:W. Europe Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:+0100\r\n" +
+	"TZOFFSETTO:+0200\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"ORGANIZER;CN=\"Müller, Anna\":mailto:anna@example.com\r\n" +
+	"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE;CN=Bob:MAILTO:bo\r\n" +
+	" b@example.com\r\n" +
+	"ATTENDEE;ROLE=OPT-PARTICIPANT;PARTSTAT=TENTATIVE:mailto:carol@example.com\r\n" +
+	"SUMMARY;LANGUAGE=en-US:Budget review\\, Q4\r\n" +
+	"DTSTART;TZID=W. Europe Standard Time:20260715T140000\r\n" +
+	"DURATION:PT1H30M\r\n" +
+	"UID:040000008200E00074C5B7101A82E008@example.com\r\n" +
+	"SEQUENCE:3\r\n" +
+	"DTSTAMP:20260701T080000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendarOutlook(t *testing.T) {
+	ev, err := ParseCalendar([]byte(outlookInvite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Method != MethodRequest || ev.UID != "040000008200E00074C5B7101A82E008@example.com" || ev.Sequence != 3 {
+		t.Errorf("method %q, uid %q, sequence %d", ev.Method, ev.UID, ev.Sequence)
+	}
+	if ev.Summary != "Budget review, Q4" {
+		t.Errorf("summary = %q", ev.Summary)
+	}
+	if !strings.HasSuffix(ev.Organizer, " <anna@example.com>") {
+		t.Errorf("organizer = %q", ev.Organizer)
+	}
+
+	// July in Central Europe is summer time, two hours ahead of UTC.
+	want := time.Date(2026, time.July, 15, 12, 0, 0, 0, time.UTC)
+	if !ev.Start.Equal(want) {
+		t.Errorf("start = %s, want %s", ev.Start, want)
+	}
+	if !ev.End.Equal(want.Add(90 * time.Minute)) {
+		t.Errorf("end = %s", ev.End)
+	}
+
+	if len(ev.Attendees) != 2 {
+		t.Fatalf("attendees = %+v", ev.Attendees)
+	}
+	bob := ev.Attendees[0]
+	if bob.Address != `"Bob" <bob@example.com>` || bob.Role != RoleRequired || bob.Status != StatusNeedsAction || !bob.RSVP {
+		t.Errorf("bob = %+v", bob)
+	}
+	if ev.Attendees[1].Status != StatusTentative {
+		t.Errorf("carol = %+v", ev.Attendees[1])
+	}
+}
+
+func TestParseCalendarWinterOffset(t *testing.T) {
+	winter := strings.Replace(outlookInvite, "20260715T140000", "20260115T140000", 1)
+	ev, err := ParseCalendar([]byte(winter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.January, 15, 13, 0, 0, 0, time.UTC); !ev.Start.Equal(want) {
+		t.Errorf("start = %s, want %s", ev.Start, want)
+	}
+}
+
+func TestParseCalendarUnknownZone(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART;TZID=Nowhere Standard Time:20260115T140000\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	if _, err := ParseCalendar([]byte(ics)); !errors.Is(err, ErrInvalidCalendar) {
+		t.Errorf("expected ErrInvalidCalendar, got %v", err)
+	}
+}
+
+// An invitation rendered by this package parses back into the same event.
+func TestParseRawEmailCalendarRoundTrip(t *testing.T) {
+	sent := testEvent(t)
+	msg, err := RenderMessage(Email{
+		From:     "Alice <alice@example.com>",
+		To:       []string{"Bob <bob@example.com>"},
+		Subject:  "Standup",
+		Body:     []byte("Weekly standup"),
+		Calendar: sent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseRawEmail(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := got.Calendar
+	if ev == nil {
+		t.Fatal("the invitation was not parsed")
+	}
+	if ev.UID != sent.UID || ev.Method != MethodRequest || ev.Recurrence != sent.Recurrence || ev.Location != sent.Location {
+		t.Errorf("event = %+v", ev)
+	}
+	if !ev.Start.Equal(sent.Start) || ev.Start.Location().String() != "America/New_York" {
+		t.Errorf("start = %s", ev.Start)
+	}
+	if ev.Organizer != `"Alice" <alice@example.com>` || len(ev.Attendees) != 1 || ev.Attendees[0].Address != `"Bob" <bob@example.com>` {
+		t.Errorf("organizer %q, attendees %+v", ev.Organizer, ev.Attendees)
+	}
+	if string(got.Body) != "Weekly standup" {
+		t.Errorf("body = %q", got.Body)
+	}
+	// The alternative part is the event, not a file, and invite.ics is the
+	// same event, which rendering attaches again.
+	if len(got.Attachments) != 0 {
+		t.Errorf("attachments = %+v", got.Attachments)
+	}
+
+	again, err := RenderMessage(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(again), "invite.ics"); n != 1 {
+		t.Errorf("re-rendered message names invite.ics %d times, want 1", n)
+	}
+}
+
+// A calendar part the renderer does not support stays as it arrived, so the
+// message can still be written out again.
+func TestParseRawEmailCalendarUnsupportedMethod(t *testing.T) {
+	counter := strings.Replace(outlookInvite, "METHOD:REQUEST", "METHOD:COUNTER", 1)
+	raw := "From: bob@example.com\r\n" +
+		"To: anna@example.com\r\n" +
+		"Subject: New time proposed\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nHow about Thursday?\r\n" +
+		"--b\r\nContent-Type: text/calendar; method=COUNTER\r\n\r\n" + counter +
+		"--b--\r\n"
+	got, err := ParseRawEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calendar != nil {
+		t.Errorf("Calendar = %+v, want nil for a COUNTER", got.Calendar)
+	}
+	if len(got.Attachments) != 1 || !strings.Contains(string(got.Attachments[0].Data), "METHOD:COUNTER") {
+		t.Fatalf("attachments = %+v, want the calendar part kept", got.Attachments)
+	}
+	if _, err := RenderMessage(got); err != nil {
+		t.Errorf("RenderMessage: %v", err)
+	}
+}
+
+func TestParseRawEmailCalendarAttachmentOnly(t *testing.T) {
+	msg, err := RenderMessage(Email{
+		From: "anna@example.com",
+		To:   []string{"bob@example.com"},
+		Body: []byte("See attached."),
+		Attachments: []Attachment{{
+			Filename:    "meeting.ics",
+			ContentType: "application/octet-stream",
+			Data:        []byte(outlookInvite),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseRawEmail(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calendar == nil || got.Calendar.Sequence != 3 {
+		t.Errorf("calendar = %+v", got.Calendar)
+	}
+	if len(got.Attachments) != 0 {
+		t.Errorf("attachments = %+v, want meeting.ics replaced by Calendar", got.Attachments)
+	}
+}
+
+func TestNewCalendarReply(t *testing.T) {
+	invite, err := ParseRawEmail([]byte("From: anna@example.com\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: Invitation: Budget review\r\n" +
+		"Message-ID: <invite-1@example.com>\r\n" +
+		"Content-Type: text/calendar; method=REQUEST\r\n" +
+		"\r\n" + outlookInvite))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := NewCalendarReply(invite, "Bob <BOB@example.com>", StatusAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Subject != "Accepted: Budget review, Q4" || reply.Header("In-Reply-To") != "<invite-1@example.com>" {
+		t.Errorf("subject %q, in-reply-to %q", reply.Subject, reply.Header("In-Reply-To"))
+	}
+	if len(reply.To) != 1 || !strings.HasSuffix(reply.To[0], "<anna@example.com>") {
+		t.Errorf("to = %q", reply.To)
+	}
+
+	msg, err := RenderMessage(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseRawEmail(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := parsed.Calendar
+	if ev == nil || ev.Method != MethodReply || ev.UID != invite.Calendar.UID || ev.Sequence != 3 {
+		t.Fatalf("reply event = %+v", ev)
+	}
+	if len(ev.Attendees) != 1 || ev.Attendees[0].Address != `"Bob" <bob@example.com>` || ev.Attendees[0].Status != StatusAccepted {
+		t.Errorf("attendees = %+v", ev.Attendees)
+	}
+
+	if _, err := NewCalendarReply(invite, "mallory@example.com", StatusAccepted); !errors.Is(err, ErrNotInvited) {
+		t.Errorf("expected ErrNotInvited, got %v", err)
+	}
+	if _, err := NewCalendarReply(invite, "bob@example.com", StatusNeedsAction); !errors.Is(err, ErrInvalidCalendar) {
+		t.Errorf("expected ErrInvalidCalendar, got %v", err)
+	}
+}
+
+func TestParseICSDuration(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"PT1H30M": 90 * time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"-PT15M":  -15 * time.Minute,
+		"P1DT2H":  26 * time.Hour,
+	} {
+		if got, ok := parseICSDuration(in); !ok || got != want {
+			t.Errorf("%s: got %s, %v", in, got, ok)
+		}
+	}
+	for _, in := range []string{"", "PT", "1H", "PTH", "P1X"} {
+		if _, ok := parseICSDuration(in); ok {
+			t.Errorf("%q should not parse", in)
+		}
+	}
+}
@@ -16,7 +16,18 @@ import (
 // "Dkim-Signature", so Headers["Message-ID"] finds nothing on a message that
 // plainly has one. A message you built yourself keeps whatever spelling you
 // used. This reads both.
+//
+// HeaderFields is consulted first, as it is when the message is rendered,
+// unless Headers was edited to differ from it (see Email.HeaderFields), and
+// gives the first value of a field that repeats; HeaderValues gives them all.
 func (e Email) Header(name string) string {
+	if e.HeaderFields.Has(name) && !e.editedInHeaders(name) {
+		return e.HeaderFields.Get(name)
+	}
+	return e.mapHeader(name)
+}
+
+func (e Email) mapHeader(name string) string {
 	if len(e.Headers) == 0 {
 		return ""
 	}
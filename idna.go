@@ -0,0 +1,369 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/secure/bidirule"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Internationalized addresses (RFC 6530) come in two halves with different
+// rules.
+//
+// A non-ASCII domain has an ASCII form: each label is mapped and
+// Punycode-encoded under "xn--" (RFC 5891), which is the name DNS holds and
+// which every SMTP server accepts. Conversion is therefore always safe, and
+// this package does it wherever a domain leaves it -- DNS lookups, SMTP
+// commands and rendered address headers.
+//
+// A non-ASCII local part has no ASCII form at all. Only the receiving
+// mailbox may interpret it, so it is carried as UTF-8, which requires every
+// server on the way to support SMTPUTF8 (RFC 6531). The SMTP sender
+// negotiates it and fails with ErrSMTPUTF8Required when the server lacks it;
+// there is nothing to fall back to.
+
+var (
+	// ErrInvalidDomain is returned for a domain that cannot be converted to
+	// its ASCII form.
+	ErrInvalidDomain = errors.New("gsmail: invalid domain name")
+
+	// ErrSMTPUTF8Required is returned when an address has a non-ASCII local
+	// part and the SMTP server does not support SMTPUTF8, so the address
+	// cannot be sent at all.
+	ErrSMTPUTF8Required = errors.New("gsmail: address needs SMTPUTF8, which the server does not support")
+)
+
+// DomainToASCII returns domain in the ASCII form DNS and SMTP use. An ASCII
+// label is kept as it is; any other is lower-cased, normalised to NFC and
+// Punycode-encoded with the "xn--" prefix, so "bücher.de" becomes
+// "xn--bcher-kva.de". The ideographic full stops some input methods produce
+// separate labels like ".".
+//
+// It rejects what cannot be a domain at all: empty labels, labels over 63
+// octets, names over 253, and spaces, controls and ASCII punctuation other
+// than "-" and "_". A label it encodes must also be valid under IDNA2008
+// (RFC 5891 to 5893): letters, marks and digits only, so no symbols or
+// punctuation such as "؟"; stable under NFKC; not beginning with a combining
+// mark or with hyphens in the third and fourth places; joiners and the other
+// contextual characters only where their rules allow; and right-to-left text
+// that satisfies the Bidi rule. A registry refuses such names, so an address
+// using one cannot exist, and it is better refused before it is sent.
+func DomainToASCII(domain string) (string, error) {
+	if isASCIIText(domain) {
+		if err := checkDomainLength(domain); err != nil {
+			return "", err
+		}
+		return domain, checkDomainRunes(domain)
+	}
+	domain = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(domain)
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidDomain, domain)
+	}
+	if err := checkDomainRunes(domain); err != nil {
+		return "", err
+	}
+
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if isASCIIText(label) {
+			continue
+		}
+		label = norm.NFC.String(strings.ToLower(label))
+		if err := checkIDNALabel(label); err != nil {
+			return "", fmt.Errorf("%w: %q: %v", ErrInvalidDomain, domain, err)
+		}
+		encoded, err := punycode(label)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q: %v", ErrInvalidDomain, domain, err)
+		}
+		labels[i] = "xn--" + encoded
+	}
+	ascii := strings.Join(labels, ".")
+	if err := checkDomainLength(ascii); err != nil {
+		return "", err
+	}
+	return ascii, nil
+}
+
+func checkDomainLength(domain string) error {
+	name := strings.TrimSuffix(domain, ".")
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+		}
+	}
+	return nil
+}
+
+func checkDomainRunes(domain string) error {
+	for _, r := range domain {
+		ok := r == '.' || r == '-' || r == '_' ||
+			('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') ||
+			(r >= utf8.RuneSelf && !unicode.IsSpace(r) && !unicode.IsControl(r))
+		if !ok {
+			return fmt.Errorf("%w: %q contains %q", ErrInvalidDomain, domain, r)
+		}
+	}
+	return nil
+}
+
+// checkIDNALabel reports why a lower-cased, NFC label with non-ASCII
+// characters is not a valid IDNA2008 U-label. The code point classes of
+// RFC 5892 are derived from the Unicode tables the same way: letters, marks
+// and digits are allowed, anything else is not, and so is a character that
+// NFKC changes, since it has a preferred form.
+func checkIDNALabel(label string) error {
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return errors.New("label begins or ends with a hyphen")
+	}
+	runes := []rune(label)
+	if len(runes) >= 4 && runes[2] == '-' && runes[3] == '-' {
+		return errors.New("label has hyphens in the third and fourth places")
+	}
+	if norm.NFKC.String(label) != label {
+		return errors.New("label is not in NFKC form")
+	}
+	if unicode.Is(unicode.M, runes[0]) {
+		return errors.New("label begins with a combining mark")
+	}
+
+	var arabicIndic, extendedArabicIndic bool
+	for i, r := range runes {
+		prev, next := rune(-1), rune(-1)
+		if i > 0 {
+			prev = runes[i-1]
+		}
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		ok := true
+		switch {
+		case r < utf8.RuneSelf:
+			ok = r == '-' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9')
+		case r == 0x200C || r == 0x200D:
+			// ZERO WIDTH NON-JOINER and JOINER follow a virama.
+			ok = prev >= 0 && norm.NFC.PropertiesString(string(prev)).CCC() == 9
+		case r == 0x00B7:
+			// MIDDLE DOT only in the Catalan "l·l".
+			ok = prev == 'l' && next == 'l'
+		case r == 0x0375:
+			// GREEK LOWER NUMERAL SIGN before Greek.
+			ok = next >= 0 && unicode.Is(unicode.Greek, next)
+		case r == 0x05F3 || r == 0x05F4:
+			// HEBREW GERESH and GERSHAYIM after Hebrew.
+			ok = prev >= 0 && unicode.Is(unicode.Hebrew, prev)
+		case r == 0x30FB:
+			// KATAKANA MIDDLE DOT with Japanese script in the label.
+			ok = strings.ContainsFunc(label, func(c rune) bool {
+				return c != 0x30FB && unicode.In(c, unicode.Hiragana, unicode.Katakana, unicode.Han)
+			})
+		case 0x0660 <= r && r <= 0x0669:
+			arabicIndic = true
+		case 0x06F0 <= r && r <= 0x06F9:
+			extendedArabicIndic = true
+		default:
+			ok = unicode.In(r, unicode.Ll, unicode.Lo, unicode.Lm, unicode.Mn, unicode.Mc, unicode.Nd)
+		}
+		if !ok {
+			return fmt.Errorf("%q is not allowed there", r)
+		}
+	}
+	if arabicIndic && extendedArabicIndic {
+		return errors.New("label mixes Arabic-Indic and extended Arabic-Indic digits")
+	}
+	if !bidirule.ValidString(label) {
+		return errors.New("label breaks the Bidi rule")
+	}
+	return nil
+}
+
+// ASCIIAddress returns a bare address with its domain in ASCII form, which
+// is how it must be written to a server without SMTPUTF8. An address whose
+// local part is not ASCII has no such form and yields ErrSMTPUTF8Required.
+func ASCIIAddress(addr string) (string, error) {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 {
+		return addr, nil
+	}
+	local, domain := addr[:i], addr[i+1:]
+	if !isASCIIText(local) {
+		return "", fmt.Errorf("%w: %q", ErrSMTPUTF8Required, addr)
+	}
+	ascii, err := DomainToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	return local + "@" + ascii, nil
+}
+
+// RequiresSMTPUTF8 reports whether an address -- bare or with a display name
+// -- has a non-ASCII local part, and so can only be delivered through servers
+// that support SMTPUTF8. A non-ASCII domain alone does not: it is converted.
+func RequiresSMTPUTF8(addr string) bool {
+	if a, err := ParseEmailAddress(addr); err == nil && a != nil {
+		addr = a.Address
+	}
+	i := strings.LastIndexByte(addr, '@')
+	return i > 0 && !isASCIIText(addr[:i])
+}
+
+// asciiDomainAddress is ASCIIAddress where it applies and addr otherwise, for
+// callers that pass a UTF-8 local part through as it is.
+func asciiDomainAddress(addr string) string {
+	if ascii, err := ASCIIAddress(addr); err == nil {
+		return ascii
+	}
+	return addr
+}
+
+func isASCIIText(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidLocalPart reports whether a non-ASCII local part is one IsValidEmail
+// accepts: the ASCII characters it accepts anyway, and letters, marks and
+// digits beyond ASCII, without a leading, trailing or doubled dot.
+func isValidLocalPart(local string) bool {
+	if local == "" || len(local) > 64 || !utf8.ValidString(local) ||
+		strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return false
+	}
+	for _, r := range local {
+		switch {
+		case r < utf8.RuneSelf:
+			if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._%+-", r) {
+				return false
+			}
+		case !unicode.In(r, unicode.L, unicode.M, unicode.N):
+			return false
+		}
+	}
+	return true
+}
+
+// asciiResolver looks names up in their ASCII form, so DNS is asked for the
+// name it actually holds.
+type asciiResolver struct{ Resolver }
+
+func (r asciiResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	ascii, err := DomainToASCII(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolver.LookupMX(ctx, ascii)
+}
+
+func (r asciiResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	ascii, err := DomainToASCII(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolver.LookupTXT(ctx, ascii)
+}
+
+// Punycode parameters, RFC 3492 section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes a label as RFC 3492 Punycode, without the "xn--" prefix.
+func punycode(label string) (string, error) {
+	runes := []rune(label)
+	out := make([]byte, 0, len(label)+8)
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled < len(runes) {
+		m := rune(unicode.MaxRune + 1)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		if int(m-n) > (1<<31-1-delta)/(handled+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,110 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDomainToASCII(t *testing.T) {
+	for in, want := range map[string]string{
+		"example.com":      "example.com",
+		"bücher.de":        "xn--bcher-kva.de",
+		"BÜCHER.de":        "xn--bcher-kva.de",
+		"münchen.de":       "xn--mnchen-3ya.de",
+		"例子.测试":            "xn--fsqu00a.xn--0zwm56d",
+		"例子。测试":            "xn--fsqu00a.xn--0zwm56d",
+		"_dmarc.bücher.de": "_dmarc.xn--bcher-kva.de",
+		// RFC 3492 section 7.1, sample A, without the question mark that
+		// IDNA2008 does not allow in a label.
+		"ليهمابتكلموشعربي": "xn--mgbcah9ar9a4efegftvvn",
+		"ক্‍ষ.example":     "xn--p5b2ezc687j.example",
+		"l·l.cat":          "xn--ll-0ea.cat",
+	} {
+		got, err := DomainToASCII(in)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{
+		"", "a..b", "bü cher.de", "a@b.de", string(make([]byte, 64)) + ".de",
+		// Not valid under IDNA2008.
+		"ليهمابتكلموشعربي؟.com", // punctuation
+		"♥.example",             // a symbol
+		"ﬁle.example",           // not NFKC
+		"\u0301a.example",       // a leading combining mark
+		"a\u200db.example",      // a joiner without a virama
+		"-bü.example",
+		"bü--x.example",
+		"aب.example", // left-to-right and right-to-left in one label
+		"٠۰.example", // both kinds of Arabic-Indic digits
+	} {
+		if _, err := DomainToASCII(bad); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("%q: expected ErrInvalidDomain, got %v", bad, err)
+		}
+	}
+}
+
+func TestInternationalizedAddresses(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"用户@例子.广告":                 true,
+		"user@bücher.de":           true,
+		"jörg.müller@web.de":       true,
+		"δοκιμή@παράδειγμα.δοκιμή": true,
+		"用户@localhost":             false,
+		".用户@例子.广告":                false,
+		"用 户@例子.广告":                false,
+		"用户@例子 .广告":                false,
+	} {
+		if got := IsValidEmail(addr); got != valid {
+			t.Errorf("IsValidEmail(%q) = %v", addr, got)
+		}
+	}
+
+	// A domain has an ASCII form and is written in it; a local part has none.
+	if got := FormatAddress("Jörg <user@bücher.de>"); got != "=?utf-8?q?J=C3=B6rg?= <user@xn--bcher-kva.de>" {
+		t.Errorf("FormatAddress = %q", got)
+	}
+	if got := FormatAddress("用户@例子.广告"); got != "用户@例子.广告" {
+		t.Errorf("FormatAddress = %q", got)
+	}
+	if _, err := ASCIIAddress("用户@例子.广告"); !errors.Is(err, ErrSMTPUTF8Required) {
+		t.Errorf("expected ErrSMTPUTF8Required, got %v", err)
+	}
+	if !RequiresSMTPUTF8(`"Yòng" <用户@example.com>`) || RequiresSMTPUTF8("user@bücher.de") {
+		t.Error("RequiresSMTPUTF8 should depend on the local part alone")
+	}
+
+	// The Message-ID domain comes from an internationalized From too.
+	if got := messageIDDomain("user@bücher.de"); got != "xn--bcher-kva.de" {
+		t.Errorf("messageIDDomain = %q", got)
+	}
+}
+
+// DNS holds the ASCII name, so that is what the lookups must ask for.
+func TestLookupsUseASCIIDomain(t *testing.T) {
+	t.Parallel()
+
+	var asked []string
+	resolver := stubResolver{
+		mx: func(ctx context.Context, name string) ([]*net.MX, error) {
+			asked = append(asked, name)
+			return []*net.MX{{Host: "mx.example.net", Pref: 10}}, nil
+		},
+		txt: func(ctx context.Context, name string) ([]string, error) {
+			asked = append(asked, name)
+			return nil, &net.DNSError{IsNotFound: true}
+		},
+	}
+
+	v := Validator{CheckMX: true, Resolver: resolver}
+	if err := v.Validate(t.Context(), "用户@例子.广告"); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	HealthChecker{Resolver: resolver}.CheckDMARC(t.Context(), "bücher.de")
+
+	if len(asked) != 2 || asked[0] != "xn--fsqu00a.xn--4rr70v" || asked[1] != "_dmarc.xn--bcher-kva.de" {
+		t.Errorf("looked up %q", asked)
+	}
+}
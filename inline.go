@@ -0,0 +1,389 @@
+package gsmail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Default bounds for InlineImages.
+const (
+	DefaultMaxInlineImageSize  = 5 << 20
+	DefaultMaxInlineImagesSize = 20 << 20
+)
+
+// ErrNotAnImage is returned by InlineImages for a src whose content is not an
+// image, which is usually an HTML error page served with a 200.
+var ErrNotAnImage = errors.New("gsmail: inline image source is not an image")
+
+// InlineImageOptions configures InlineImages. Each kind of source is off
+// until enabled, since an HTML body that includes anything a user typed could
+// otherwise name a URL on the internal network, or a file on the server, and
+// have it mailed out.
+type InlineImageOptions struct {
+	// Remote fetches http and https sources.
+	Remote bool
+	// Client fetches remote sources. When nil, a client with a 30 second
+	// timeout is used; supply one whose transport refuses private
+	// addresses if the HTML is not entirely your own.
+	Client *http.Client
+
+	// FS resolves every other source: a relative path, an absolute one and
+	// a file: URL, the latter two with the leading slash removed. An
+	// embed.FS holding the images beside the templates is typical; use
+	// os.DirFS for a directory on disk, which also keeps ".." from
+	// reaching outside it. Nil leaves such sources as they are.
+	FS fs.FS
+
+	// MaxImageSize bounds each image in bytes, and MaxTotalSize all of them
+	// together. Zero means DefaultMaxInlineImageSize and
+	// DefaultMaxInlineImagesSize.
+	MaxImageSize int64
+	MaxTotalSize int64
+
+	// IgnoreErrors leaves the src of an image that cannot be inlined as it
+	// was, instead of failing. The message then goes out with that image
+	// remote or broken.
+	IgnoreErrors bool
+}
+
+// InlineImages returns email with the images its HTMLBody shows attached
+// inline, each under a generated Content-ID, and every src referring to one
+// rewritten to "cid:" followed by it. Mail clients show an image carried in
+// the message where they block remote ones, which corporate clients do by
+// default.
+//
+// Only the src of img elements is read; CSS url() references and srcset are
+// left alone. An image named twice is attached once. cid: and data: sources
+// are already inline, and a source of a kind opts does not enable is left as
+// it is. The rest of the HTML is unchanged byte for byte.
+//
+// A message with Raw set is final and returned as it is.
+func InlineImages(ctx context.Context, email Email, opts InlineImageOptions) (Email, error) {
+	if len(email.HTMLBody) == 0 || len(email.Raw) > 0 {
+		return email, nil
+	}
+	in := imageInliner{
+		ctx:    ctx,
+		opts:   opts,
+		from:   email.From,
+		bySrc:  make(map[string]string),
+		maxOne: opts.MaxImageSize,
+		maxAll: opts.MaxTotalSize,
+	}
+	if in.maxOne <= 0 {
+		in.maxOne = DefaultMaxInlineImageSize
+	}
+	if in.maxAll <= 0 {
+		in.maxAll = DefaultMaxInlineImagesSize
+	}
+
+	var rewritten bytes.Buffer
+	written := 0 // how much of HTMLBody has been copied to rewritten
+	err := forEachHTMLAttr(email.HTMLBody, "img", "src", func(start, end int) error {
+		cid, err := in.inline(html.UnescapeString(string(email.HTMLBody[start:end])))
+		if err != nil || cid == "" {
+			return err
+		}
+		rewritten.Write(email.HTMLBody[written:start])
+		rewritten.WriteString("cid:" + cid)
+		written = end
+		return nil
+	})
+	if err != nil {
+		return Email{}, err
+	}
+	if len(in.attachments) == 0 {
+		return email, nil
+	}
+	rewritten.Write(email.HTMLBody[written:])
+
+	email.HTMLBody = rewritten.Bytes()
+	email.Attachments = append(append([]Attachment(nil), email.Attachments...), in.attachments...)
+	return email, nil
+}
+
+// InlineImagesInterceptor applies InlineImages to every message sent. A
+// failure to inline one fails the send, unless opts.IgnoreErrors is set;
+// whether it is retried follows the failure: a server error is worth
+// another attempt, a missing file is not.
+func InlineImagesInterceptor(opts InlineImageOptions) SendInterceptor {
+	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
+		email, err := InlineImages(ctx, email, opts)
+		if err != nil {
+			return err
+		}
+		return next(ctx, email)
+	}
+}
+
+// imageInliner carries the state of one InlineImages call.
+type imageInliner struct {
+	ctx         context.Context
+	opts        InlineImageOptions
+	from        string
+	bySrc       map[string]string // src to the Content-ID it was attached under, or "" when it was left alone
+	attachments []Attachment
+	total       int64
+	maxOne      int64
+	maxAll      int64
+}
+
+// inline attaches the image src names and returns its Content-ID, or "" when
+// src is to be left as it is.
+func (in *imageInliner) inline(src string) (string, error) {
+	src = strings.TrimSpace(src)
+	if cid, ok := in.bySrc[src]; ok {
+		return cid, nil
+	}
+	att, err := in.load(src)
+	if err != nil {
+		if in.opts.IgnoreErrors {
+			// Remembered, so an image named again is not fetched again.
+			in.bySrc[src] = ""
+			return "", nil
+		}
+		return "", fmt.Errorf("gsmail: inline image %q: %w", src, err)
+	}
+	if att == nil {
+		in.bySrc[src] = ""
+		return "", nil
+	}
+	if in.total += int64(len(att.Data)); in.total > in.maxAll {
+		if in.opts.IgnoreErrors {
+			in.total -= int64(len(att.Data))
+			in.bySrc[src] = ""
+			return "", nil
+		}
+		return "", NonRetryable(fmt.Errorf("gsmail: inline images exceed %d bytes", in.maxAll))
+	}
+
+	att.ContentID = strings.Trim(generateMessageID(in.from), "<>")
+	in.bySrc[src] = att.ContentID
+	in.attachments = append(in.attachments, *att)
+	return att.ContentID, nil
+}
+
+// load reads the image src names, or returns nil for a source it is not to
+// touch.
+func (in *imageInliner) load(src string) (*Attachment, error) {
+	u, err := url.Parse(src)
+	if err != nil || src == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if !in.opts.Remote {
+			return nil, nil
+		}
+		return in.fetch(u)
+	case "file":
+		return in.open(u.Path)
+	case "":
+		if u.Host != "" {
+			// Protocol-relative ("//cdn.example.com/a.png"): the scheme
+			// would be the page's, and mail has none.
+			return nil, nil
+		}
+		return in.open(u.Path)
+	}
+	// cid:, data: and anything else.
+	return nil, nil
+}
+
+func (in *imageInliner) fetch(u *url.URL) (*Attachment, error) {
+	client := in.opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(in.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, NonRetryable(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return nil, NewHTTPError(u.Host, resp)
+	}
+	if resp.ContentLength > in.maxOne {
+		return nil, NonRetryable(fmt.Errorf("image is %d bytes, above the limit of %d", resp.ContentLength, in.maxOne))
+	}
+	data, err := in.readImage(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return imageAttachment(path.Base(u.Path), mediaType, data)
+}
+
+func (in *imageInliner) open(name string) (*Attachment, error) {
+	if in.opts.FS == nil {
+		return nil, nil
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	f, err := in.opts.FS.Open(name)
+	if err != nil {
+		return nil, NonRetryable(err)
+	}
+	defer func() { _ = f.Close() }()
+	data, err := in.readImage(f)
+	if err != nil {
+		return nil, err
+	}
+	return imageAttachment(path.Base(name), mime.TypeByExtension(path.Ext(name)), data)
+}
+
+// readImage reads r to the end, refusing more than the per-image limit.
+func (in *imageInliner) readImage(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, in.maxOne+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > in.maxOne {
+		return nil, NonRetryable(fmt.Errorf("image exceeds the limit of %d bytes", in.maxOne))
+	}
+	return data, nil
+}
+
+// imageAttachment checks that data is an image, trusting the declared type
+// only when the content does not say otherwise: a 200 carrying an HTML error
+// page is common, and some servers call every file application/octet-stream.
+func imageAttachment(filename, declared string, data []byte) (*Attachment, error) {
+	contentType := strings.ToLower(declared)
+	if sniffed := http.DetectContentType(data); strings.HasPrefix(sniffed, "image/") {
+		if !strings.HasPrefix(contentType, "image/") {
+			contentType = sniffed
+		}
+	} else if !strings.HasPrefix(contentType, "image/") || strings.HasPrefix(sniffed, "text/html") {
+		return nil, NonRetryable(ErrNotAnImage)
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "image"
+	}
+	return &Attachment{Filename: filename, ContentType: contentType, Data: data}, nil
+}
+
+// forEachHTMLAttr calls fn with the span of the raw value of attr on every
+// tag named tag in doc, in order, skipping comments and the content of
+// script and style. The span excludes the quotes, so replacing it keeps them.
+func forEachHTMLAttr(doc []byte, tag, attr string, fn func(start, end int) error) error {
+	return walkHTMLTags(doc, func(name string, _ map[string]string, start, n int) error {
+		if name != tag {
+			return nil
+		}
+		if s, e, ok := htmlAttrSpan(doc[start:start+n], attr); ok {
+			return fn(start+s, start+e)
+		}
+		return nil
+	})
+}
+
+// walkHTMLTags calls fn with every start tag in doc, in order: its name, its
+// attributes, its offset and its length. Comments, declarations and the
+// content of script and style are skipped.
+func walkHTMLTags(doc []byte, fn func(name string, attrs map[string]string, start, n int) error) error {
+	for i := 0; i < len(doc); {
+		lt := bytes.IndexByte(doc[i:], '<')
+		if lt < 0 {
+			return nil
+		}
+		i += lt
+		rest := doc[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			end := bytes.Index(rest[4:], []byte("-->"))
+			if end < 0 {
+				return nil
+			}
+			i += 4 + end + 3
+			continue
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")):
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				return nil
+			}
+			i += end + 1
+			continue
+		}
+
+		name, attrs, closing, n := parseHTMLTag(rest)
+		if n == 0 {
+			i++
+			continue
+		}
+		if !closing {
+			if err := fn(name, attrs, i, n); err != nil {
+				return err
+			}
+		}
+		i += n
+		if !closing && (name == "script" || name == "style") {
+			i += skipHTMLElement(doc[i:], name)
+		}
+	}
+	return nil
+}
+
+// htmlAttrSpan finds the raw value of attr in a single tag, as parseHTMLTag
+// reads it: the first occurrence counts.
+func htmlAttrSpan(tag []byte, attr string) (start, end int, ok bool) {
+	i := 1
+	for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '>' && tag[i] != '/' {
+		i++
+	}
+	for i < len(tag) {
+		for i < len(tag) && (isHTMLSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '>' {
+			return 0, 0, false
+		}
+		keyStart := i
+		for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		key := tag[keyStart:i]
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || tag[i] != '=' {
+			continue
+		}
+		i++
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+			quote := tag[i]
+			close := bytes.IndexByte(tag[i+1:], quote)
+			if close < 0 {
+				return 0, 0, false
+			}
+			start, end = i+1, i+1+close
+			i = end + 1
+		} else {
+			start = i
+			for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '>' {
+				i++
+			}
+			end = i
+		}
+		if strings.EqualFold(string(key), attr) {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
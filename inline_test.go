@@ -0,0 +1,185 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var pngImage = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestInlineImages(t *testing.T) {
+	fetched := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(pngImage)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	fsys := fstest.MapFS{"images/banner.png": {Data: pngImage}}
+	email := Email{
+		From: "news@example.com",
+		HTMLBody: []byte(`<p><img alt="logo" src="` + srv.URL + `/logo.png"></p>` +
+			`<!-- <img src="` + srv.URL + `/commented.png"> -->` +
+			`<img src='/images/banner.png' width=600>` +
+			`<img src=` + srv.URL + `/logo.png>` +
+			`<img src="cid:kept@example.com"><img src="data:image/gif;base64,R0lGOD">`),
+	}
+
+	got, err := InlineImages(context.Background(), email, InlineImageOptions{Remote: true, FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d times; the commented image must be skipped and the repeated one fetched once", fetched)
+	}
+	if len(got.Attachments) != 2 {
+		t.Fatalf("got %d attachments", len(got.Attachments))
+	}
+	logo, banner := got.Attachments[0], got.Attachments[1]
+	if logo.Filename != "logo.png" || logo.ContentType != "image/png" || !strings.HasSuffix(logo.ContentID, "@example.com") {
+		t.Errorf("logo %q %q %q", logo.Filename, logo.ContentType, logo.ContentID)
+	}
+	want := `<p><img alt="logo" src="cid:` + logo.ContentID + `"></p>` +
+		`<!-- <img src="` + srv.URL + `/commented.png"> -->` +
+		`<img src='cid:` + banner.ContentID + `' width=600>` +
+		`<img src=cid:` + logo.ContentID + `>` +
+		`<img src="cid:kept@example.com"><img src="data:image/gif;base64,R0lGOD">`
+	if string(got.HTMLBody) != want {
+		t.Errorf("HTMLBody\n got %s\nwant %s", got.HTMLBody, want)
+	}
+	if len(email.Attachments) != 0 || strings.Contains(string(email.HTMLBody), "cid:"+logo.ContentID) {
+		t.Error("the original message was modified")
+	}
+
+	raw, err := RenderMessage(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "Content-ID: <"+banner.ContentID+">") {
+		t.Errorf("rendered message lacks the banner's Content-ID:\n%s", raw)
+	}
+}
+
+// Sources are fetched only when enabled: an HTML body holding user input must
+// not be able to mail out an internal URL or a local file.
+func TestInlineImagesSourcesAreOptIn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("fetched without Remote")
+	}))
+	defer srv.Close()
+
+	email := Email{HTMLBody: []byte(`<img src="` + srv.URL + `/a.png"><img src="file:///etc/passwd">`)}
+	got, err := InlineImages(context.Background(), email, InlineImageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.HTMLBody) != string(email.HTMLBody) || len(got.Attachments) != 0 {
+		t.Errorf("message changed: %s", got.HTMLBody)
+	}
+}
+
+func TestInlineImagesFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page.png":
+			_, _ = w.Write([]byte("<!doctype html><html><body>Sign in</body></html>"))
+		case "/big.png":
+			_, _ = w.Write(append(pngImage, make([]byte, 100)...))
+		case "/busy.png":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	fsys := fstest.MapFS{"a.png": {Data: pngImage}}
+
+	for _, tc := range []struct {
+		src       string
+		opts      InlineImageOptions
+		retryable bool
+		is        error
+	}{
+		{src: srv.URL + "/page.png", opts: InlineImageOptions{Remote: true}, is: ErrNotAnImage},
+		{src: srv.URL + "/big.png", opts: InlineImageOptions{Remote: true, MaxImageSize: 64}},
+		{src: srv.URL + "/busy.png", opts: InlineImageOptions{Remote: true}, retryable: true},
+		{src: "missing.png", opts: InlineImageOptions{FS: fsys}},
+		{src: "a.png", opts: InlineImageOptions{FS: fsys, MaxTotalSize: 4}},
+	} {
+		email := Email{HTMLBody: []byte(`<img src="` + tc.src + `">`)}
+		_, err := InlineImages(context.Background(), email, tc.opts)
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.src)
+			continue
+		}
+		if IsRetryable(err) != tc.retryable {
+			t.Errorf("%s: IsRetryable = %v for %v", tc.src, !tc.retryable, err)
+		}
+		if tc.is != nil && !errors.Is(err, tc.is) {
+			t.Errorf("%s: %v is not %v", tc.src, err, tc.is)
+		}
+
+		tc.opts.IgnoreErrors = true
+		got, err := InlineImages(context.Background(), email, tc.opts)
+		if err != nil || string(got.HTMLBody) != string(email.HTMLBody) {
+			t.Errorf("%s: IgnoreErrors gave %q, %v", tc.src, got.HTMLBody, err)
+		}
+	}
+}
+
+// A stylesheet holding text whose lower case is longer, such as "Ⱥ", must not
+// hide the image after it.
+func TestInlineImagesAfterCaseChangingStyle(t *testing.T) {
+	fsys := fstest.MapFS{"logo.png": {Data: pngImage}}
+	email := Email{HTMLBody: []byte(`<style>p::before{content:"` + strings.Repeat("Ⱥ", 40) + `"}</style><img src="logo.png">`)}
+	got, err := InlineImages(context.Background(), email, InlineImageOptions{FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Attachments) != 1 || !strings.Contains(string(got.HTMLBody), `<img src="cid:`+got.Attachments[0].ContentID+`">`) {
+		t.Errorf("attachments %d, html %s", len(got.Attachments), got.HTMLBody)
+	}
+}
+
+// With IgnoreErrors, an image that failed is not fetched again for each time
+// it is named.
+func TestInlineImagesRemembersFailures(t *testing.T) {
+	fetched := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	img := `<img src="` + srv.URL + `/busy.png">`
+	email := Email{HTMLBody: []byte(img + img + img)}
+	got, err := InlineImages(context.Background(), email, InlineImageOptions{Remote: true, IgnoreErrors: true})
+	if err != nil || string(got.HTMLBody) != string(email.HTMLBody) {
+		t.Fatalf("got %s, %v", got.HTMLBody, err)
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d times, want 1", fetched)
+	}
+}
+
+func TestInlineImagesInterceptor(t *testing.T) {
+	inner := &recordingSender{}
+	sender := WrapSender(inner, InlineImagesInterceptor(InlineImageOptions{FS: fstest.MapFS{"logo.png": {Data: pngImage}}}))
+
+	if err := sender.Send(context.Background(), Email{HTMLBody: []byte(`<img src="logo.png">`)}); err != nil {
+		t.Fatal(err)
+	}
+	sent, _ := inner.last()
+	if len(sent.Attachments) != 1 || string(sent.HTMLBody) != `<img src="cid:`+sent.Attachments[0].ContentID+`">` {
+		t.Errorf("sent %q with %d attachments", sent.HTMLBody, len(sent.Attachments))
+	}
+}
@@ -0,0 +1,260 @@
+// Package mimeentity splits a rendered message into the parts that signing
+// and encryption operate on, and puts them back together.
+//
+// S/MIME and OpenPGP/MIME both protect a MIME entity -- the Content-* header
+// fields and the body they describe -- and leave the rest of the header block
+// (From, To, Subject, Message-ID) outside the protection. Both also verify a
+// signature over the exact bytes of one multipart part, so the helpers here
+// work on byte slices and never re-serialise anything they did not produce.
+//
+// It is internal so the smime and pgp packages can share it without it
+// becoming part of the gsmail API.
+package mimeentity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// ErrMalformed is returned for input that does not have the structure the
+// caller asked for.
+var ErrMalformed = errors.New("mimeentity: malformed message")
+
+// Split separates a message into its header block and body. The header block
+// keeps its final line break; the blank line between the two belongs to
+// neither.
+func Split(msg []byte) (header, body []byte) {
+	crlf := bytes.Index(msg, []byte("\r\n\r\n"))
+	lf := bytes.Index(msg, []byte("\n\n"))
+	// Whichever comes first ends the header; a message stored with bare LF
+	// can still carry CRLF pairs further down, in its body.
+	if crlf >= 0 && (lf < 0 || crlf < lf) {
+		return msg[:crlf+2], msg[crlf+4:]
+	}
+	if lf >= 0 {
+		return msg[:lf+1], msg[lf+2:]
+	}
+	return msg, nil
+}
+
+// Field is one header field, with any folded continuation lines, exactly as
+// it appeared.
+type Field struct {
+	Name string
+	Raw  []byte
+}
+
+// Fields splits a header block into its fields. A continuation line before
+// the first field is dropped, since it has nothing to continue.
+func Fields(header []byte) []Field {
+	var fields []Field
+	for len(header) > 0 {
+		end := bytes.IndexByte(header, '\n')
+		if end < 0 {
+			end = len(header)
+		} else {
+			end++
+		}
+		line := header[:end]
+		header = header[end:]
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if n := len(fields); n > 0 {
+				f := &fields[n-1]
+				f.Raw = f.Raw[:len(f.Raw)+len(line)]
+			}
+			continue
+		}
+		name := line
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			name = line[:i]
+		}
+		// Raw is a window onto the input, grown in place by continuations.
+		fields = append(fields, Field{Name: strings.TrimSpace(string(name)), Raw: line})
+	}
+	return fields
+}
+
+// IsContentField reports whether a header field describes the entity rather
+// than the message, and so travels inside the protection.
+func IsContentField(name string) bool {
+	return len(name) > 8 && strings.EqualFold(name[:8], "content-")
+}
+
+// Extract divides a message into the header fields that stay outside the
+// protection and the entity that goes inside it. The entity is a complete
+// MIME entity: its Content-* fields, a blank line, and the body, with line
+// endings as they were.
+func Extract(msg []byte) (outer, entity []byte) {
+	header, body := Split(msg)
+
+	var o, e bytes.Buffer
+	for _, f := range Fields(header) {
+		if IsContentField(f.Name) {
+			e.Write(crlfTerminated(f.Raw))
+		} else {
+			o.Write(crlfTerminated(f.Raw))
+		}
+	}
+	// An entity with no Content-Type is text/plain; us-ascii by RFC 2045, so
+	// it needs no fields to be complete.
+	e.WriteString("\r\n")
+	e.Write(body)
+	return o.Bytes(), e.Bytes()
+}
+
+// Join places an entity under the outer header fields, producing a message.
+func Join(outer, entity []byte) []byte {
+	out := make([]byte, 0, len(outer)+len(entity))
+	out = append(out, outer...)
+	return append(out, entity...)
+}
+
+// crlfTerminated returns a field with a CRLF line ending, whatever it had.
+func crlfTerminated(raw []byte) []byte {
+	raw = bytes.TrimRight(raw, "\r\n")
+	out := make([]byte, 0, len(raw)+2)
+	out = append(out, raw...)
+	return append(out, '\r', '\n')
+}
+
+// Parts returns the exact bytes of each part of a multipart body, without the
+// delimiter lines or the line break that precedes each delimiter.
+//
+// mime/multipart cannot be used for this: it hands back a decoded reader,
+// and a signature is over the bytes as they were sent.
+func Parts(body []byte, boundary string) ([][]byte, error) {
+	spans, err := PartSpans(body, boundary)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]byte, len(spans))
+	for i, sp := range spans {
+		parts[i] = body[sp[0]:sp[1]]
+	}
+	return parts, nil
+}
+
+// PartSpans is Parts reporting where each part lies in body, as start and end
+// offsets, for a caller that needs to know where a part is as well as what
+// it holds.
+func PartSpans(body []byte, boundary string) ([][2]int, error) {
+	if boundary == "" {
+		return nil, ErrMalformed
+	}
+	delim := []byte("--" + boundary)
+
+	var spans [][2]int
+	start := -1
+	for pos := 0; pos <= len(body); {
+		end := bytes.IndexByte(body[pos:], '\n')
+		var line []byte
+		next := len(body) + 1
+		if end < 0 {
+			line = body[pos:]
+		} else {
+			line = body[pos : pos+end+1]
+			next = pos + end + 1
+		}
+
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if bytes.HasPrefix(trimmed, delim) {
+			rest := trimmed[len(delim):]
+			if len(rest) == 0 || bytes.Equal(rest, []byte("--")) {
+				if start >= 0 {
+					spans = append(spans, [2]int{start, start + len(trimLineBreak(body[start:pos]))})
+				}
+				if len(rest) > 0 {
+					return spans, nil
+				}
+				start = next
+				if start > len(body) {
+					start = len(body)
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		pos = next
+	}
+	return nil, ErrMalformed
+}
+
+// trimLineBreak removes the single line break that belongs to the delimiter
+// following a part.
+func trimLineBreak(b []byte) []byte {
+	if bytes.HasSuffix(b, []byte("\r\n")) {
+		return b[:len(b)-2]
+	}
+	if bytes.HasSuffix(b, []byte("\n")) {
+		return b[:len(b)-1]
+	}
+	return b
+}
+
+// Canonicalize converts every line ending to CRLF, which is the form both
+// S/MIME and OpenPGP/MIME sign. A message that passed through a store using
+// bare LF still verifies once converted back.
+func Canonicalize(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+bytes.Count(b, []byte("\n")))
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+// Multipart assembles a multipart body from complete entities.
+func Multipart(boundary string, parts ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, p := range parts {
+		b.WriteString("--")
+		b.WriteString(boundary)
+		b.WriteString("\r\n")
+		b.Write(p)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--")
+	b.WriteString(boundary)
+	b.WriteString("--\r\n")
+	return b.Bytes()
+}
+
+// TrimTrailingLineBreaks removes the line breaks at the end of an entity. A
+// part inside a multipart body cannot end with one of its own: the line break
+// before the next delimiter belongs to the delimiter, so signed content is
+// taken without it.
+func TrimTrailingLineBreaks(b []byte) []byte {
+	return bytes.TrimRight(b, "\r\n")
+}
+
+// Boundary returns a random multipart boundary beginning with prefix. The
+// random part is long enough that it cannot plausibly occur in the content.
+func Boundary(prefix string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("mimeentity: boundary: %w", err)
+	}
+	return prefix + hex.EncodeToString(b[:]), nil
+}
+
+// Header parses a header block into fields. A malformed block yields what was
+// read before the fault, which is enough to find Content-Type.
+func Header(header []byte) textproto.MIMEHeader {
+	r := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(header), strings.NewReader("\r\n"))))
+	h, _ := r.ReadMIMEHeader()
+	return h
+}
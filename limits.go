@@ -0,0 +1,152 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrLimitExceeded is wrapped by the error a preflight check returns for a
+// message the provider would refuse.
+var ErrLimitExceeded = errors.New("gsmail: message exceeds the provider's limits")
+
+// MaxHeaderLine is the longest line RFC 5322 allows, without its CRLF. The
+// renderer does not fold header values, so a long subject or recipient list
+// can exceed it, and some servers refuse such a message outright.
+const MaxHeaderLine = 998
+
+// Limits is what a provider accepts in one message. A zero field is no limit.
+//
+// Providers publish theirs through a Limits method and check them in
+// Preflight, so a message the service would refuse fails before the upload
+// rather than with an HTTPError after it. The values are the documented
+// limits of each service, in decimal megabytes where the documentation says
+// "MB", which errs on the side of refusing a message the service would just
+// have taken.
+type Limits struct {
+	// MaxMessageSize is the size of the rendered message in bytes, after
+	// base64 encoding of bodies and attachments -- the size the service
+	// counts, not the size of the files.
+	MaxMessageSize int64
+
+	// MaxRecipients is the number of mailboxes delivered to: To, Cc and Bcc
+	// with groups counted by their members, or Email.Envelope when it is set.
+	MaxRecipients int
+
+	// MaxHeaderLine is the longest header line the service accepts. It
+	// matters where the rendered header is sent as it is, over SMTP; the
+	// HTTP APIs build a header of their own from the request.
+	MaxHeaderLine int
+
+	// ForbiddenExtensions are attachment file name extensions the service
+	// refuses, lower case with the dot: ".exe".
+	ForbiddenExtensions []string
+}
+
+// Check reports whether email is within l. It returns a NonRetryable error
+// naming the provider and wrapping ErrLimitExceeded for the first limit the
+// message exceeds, or nil.
+//
+// The cheap checks come first. Size and header lines are measured by
+// rendering the message into a counter, so an attachment backed by
+// Attachment.Open is read once more but never held.
+func (l Limits) Check(provider string, email Email) error {
+	fail := func(format string, args ...any) error {
+		return NonRetryable(fmt.Errorf("%s: %w: "+format, append([]any{provider, ErrLimitExceeded}, args...)...))
+	}
+
+	if l.MaxRecipients > 0 {
+		n := len(email.Envelope)
+		if n == 0 {
+			n = len(Mailboxes(email.To)) + len(Mailboxes(email.Cc)) + len(Mailboxes(email.Bcc))
+		}
+		if n > l.MaxRecipients {
+			return fail("%d recipients, the limit is %d", n, l.MaxRecipients)
+		}
+	}
+
+	for _, a := range email.Attachments {
+		if ext := fileExtension(a.Filename); ext != "" {
+			for _, forbidden := range l.ForbiddenExtensions {
+				if ext == forbidden {
+					return fail("attachment %q has a forbidden type", a.Filename)
+				}
+			}
+		}
+	}
+
+	if l.MaxMessageSize <= 0 && l.MaxHeaderLine <= 0 {
+		return nil
+	}
+	var m messageMeter
+	if err := RenderMessageTo(&m, WithPlainText(email)); err != nil {
+		return err
+	}
+	if l.MaxMessageSize > 0 && m.size > l.MaxMessageSize {
+		return fail("message is %d bytes, the limit is %d", m.size, l.MaxMessageSize)
+	}
+	if l.MaxHeaderLine > 0 && m.longest > l.MaxHeaderLine {
+		return fail("a header line is %d bytes, the limit is %d", m.longest, l.MaxHeaderLine)
+	}
+	return nil
+}
+
+// PreflightInterceptor refuses, before it reaches the sender, a message p
+// reports will be refused. p is usually the provider being wrapped:
+//
+//	s := sendgrid.NewSender(key)
+//	sender := gsmail.WrapSender(s, gsmail.PreflightInterceptor(s))
+//
+// Place it inside interceptors that change the message, such as
+// SuppressionInterceptor, so it checks what is actually sent.
+func PreflightInterceptor(p Preflighter) SendInterceptor {
+	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
+		if p == nil {
+			return next(ctx, email)
+		}
+		if err := p.Preflight(email); err != nil {
+			return err
+		}
+		return next(ctx, email)
+	}
+}
+
+// messageMeter counts a rendered message and the longest line of its header.
+type messageMeter struct {
+	size    int64
+	line    int  // length of the current header line, without its CRLF
+	longest int  // longest header line seen
+	body    bool // past the blank line that ends the header
+}
+
+func (m *messageMeter) Write(p []byte) (int, error) {
+	m.size += int64(len(p))
+	for _, c := range p {
+		if m.body {
+			break
+		}
+		switch c {
+		case '\n':
+			m.body = m.line == 0
+			m.longest = max(m.longest, m.line)
+			m.line = 0
+		case '\r':
+		default:
+			m.line++
+		}
+	}
+	return len(p), nil
+}
+
+// fileExtension is the lower-case extension of a file name, with the dot.
+// Trailing dots and spaces are dropped first, as Windows drops them, so
+// "setup.exe." is an ".exe".
+func fileExtension(name string) string {
+	name = strings.TrimRight(name[strings.LastIndexAny(name, `/\`)+1:], ". ")
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return ""
+	}
+	return strings.ToLower(name[i:])
+}
@@ -0,0 +1,57 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{
+		MaxMessageSize:      4096,
+		MaxRecipients:       3,
+		MaxHeaderLine:       MaxHeaderLine,
+		ForbiddenExtensions: []string{".exe"},
+	}
+	ok := Email{From: "a@example.com", To: []string{"b@example.com"}, Subject: "hi", Body: []byte("hello")}
+	if err := limits.Check("test", ok); err != nil {
+		t.Fatalf("a small message was refused: %v", err)
+	}
+
+	for name, e := range map[string]Email{
+		"recipients": {From: "a@example.com", To: []string{"Team: b@example.com, c@example.com;"}, Cc: []string{"d@example.com", "e@example.com"}},
+		// The size counts the base64 encoding, a third more than the data.
+		"size":      {From: "a@example.com", To: []string{"b@example.com"}, Attachments: []Attachment{{Filename: "a.bin", Data: make([]byte, 3500)}}},
+		"forbidden": {From: "a@example.com", To: []string{"b@example.com"}, Attachments: []Attachment{{Filename: `C:\tmp\Setup.EXE.`, Data: []byte("MZ")}}},
+		"header":    {From: "a@example.com", To: []string{"b@example.com"}, Subject: strings.Repeat("x", 1000)},
+	} {
+		err := limits.Check("test", e)
+		if !errors.Is(err, ErrLimitExceeded) || IsRetryable(err) || !strings.HasPrefix(err.Error(), "test: ") {
+			t.Errorf("%s: expected a non-retryable ErrLimitExceeded, got %v", name, err)
+		}
+	}
+
+	// An explicit envelope is what is delivered to.
+	e := ok
+	e.Envelope = []string{"1@example.com", "2@example.com", "3@example.com", "4@example.com"}
+	if err := limits.Check("test", e); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("envelope: got %v", err)
+	}
+}
+
+func TestPreflightInterceptor(t *testing.T) {
+	refuse := PreflightFunc(func(e Email) error {
+		return Limits{MaxRecipients: 1}.Check("test", e)
+	})
+	sent := 0
+	next := func(ctx context.Context, e Email) error { sent++; return nil }
+	intercept := PreflightInterceptor(refuse)
+
+	if err := intercept(t.Context(), Email{To: []string{"a@example.com"}}, next); err != nil || sent != 1 {
+		t.Fatalf("err %v, sent %d", err, sent)
+	}
+	if err := intercept(t.Context(), Email{To: []string{"a@example.com", "b@example.com"}}, next); !errors.Is(err, ErrLimitExceeded) || sent != 1 {
+		t.Errorf("err %v, sent %d", err, sent)
+	}
+}
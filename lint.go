@@ -0,0 +1,282 @@
+package gsmail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Severity ranks a lint finding. The zero value is the least severe.
+type Severity int
+
+const (
+	// SeverityInfo is worth knowing but rarely wrong.
+	SeverityInfo Severity = iota
+	// SeverityWarning hurts how the message is shown or filtered.
+	SeverityWarning
+	// SeverityError breaks the message for some or all recipients.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// The rules Lint applies, as they appear in LintFinding.Rule.
+const (
+	// LintFrom: From is missing or not a single mailbox.
+	LintFrom = "from"
+	// LintDKIMAlignment: From is not in the domain the message is signed
+	// for, so DMARC fails wherever SPF does not rescue it.
+	LintDKIMAlignment = "dkim-alignment"
+	// LintSubject: the subject is empty.
+	LintSubject = "subject"
+	// LintPlainText: there is HTML but no text/plain alternative.
+	LintPlainText = "plain-text"
+	// LintImageAlt: an image has no alt attribute.
+	LintImageAlt = "image-alt"
+	// LintMissingCID: a cid: reference names no attachment.
+	LintMissingCID = "missing-cid"
+	// LintUnusedCID: an inline attachment is never referenced.
+	LintUnusedCID = "unused-cid"
+	// LintDataURI: an image is embedded as a data: URI.
+	LintDataURI = "data-uri"
+	// LintImageSize: an embedded image exceeds LintOptions.MaxImageSize.
+	LintImageSize = "image-size"
+	// LintUnsubscribe: bulk mail lacks List-Unsubscribe, or its one-click
+	// form.
+	LintUnsubscribe = "unsubscribe"
+)
+
+// DefaultLintMaxImageSize is the LintOptions.MaxImageSize used when it is
+// zero.
+const DefaultLintMaxImageSize = 1 << 20
+
+// LintFinding is one problem Lint found.
+type LintFinding struct {
+	// Rule is one of the Lint constants, stable enough to filter on.
+	Rule     string
+	Severity Severity
+	// Message describes this occurrence, naming the image or header.
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return f.Severity.String() + " " + f.Rule + ": " + f.Message
+}
+
+// LintOptions supplies what Lint cannot tell from the message alone.
+type LintOptions struct {
+	// DKIM is the key the message will be signed with. When set, a From
+	// outside its Domain is reported.
+	DKIM *DKIMOptions
+
+	// Bulk marks the message as bulk mail, which must offer one-click
+	// unsubscribe. A message with a List-Id header, or Precedence: bulk or
+	// list, is taken to be bulk mail without it.
+	Bulk bool
+
+	// MaxImageSize bounds, in bytes, an image carried in the message: an
+	// inline attachment, or a data: URI once decoded. Zero means
+	// DefaultLintMaxImageSize.
+	MaxImageSize int64
+}
+
+// Lint checks email for mistakes that do not stop it being sent but do stop
+// it arriving, or arriving looking as intended, and returns what it finds in
+// a fixed order: the envelope first, then the bodies, then the headers. It
+// returns nil for a message with nothing to report.
+//
+// The HTML is scanned, not rendered, so a finding inside markup a client
+// would never show, such as an image in a conditional comment, is missed
+// rather than invented. A message with Raw set is final and is not linted.
+func (e Email) Lint(opts LintOptions) []LintFinding {
+	if len(e.Raw) > 0 {
+		return nil
+	}
+	l := linter{email: e, opts: opts}
+	if l.opts.MaxImageSize <= 0 {
+		l.opts.MaxImageSize = DefaultLintMaxImageSize
+	}
+	l.from()
+	if strings.TrimSpace(e.Subject) == "" {
+		l.add(LintSubject, SeverityWarning, "the subject is empty")
+	}
+	if len(e.HTMLBody) > 0 && len(e.Body) == 0 && !e.AutoPlainText {
+		l.add(LintPlainText, SeverityWarning, "HTMLBody has no text/plain alternative; set Body or AutoPlainText")
+	}
+	l.html()
+	l.unsubscribe()
+	return l.findings
+}
+
+// LintError is the error LintInterceptor returns, listing the findings that
+// stopped the send.
+type LintError struct {
+	Findings []LintFinding
+}
+
+func (e *LintError) Error() string {
+	parts := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		parts[i] = f.String()
+	}
+	return "gsmail: lint: " + strings.Join(parts, "; ")
+}
+
+// LintInterceptor refuses to send a message with a finding at block or above,
+// returning a permanent error that errors.As finds a *LintError in. A message
+// that only has lesser findings is sent.
+//
+// SeverityError is the usual threshold for production, where a warning is
+// better logged than fatal; SeverityWarning suits a staging environment or a
+// test of the templates.
+func LintInterceptor(opts LintOptions, block Severity) SendInterceptor {
+	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
+		var blocking []LintFinding
+		for _, f := range email.Lint(opts) {
+			if f.Severity >= block {
+				blocking = append(blocking, f)
+			}
+		}
+		if len(blocking) > 0 {
+			return NonRetryable(&LintError{Findings: blocking})
+		}
+		return next(ctx, email)
+	}
+}
+
+// linter carries the state of one Lint call.
+type linter struct {
+	email    Email
+	opts     LintOptions
+	findings []LintFinding
+}
+
+func (l *linter) add(rule string, severity Severity, format string, args ...any) {
+	l.findings = append(l.findings, LintFinding{Rule: rule, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) from() {
+	from, err := ParseAddress(l.email.From)
+	if err != nil || from.IsGroup() || from.Address == "" {
+		l.add(LintFrom, SeverityError, "From %q is not a single mailbox", l.email.From)
+		return
+	}
+	if l.opts.DKIM == nil || l.opts.DKIM.Domain == "" {
+		return
+	}
+	domain := from.Address[strings.LastIndexByte(from.Address, '@')+1:]
+	if !dkimAligned(domain, l.opts.DKIM.Domain) {
+		l.add(LintDKIMAlignment, SeverityError, "From domain %q is not aligned with the DKIM domain %q", domain, l.opts.DKIM.Domain)
+	}
+}
+
+// dkimAligned reports whether a From domain and a signing domain pass DMARC's
+// relaxed alignment, approximated without the public suffix list: equal, or
+// one a subdomain of the other. That accepts news.example.com signed as
+// example.com and the reverse, and refuses two unrelated domains, which is
+// the mistake worth catching.
+func dkimAligned(from, signing string) bool {
+	normalize := func(d string) string {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if ascii, err := DomainToASCII(d); err == nil {
+			return ascii
+		}
+		return d
+	}
+	from, signing = normalize(from), normalize(signing)
+	return from == signing || strings.HasSuffix(from, "."+signing) || strings.HasSuffix(signing, "."+from)
+}
+
+// html reports on the images and cid: references of the HTML body.
+func (l *linter) html() {
+	inline := make(map[string]bool) // Content-ID to whether it is referenced
+	for _, a := range l.email.Attachments {
+		if a.ContentID == "" {
+			continue
+		}
+		inline[strings.Trim(a.ContentID, "<>")] = false
+		if strings.HasPrefix(strings.ToLower(a.ContentType), "image/") && int64(len(a.Data)) > l.opts.MaxImageSize {
+			l.add(LintImageSize, SeverityWarning, "inline image %q is %d bytes, above %d", a.Filename, len(a.Data), l.opts.MaxImageSize)
+		}
+	}
+
+	_ = walkHTMLTags(l.email.HTMLBody, func(name string, attrs map[string]string, _, _ int) error {
+		for _, attr := range []string{"src", "background"} {
+			if ref, ok := attrs[attr]; ok {
+				l.reference(name, strings.TrimSpace(ref), inline)
+			}
+		}
+		if name == "img" {
+			if _, ok := attrs["alt"]; !ok {
+				l.add(LintImageAlt, SeverityWarning, "image %s has no alt attribute; screen readers and clients blocking images show nothing", shortRef(attrs["src"]))
+			}
+		}
+		return nil
+	})
+
+	for _, a := range l.email.Attachments {
+		if id := strings.Trim(a.ContentID, "<>"); id != "" && !inline[id] && len(l.email.HTMLBody) > 0 {
+			inline[id] = true // report a repeated Content-ID once
+			l.add(LintUnusedCID, SeverityInfo, "inline attachment %q (cid:%s) is not referenced by the HTML", a.Filename, id)
+		}
+	}
+}
+
+// reference checks one src or background value.
+func (l *linter) reference(tag, ref string, inline map[string]bool) {
+	lower := strings.ToLower(ref)
+	switch {
+	case strings.HasPrefix(lower, "cid:"):
+		id, err := url.PathUnescape(ref[len("cid:"):])
+		if err != nil {
+			id = ref[len("cid:"):]
+		}
+		if _, ok := inline[id]; !ok {
+			l.add(LintMissingCID, SeverityError, "<%s> refers to cid:%s, which no attachment carries", tag, id)
+			return
+		}
+		inline[id] = true
+	case strings.HasPrefix(lower, "data:image/"):
+		l.add(LintDataURI, SeverityWarning, "<%s> embeds an image as a data: URI, which Gmail and Outlook do not show; attach it inline instead", tag)
+		// Base64 carries three bytes in four characters.
+		if size := int64(len(ref)-strings.IndexByte(ref, ',')-1) * 3 / 4; size > l.opts.MaxImageSize {
+			l.add(LintImageSize, SeverityWarning, "<%s> embeds an image of about %d bytes, above %d", tag, size, l.opts.MaxImageSize)
+		}
+	}
+}
+
+// shortRef quotes an image source for a message, abbreviating a data: URI.
+func shortRef(src string) string {
+	if src == "" {
+		return "without a src"
+	}
+	if len(src) > 64 {
+		src = src[:61] + "..."
+	}
+	return fmt.Sprintf("%q", src)
+}
+
+func (l *linter) unsubscribe() {
+	precedence := strings.ToLower(strings.TrimSpace(l.email.Header("Precedence")))
+	bulk := l.opts.Bulk || l.email.Header("List-Id") != "" || precedence == "bulk" || precedence == "list"
+	if !bulk {
+		return
+	}
+	switch {
+	case l.email.Header("List-Unsubscribe") == "":
+		l.add(LintUnsubscribe, SeverityError, "bulk mail has no List-Unsubscribe header; Gmail and Yahoo require one-click unsubscribe")
+	case !l.email.HasOneClickUnsubscribe():
+		l.add(LintUnsubscribe, SeverityWarning, "List-Unsubscribe is not one-click (RFC 8058); call SetOneClickUnsubscribe")
+	}
+}
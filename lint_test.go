@@ -0,0 +1,159 @@
+package gsmail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func lintRules(findings []LintFinding) []string {
+	var rules []string
+	for _, f := range findings {
+		rules = append(rules, f.Severity.String()+" "+f.Rule)
+	}
+	return rules
+}
+
+func TestLint(t *testing.T) {
+	clean := func() Email {
+		return Email{
+			From:     "News <news@mail.example.com>",
+			Subject:  "Hello",
+			Body:     []byte("Hello"),
+			HTMLBody: []byte(`<p>Hello</p><img src="cid:logo@example.com" alt="Example">`),
+			Attachments: []Attachment{
+				{Filename: "logo.png", ContentType: "image/png", ContentID: "logo@example.com", Data: []byte("png")},
+			},
+		}
+	}
+	dkim := &DKIMOptions{Domain: "example.com", Selector: "s1"}
+
+	tests := []struct {
+		name   string
+		edit   func(*Email)
+		opts   LintOptions
+		wanted []string
+	}{
+		{name: "Clean", opts: LintOptions{DKIM: dkim}},
+		{
+			name:   "Unaligned From",
+			edit:   func(e *Email) { e.From = "news@example.net" },
+			opts:   LintOptions{DKIM: dkim},
+			wanted: []string{"error dkim-alignment"},
+		},
+		{
+			name:   "Bad From and empty subject",
+			edit:   func(e *Email) { e.From = "nobody"; e.Subject = " " },
+			wanted: []string{"error from", "warning subject"},
+		},
+		{
+			name:   "HTML only",
+			edit:   func(e *Email) { e.Body = nil },
+			wanted: []string{"warning plain-text"},
+		},
+		{
+			name: "AutoPlainText counts as a text part",
+			edit: func(e *Email) { e.Body = nil; e.AutoPlainText = true },
+		},
+		{
+			name: "Images",
+			edit: func(e *Email) {
+				e.HTMLBody = []byte(`<img src="cid:missing"><table background="cid:gone"><tr><td><img alt="" src="data:image/png;base64,` +
+					strings.Repeat("A", 32) + `"></table><!-- <img src="x.png"> -->`)
+			},
+			opts: LintOptions{MaxImageSize: 16},
+			wanted: []string{
+				"error missing-cid", "warning image-alt", "error missing-cid",
+				"warning data-uri", "warning image-size", "info unused-cid",
+			},
+		},
+		{
+			name:   "Large inline image",
+			edit:   func(e *Email) { e.Attachments[0].Data = make([]byte, 32) },
+			opts:   LintOptions{MaxImageSize: 16},
+			wanted: []string{"warning image-size"},
+		},
+		{
+			name:   "Bulk without List-Unsubscribe",
+			opts:   LintOptions{Bulk: true},
+			wanted: []string{"error unsubscribe"},
+		},
+		{
+			name: "List-Id without one-click",
+			edit: func(e *Email) {
+				e.SetHeader("List-Id", "<news.example.com>")
+				e.SetHeader("List-Unsubscribe", "<mailto:u@example.com>")
+			},
+			wanted: []string{"warning unsubscribe"},
+		},
+		{
+			name: "Bulk with one-click",
+			edit: func(e *Email) {
+				if err := e.SetOneClickUnsubscribe("https://example.com/u?t=1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts: LintOptions{Bulk: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := clean()
+			if tt.edit != nil {
+				tt.edit(&email)
+			}
+			got := lintRules(email.Lint(tt.opts))
+			if strings.Join(got, ", ") != strings.Join(tt.wanted, ", ") {
+				t.Errorf("Lint() = %q, want %q", got, tt.wanted)
+			}
+		})
+	}
+
+	if got := (Email{Raw: []byte("From: x\r\n\r\n")}).Lint(LintOptions{Bulk: true}); got != nil {
+		t.Errorf("a raw message should not be linted, got %v", got)
+	}
+}
+
+func TestDKIMAligned(t *testing.T) {
+	tests := []struct {
+		from, signing string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"News.Example.com", "example.com.", true},
+		{"example.com", "mail.example.com", true},
+		{"badexample.com", "example.com", false},
+		{"bücher.example", "xn--bcher-kva.example", true},
+	}
+	for _, tt := range tests {
+		if got := dkimAligned(tt.from, tt.signing); got != tt.want {
+			t.Errorf("dkimAligned(%q, %q) = %v, want %v", tt.from, tt.signing, got, tt.want)
+		}
+	}
+}
+
+func TestLintInterceptor(t *testing.T) {
+	inner := &recordingSender{}
+	sender := WrapSender(inner, LintInterceptor(LintOptions{}, SeverityError))
+
+	warned := Email{From: "a@example.com", Subject: "Hi", HTMLBody: []byte("<p>Hi</p>")}
+	if err := sender.Send(context.Background(), warned); err != nil {
+		t.Fatalf("a warning should not block: %v", err)
+	}
+
+	broken := warned
+	broken.HTMLBody = []byte(`<img src="cid:nothing" alt="">`)
+	err := sender.Send(context.Background(), broken)
+	var lintErr *LintError
+	if !errors.As(err, &lintErr) || IsRetryable(err) {
+		t.Fatalf("got %v, want a permanent *LintError", err)
+	}
+	if len(lintErr.Findings) != 1 || lintErr.Findings[0].Rule != LintMissingCID {
+		t.Errorf("findings = %v, want only the missing cid", lintErr.Findings)
+	}
+	if inner.count() != 1 {
+		t.Errorf("sent %d messages, want 1", inner.count())
+	}
+}
@@ -0,0 +1,305 @@
+// Package maildir reads and writes Maildir directories, one file per message,
+// and provides a gsmail.Receiver over one, so mail delivered locally -- by
+// Postfix, fetchmail, offlineimap or a test fixture -- is consumed the way
+// IMAP mail is.
+//
+// A Maildir holds three directories. A message is written into tmp and renamed
+// into new once complete, so a reader never sees half of one; a client that
+// has noticed it moves it into cur, appending ":2," and its flags to the file
+// name. The part of the name before the colon is the message's key, which
+// stays the same as it moves.
+package maildir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// ErrNotFound is returned for a key no message in the Maildir has.
+var ErrNotFound = errors.New("maildir: no such message")
+
+// Flag is a Maildir message flag, stored as one letter of the file name.
+type Flag byte
+
+// The flags of the Maildir specification, and the IMAP flags they stand for.
+const (
+	FlagDraft   Flag = 'D' // \Draft
+	FlagFlagged Flag = 'F' // \Flagged
+	FlagPassed  Flag = 'P' // forwarded, resent or bounced
+	FlagReplied Flag = 'R' // \Answered
+	FlagSeen    Flag = 'S' // \Seen
+	FlagTrashed Flag = 'T' // \Deleted
+)
+
+// Dir is the path of a Maildir.
+type Dir string
+
+// Init creates the Maildir and its tmp, new and cur directories, readable by
+// the owner only, if they do not already exist.
+func (d Dir) Init() error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(string(d), sub), 0o700); err != nil {
+			return fmt.Errorf("maildir: %w", err)
+		}
+	}
+	return nil
+}
+
+// Deliver writes email into new, as a mail server delivers, and returns its
+// key. It is rendered with gsmail.RenderMessageTo, so a message with Raw set
+// is stored as it is.
+func (d Dir) Deliver(email gsmail.Email) (string, error) {
+	return d.store(email, "new", "")
+}
+
+// Import writes email into cur with flags, as a message already seen by a
+// client, and returns its key. It is how mail migrated from elsewhere keeps
+// its read and answered state.
+func (d Dir) Import(email gsmail.Email, flags ...Flag) (string, error) {
+	return d.store(email, "cur", infoSuffix(flags))
+}
+
+func (d Dir) store(email gsmail.Email, sub, info string) (key string, err error) {
+	key = newKey()
+	tmp := filepath.Join(string(d), "tmp", key)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("maildir: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+	if err := gsmail.RenderMessageTo(f, email); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("maildir: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("maildir: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(string(d), sub, key+info)); err != nil {
+		return "", fmt.Errorf("maildir: %w", err)
+	}
+	return key, nil
+}
+
+// Message is a message in a Maildir, as Messages lists it.
+type Message struct {
+	// Key identifies the message for as long as it stays in the Maildir.
+	Key string
+	// Flags are the message's flags, in the order of the file name.
+	Flags []Flag
+	// New is set for a message in new, which no client has noticed yet.
+	New bool
+	// Delivered is when the message was written into the Maildir.
+	Delivered time.Time
+
+	path string
+}
+
+// Has reports whether the message has flag.
+func (m Message) Has(flag Flag) bool {
+	for _, f := range m.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Messages lists the messages in new and cur, oldest first. It reads only the
+// directories, not the messages.
+func (d Dir) Messages() ([]Message, error) {
+	var messages []Message
+	for _, sub := range []string{"new", "cur"} {
+		dir := filepath.Join(string(d), sub)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("maildir: %w", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // moved or removed since the listing
+			}
+			key, flags := splitName(name)
+			messages = append(messages, Message{
+				Key:       key,
+				Flags:     flags,
+				New:       sub == "new",
+				Delivered: info.ModTime(),
+				path:      filepath.Join(dir, name),
+			})
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].Delivered.Equal(messages[j].Delivered) {
+			return messages[i].Delivered.Before(messages[j].Delivered)
+		}
+		return messages[i].Key < messages[j].Key
+	})
+	return messages, nil
+}
+
+// Open returns the message stored under key. The caller must close it.
+func (d Dir) Open(key string) (io.ReadCloser, error) {
+	path, err := d.find(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("maildir: %w", err)
+	}
+	return f, nil
+}
+
+// Read parses the message stored under key with
+// gsmail.ParseRawEmailWithOptions. Its Mailbox is the Maildir's path.
+func (d Dir) Read(key string, opts gsmail.ParseOptions) (gsmail.Email, error) {
+	path, err := d.find(key)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+	return d.read(path, opts)
+}
+
+func (d Dir) read(path string, opts gsmail.ParseOptions) (gsmail.Email, error) {
+	email, err := gsmail.ReadEMLFile(path, opts)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+	email.Mailbox = string(d)
+	return email, nil
+}
+
+// SetFlags replaces the flags of the message stored under key, moving it into
+// cur if it is in new.
+func (d Dir) SetFlags(key string, flags ...Flag) error {
+	path, err := d.find(key)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(string(d), "cur", key+infoSuffix(flags))
+	if path == target {
+		return nil
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("maildir: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the message stored under key.
+func (d Dir) Remove(key string) error {
+	path, err := d.find(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("maildir: %w", err)
+	}
+	return nil
+}
+
+// find returns the path of the message stored under key.
+func (d Dir) find(key string) (string, error) {
+	if key == "" || key == ".." || strings.ContainsRune(key, ':') || filepath.Base(key) != key {
+		return "", fmt.Errorf("%w: %q", ErrNotFound, key)
+	}
+	if path := filepath.Join(string(d), "new", key); fileExists(path) {
+		return path, nil
+	}
+	cur := filepath.Join(string(d), "cur")
+	entries, err := os.ReadDir(cur)
+	if err != nil {
+		return "", fmt.Errorf("maildir: %w", err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name == key || strings.HasPrefix(name, key+":") {
+			return filepath.Join(cur, name), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrNotFound, key)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// splitName separates a file name into the key and the flags of its ":2,"
+// info. Info in another form is kept in the key's place, unparsed.
+func splitName(name string) (string, []Flag) {
+	i := strings.LastIndexByte(name, ':')
+	if i < 0 {
+		return name, nil
+	}
+	info := name[i+1:]
+	if !strings.HasPrefix(info, "2,") {
+		return name[:i], nil
+	}
+	var flags []Flag
+	for _, c := range []byte(info[2:]) {
+		if c >= 'A' && c <= 'Z' {
+			flags = append(flags, Flag(c))
+		}
+	}
+	return name[:i], flags
+}
+
+// infoSuffix is the ":2," info for flags, which the specification requires in
+// ASCII order without repeats.
+func infoSuffix(flags []Flag) string {
+	letters := make([]byte, 0, len(flags))
+	for _, f := range flags {
+		if f >= 'A' && f <= 'Z' && !strings.ContainsRune(string(letters), rune(f)) {
+			letters = append(letters, byte(f))
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return ":2," + string(letters)
+}
+
+var (
+	deliveries atomic.Uint64
+	hostname   = func() string {
+		h, err := os.Hostname()
+		if err != nil || h == "" {
+			h = "localhost"
+		}
+		// The specification's escapes for the two characters a name
+		// cannot hold.
+		return strings.NewReplacer("/", `\057`, ":", `\072`).Replace(h)
+	}()
+)
+
+// newKey returns a name unique to this delivery, in the form the Maildir
+// specification recommends: the time, the process and a counter, and the
+// host.
+func newKey() string {
+	now := time.Now()
+	return strconv.FormatInt(now.Unix(), 10) +
+		".M" + strconv.Itoa(now.Nanosecond()/1000) +
+		"P" + strconv.Itoa(os.Getpid()) +
+		"Q" + strconv.FormatUint(deliveries.Add(1), 10) +
+		"." + hostname
+}
@@ -0,0 +1,137 @@
+package maildir
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gsoultan/gsmail"
+)
+
+func newDir(t *testing.T) Dir {
+	t.Helper()
+	d := Dir(filepath.Join(t.TempDir(), "Maildir"))
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func message(subject string) gsmail.Email {
+	return gsmail.Email{
+		From:    "alice@example.com",
+		To:      []string{"bob@example.com"},
+		Subject: subject,
+		Body:    []byte("Hello"),
+	}
+}
+
+func TestDeliverAndRead(t *testing.T) {
+	d := newDir(t)
+	key, err := d.Deliver(message("Delivered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), "new", key)); err != nil {
+		t.Errorf("not in new: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(string(d), "tmp")); len(entries) != 0 {
+		t.Errorf("%d files left in tmp", len(entries))
+	}
+
+	got, err := d.Read(key, gsmail.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "Delivered" || got.Mailbox != string(d) {
+		t.Errorf("Read() = %q from %q", got.Subject, got.Mailbox)
+	}
+
+	rc, err := d.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(rc)
+	rc.Close()
+	if !strings.Contains(string(raw), "Subject: Delivered\r\n") {
+		t.Errorf("Open() read %q", raw)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	d := newDir(t)
+	key, err := d.Deliver(message("Flags"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetFlags(key, FlagSeen, FlagFlagged, FlagSeen); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), "cur", key+":2,FS")); err != nil {
+		t.Errorf("want the file in cur with sorted flags: %v", err)
+	}
+
+	imported, err := d.Import(message("Imported"), FlagReplied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := d.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Messages() returned %d", len(messages))
+	}
+	byKey := map[string]Message{messages[0].Key: messages[0], messages[1].Key: messages[1]}
+	if m := byKey[key]; m.New || !m.Has(FlagSeen) || !m.Has(FlagFlagged) || m.Has(FlagReplied) {
+		t.Errorf("delivered message listed as %+v", m)
+	}
+	if m := byKey[imported]; m.New || string(m.Flags) != "R" {
+		t.Errorf("imported message listed as %+v", m)
+	}
+
+	if err := d.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read(key, gsmail.ParseOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("after Remove: %v, want ErrNotFound", err)
+	}
+	for _, bad := range []string{"", "..", "../x", "a:2,S"} {
+		if _, err := d.Open(bad); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q) = %v, want ErrNotFound", bad, err)
+		}
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		name, key, flags string
+	}{
+		{"123.M1P2Q3.host", "123.M1P2Q3.host", ""},
+		{"123.M1P2Q3.host:2,FRS", "123.M1P2Q3.host", "FRS"},
+		{"123.host:2,", "123.host", ""},
+		{"123.host:1,experimental", "123.host", ""},
+	}
+	for _, tt := range tests {
+		key, flags := splitName(tt.name)
+		if key != tt.key || string(flags) != tt.flags {
+			t.Errorf("splitName(%q) = %q, %q", tt.name, key, flags)
+		}
+	}
+}
+
+func TestKeysAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 1000 {
+		k := newKey()
+		if seen[k] || strings.ContainsAny(k, "/:") {
+			t.Fatalf("bad or repeated key %q", k)
+		}
+		seen[k] = true
+	}
+}
@@ -0,0 +1,241 @@
+package maildir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// DefaultPollInterval is how often Idle looks for new mail when
+// Receiver.PollInterval is zero.
+const DefaultPollInterval = 5 * time.Second
+
+// Receiver implements gsmail.Receiver over a Maildir, so interceptors, the
+// attachment policy and the rest of the receiving side work on local mail as
+// they do on IMAP.
+//
+// The Maildir is only read unless MarkSeen is set. A Receiver is safe for
+// concurrent use once configured.
+type Receiver struct {
+	gsmail.BaseProvider
+
+	// Dir is the Maildir to read.
+	Dir Dir
+
+	// MarkSeen gives each message returned the Seen flag, moving it from new
+	// into cur, as an IMAP fetch marks the messages it returns \Seen.
+	MarkSeen bool
+
+	// PollInterval is how often Idle lists new. Zero means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+
+	// ParseOptions are passed to gsmail.ParseRawEmailWithOptions for each
+	// message.
+	ParseOptions gsmail.ParseOptions
+
+	// OnUnparseable is called for each message Receive, Search and Idle skip
+	// because it does not parse, with the error. The message is left where
+	// it is, unmarked, as the IMAP and POP3 receivers leave one on the
+	// server.
+	OnUnparseable func(m Message, err error)
+}
+
+// NewReceiver returns a Receiver reading the Maildir at path.
+func NewReceiver(path string) *Receiver {
+	return &Receiver{Dir: Dir(path)}
+}
+
+// Ping reports whether the Maildir exists, with its tmp, new and cur
+// directories.
+func (r *Receiver) Ping(ctx context.Context) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		info, err := os.Stat(filepath.Join(string(r.Dir), sub))
+		if err != nil {
+			return gsmail.NonRetryable(fmt.Errorf("maildir: %w", err))
+		}
+		if !info.IsDir() {
+			return gsmail.NonRetryable(fmt.Errorf("maildir: %s is not a directory", info.Name()))
+		}
+	}
+	return nil
+}
+
+// Receive returns the newest messages, most recent first, ordered by when
+// they were delivered. limit must be greater than zero; see
+// gsmail.ErrInvalidLimit.
+func (r *Receiver) Receive(ctx context.Context, limit int) ([]gsmail.Email, error) {
+	return r.Search(ctx, gsmail.SearchOptions{}, limit)
+}
+
+// Search returns at most limit messages matching options, newest first. From
+// and Subject match a substring of the header without regard to case, Since
+// and Before compare the delivery time, as IMAP compares its internal date,
+// and Unseen selects messages without the Seen flag. limit must be greater
+// than zero; see gsmail.ErrInvalidLimit.
+//
+// Every message delivered in the Since and Before window is parsed until
+// limit are found, so narrow the window on a large Maildir.
+func (r *Receiver) Search(ctx context.Context, options gsmail.SearchOptions, limit int) ([]gsmail.Email, error) {
+	if err := gsmail.CheckLimit(limit); err != nil {
+		return nil, err
+	}
+	messages, err := r.Dir.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []gsmail.Email
+	for i := len(messages) - 1; i >= 0 && len(emails) < limit; i-- {
+		if err := ctx.Err(); err != nil {
+			return emails, err
+		}
+		m := messages[i]
+		if !options.Since.IsZero() && m.Delivered.Before(options.Since) ||
+			!options.Before.IsZero() && !m.Delivered.Before(options.Before) ||
+			options.Unseen && m.Has(FlagSeen) {
+			continue
+		}
+		email, ok, err := r.read(m)
+		if err != nil {
+			return emails, err
+		}
+		if !ok {
+			continue
+		}
+		if !containsFold(email.From, options.From) || !containsFold(email.Subject, options.Subject) {
+			continue
+		}
+		if err := r.seen(m); err != nil {
+			return emails, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// Idle lists new every PollInterval and sends each message that has appeared
+// there since the previous listing. Messages already in new when it starts
+// are not sent; Receive returns those. Both channels are closed when ctx is
+// done or listing fails.
+func (r *Receiver) Idle(ctx context.Context) (<-chan gsmail.Email, <-chan error) {
+	emailChan := make(chan gsmail.Email, 10)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(emailChan)
+		defer close(errChan)
+
+		interval := r.PollInterval
+		if interval <= 0 {
+			interval = DefaultPollInterval
+		}
+		known, err := r.newKeys()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			messages, err := r.Dir.Messages()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			current := make(map[string]bool, len(known))
+			for _, m := range messages {
+				if !m.New {
+					continue
+				}
+				current[m.Key] = true
+				if known[m.Key] {
+					continue
+				}
+				email, ok, err := r.read(m)
+				if err != nil {
+					errChan <- err
+					return
+				}
+				if !ok {
+					continue
+				}
+				if err := r.seen(m); err != nil {
+					errChan <- err
+					return
+				}
+				select {
+				case emailChan <- email:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = current
+		}
+	}()
+
+	return emailChan, errChan
+}
+
+// read parses m. It returns false without an error for a message that has
+// been moved or removed since the listing, and for one that does not parse,
+// which it reports to OnUnparseable.
+func (r *Receiver) read(m Message) (gsmail.Email, bool, error) {
+	raw, err := os.ReadFile(m.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return gsmail.Email{}, false, nil
+		}
+		return gsmail.Email{}, false, fmt.Errorf("maildir: %w", err)
+	}
+	email, err := gsmail.ParseRawEmailWithOptions(raw, r.ParseOptions)
+	if err != nil {
+		if r.OnUnparseable != nil {
+			r.OnUnparseable(m, err)
+		}
+		return gsmail.Email{}, false, nil
+	}
+	email.Mailbox = string(r.Dir)
+	return email, true, nil
+}
+
+// newKeys returns the keys of the messages in new.
+func (r *Receiver) newKeys() (map[string]bool, error) {
+	messages, err := r.Dir.Messages()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, m := range messages {
+		if m.New {
+			keys[m.Key] = true
+		}
+	}
+	return keys, nil
+}
+
+// seen marks m Seen when MarkSeen is set.
+func (r *Receiver) seen(m Message) error {
+	if !r.MarkSeen || m.Has(FlagSeen) {
+		return nil
+	}
+	return r.Dir.SetFlags(m.Key, append(m.Flags, FlagSeen)...)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
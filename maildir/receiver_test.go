@@ -0,0 +1,173 @@
+package maildir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+)
+
+var _ gsmail.Receiver = (*Receiver)(nil)
+
+// deliverAt delivers a message and dates its delivery.
+func deliverAt(t *testing.T, d Dir, subject string, at time.Time) string {
+	t.Helper()
+	key, err := d.Deliver(message(subject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(string(d), "new", key), at, at); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func subjects(emails []gsmail.Email) string {
+	var s []string
+	for _, e := range emails {
+		s = append(s, e.Subject)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestReceiveNewestFirst(t *testing.T) {
+	d := newDir(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, s := range []string{"one", "two", "three"} {
+		deliverAt(t, d, s, base.Add(time.Duration(i)*time.Hour))
+	}
+
+	r := NewReceiver(string(d))
+	if err := r.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	emails, err := r.Receive(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(emails); got != "three,two" {
+		t.Errorf("Receive() = %s", got)
+	}
+	if _, err := r.Receive(context.Background(), 0); !errors.Is(err, gsmail.ErrInvalidLimit) {
+		t.Errorf("limit 0: %v", err)
+	}
+}
+
+func TestSearchAndMarkSeen(t *testing.T) {
+	d := newDir(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	deliverAt(t, d, "Invoice 1", base)
+	deliverAt(t, d, "Newsletter", base.Add(time.Hour))
+	deliverAt(t, d, "Invoice 2", base.Add(2*time.Hour))
+
+	r := NewReceiver(string(d))
+	r.MarkSeen = true
+
+	emails, err := r.Search(context.Background(), gsmail.SearchOptions{Subject: "invoice", Unseen: true}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(emails); got != "Invoice 2,Invoice 1" {
+		t.Errorf("Search() = %s", got)
+	}
+	emails, _ = r.Search(context.Background(), gsmail.SearchOptions{Unseen: true}, 10)
+	if got := subjects(emails); got != "Newsletter" {
+		t.Errorf("unseen after MarkSeen = %s", got)
+	}
+
+	emails, _ = r.Search(context.Background(), gsmail.SearchOptions{Since: base.Add(30 * time.Minute), Before: base.Add(2 * time.Hour)}, 10)
+	if got := subjects(emails); got != "Newsletter" {
+		t.Errorf("Since/Before = %s", got)
+	}
+
+	if err := NewReceiver(filepath.Join(string(d), "missing")).Ping(context.Background()); err == nil || gsmail.IsRetryable(err) {
+		t.Errorf("Ping on a missing Maildir: %v", err)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	d := newDir(t)
+	deliverAt(t, d, "Before", time.Now())
+
+	r := NewReceiver(string(d))
+	r.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emails, errs := r.Idle(ctx)
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := d.Deliver(message("After")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-emails:
+		if e.Subject != "After" {
+			t.Errorf("Idle sent %q, want only the new message", e.Subject)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle sent nothing")
+	}
+
+	cancel()
+	for range emails {
+	}
+	if err, ok := <-errs; ok {
+		t.Errorf("error after cancel: %v", err)
+	}
+}
+
+func TestSkipUnparseable(t *testing.T) {
+	d := newDir(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	deliverAt(t, d, "one", base)
+	bad := filepath.Join(string(d), "new", "1.bad.host")
+	if err := os.WriteFile(bad, []byte("not a message\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(bad, base.Add(time.Hour), base.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	deliverAt(t, d, "two", base.Add(2*time.Hour))
+
+	var skipped []string
+	r := NewReceiver(string(d))
+	r.OnUnparseable = func(m Message, err error) { skipped = append(skipped, m.Key) }
+	emails, err := r.Receive(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(emails); got != "two,one" || len(skipped) != 1 || skipped[0] != "1.bad.host" {
+		t.Errorf("Receive() = %s, skipped %q", got, skipped)
+	}
+
+	r.OnUnparseable = nil
+	r.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle, errs := r.Idle(ctx)
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(string(d), "new", "2.bad.host"), []byte("not a message\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Deliver(message("three")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-idle:
+		if e.Subject != "three" {
+			t.Errorf("Idle sent %q", e.Subject)
+		}
+	case err := <-errs:
+		t.Fatalf("Idle stopped: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle sent nothing")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"time"
@@ -43,6 +44,19 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	if err := gsmail.RejectEnvelope("mailgun", email); err != nil {
 		return err
 	}
+	if err := gsmail.RejectRaw("mailgun", email); err != nil {
+		return err
+	}
+	email = gsmail.WithPlainText(email)
+	// The API cannot add a part to multipart/alternative, so an invitation
+	// travels as a text/calendar attachment.
+	email, err := gsmail.CalendarAsAttachment(email)
+	if err != nil {
+		return err
+	}
+	if err := gsmail.CheckAMP(email); err != nil {
+		return err
+	}
 	// Build the multipart payload once. It is identical on every attempt, and
 	// re-encoding attachments per retry is pure waste.
 	body, contentType, err := buildForm(email)
@@ -82,14 +96,14 @@ func buildForm(email gsmail.Email) ([]byte, string, error) {
 	writer := multipart.NewWriter(buf)
 
 	_ = writer.WriteField("from", gsmail.FormatAddress(email.From))
-	for _, to := range email.To {
-		_ = writer.WriteField("to", gsmail.FormatAddress(to))
+	for _, to := range gsmail.FormatMailboxes(email.To) {
+		_ = writer.WriteField("to", to)
 	}
-	for _, cc := range email.Cc {
-		_ = writer.WriteField("cc", gsmail.FormatAddress(cc))
+	for _, cc := range gsmail.FormatMailboxes(email.Cc) {
+		_ = writer.WriteField("cc", cc)
 	}
-	for _, bcc := range email.Bcc {
-		_ = writer.WriteField("bcc", gsmail.FormatAddress(bcc))
+	for _, bcc := range gsmail.FormatMailboxes(email.Bcc) {
+		_ = writer.WriteField("bcc", bcc)
 	}
 	_ = writer.WriteField("subject", email.Subject)
 
@@ -98,13 +112,14 @@ func buildForm(email gsmail.Email) ([]byte, string, error) {
 	}
 
 	// Custom headers (List-Unsubscribe, In-Reply-To, X-*) ride along as
-	// "h:Name" fields, which is Mailgun's pass-through convention.
-	hdrs, err := gsmail.CustomHeaders(email.Headers)
+	// "h:Name" fields, which is Mailgun's pass-through convention. A form
+	// may repeat a field, so repeated headers keep their order.
+	fields, err := gsmail.CustomHeaderFields(email)
 	if err != nil {
 		return nil, "", err
 	}
-	for name, value := range hdrs {
-		_ = writer.WriteField("h:"+name, value)
+	for _, f := range fields {
+		_ = writer.WriteField("h:"+f.Name, f.Value)
 	}
 
 	// Body is text/plain and HTMLBody is text/html. Sniffing Body for markup
@@ -115,6 +130,9 @@ func buildForm(email gsmail.Email) ([]byte, string, error) {
 	if len(email.HTMLBody) > 0 {
 		_ = writer.WriteField("html", string(email.HTMLBody))
 	}
+	if len(email.AMPBody) > 0 {
+		_ = writer.WriteField("amp-html", string(email.AMPBody))
+	}
 
 	for _, att := range email.Attachments {
 		// Mailgun supports "attachment" for regular and "inline" for inline
@@ -126,7 +144,7 @@ func buildForm(email gsmail.Email) ([]byte, string, error) {
 		if err != nil {
 			return nil, "", gsmail.NonRetryable(fmt.Errorf("mailgun: attach %q: %w", att.Filename, err))
 		}
-		if _, err := part.Write(att.Data); err != nil {
+		if err := copyAttachment(part, att); err != nil {
 			return nil, "", gsmail.NonRetryable(fmt.Errorf("mailgun: attach %q: %w", att.Filename, err))
 		}
 	}
@@ -138,6 +156,18 @@ func buildForm(email gsmail.Email) ([]byte, string, error) {
 	return buf.Bytes(), writer.FormDataContentType(), nil
 }
 
+// copyAttachment writes the attachment content into its form part, streaming
+// it when the attachment is backed by Attachment.Open.
+func copyAttachment(w io.Writer, att gsmail.Attachment) error {
+	rc, err := att.Reader()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
 // Ping checks the connection to Mailgun by querying domain information.
 func (p *Sender) Ping(ctx context.Context) error {
 	return gsmail.Retry(ctx, p.GetRetryConfig(), func() error {
@@ -157,3 +187,19 @@ func (p *Sender) Ping(ctx context.Context) error {
 		return nil
 	})
 }
+
+// mailgunLimits are Mailgun's: 25 MB per message including attachments
+// after encoding, and 1000 recipients.
+var mailgunLimits = gsmail.Limits{
+	MaxMessageSize: 25_000_000,
+	MaxRecipients:  1000,
+}
+
+// Limits returns what Mailgun accepts in one message.
+func (p *Sender) Limits() gsmail.Limits { return mailgunLimits }
+
+// Preflight reports, without calling Mailgun, whether it would refuse email
+// for exceeding its limits. Use gsmail.PreflightInterceptor to apply it.
+func (p *Sender) Preflight(email gsmail.Email) error {
+	return p.Limits().Check("mailgun", email)
+}
@@ -2,6 +2,7 @@ package mailgun
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -41,3 +42,42 @@ func TestMailgunSender_Send(t *testing.T) {
 		t.Fatalf("Send failed: %v", err)
 	}
 }
+
+func TestMailgunAMPBody(t *testing.T) {
+	var amp string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		amp = r.FormValue("amp-html")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender("example.com", "test-key")
+	sender.BaseURL = server.URL
+	sender.Client = server.Client()
+
+	email := gsmail.Email{
+		From:     "sender@example.com",
+		To:       []string{"receiver@example.com"},
+		Subject:  "RSVP",
+		HTMLBody: []byte("<p>Hi</p>"),
+		AMPBody:  []byte(ampDocument),
+	}
+	if err := sender.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if amp != ampDocument {
+		t.Errorf("amp-html = %q", amp)
+	}
+
+	email.AMPBody = []byte("<p>not AMP</p>")
+	if err := sender.Send(context.Background(), email); !errors.Is(err, gsmail.ErrInvalidAMP) {
+		t.Errorf("invalid AMP: %v", err)
+	}
+}
+
+const ampDocument = `<!doctype html><html ⚡4email><head><meta charset="utf-8">` +
+	`<script async src="https://cdn.ampproject.org/v0.js"></script>` +
+	`<style amp4email-boilerplate>body{visibility:hidden}</style></head><body>Hi</body></html>`
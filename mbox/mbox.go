@@ -0,0 +1,285 @@
+// Package mbox reads and writes mbox files, the single-file mailbox format of
+// Unix mail spools, Thunderbird and Google Takeout, as streams of
+// gsmail.Email values.
+//
+// Messages are written in the mboxrd variant: a body line beginning "From ",
+// after any number of '>', gains one more '>', and the reader removes one
+// again, so every message reads back as it was written. The unescaped mboxo
+// files most other tools produce read the same way, except that a body line
+// that was ">From " in the original loses its '>'; mboxo cannot tell the two
+// apart, which is why mboxrd exists.
+//
+// An mbox stores lines ending in LF. The reader returns each message with
+// CRLF line endings, as the network carries it and as a DKIM signature was
+// computed over it.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// ErrInvalidMbox is returned by Reader for input that does not begin with the
+// "From " line that opens every message.
+var ErrInvalidMbox = errors.New("mbox: not an mbox file")
+
+// Reader reads the messages of an mbox file in turn. It holds one message at
+// a time, so a mailbox of any size can be read.
+type Reader struct {
+	// Options are passed to gsmail.ParseRawEmailWithOptions for each message
+	// Next returns. RetainRaw keeps each message's bytes, for re-sending or
+	// writing it out again unchanged.
+	Options gsmail.ParseOptions
+
+	r       *bufio.Reader
+	started bool
+	err     error
+}
+
+// NewReader returns a Reader reading the mbox file r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next parses the next message. It returns io.EOF after the last one.
+//
+// A message that does not parse is reported with its error and skipped, so
+// the caller may log it and call Next again.
+func (r *Reader) Next() (gsmail.Email, error) {
+	raw, err := r.NextRaw()
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+	return gsmail.ParseRawEmailWithOptions(raw, r.Options)
+}
+
+// NextRaw returns the next message as it is stored, unescaped and with CRLF
+// line endings, without its "From " line. It returns io.EOF after the last
+// one.
+func (r *Reader) NextRaw() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !r.started {
+		if err := r.start(); err != nil {
+			r.err = err
+			return nil, err
+		}
+		r.started = true
+	}
+
+	var msg bytes.Buffer
+	blank := false // the previous line was empty; it may be the separator
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			r.err = readError(err)
+			break
+		}
+		if bytes.HasPrefix(line, []byte(fromLine)) {
+			break
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if blank {
+			msg.WriteString("\r\n")
+		}
+		if blank = len(line) == 0; blank {
+			if err != nil {
+				r.err = readError(err)
+				break
+			}
+			continue
+		}
+		if isEscapedFrom(line) {
+			line = line[1:]
+		}
+		msg.Write(line)
+		msg.WriteString("\r\n")
+		if err != nil {
+			r.err = readError(err)
+			break
+		}
+	}
+	if msg.Len() == 0 && r.err != nil {
+		return nil, r.err
+	}
+	return msg.Bytes(), nil
+}
+
+// start consumes the first "From " line, after any blank lines before it.
+func (r *Reader) start() error {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte(fromLine)) {
+			return nil
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			return ErrInvalidMbox
+		}
+		if err != nil {
+			return readError(err)
+		}
+	}
+}
+
+// readError passes io.EOF through and wraps anything else.
+func readError(err error) error {
+	if err == io.EOF {
+		return io.EOF
+	}
+	return fmt.Errorf("mbox: read: %w", err)
+}
+
+// isEscapedFrom reports whether line is "From " behind one or more '>'.
+func isEscapedFrom(line []byte) bool {
+	rest := bytes.TrimLeft(line, ">")
+	return len(rest) < len(line) && bytes.HasPrefix(rest, []byte(fromLine))
+}
+
+// Writer appends messages to an mbox file.
+type Writer struct {
+	w   *bufio.Writer
+	msg bytes.Buffer // the message being written, until it has rendered
+}
+
+// NewWriter returns a Writer appending to w. Open an existing mbox with
+// os.O_APPEND to add to it.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write renders email with gsmail.RenderMessageTo and appends it. A message
+// with Raw set, such as one read with Options.RetainRaw, is written as it is.
+// A message that fails to render is not written at all.
+//
+// The "From " line names the address in From, or MAILER-DAEMON, and the time
+// in the message's Date header, or the current time when it has none.
+func (w *Writer) Write(email gsmail.Email) error {
+	sender := "MAILER-DAEMON"
+	if from, err := gsmail.ParseAddress(email.From); err == nil && from.Address != "" {
+		sender = from.Address
+	}
+	date, err := mail.ParseDate(email.Header("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	return w.write(sender, date, func(out io.Writer) error { return gsmail.RenderMessageTo(out, email) })
+}
+
+// WriteRaw appends a message that is already rendered, with sender and date
+// for its "From " line.
+func (w *Writer) WriteRaw(raw []byte, sender string, date time.Time) error {
+	return w.write(sender, date, func(out io.Writer) error {
+		_, err := out.Write(raw)
+		return err
+	})
+}
+
+func (w *Writer) write(sender string, date time.Time, render func(io.Writer) error) error {
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	// A message that fails to render must leave nothing behind, or the next
+	// one would be appended to half of it.
+	w.msg.Reset()
+	fmt.Fprintf(&w.msg, "From %s %s\n", sender, date.UTC().Format(time.ANSIC))
+	body := &escaper{w: &w.msg}
+	if err := render(body); err != nil {
+		return err
+	}
+	body.finish()
+	w.msg.WriteString("\n")
+	w.w.Write(w.msg.Bytes())
+	if err := w.w.Flush(); err != nil {
+		return fmt.Errorf("mbox: write: %w", err)
+	}
+	return nil
+}
+
+// escaper writes a message body in mbox form: LF line endings, and "From "
+// lines escaped. It holds back the start of a line until it knows whether the
+// line needs a '>'.
+type escaper struct {
+	w    *bytes.Buffer
+	line []byte // the start of the current line, while it may be a "From " line
+	mid  bool   // the current line is decided, and its start written
+	cr   bool   // a write ended in CR, which is dropped if LF follows
+}
+
+const fromLine = "From "
+
+func (e *escaper) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			e.text(p)
+			break
+		}
+		e.text(p[:i])
+		e.cr = false
+		e.endLine()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// text adds bytes that do not end the current line.
+func (e *escaper) text(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	if e.cr {
+		e.put([]byte{'\r'})
+		e.cr = false
+	}
+	if p[len(p)-1] == '\r' {
+		p = p[:len(p)-1]
+		e.cr = true
+	}
+	e.put(p)
+}
+
+func (e *escaper) put(p []byte) {
+	if e.mid {
+		e.w.Write(p)
+		return
+	}
+	e.line = append(e.line, p...)
+	rest := bytes.TrimLeft(e.line, ">")
+	if len(rest) < len(fromLine) && strings.HasPrefix(fromLine, string(rest)) {
+		return
+	}
+	if bytes.HasPrefix(rest, []byte(fromLine)) {
+		e.w.WriteByte('>')
+	}
+	e.w.Write(e.line)
+	e.line = e.line[:0]
+	e.mid = true
+}
+
+func (e *escaper) endLine() {
+	e.w.Write(e.line)
+	e.w.WriteByte('\n')
+	e.line = e.line[:0]
+	e.mid = false
+}
+
+// finish ends a message whose last line has no line break.
+func (e *escaper) finish() {
+	if e.cr {
+		e.put([]byte{'\r'})
+		e.cr = false
+	}
+	if e.mid || len(e.line) > 0 {
+		e.endLine()
+	}
+}
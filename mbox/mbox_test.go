@@ -0,0 +1,190 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+)
+
+func TestWriteThenRead(t *testing.T) {
+	emails := []gsmail.Email{
+		{
+			From:    "Alice <alice@example.com>",
+			To:      []string{"bob@example.com"},
+			Subject: "Escaping",
+			Body:    []byte("From here on\n>From quoted\n>>From twice\nFrom\n\nend\n\n"),
+		},
+		{
+			From:        "carol@example.com",
+			To:          []string{"bob@example.com"},
+			Subject:     "Attachment",
+			Body:        []byte("See attached."),
+			Attachments: []gsmail.Attachment{{Filename: "a.txt", ContentType: "text/plain", Data: []byte("From a file")}},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, e := range emails {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := buf.String()
+	if strings.Count(out, "\nFrom ") != 1 || !strings.HasPrefix(out, "From alice@example.com ") {
+		t.Errorf("want two From_ lines, got:\n%s", out)
+	}
+	if strings.Contains(out, "\r") {
+		t.Error("mbox output should have LF line endings")
+	}
+
+	r := NewReader(&buf)
+	for i, want := range emails {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got.Subject != want.Subject || string(got.Body) != string(want.Body) {
+			t.Errorf("message %d: got %q %q", i, got.Subject, got.Body)
+		}
+		if len(got.Attachments) != len(want.Attachments) {
+			t.Errorf("message %d: %d attachments, want %d", i, len(got.Attachments), len(want.Attachments))
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last message: %v, want io.EOF", err)
+	}
+}
+
+func TestReadRawKeepsMessageExactly(t *testing.T) {
+	raw := "Subject: a\r\nFrom: a@example.com\r\n\r\nline\r\nFrom me\r\n>From you\r\n\r\n"
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	date := time.Date(2024, 3, 1, 9, 5, 0, 0, time.UTC)
+	if err := w.WriteRaw([]byte(raw), "a@example.com", date); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRaw([]byte("Subject: b\r\n\r\nno final newline"), "", date); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "From a@example.com Fri Mar  1 09:05:00 2024\n") {
+		t.Errorf("From_ line: %q", buf.String()[:50])
+	}
+
+	r := NewReader(&buf)
+	got, err := r.NextRaw()
+	if err != nil || string(got) != raw {
+		t.Errorf("NextRaw() = %q, %v\nwant %q", got, err, raw)
+	}
+	got, err = r.NextRaw()
+	if err != nil || string(got) != "Subject: b\r\n\r\nno final newline\r\n" {
+		t.Errorf("NextRaw() = %q, %v", got, err)
+	}
+	if _, err := r.NextRaw(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReadMboxo(t *testing.T) {
+	// The form most tools write: LF endings, no escaping of ">From".
+	in := "\nFrom MAILER-DAEMON Thu Jan  1 00:00:00 1970\nSubject: one\n\nbody\n\nFrom x@example.com Thu Jan  1 00:00:00 1970\nSubject: two\n\nbody two\n"
+	r := NewReader(strings.NewReader(in))
+	var subjects []string
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects = append(subjects, e.Subject)
+	}
+	if strings.Join(subjects, ",") != "one,two" {
+		t.Errorf("subjects = %q", subjects)
+	}
+}
+
+func TestReadRejectsNonMbox(t *testing.T) {
+	r := NewReader(strings.NewReader("Subject: not an mbox\r\n\r\nbody"))
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidMbox) {
+		t.Errorf("got %v, want ErrInvalidMbox", err)
+	}
+	if _, err := NewReader(strings.NewReader("")).Next(); err != io.EOF {
+		t.Errorf("empty input: got %v, want io.EOF", err)
+	}
+}
+
+// splitWriter passes each byte in a separate call, the worst case for the
+// escaper's line handling.
+type splitWriter struct{ w io.Writer }
+
+func (s splitWriter) Write(p []byte) (int, error) {
+	for i := range p {
+		if _, err := s.w.Write(p[i : i+1]); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+func TestEscaperAcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	body := "a\r\nFrom b\r\n>From c\r\nFro\r\nx\r"
+	err := w.write("s", time.Unix(0, 0), func(out io.Writer) error {
+		_, err := splitWriter{out}.Write([]byte(body))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a\n>From b\n>>From c\nFro\nx\r\n\n"
+	if got := buf.String()[strings.IndexByte(buf.String(), '\n')+1:]; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteAfterFailedRender(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	failed := errors.New("render failed")
+	err := w.write("s", time.Unix(0, 0), func(out io.Writer) error {
+		io.WriteString(out, "Subject: Broken\r\n\r\n"+strings.Repeat("x", 10000))
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("write() = %v", err)
+	}
+	err = w.Write(gsmail.Email{
+		From:     "a@example.com",
+		To:       []string{"b@example.com"},
+		Subject:  "Invalid AMP",
+		HTMLBody: []byte("<p>Hi</p>"),
+		AMPBody:  []byte("<p>not AMP</p>"),
+	})
+	if !errors.Is(err, gsmail.ErrInvalidAMP) {
+		t.Fatalf("Write() = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("failed writes left %d bytes", buf.Len())
+	}
+
+	if err := w.Write(gsmail.Email{From: "a@example.com", To: []string{"b@example.com"}, Subject: "Good", Body: []byte("Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(&buf)
+	got, err := r.Next()
+	if err != nil || got.Subject != "Good" {
+		t.Fatalf("Next() = %q, %v", got.Subject, err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the message: %v, want io.EOF", err)
+	}
+}
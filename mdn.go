@@ -0,0 +1,329 @@
+package gsmail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// DispositionType is what happened to a message, as a Message Disposition
+// Notification reports it (RFC 8098 section 3.2.6.2).
+type DispositionType string
+
+const (
+	// DispositionDisplayed means the message was shown to the recipient.
+	// It says nothing about whether it was read or understood.
+	DispositionDisplayed DispositionType = "displayed"
+	// DispositionDeleted means the message was deleted without being shown.
+	DispositionDeleted DispositionType = "deleted"
+	// DispositionDispatched means the message was printed, faxed or
+	// forwarded without being shown.
+	DispositionDispatched DispositionType = "dispatched"
+	// DispositionProcessed means the message was handled by some other
+	// means without being shown.
+	DispositionProcessed DispositionType = "processed"
+)
+
+// Disposition is the Disposition field of an MDN: what happened to the
+// message, and whether the recipient or their software decided it.
+type Disposition struct {
+	Type DispositionType `json:"type"`
+	// AutomaticAction is set when the disposition was performed by software
+	// rather than by the user ("automatic-action" rather than
+	// "manual-action").
+	AutomaticAction bool `json:"automatic_action"`
+	// AutomaticSend is set when the MDN was sent without the user being
+	// asked ("MDN-sent-automatically" rather than "MDN-sent-manually").
+	AutomaticSend bool `json:"automatic_send"`
+	// Modifiers qualify the type; "error" is the one RFC 8098 defines.
+	Modifiers []string `json:"modifiers,omitempty"`
+}
+
+// String returns the disposition as the field value, such as
+// "manual-action/MDN-sent-manually; displayed". A zero Type is written as
+// displayed.
+func (d Disposition) String() string {
+	action, sending := "manual-action", "MDN-sent-manually"
+	if d.AutomaticAction {
+		action = "automatic-action"
+	}
+	if d.AutomaticSend {
+		sending = "MDN-sent-automatically"
+	}
+	typ := d.Type
+	if typ == "" {
+		typ = DispositionDisplayed
+	}
+	s := action + "/" + sending + "; " + string(typ)
+	if len(d.Modifiers) > 0 {
+		s += "/" + strings.Join(d.Modifiers, ",")
+	}
+	return s
+}
+
+// DispositionNotification represents a received read receipt: a Message
+// Disposition Notification (RFC 8098).
+type DispositionNotification struct {
+	Disposition Disposition `json:"disposition"`
+	// EmailAddress is the recipient the notification is about, from its
+	// Final-Recipient field.
+	EmailAddress string `json:"email_address"`
+	// OriginalRecipient is the address the message was sent to, when the
+	// recipient's server recorded it and it differs from EmailAddress, as
+	// it does after forwarding.
+	OriginalRecipient string    `json:"original_recipient,omitempty"`
+	OriginalMsgID     string    `json:"original_msg_id"`
+	ReportingUA       string    `json:"reporting_ua,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// ErrNoReadReceiptRequested is returned by ReadReceipt for a message that
+// does not ask for one, or that may not be answered with one.
+var ErrNoReadReceiptRequested = errors.New("gsmail: message does not request a read receipt")
+
+// RequestReadReceipt asks the recipient's mail client to send a read receipt
+// to addr, or to From when addr is empty, by setting
+// Disposition-Notification-To.
+//
+// A receipt is a request, not a guarantee: clients ask the user first, many
+// never send one, and a receipt that does arrive means the message was
+// displayed, not that it was read. ParseDispositionNotification reads the
+// ones that come back.
+func (e *Email) RequestReadReceipt(addr string) {
+	if strings.TrimSpace(addr) == "" {
+		addr = e.From
+	}
+	e.SetHeader("Disposition-Notification-To", FormatAddress(addr))
+}
+
+// ReadReceipt returns the Message Disposition Notification that reports d
+// for e, sent from from -- the recipient of e -- to the address e's
+// Disposition-Notification-To names. The result is a multipart/report with a
+// sentence for people, the message/disposition-notification part for
+// software and, when e was parsed with ParseOptions.RetainRaw, e's header
+// fields; Raw holds it rendered, so it is sent as built.
+//
+// A message that does not ask for a receipt gets ErrNoReadReceiptRequested,
+// and so does a receipt itself: RFC 8098 forbids answering one, which stops
+// two clients acknowledging each other forever. Deciding whether to send one
+// at all is the caller's; RFC 8098 expects the user to be asked, especially
+// when the address differs from the Return-Path.
+func (e Email) ReadReceipt(from string, d Disposition) (Email, error) {
+	to := usableAddresses(FormatMailboxes([]string{e.Header("Disposition-Notification-To")}))
+	if len(to) == 0 || isDispositionNotification(e) {
+		return Email{}, NonRetryable(ErrNoReadReceiptRequested)
+	}
+	if d.Type == "" {
+		d.Type = DispositionDisplayed
+	}
+	verb, prefix, ok := dispositionWording(d.Type)
+	if !ok {
+		return Email{}, NonRetryable(fmt.Errorf("gsmail: unknown disposition type %q", d.Type))
+	}
+
+	recipient, err := ParseAddress(from)
+	if err == nil && recipient.IsGroup() {
+		err = ErrInvalidEmailFormat
+	}
+	if err != nil {
+		return Email{}, NonRetryable(fmt.Errorf("gsmail: read receipt from %q: %w", from, err))
+	}
+
+	r := Email{
+		From:    from,
+		To:      to,
+		Subject: prefixSubject(prefix, e.Subject, strings.ToLower(prefix)),
+	}
+	e.thread(&r, false)
+	// RFC 3834: an automatic responder must not answer this in turn.
+	r.SetHeader("Auto-Submitted", "auto-replied")
+
+	var text strings.Builder
+	text.WriteString("This is a receipt for the message you sent to " + recipient.Address)
+	if date := e.Header("Date"); date != "" {
+		text.WriteString(" on " + date)
+	}
+	if subject := strings.TrimSpace(e.Subject); subject != "" {
+		text.WriteString(` with the subject "` + subject + `"`)
+	}
+	text.WriteString(".\r\n\r\nThe message " + verb + ".")
+	if d.Type == DispositionDisplayed {
+		text.WriteString(" This is no guarantee that it was read or understood.")
+	}
+	text.WriteString("\r\n")
+	r.Body = []byte(text.String())
+
+	// The report fields name addresses as RFC 5321 would: ASCII, unless
+	// the local part has no ASCII form, which only RFC 6533's global
+	// report can carry.
+	notificationType, addrType := "message/disposition-notification", "rfc822"
+	final, err := ASCIIAddress(recipient.Address)
+	if errors.Is(err, ErrSMTPUTF8Required) {
+		notificationType, addrType, final = "message/global-disposition-notification", "utf-8", recipient.Address
+	} else if err != nil {
+		return Email{}, NonRetryable(fmt.Errorf("gsmail: read receipt from %q: %w", from, err))
+	}
+
+	var fields bytes.Buffer
+	if original := strings.TrimSpace(e.Header("Original-Recipient")); original != "" && !strings.ContainsAny(original, "\r\n") {
+		fields.WriteString("Original-Recipient: " + original + "\r\n")
+	}
+	fields.WriteString("Final-Recipient: " + addrType + ";" + final + "\r\n")
+	if id := e.MessageID(); id != "" {
+		fields.WriteString("Original-Message-ID: <" + id + ">\r\n")
+	}
+	fields.WriteString("Disposition: " + d.String() + "\r\n")
+	r.Attachments = []Attachment{{
+		Filename:    "MDNPart2.txt",
+		ContentType: notificationType,
+		Data:        fields.Bytes(),
+	}}
+	if len(e.Raw) > 0 {
+		header, _ := mimeentity.Split(e.Raw)
+		r.Attachments = append(r.Attachments, Attachment{
+			Filename:    "MDNPart3.txt",
+			ContentType: "text/rfc822-headers",
+			Data:        mimeentity.Canonicalize(header),
+		})
+	}
+
+	// The typed fields describe the report for reading; the report itself
+	// is a multipart/report, which the renderer does not write, so it is
+	// assembled around the header fields and text part it does.
+	rendered, err := RenderMessage(Email{From: r.From, To: r.To, Subject: r.Subject, Headers: r.Headers, Body: r.Body})
+	if err != nil {
+		return Email{}, fmt.Errorf("read receipt: %w", err)
+	}
+	outer, entity := mimeentity.Extract(rendered)
+	boundary, err := mimeentity.Boundary("mdn-")
+	if err != nil {
+		return Email{}, err
+	}
+	parts := [][]byte{mimeentity.TrimTrailingLineBreaks(entity)}
+	for _, a := range r.Attachments {
+		parts = append(parts, []byte("Content-Type: "+a.ContentType+"\r\n\r\n"+string(mimeentity.TrimTrailingLineBreaks(a.Data))))
+	}
+
+	var msg bytes.Buffer
+	msg.Write(outer)
+	fmt.Fprintf(&msg, "Content-Type: multipart/report; report-type=disposition-notification; boundary=\"%s\"\r\n\r\n", boundary)
+	msg.Write(mimeentity.Multipart(boundary, parts...))
+	r.Raw = msg.Bytes()
+	return r, nil
+}
+
+// dispositionWording is how a receipt describes t to people, and the prefix
+// of its subject.
+func dispositionWording(t DispositionType) (verb, subjectPrefix string, ok bool) {
+	switch t {
+	case DispositionDisplayed:
+		return "was displayed", "Read:", true
+	case DispositionDeleted:
+		return "was deleted without being displayed", "Deleted:", true
+	case DispositionDispatched:
+		return "was printed, faxed or forwarded without being displayed", "Dispatched:", true
+	case DispositionProcessed:
+		return "was processed without being displayed", "Processed:", true
+	}
+	return "", "", false
+}
+
+// ParseDispositionNotification attempts to extract read receipt information
+// from an email. It looks for a "message/disposition-notification" part
+// according to RFC 8098, or the "message/global-disposition-notification"
+// of RFC 6533.
+//
+// OriginalMsgID is the Original-Message-ID the receipt reports or, when it
+// has none, the Message-ID of the returned header fields, with the angle
+// brackets as they were. Timestamp is the receipt's Date.
+func ParseDispositionNotification(email Email) (*DispositionNotification, error) {
+	for _, att := range email.Attachments {
+		if isDispositionNotificationType(att.ContentType) {
+			return parseMDN(att.Data, email)
+		}
+	}
+	return nil, fmt.Errorf("no disposition-notification part found")
+}
+
+func parseMDN(data []byte, email Email) (*DispositionNotification, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	headers, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read MDN fields: %w", err)
+	}
+	disposition := headers.Get("Disposition")
+	if disposition == "" {
+		return nil, fmt.Errorf("invalid MDN format: missing Disposition field")
+	}
+
+	n := &DispositionNotification{
+		Disposition:       parseDisposition(disposition),
+		EmailAddress:      reportedAddress(headers.Get("Final-Recipient")),
+		OriginalRecipient: reportedAddress(headers.Get("Original-Recipient")),
+		OriginalMsgID:     strings.TrimSpace(headers.Get("Original-Message-ID")),
+		ReportingUA:       strings.TrimSpace(headers.Get("Reporting-UA")),
+		Timestamp:         time.Now(),
+	}
+	if n.OriginalMsgID == "" {
+		n.OriginalMsgID = findOriginalMsgID(email)
+	}
+	if date, err := mail.ParseDate(email.Header("Date")); err == nil {
+		n.Timestamp = date
+	}
+	return n, nil
+}
+
+// parseDisposition reads a Disposition field value:
+//
+//	action-mode "/" sending-mode ";" disposition-type [ "/" modifier *( "," modifier ) ]
+func parseDisposition(v string) Disposition {
+	modes, typ, ok := strings.Cut(v, ";")
+	if !ok {
+		modes, typ = "", modes
+	}
+	action, sending, _ := strings.Cut(modes, "/")
+	d := Disposition{
+		AutomaticAction: strings.EqualFold(strings.TrimSpace(action), "automatic-action"),
+		AutomaticSend:   strings.EqualFold(strings.TrimSpace(sending), "MDN-sent-automatically"),
+	}
+	typ, modifiers, _ := strings.Cut(typ, "/")
+	d.Type = DispositionType(strings.ToLower(strings.TrimSpace(typ)))
+	for _, m := range strings.Split(modifiers, ",") {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			d.Modifiers = append(d.Modifiers, m)
+		}
+	}
+	return d
+}
+
+// reportedAddress strips the address type from a report field such as
+// "rfc822;user@example.com".
+func reportedAddress(v string) string {
+	if _, addr, ok := strings.Cut(v, ";"); ok {
+		return strings.TrimSpace(addr)
+	}
+	return strings.TrimSpace(v)
+}
+
+func isDispositionNotification(email Email) bool {
+	for _, att := range email.Attachments {
+		if isDispositionNotificationType(att.ContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDispositionNotificationType(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return strings.Contains(ct, "message/disposition-notification") ||
+		strings.Contains(ct, "message/global-disposition-notification")
+}
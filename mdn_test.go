@@ -0,0 +1,161 @@
+package gsmail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A receipt as Thunderbird sends it, abridged.
+const thunderbirdMDN = `From: Bea <bea@example.net>
+To: legal@example.com
+Subject: Return Receipt (displayed) - Notice of termination
+Date: Tue, 13 Oct 2026 09:30:00 +0200
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=disposition-notification; boundary="mdn"
+
+--mdn
+Content-Type: text/plain; charset=UTF-8
+
+This is a Return Receipt for the mail that you sent to bea@example.net.
+
+--mdn
+Content-Type: message/disposition-notification; name="MDNPart2.txt"
+Content-Disposition: inline
+
+Reporting-UA: example.net; Thunderbird 128.3.0
+Final-Recipient: rfc822;bea@example.net
+Original-Message-ID: <notice-17@example.com>
+Disposition: manual-action/MDN-sent-manually; displayed
+
+--mdn
+Content-Type: text/rfc822-headers; name="MDNPart3.txt"
+Content-Disposition: inline
+
+Message-ID: <notice-17@example.com>
+Subject: Notice of termination
+
+--mdn--
+`
+
+func TestParseDispositionNotification(t *testing.T) {
+	email, err := ParseRawEmail([]byte(thunderbirdMDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := ParseDispositionNotification(email)
+	if err != nil {
+		t.Fatalf("ParseDispositionNotification: %v", err)
+	}
+	if n.EmailAddress != "bea@example.net" || n.OriginalMsgID != "<notice-17@example.com>" {
+		t.Errorf("recipient %q, original %q", n.EmailAddress, n.OriginalMsgID)
+	}
+	if n.Disposition.Type != DispositionDisplayed || n.Disposition.AutomaticAction || n.Disposition.AutomaticSend {
+		t.Errorf("disposition %+v", n.Disposition)
+	}
+	if n.ReportingUA != "example.net; Thunderbird 128.3.0" {
+		t.Errorf("Reporting-UA %q", n.ReportingUA)
+	}
+	if !n.Timestamp.Equal(time.Date(2026, 10, 13, 7, 30, 0, 0, time.UTC)) {
+		t.Errorf("Timestamp %v", n.Timestamp)
+	}
+
+	if _, err := ParseDispositionNotification(Email{Body: []byte("hello")}); err == nil {
+		t.Error("a message without a notification part should be an error")
+	}
+}
+
+func TestParseDisposition(t *testing.T) {
+	d := parseDisposition("automatic-action/MDN-sent-automatically; Deleted/error")
+	if !d.AutomaticAction || !d.AutomaticSend || d.Type != DispositionDeleted || len(d.Modifiers) != 1 || d.Modifiers[0] != "error" {
+		t.Errorf("parsed %+v", d)
+	}
+	if got := d.String(); got != "automatic-action/MDN-sent-automatically; deleted/error" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Disposition{}).String(); got != "manual-action/MDN-sent-manually; displayed" {
+		t.Errorf("zero Disposition = %q", got)
+	}
+}
+
+func TestReadReceiptRoundTrip(t *testing.T) {
+	notice := Email{
+		From:    "Legal <legal@example.com>",
+		To:      []string{"bea@example.net"},
+		Subject: "Notice of termination",
+		Body:    []byte("Please confirm receipt."),
+	}
+	notice.RequestReadReceipt("")
+
+	raw, err := RenderMessage(notice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "Disposition-Notification-To: \"Legal\" <legal@example.com>\r\n") {
+		t.Fatalf("request missing from:\n%s", raw)
+	}
+
+	received, err := ParseRawEmailWithOptions(raw, ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := received.ReadReceipt("Bea <bea@example.net>", Disposition{})
+	if err != nil {
+		t.Fatalf("ReadReceipt: %v", err)
+	}
+	if len(receipt.To) != 1 || receipt.To[0] != `"Legal" <legal@example.com>` {
+		t.Errorf("receipt To = %q", receipt.To)
+	}
+	if receipt.Subject != "Read: Notice of termination" {
+		t.Errorf("receipt Subject = %q", receipt.Subject)
+	}
+	for _, want := range []string{
+		"Content-Type: multipart/report; report-type=disposition-notification;",
+		"Auto-Submitted: auto-replied\r\n",
+		"Content-Type: message/disposition-notification\r\n",
+		"Disposition: manual-action/MDN-sent-manually; displayed\r\n",
+		"Content-Type: text/rfc822-headers\r\n",
+	} {
+		if !strings.Contains(string(receipt.Raw), want) {
+			t.Errorf("receipt lacks %q:\n%s", want, receipt.Raw)
+		}
+	}
+
+	// What the sender receives reads back as the receipt for their message.
+	back, err := ParseRawEmail(receipt.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := ParseDispositionNotification(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.EmailAddress != "bea@example.net" || n.OriginalMsgID != "<"+received.MessageID()+">" || n.Disposition.Type != DispositionDisplayed {
+		t.Errorf("round trip %+v", n)
+	}
+	if !strings.Contains(string(back.Body), "Notice of termination") {
+		t.Errorf("human-readable part %q", back.Body)
+	}
+
+	// A receipt is never answered with another.
+	back.SetHeader("Disposition-Notification-To", "bea@example.net")
+	if _, err := back.ReadReceipt("legal@example.com", Disposition{}); !errors.Is(err, ErrNoReadReceiptRequested) {
+		t.Errorf("answering a receipt: %v", err)
+	}
+}
+
+func TestReadReceiptRefusals(t *testing.T) {
+	plain := Email{From: "a@example.com", Subject: "hi"}
+	if _, err := plain.ReadReceipt("b@example.com", Disposition{}); !errors.Is(err, ErrNoReadReceiptRequested) || !errors.Is(err, ErrNonRetryable) {
+		t.Errorf("no request: %v", err)
+	}
+
+	plain.RequestReadReceipt("a@example.com")
+	if _, err := plain.ReadReceipt("b@example.com", Disposition{Type: "opened"}); err == nil {
+		t.Error("an unknown disposition type should be refused")
+	}
+	if _, err := plain.ReadReceipt("Team: b@example.com;", Disposition{}); err == nil {
+		t.Error("a group cannot be the recipient reporting")
+	}
+}
@@ -114,31 +114,56 @@ func (r *interceptedReceiver) Idle(ctx context.Context) (<-chan Email, <-chan er
 //
 // It deliberately records no personal data: recipient addresses and the
 // subject line are omitted, because logs are usually retained far longer than
-// the message itself. Use VerboseLoggerInterceptor if you accept that.
+// the message itself. It is RedactingLoggerInterceptor with the zero Redactor;
+// use that to record hashed addresses or a masked subject instead, or
+// VerboseLoggerInterceptor if you accept the personal data itself.
 func LoggerInterceptor(logFn func(string, ...any)) SendInterceptor {
-	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
-		start := time.Now()
-		err := next(ctx, email)
-		logFn("gsmail: send recipients=%d attachments=%d bytes=%d duration=%v err=%v",
-			len(email.To)+len(email.Cc)+len(email.Bcc),
-			len(email.Attachments),
-			len(email.Body)+len(email.HTMLBody),
-			time.Since(start), err)
-		return err
-	}
+	return RedactingLoggerInterceptor(logFn, Redactor{})
 }
 
 // VerboseLoggerInterceptor returns a logging interceptor that includes the
-// sender, recipients and subject.
+// sender, recipients and subject, as VerboseRedactor renders them. Bcc
+// addresses are still left out.
 //
 // Those fields are personal data. Only use this where your log retention and
 // access controls allow it.
 func VerboseLoggerInterceptor(logFn func(string, ...any)) SendInterceptor {
+	return RedactingLoggerInterceptor(logFn, VerboseRedactor())
+}
+
+// RedactingLoggerInterceptor returns a logging interceptor that records each
+// send's counts, sizes, duration and error, and whatever r lets through of the
+// addresses, subject and body.
+func RedactingLoggerInterceptor(logFn func(string, ...any), r Redactor) SendInterceptor {
 	return func(ctx context.Context, email Email, next func(ctx context.Context, email Email) error) error {
 		start := time.Now()
 		err := next(ctx, email)
-		logFn("gsmail: send from=%s to=%v subject=%q duration=%v err=%v",
-			email.From, email.To, email.Subject, time.Since(start), err)
+		red := r.Redact(email)
+
+		format := "gsmail: send recipients=%d attachments=%d bytes=%d"
+		args := []any{red.Recipients, red.Attachments, red.BodyBytes}
+		if red.From != "" {
+			format += " from=%s"
+			args = append(args, red.From)
+		}
+		for _, f := range []struct {
+			name  string
+			addrs []string
+		}{{"to", red.To}, {"cc", red.Cc}, {"bcc", red.Bcc}} {
+			if len(f.addrs) > 0 {
+				format += " " + f.name + "=%v"
+				args = append(args, f.addrs)
+			}
+		}
+		if red.Subject != "" {
+			format += " subject=%q"
+			args = append(args, red.Subject)
+		}
+		if red.Body != "" {
+			format += " body=%q"
+			args = append(args, red.Body)
+		}
+		logFn(format+" duration=%v err=%v", append(args, time.Since(start), err)...)
 		return err
 	}
 }
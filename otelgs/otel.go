@@ -19,34 +19,56 @@ const (
 // It deliberately records no personal data: the sender, the recipient
 // addresses and the subject line are omitted, because traces are usually
 // retained far longer than the message itself. This mirrors
-// gsmail.LoggerInterceptor. Use VerboseSendInterceptor if you accept that.
+// gsmail.LoggerInterceptor. Use RedactingSendInterceptor to record them
+// hashed or masked, or VerboseSendInterceptor if you accept them as they are.
 func SendInterceptor() gsmail.SendInterceptor {
-	tracer := otel.Tracer(instrumentationName)
-	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
-		ctx, span := tracer.Start(ctx, "gsmail.Send", trace.WithAttributes(
-			attribute.Int("email.recipients", len(email.To)+len(email.Cc)+len(email.Bcc)),
-			attribute.Int("email.attachments", len(email.Attachments)),
-			attribute.Int("email.body_bytes", len(email.Body)+len(email.HTMLBody)),
-		))
-		defer span.End()
-
-		return record(span, next(ctx, email))
-	}
+	return RedactingSendInterceptor(gsmail.Redactor{})
 }
 
 // VerboseSendInterceptor behaves like SendInterceptor but also records the
-// sender, recipients and subject.
+// sender, recipients and subject, as gsmail.VerboseRedactor renders them. Bcc
+// addresses are still left out.
 //
 // Those fields are personal data. Only use this where your trace retention and
 // access controls allow it.
 func VerboseSendInterceptor() gsmail.SendInterceptor {
+	return RedactingSendInterceptor(gsmail.VerboseRedactor())
+}
+
+// RedactingSendInterceptor behaves like SendInterceptor but also records
+// whatever r lets through of the addresses, subject and body, in the
+// attributes email.from, email.to, email.cc, email.bcc, email.subject and
+// email.body. An attribute r omits is not set. The same Redactor given to
+// gsmail.RedactingLoggerInterceptor renders the same values, so a hashed
+// address can be followed from the logs to the traces.
+func RedactingSendInterceptor(r gsmail.Redactor) gsmail.SendInterceptor {
 	tracer := otel.Tracer(instrumentationName)
 	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
-		ctx, span := tracer.Start(ctx, "gsmail.Send", trace.WithAttributes(
-			attribute.String("email.from", email.From),
-			attribute.StringSlice("email.to", email.To),
-			attribute.String("email.subject", email.Subject),
-		))
+		red := r.Redact(email)
+		attrs := []attribute.KeyValue{
+			attribute.Int("email.recipients", red.Recipients),
+			attribute.Int("email.attachments", red.Attachments),
+			attribute.Int("email.body_bytes", red.BodyBytes),
+		}
+		if red.From != "" {
+			attrs = append(attrs, attribute.String("email.from", red.From))
+		}
+		for _, f := range []struct {
+			key   string
+			addrs []string
+		}{{"email.to", red.To}, {"email.cc", red.Cc}, {"email.bcc", red.Bcc}} {
+			if len(f.addrs) > 0 {
+				attrs = append(attrs, attribute.StringSlice(f.key, f.addrs))
+			}
+		}
+		if red.Subject != "" {
+			attrs = append(attrs, attribute.String("email.subject", red.Subject))
+		}
+		if red.Body != "" {
+			attrs = append(attrs, attribute.String("email.body", red.Body))
+		}
+
+		ctx, span := tracer.Start(ctx, "gsmail.Send", trace.WithAttributes(attrs...))
 		defer span.End()
 
 		return record(span, next(ctx, email))
@@ -120,6 +120,46 @@ func TestVerboseSendInterceptorRecordsPersonalData(t *testing.T) {
 	if !strings.Contains(attrs["email.to"], "bob.recipient@example.com") {
 		t.Errorf("email.to = %q", attrs["email.to"])
 	}
+	// Blind copies stay blind even here; only their number is recorded.
+	if v, present := attrs["email.bcc"]; present {
+		t.Errorf("email.bcc = %q, want it absent", v)
+	}
+	if attrs["email.recipients"] != "4" {
+		t.Errorf("email.recipients = %q, want 4", attrs["email.recipients"])
+	}
+}
+
+// RedactingSendInterceptor records what its Redactor lets through, and the
+// same values gsmail.RedactingLoggerInterceptor logs, so the two can be joined.
+func TestRedactingSendInterceptor(t *testing.T) {
+	sr := recorder(t)
+
+	r := gsmail.Redactor{
+		Addresses: gsmail.AddressHashLocalPart,
+		Subject:   gsmail.SubjectMask,
+		Key:       []byte("a secret key of reasonable length"),
+	}
+	wrapped := gsmail.WrapSender(&stubSender{}, RedactingSendInterceptor(r))
+	if err := wrapped.Send(context.Background(), personalEmail()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	attrs := attrsOf(sr.Ended()[0])
+	if want := r.RedactAddress("alice.sender@example.com"); attrs["email.from"] != want {
+		t.Errorf("email.from = %q, want %q", attrs["email.from"], want)
+	}
+	if !strings.HasSuffix(attrs["email.from"], "@example.com") || strings.Contains(attrs["email.from"], "alice") {
+		t.Errorf("email.from = %q, want a hashed local part", attrs["email.from"])
+	}
+	if attrs["email.subject"] != "**** ******* *** *****" {
+		t.Errorf("email.subject = %q", attrs["email.subject"])
+	}
+	if _, present := attrs["email.body"]; present {
+		t.Error("email.body should not be set when the Redactor omits bodies")
+	}
+	if attrs["email.recipients"] != "4" {
+		t.Errorf("email.recipients = %q, want 4", attrs["email.recipients"])
+	}
 }
 
 // A failed send must mark the span itself as errored, not merely attach an
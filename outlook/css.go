@@ -0,0 +1,481 @@
+package outlook
+
+import "strings"
+
+// cssSelector is one selector of a rule's list, as far as InlineCSS can
+// match it.
+type cssSelector struct {
+	text  string // as written
+	ok    bool   // InlineCSS can match it
+	parts []cssCompound
+	spec  [3]int // ids; classes, attributes and pseudo-classes; types
+	used  bool   // it matched, and its rule was inlined there
+}
+
+// cssCompound is a run of simple selectors with no combinator between them,
+// such as "td.header[align]".
+type cssCompound struct {
+	// combinator relates the element to the one the compound before it
+	// matched: ' ' for a descendant, '>', '+' or '~'.
+	combinator byte
+	tag        string // "" for any
+	ids        []string
+	classes    []string
+	attrs      []cssAttrSelector
+	first      bool // :first-child
+	last       bool // :last-child
+}
+
+type cssAttrSelector struct {
+	name, op, value string
+	fold            bool // the " i" flag
+}
+
+// parseStylesheet splits css into its rules. Comments are dropped, and so are
+// the "<!--" and "-->" old templates wrap a stylesheet in.
+func parseStylesheet(css string) []cssRule {
+	css = stripCSSComments(css)
+	var rules []cssRule
+	for i := 0; i < len(css); {
+		for i < len(css) && isSpace(css[i]) {
+			i++
+		}
+		switch {
+		case i >= len(css):
+			return rules
+		case strings.HasPrefix(css[i:], "<!--"):
+			i += 4
+			continue
+		case strings.HasPrefix(css[i:], "-->"):
+			i += 3
+			continue
+		case css[i] == '@':
+			end := scanCSS(css, i, ";{")
+			if end < len(css) && css[end] == '{' {
+				end = matchBrace(css, end)
+			}
+			if end < len(css) {
+				end++
+			}
+			rules = append(rules, cssRule{atRule: strings.TrimSpace(css[i:end])})
+			i = end
+			continue
+		}
+
+		open := scanCSS(css, i, "{")
+		if open >= len(css) {
+			return rules
+		}
+		close := matchBrace(css, open)
+		rule := cssRule{body: strings.TrimSpace(css[open+1 : min(close, len(css))])}
+		for _, text := range splitCSS(css[i:open], ',') {
+			if text = strings.TrimSpace(text); text != "" {
+				rule.selectors = append(rule.selectors, parseSelector(text))
+			}
+		}
+		rule.decls = parseCSSDeclarations(rule.body)
+		rules = append(rules, rule)
+		i = close + 1
+	}
+	return rules
+}
+
+// parseCSSDeclarations reads a declaration block, such as the value of a
+// style attribute, skipping what is not a declaration.
+func parseCSSDeclarations(block string) []cssDecl {
+	var decls []cssDecl
+	for _, d := range splitCSS(block, ';') {
+		colon := strings.IndexByte(d, ':')
+		if colon < 0 {
+			continue
+		}
+		decl := cssDecl{
+			prop:  strings.ToLower(strings.TrimSpace(d[:colon])),
+			value: strings.TrimSpace(d[colon+1:]),
+		}
+		if bang := strings.LastIndexByte(decl.value, '!'); bang >= 0 &&
+			strings.EqualFold(strings.TrimSpace(decl.value[bang+1:]), "important") {
+			decl.value, decl.important = strings.TrimSpace(decl.value[:bang]), true
+		}
+		if decl.prop == "" || decl.value == "" || strings.ContainsAny(decl.prop, " {}") {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+func stripCSSComments(css string) string {
+	if !strings.Contains(css, "/*") {
+		return css
+	}
+	var b strings.Builder
+	for i := 0; i < len(css); {
+		start := scanCSSComment(css, i)
+		b.WriteString(css[i:start])
+		if start >= len(css) {
+			break
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			break
+		}
+		b.WriteByte(' ')
+		i = start + 2 + end + 2
+	}
+	return b.String()
+}
+
+// scanCSSComment returns the index of the next comment in css from i, outside
+// strings, or len(css).
+func scanCSSComment(css string, i int) int {
+	for ; i < len(css); i++ {
+		switch css[i] {
+		case '"', '\'':
+			i = skipCSSString(css, i)
+		case '/':
+			if i+1 < len(css) && css[i+1] == '*' {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// scanCSS returns the index of the first byte of stop in css from i that is
+// outside strings, parentheses and brackets, or len(css).
+func scanCSS(css string, i int, stop string) int {
+	depth := 0
+	for ; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case c == '"' || c == '\'':
+			i = skipCSSString(css, i)
+		case c == '\\':
+			i++
+		case c == '(' || c == '[':
+			depth++
+		case (c == ')' || c == ']') && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(stop, c) >= 0:
+			return i
+		}
+	}
+	return len(css)
+}
+
+// skipCSSString returns the index of the quote closing the string opening at
+// i, or the last index of css.
+func skipCSSString(css string, i int) int {
+	quote := css[i]
+	for i++; i < len(css); i++ {
+		switch css[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return len(css) - 1
+}
+
+// matchBrace returns the index of the brace closing the one at open, or
+// len(css).
+func matchBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '"', '\'':
+			i = skipCSSString(css, i)
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// splitCSS splits css at every sep outside strings, parentheses and brackets,
+// so a data: URL's ";base64," or an attribute selector's comma stays whole.
+func splitCSS(css string, sep byte) []string {
+	var parts []string
+	for {
+		i := scanCSS(css, 0, string(sep))
+		if i >= len(css) {
+			return append(parts, css)
+		}
+		parts = append(parts, css[:i])
+		css = css[i+1:]
+	}
+}
+
+// parseSelector parses one selector. The result is not ok when it uses
+// anything InlineCSS cannot match against the document as written.
+func parseSelector(text string) cssSelector {
+	sel := cssSelector{text: text}
+	combinator := byte(0)
+	for i := 0; i < len(text); {
+		spaced := false
+		for i < len(text) && isSpace(text[i]) {
+			i, spaced = i+1, true
+		}
+		if i >= len(text) {
+			break
+		}
+		if c := text[i]; c == '>' || c == '+' || c == '~' {
+			if len(sel.parts) == 0 || combinator > ' ' {
+				return sel
+			}
+			combinator = c
+			i++
+			continue
+		}
+		if spaced && combinator == 0 && len(sel.parts) > 0 {
+			combinator = ' '
+		}
+		if len(sel.parts) > 0 && combinator == 0 {
+			return sel
+		}
+		part, n, ok := parseCompound(text[i:], &sel.spec)
+		if !ok {
+			return sel
+		}
+		part.combinator = combinator
+		sel.parts = append(sel.parts, part)
+		combinator = 0
+		i += n
+	}
+	sel.ok = len(sel.parts) > 0 && combinator == 0
+	return sel
+}
+
+// parseCompound reads the compound selector at the start of s, adding to
+// spec, and returns its length.
+func parseCompound(s string, spec *[3]int) (cssCompound, int, bool) {
+	var c cssCompound
+	i := 0
+	if i < len(s) && s[i] == '*' {
+		i++
+	} else if n := cssIdentLen(s); n > 0 {
+		c.tag = strings.ToLower(s[:n])
+		spec[2]++
+		i = n
+	}
+	for i < len(s) && !isSpace(s[i]) && s[i] != '>' && s[i] != '+' && s[i] != '~' {
+		switch s[i] {
+		case '#', '.':
+			n := cssIdentLen(s[i+1:])
+			if n == 0 {
+				return c, 0, false
+			}
+			if s[i] == '#' {
+				c.ids = append(c.ids, s[i+1:i+1+n])
+				spec[0]++
+			} else {
+				c.classes = append(c.classes, s[i+1:i+1+n])
+				spec[1]++
+			}
+			i += 1 + n
+		case '[':
+			end := scanCSS(s, i+1, "]")
+			if end >= len(s) {
+				return c, 0, false
+			}
+			a, ok := parseAttrSelector(s[i+1 : end])
+			if !ok {
+				return c, 0, false
+			}
+			c.attrs = append(c.attrs, a)
+			spec[1]++
+			i = end + 1
+		case ':':
+			n := cssIdentLen(s[i+1:])
+			switch strings.ToLower(s[i+1 : i+1+n]) {
+			case "first-child":
+				c.first = true
+			case "last-child":
+				c.last = true
+			case "only-child":
+				c.first, c.last = true, true
+			default:
+				// Pseudo-elements, :hover, :not() and the rest.
+				return c, 0, false
+			}
+			spec[1]++
+			i += 1 + n
+		default:
+			return c, 0, false
+		}
+	}
+	return c, i, i > 0
+}
+
+func parseAttrSelector(s string) (cssAttrSelector, bool) {
+	s = strings.TrimSpace(s)
+	n := cssIdentLen(s)
+	if n == 0 {
+		return cssAttrSelector{}, false
+	}
+	a := cssAttrSelector{name: strings.ToLower(s[:n])}
+	rest := strings.TrimSpace(s[n:])
+	if rest == "" {
+		return a, true
+	}
+	for _, op := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(rest, op) {
+			a.op = op
+		}
+	}
+	if a.op == "" {
+		return a, false
+	}
+	rest = strings.TrimSpace(rest[len(a.op):])
+	if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+		end := skipCSSString(rest, 0)
+		if end == 0 || rest[end] != rest[0] || strings.Contains(rest[1:end], `\`) {
+			return a, false
+		}
+		a.value, rest = rest[1:end], rest[end+1:]
+	} else {
+		n := cssIdentLen(rest)
+		if n == 0 {
+			return a, false
+		}
+		a.value, rest = rest[:n], rest[n:]
+	}
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "":
+	case "i":
+		a.fold = true
+	default:
+		return a, false
+	}
+	return a, true
+}
+
+// cssIdentLen returns the length of the identifier s begins with, or 0. An
+// escape ends it early, which leaves the selector unmatched rather than
+// matched wrongly.
+func cssIdentLen(s string) int {
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if isLetter(c) || c == '_' || c == '-' || c >= 0x80 || (i > 0 && c >= '0' && c <= '9') {
+			i++
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// matchAll reports, for every element, whether the selector matches it.
+//
+// It works through the compounds left to right over the whole document,
+// rather than from each element up through its ancestors, because the latter
+// backtracks: "div div div p" tried against deeply nested markup revisits the
+// same ancestors once per way of choosing them. Parents and previous siblings
+// come first in document order, so one pass per compound is enough.
+func (s *cssSelector) matchAll(elems []cssElement) []bool {
+	var prev []bool
+	for k := range s.parts {
+		part := &s.parts[k]
+		cur := make([]bool, len(elems))
+		// reach[e]: prev holds for e or, along the combinator's axis,
+		// for an element before it.
+		reach := make([]bool, len(elems))
+		for e := range elems {
+			el := &elems[e]
+			if k > 0 {
+				related := el.parent
+				if part.combinator == '+' || part.combinator == '~' {
+					related = el.prev
+				}
+				ok := false
+				switch part.combinator {
+				case '>', '+':
+					ok = related >= 0 && prev[related]
+				default:
+					ok = related >= 0 && reach[related]
+					reach[e] = prev[e] || ok
+				}
+				if !ok {
+					continue
+				}
+			}
+			cur[e] = part.matches(el)
+		}
+		prev = cur
+	}
+	return prev
+}
+
+func (c *cssCompound) matches(e *cssElement) bool {
+	if c.tag != "" && c.tag != e.name {
+		return false
+	}
+	if (c.first && e.prev >= 0) || (c.last && !e.last) {
+		return false
+	}
+	for _, id := range c.ids {
+		if e.attrs["id"] != id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(e.attrs["class"])
+		for _, want := range c.classes {
+			found := false
+			for _, class := range classes {
+				if class == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := e.attrs[a.name]
+		if !ok || !a.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *cssAttrSelector) matches(value string) bool {
+	want := a.value
+	if a.fold {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == want
+	case "~=":
+		for _, word := range strings.Fields(value) {
+			if word == want {
+				return true
+			}
+		}
+		return false
+	case "|=":
+		return value == want || strings.HasPrefix(value, want+"-")
+	case "^=":
+		return want != "" && strings.HasPrefix(value, want)
+	case "$=":
+		return want != "" && strings.HasSuffix(value, want)
+	case "*=":
+		return want != "" && strings.Contains(value, want)
+	}
+	return false
+}
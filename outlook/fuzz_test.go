@@ -96,3 +96,20 @@ func assertStructureUnchanged(t *testing.T, benign, got string, inputs ...string
 		}
 	}
 }
+
+// InlineCSS moves stylesheet text into attributes, so what it writes there
+// must not open or close a tag.
+func FuzzInlineCSS(f *testing.F) {
+	f.Add(`<style>p { color: red }</style><p>a</p>`, `p`)
+	f.Add(`<style>a { content: "</style><script>" }</style><a style=x>b</a>`, `a`)
+	f.Add(`<style>@media (x) { p { a: b } }</style><ul><li>1<li>2</ul>`, `li + li`)
+
+	f.Fuzz(func(t *testing.T, doc, selector string) {
+		src := doc + `<style>` + selector + ` { color: red }</style><div class="a"><p id="b">x</p></div>`
+		got := InlineCSS([]byte(src))
+		if want, have := strings.Count(src, "<"), strings.Count(string(got), "<"); have > want {
+			t.Fatalf("inlining added markup: %d '<' in the input, %d in the output\n%s", want, have, got)
+		}
+		_ = InlineCSS(got)
+	})
+}
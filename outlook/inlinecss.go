@@ -0,0 +1,590 @@
+package outlook
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InlineCSS copies the rules of the document's <style> blocks into the style
+// attribute of every element they match. Gmail drops a <style> block in some
+// of its clients, Outlook.com rewrites its selectors and Word, which renders
+// Outlook on Windows, ignores most of what it keeps, so a template designed
+// with a stylesheet falls apart unless someone inlines it by hand.
+//
+// Rules are applied in cascade order: of two setting the same property the
+// more specific selector wins, and the later one between equals. A style
+// attribute already on an element beats every rule, except one marked
+// !important. The marker is dropped as a rule is inlined, so that a media
+// query left in the stylesheet can still override the result, which is what
+// responsive templates rely on.
+//
+// What a style attribute cannot express stays in the <style> block: at-rules
+// such as @media and @font-face, and selectors using a pseudo-element or a
+// pseudo-class other than :first-child, :last-child and :only-child. So do
+// selectors that match nothing: the markup Apple Mail's
+// a[x-apple-data-detectors] or Outlook.com's .ExternalClass target is added
+// by the client, and is never in the document to match. A block left empty is
+// removed. A <style> element carrying data-embed or a media attribute is left
+// alone, as is anything inside a comment, Outlook's conditional comments
+// included.
+//
+// The rest of the document is unchanged byte for byte, and the argument is
+// returned as it is when there is nothing to inline.
+func InlineCSS(html []byte) []byte {
+	if !containsFold(html, []byte("<style")) {
+		return html
+	}
+	doc := parseCSSDocument(html)
+	if len(doc.blocks) == 0 {
+		return html
+	}
+
+	var applied [][]cssApplied // by element
+	order := 0
+	for b := range doc.blocks {
+		block := &doc.blocks[b]
+		for r := range block.rules {
+			rule := &block.rules[r]
+			if rule.atRule != "" {
+				continue
+			}
+			for s, sel := range rule.selectors {
+				if !sel.ok {
+					continue
+				}
+				for e, matched := range sel.matchAll(doc.elems) {
+					if !matched || doc.elems[e].skip {
+						continue
+					}
+					if applied == nil {
+						applied = make([][]cssApplied, len(doc.elems))
+					}
+					rule.selectors[s].used = true
+					block.changed = true
+					for d, decl := range rule.decls {
+						applied[e] = append(applied[e], cssApplied{decl: decl, spec: sel.spec, order: order + d})
+					}
+				}
+			}
+			order += len(rule.decls)
+		}
+	}
+	if applied == nil {
+		return html
+	}
+
+	var edits []cssEdit
+	for e, decls := range applied {
+		if len(decls) > 0 {
+			edits = append(edits, doc.elems[e].styleEdit(html, decls))
+		}
+	}
+	for _, block := range doc.blocks {
+		if block.changed {
+			edits = append(edits, block.edit())
+		}
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	out := make([]byte, 0, len(html)+len(html)/2)
+	written := 0
+	for _, ed := range edits {
+		out = append(out, html[written:ed.start]...)
+		out = append(out, ed.text...)
+		written = ed.end
+	}
+	return append(out, html[written:]...)
+}
+
+// ToOutlookHTMLWithOptions is ToOutlookHTML with the optional steps opts
+// enables run first. A document already converted is returned as it is.
+func ToOutlookHTMLWithOptions(html []byte, opts Options) []byte {
+	if AlreadyConverted(html) {
+		return html
+	}
+	if opts.InlineCSS {
+		// Before the conversion, which adds a stylesheet of its own whose
+		// rules target clients rather than the document.
+		html = InlineCSS(html)
+	}
+	return ToOutlookHTML(html)
+}
+
+// Options selects the optional steps of ToOutlookHTMLWithOptions.
+type Options struct {
+	// InlineCSS applies InlineCSS to the document.
+	InlineCSS bool
+}
+
+// cssEdit replaces src[start:end] with text.
+type cssEdit struct {
+	start, end int
+	text       string
+}
+
+// cssDocument is the little of an HTML document the inliner needs: its
+// elements, in order, and the stylesheets it can rewrite.
+type cssDocument struct {
+	elems  []cssElement
+	blocks []styleBlock
+}
+
+type cssElement struct {
+	name   string
+	attrs  map[string]string // unescaped; the first of a repeated attribute counts
+	parent int               // -1 at the top
+	prev   int               // previous element sibling, or -1
+	last   bool              // no element sibling follows
+	skip   bool              // never rendered, so never styled
+
+	// Where to write the style attribute: the raw value of the existing one,
+	// or, without one, the point in the start tag to insert it at.
+	styleStart, styleEnd int
+	style                cssAttrForm
+}
+
+// cssAttrForm is how a style attribute is written in the start tag.
+type cssAttrForm int
+
+const (
+	styleMissing  cssAttrForm = iota
+	styleQuoted               // style="..." or style='...'
+	styleUnquoted             // style=...
+	styleBare                 // style
+)
+
+// styleBlock is a <style> element the inliner may rewrite.
+type styleBlock struct {
+	start, end               int // the whole element
+	contentStart, contentEnd int
+	rules                    []cssRule
+	changed                  bool
+}
+
+type cssRule struct {
+	atRule    string // an at-rule, kept as written
+	selectors []cssSelector
+	body      string // the declarations as written
+	decls     []cssDecl
+}
+
+type cssDecl struct {
+	prop, value string
+	important   bool
+}
+
+// cssApplied is a declaration as it applies to one element.
+type cssApplied struct {
+	decl   cssDecl
+	spec   [3]int
+	order  int
+	inline bool // from the element's own style attribute
+}
+
+// precedes reports whether a loses to b in the cascade.
+func (a cssApplied) precedes(b cssApplied) bool {
+	if a.decl.important != b.decl.important {
+		return b.decl.important
+	}
+	if a.inline != b.inline {
+		return b.inline
+	}
+	if a.spec != b.spec {
+		for i := range a.spec {
+			if a.spec[i] != b.spec[i] {
+				return a.spec[i] < b.spec[i]
+			}
+		}
+	}
+	return a.order < b.order
+}
+
+// styleEdit merges decls with the element's own style attribute and returns
+// the edit that writes the result.
+func (e *cssElement) styleEdit(src []byte, decls []cssApplied) cssEdit {
+	if e.style != styleMissing {
+		for i, d := range parseCSSDeclarations(e.attrs["style"]) {
+			decls = append(decls, cssApplied{decl: d, order: i, inline: true})
+		}
+	}
+	winners := make(map[string]cssApplied, len(decls))
+	for _, d := range decls {
+		if w, ok := winners[d.decl.prop]; !ok || w.precedes(d) {
+			winners[d.decl.prop] = d
+		}
+	}
+	merged := make([]cssApplied, 0, len(winners))
+	for _, d := range winners {
+		merged = append(merged, d)
+	}
+	// Lowest precedence first, so a shorthand that lost to a longhand is
+	// written before it and cannot undo it.
+	sort.Slice(merged, func(i, j int) bool { return merged[i].precedes(merged[j]) })
+
+	var style strings.Builder
+	for i, d := range merged {
+		if i > 0 {
+			style.WriteString("; ")
+		}
+		style.WriteString(d.decl.prop + ": " + d.decl.value)
+		if d.inline && d.decl.important {
+			style.WriteString(" !important")
+		}
+	}
+	value := style.String()
+	quote := byte('"')
+	if e.style == styleQuoted {
+		quote = src[e.styleStart-1]
+	}
+	// Swapping the quotes of the CSS strings, which is safe when there are
+	// none of the other kind, saves writing font names as &quot;Segoe UI&quot;.
+	other := "'"
+	if quote == '\'' {
+		other = `"`
+	}
+	if !strings.Contains(value, other) {
+		value = strings.ReplaceAll(value, string(quote), other)
+	}
+	value = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", string(quote), "&#"+strconv.Itoa(int(quote))+";").Replace(value)
+
+	switch e.style {
+	case styleQuoted:
+		return cssEdit{start: e.styleStart, end: e.styleEnd, text: value}
+	case styleUnquoted:
+		return cssEdit{start: e.styleStart, end: e.styleEnd, text: `"` + value + `"`}
+	case styleBare:
+		return cssEdit{start: e.styleStart, end: e.styleEnd, text: `="` + value + `"`}
+	}
+	return cssEdit{start: e.styleStart, end: e.styleStart, text: ` style="` + value + `"`}
+}
+
+// edit rewrites the block to hold only the rules that were not inlined, or
+// removes it when none are left.
+func (b *styleBlock) edit() cssEdit {
+	var kept []string
+	for _, rule := range b.rules {
+		if rule.atRule != "" {
+			kept = append(kept, rule.atRule)
+			continue
+		}
+		var selectors []string
+		for _, sel := range rule.selectors {
+			if !sel.used {
+				selectors = append(selectors, sel.text)
+			}
+		}
+		if len(selectors) > 0 {
+			kept = append(kept, strings.Join(selectors, ", ")+" { "+rule.body+" }")
+		}
+	}
+	if len(kept) == 0 {
+		return cssEdit{start: b.start, end: b.end}
+	}
+	return cssEdit{start: b.contentStart, end: b.contentEnd, text: "\n" + strings.Join(kept, "\n") + "\n"}
+}
+
+// htmlImpliedEnds lists, for a start tag, the open elements it closes when
+// they are the current one, as an HTML parser would: "<li>a<li>b" is two
+// siblings, not one item inside another.
+var htmlImpliedEnds = map[string][]string{
+	"li":     {"li"},
+	"dt":     {"dt", "dd"},
+	"dd":     {"dt", "dd"},
+	"option": {"option"},
+	"td":     {"td", "th"},
+	"th":     {"td", "th"},
+	"tr":     {"td", "th", "tr"},
+	"thead":  {"td", "th", "tr", "thead", "tbody", "tfoot"},
+	"tbody":  {"td", "th", "tr", "thead", "tbody", "tfoot"},
+	"tfoot":  {"td", "th", "tr", "thead", "tbody", "tfoot"},
+}
+
+// htmlClosesParagraph lists the start tags that end an open <p>.
+var htmlClosesParagraph = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true,
+	"dl": true, "fieldset": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "ul": true,
+}
+
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+// htmlRawText lists the elements whose content is text up to their end tag.
+var htmlRawText = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// htmlUnstyled lists the elements that are never rendered.
+var htmlUnstyled = map[string]bool{
+	"head": true, "meta": true, "title": true, "style": true, "script": true,
+	"link": true, "base": true,
+}
+
+// parseCSSDocument builds the element tree of src and parses its stylesheets.
+func parseCSSDocument(src []byte) cssDocument {
+	var doc cssDocument
+	var open []int         // the open elements, innermost last
+	lastChild := []int{-1} // the last element child of each open element, and of the top
+	pop := func() {
+		open = open[:len(open)-1]
+		lastChild = lastChild[:len(lastChild)-1]
+	}
+
+	for i := 0; i < len(src); {
+		lt := bytes.IndexByte(src[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		rest := src[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			end := bytes.Index(rest[4:], []byte("-->"))
+			if end < 0 {
+				i = len(src)
+				continue
+			}
+			i += 4 + end + 3
+			continue
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")):
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				i = len(src)
+				continue
+			}
+			i += end + 1
+			continue
+		case bytes.HasPrefix(rest, []byte("</")):
+			name, n := scanEndTag(rest)
+			if n == 0 {
+				i++
+				continue
+			}
+			for k := len(open) - 1; k >= 0; k-- {
+				if doc.elems[open[k]].name == name {
+					for len(open) > k {
+						pop()
+					}
+					break
+				}
+			}
+			i += n
+			continue
+		}
+
+		tag, n := scanStartTag(rest)
+		if n == 0 {
+			i++
+			continue
+		}
+		for len(open) > 0 {
+			current := doc.elems[open[len(open)-1]].name
+			if !impliedEnd(tag.name, current) {
+				break
+			}
+			pop()
+		}
+
+		e := cssElement{
+			name:   tag.name,
+			attrs:  make(map[string]string, len(tag.attrs)),
+			parent: -1,
+			prev:   lastChild[len(lastChild)-1],
+			skip:   htmlUnstyled[tag.name],
+		}
+		if len(open) > 0 {
+			e.parent = open[len(open)-1]
+		}
+		e.styleStart = i + tag.insertAt
+		for _, a := range tag.attrs {
+			if _, dup := e.attrs[a.name]; dup {
+				continue
+			}
+			e.attrs[a.name] = html.UnescapeString(string(rest[a.start:a.end]))
+			if a.name == "style" {
+				e.styleStart, e.styleEnd, e.style = i+a.start, i+a.end, a.form
+			}
+		}
+		index := len(doc.elems)
+		doc.elems = append(doc.elems, e)
+		lastChild[len(lastChild)-1] = index
+		i += n
+
+		if htmlRawText[tag.name] {
+			contentEnd, end := rawTextEnd(src[i:], tag.name)
+			_, embedded := e.attrs["data-embed"]
+			_, media := e.attrs["media"]
+			if tag.name == "style" && !embedded && !media {
+				doc.blocks = append(doc.blocks, styleBlock{
+					start:        i - n,
+					end:          i + end,
+					contentStart: i,
+					contentEnd:   i + contentEnd,
+					rules:        parseStylesheet(string(src[i : i+contentEnd])),
+				})
+			}
+			i += end
+			continue
+		}
+		if !tag.selfClosing && !htmlVoidElements[tag.name] {
+			open = append(open, index)
+			lastChild = append(lastChild, -1)
+		}
+	}
+
+	for i := range doc.elems {
+		doc.elems[i].last = true
+		if p := doc.elems[i].prev; p >= 0 {
+			doc.elems[p].last = false
+		}
+	}
+	return doc
+}
+
+func impliedEnd(start, current string) bool {
+	if current == "p" {
+		return htmlClosesParagraph[start]
+	}
+	for _, name := range htmlImpliedEnds[start] {
+		if name == current {
+			return true
+		}
+	}
+	return false
+}
+
+type startTag struct {
+	name        string
+	attrs       []tagAttr
+	selfClosing bool
+	insertAt    int // where a new attribute goes: before the ">" or "/>"
+}
+
+type tagAttr struct {
+	name       string
+	start, end int // the raw value, without quotes
+	form       cssAttrForm
+}
+
+// scanStartTag reads the start tag at the beginning of src, returning its
+// length, or 0 when src does not begin with one.
+func scanStartTag(src []byte) (startTag, int) {
+	var t startTag
+	if len(src) < 2 || !isLetter(src[1]) {
+		return t, 0
+	}
+	i := 1
+	for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '/' {
+		i++
+	}
+	t.name = strings.ToLower(string(src[1:i]))
+	for i < len(src) {
+		t.selfClosing = false
+		for i < len(src) && (isSpace(src[i]) || src[i] == '/') {
+			t.selfClosing = src[i] == '/' && i+1 < len(src) && src[i+1] == '>'
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			t.insertAt = i
+			if t.selfClosing {
+				t.insertAt--
+			}
+			return t, i + 1
+		}
+		keyStart := i
+		for i < len(src) && !isSpace(src[i]) && src[i] != '=' && src[i] != '>' && src[i] != '/' {
+			i++
+		}
+		if i == keyStart {
+			// A stray "=": skip it as a parser would.
+			i++
+			continue
+		}
+		a := tagAttr{name: strings.ToLower(string(src[keyStart:i])), start: i, end: i, form: styleBare}
+		j := i
+		for j < len(src) && isSpace(src[j]) {
+			j++
+		}
+		if j < len(src) && src[j] == '=' {
+			j++
+			for j < len(src) && isSpace(src[j]) {
+				j++
+			}
+			if j < len(src) && (src[j] == '"' || src[j] == '\'') {
+				end := bytes.IndexByte(src[j+1:], src[j])
+				if end < 0 {
+					return t, 0
+				}
+				a.start, a.end, a.form = j+1, j+1+end, styleQuoted
+				i = a.end + 1
+			} else {
+				a.start = j
+				for j < len(src) && !isSpace(src[j]) && src[j] != '>' {
+					j++
+				}
+				a.end, a.form = j, styleUnquoted
+				i = j
+			}
+		}
+		t.attrs = append(t.attrs, a)
+	}
+	return t, 0
+}
+
+// scanEndTag reads the end tag at the beginning of src.
+func scanEndTag(src []byte) (string, int) {
+	if len(src) < 3 || !isLetter(src[2]) {
+		return "", 0
+	}
+	i := 2
+	for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '/' {
+		i++
+	}
+	end := bytes.IndexByte(src[i:], '>')
+	if end < 0 {
+		return "", 0
+	}
+	return strings.ToLower(string(src[2:i])), i + end + 1
+}
+
+// rawTextEnd finds the end tag closing a raw text element whose content
+// begins src, returning where the content ends and where the end tag does.
+// An unclosed element runs to the end of src.
+func rawTextEnd(src []byte, name string) (contentEnd, end int) {
+	for i := 0; ; {
+		k := bytes.Index(src[i:], []byte("</"))
+		if k < 0 {
+			return len(src), len(src)
+		}
+		i += k
+		tag := src[i+2:]
+		if len(tag) >= len(name) && strings.EqualFold(string(tag[:len(name)]), name) &&
+			(len(tag) == len(name) || isSpace(tag[len(name)]) || tag[len(name)] == '>' || tag[len(name)] == '/') {
+			gt := bytes.IndexByte(tag, '>')
+			if gt < 0 {
+				return i, len(src)
+			}
+			return i, i + 2 + gt + 1
+		}
+		i += 2
+	}
+}
+
+func containsFold(s, substr []byte) bool {
+	return bytes.Contains(bytes.ToLower(s), substr)
+}
+
+func isLetter(b byte) bool { return b|0x20 >= 'a' && b|0x20 <= 'z' }
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
@@ -0,0 +1,114 @@
+package outlook
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "No stylesheet",
+			html: `<p class="a">Hello</p>`,
+			want: `<p class="a">Hello</p>`,
+		},
+		{
+			name: "Specificity and source order",
+			html: `<style>#main p { color: green } p { color: red; margin: 0 } .lead { color: blue } p { color: black }</style>` +
+				`<p class="lead">a</p><div id="main"><p class="lead">b</p></div>`,
+			want: `<p class="lead" style="margin: 0; color: blue">a</p><div id="main"><p class="lead" style="margin: 0; color: green">b</p></div>`,
+		},
+		{
+			name: "Inline style beats rules except important ones",
+			html: `<style>p { color: red; font-size: 12px !important; margin: 0 }</style>` +
+				`<p style="color: black; font-size: 20px; padding: 1px !important">a</p>`,
+			want: `<p style="margin: 0; color: black; font-size: 12px; padding: 1px !important">a</p>`,
+		},
+		{
+			name: "Longhand after the shorthand it beat",
+			html: `<style>.box { margin-top: 8px } div { margin: 0 }</style><div class="box">a</div>`,
+			want: `<div class="box" style="margin: 0; margin-top: 8px">a</div>`,
+		},
+		{
+			name: "Media queries and pseudo-classes stay",
+			html: `<html><head><style type="text/css">
+/* brand */ a { color: #f60 } a:hover { color: #c30 } p::first-line { font-weight: bold }
+@media only screen and (max-width: 600px) { .col { width: 100% !important } }
+</style></head><body><a href="#" class="col">a</a></body></html>`,
+			want: `<html><head><style type="text/css">
+a:hover { color: #c30 }
+p::first-line { font-weight: bold }
+@media only screen and (max-width: 600px) { .col { width: 100% !important } }
+</style></head><body><a href="#" class="col" style="color: #f60">a</a></body></html>`,
+		},
+		{
+			name: "Client hooks that match nothing stay",
+			html: `<style>.ExternalClass, td { line-height: 100% }</style><td>a</td>`,
+			want: `<style>
+.ExternalClass { line-height: 100% }
+</style><td style="line-height: 100%">a</td>`,
+		},
+		{
+			name: "Combinators, attributes and structure",
+			html: `<style>ul > li + li { margin-top: 4px } td:first-child { padding: 0 } a[href^="tel:"] { color: inherit } h1 ~ p { margin: 0 }</style>` +
+				`<ul><li>1<li>2</ul><table><tr><td>a<td>b</table><a href="tel:1">c</a><h1>t</h1><p>d</p>`,
+			want: `<ul><li>1<li style="margin-top: 4px">2</ul><table><tr><td style="padding: 0">a<td>b</table>` +
+				`<a href="tel:1" style="color: inherit">c</a><h1>t</h1><p style="margin: 0">d</p>`,
+		},
+		{
+			name: "Attribute forms",
+			html: `<style>p { font-family: "Segoe UI", Arial }</style><p style=color:red>a</p><p style='color: red'>b</p><p style>c</p><br/><p>d</p>`,
+			want: `<p style="font-family: 'Segoe UI', Arial; color: red">a</p><p style='font-family: "Segoe UI", Arial; color: red'>b</p>` +
+				`<p style="font-family: 'Segoe UI', Arial">c</p><br/><p style="font-family: 'Segoe UI', Arial">d</p>`,
+		},
+		{
+			name: "Comments, embedded blocks and head elements are left alone",
+			html: `<head><title>t</title><style data-embed>p { color: red }</style><style>* { color: blue }</style></head>` +
+				`<!--[if mso]><p>mso</p><![endif]--><p>a</p>`,
+			want: `<head><title>t</title><style data-embed>p { color: red }</style></head>` +
+				`<!--[if mso]><p>mso</p><![endif]--><p style="color: blue">a</p>`,
+		},
+		{
+			name: "Values are escaped for the attribute",
+			html: `<style>a { background: url("https://x.test/a?b=1&c=2") }</style><a>x</a>`,
+			want: `<a style="background: url('https://x.test/a?b=1&amp;c=2')">x</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InlineCSS([]byte(tt.html))
+			if string(got) != tt.want {
+				t.Errorf("InlineCSS()\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToOutlookHTMLWithOptions(t *testing.T) {
+	src := []byte(`<html><head><style>td { font-size: 14px }</style></head><body><table><tr><td>a</td></tr></table></body></html>`)
+
+	got := ToOutlookHTMLWithOptions(src, Options{InlineCSS: true})
+	if !bytes.Contains(got, []byte(`<td style="font-size: 14px">a</td>`)) {
+		t.Errorf("rule not inlined:\n%s", got)
+	}
+	if strings.Contains(string(got), "td { font-size") {
+		t.Errorf("inlined rule left in the stylesheet:\n%s", got)
+	}
+	// The conversion's own stylesheet is not inlined.
+	if strings.Contains(string(got), "mso-table-lspace: 0pt;\"") || !AlreadyConverted(got) {
+		t.Errorf("conversion stylesheet inlined or missing:\n%s", got)
+	}
+	if again := ToOutlookHTMLWithOptions(got, Options{InlineCSS: true}); !bytes.Equal(again, got) {
+		t.Error("a converted document should be returned as it is")
+	}
+
+	if plain := ToOutlookHTMLWithOptions(src, Options{}); !bytes.Equal(plain, ToOutlookHTML(src)) {
+		t.Error("without options the result should be ToOutlookHTML's")
+	}
+}
@@ -53,3 +53,24 @@ func TestSetOutlookBody(t *testing.T) {
 		t.Error("IsOutlookCompatible should report true")
 	}
 }
+
+func TestSetHTMLBodyInlineCSS(t *testing.T) {
+	tmpl := `<html><head><style>.cta { color: {{.}} }</style></head><body><a class="cta" href="#">Go</a></body></html>`
+
+	email := &Email{InlineCSS: true}
+	if err := email.SetHTMLBody(tmpl, "red"); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<html><head></head><body><a class="cta" href="#" style="color: red">Go</a></body></html>`; string(email.HTMLBody) != want {
+		t.Errorf("HTMLBody\n got %s\nwant %s", email.HTMLBody, want)
+	}
+
+	// Inlined before the conversion, whose own stylesheet stays a stylesheet.
+	email = &Email{InlineCSS: true, OutlookCompatible: true}
+	if err := email.SetHTMLBody(tmpl, "red"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(email.HTMLBody, []byte(`style="color: red"`)) || !bytes.Contains(email.HTMLBody, []byte("mso-line-height-rule: exactly")) {
+		t.Errorf("HTMLBody %s", email.HTMLBody)
+	}
+}
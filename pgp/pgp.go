@@ -0,0 +1,249 @@
+// Package pgp signs and encrypts outgoing mail with OpenPGP/MIME (RFC 3156),
+// and verifies and decrypts it on the way in.
+//
+// It works like the smime package: Sign and Encrypt return the Email with Raw
+// set to the protected message, leaving the address fields in place for the
+// envelope, and SignInterceptor and EncryptInterceptor apply them to every
+// message a Sender sends. Only transports that send a rendered message carry
+// the result -- SMTP and SES; the API providers refuse a message with Raw set.
+//
+// Keys are go-crypto openpgp entities. A key read from an armored file is used
+// as is; a passphrase-protected private key must be decrypted first:
+//
+//	keys, err := openpgp.ReadArmoredKeyRing(f)
+//	err = keys[0].DecryptPrivateKeys(passphrase)
+//
+// On the receiving side, Unwrapper plugs into gsmail.ParseRawEmailWithOptions.
+package pgp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// Scheme is the value of gsmail.Protection.Scheme for layers this package
+// removes.
+const Scheme = "OpenPGP"
+
+// ErrNoKey is returned when there is no key to sign with, or no recipient to
+// encrypt to.
+var ErrNoKey = errors.New("pgp: no key")
+
+// config pins the hash to SHA-256. The library default is the same today;
+// naming it keeps micalg and the signature from drifting apart if that
+// changes.
+var config = &packet.Config{DefaultHash: crypto.SHA256}
+
+// Sign returns email with Raw set to a multipart/signed message: the rendered
+// message, unchanged, beside a detached OpenPGP signature over it.
+//
+// signer must hold a decrypted private signing key. If email already has Raw
+// set, that message is the one signed. As with S/MIME, the header fields
+// outside the MIME entity -- From, To, Subject -- are not covered.
+//
+// Errors are permanent: retrying will not make a bad key sign.
+func Sign(email gsmail.Email, signer *openpgp.Entity) (gsmail.Email, error) {
+	if signer == nil {
+		return gsmail.Email{}, gsmail.NonRetryable(ErrNoKey)
+	}
+	raw, err := rendered(email)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	outer, entity := mimeentity.Extract(raw)
+	content := mimeentity.Canonicalize(mimeentity.TrimTrailingLineBreaks(entity))
+
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, signer, bytes.NewReader(content), config); err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("pgp: sign: %w", err))
+	}
+	// The library may override the configured hash for keys that require a
+	// stronger one, and micalg has to name the hash actually used.
+	micalg, err := micalgOf(sig.Bytes())
+	if err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(err)
+	}
+
+	boundary, err := mimeentity.Boundary("pgp-")
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	var sigPart bytes.Buffer
+	sigPart.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+	sigPart.WriteString("Content-Description: OpenPGP digital signature\r\n")
+	sigPart.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	if err := writeArmored(&sigPart, openpgp.SignatureType, sig.Bytes()); err != nil {
+		return gsmail.Email{}, err
+	}
+
+	var msg bytes.Buffer
+	msg.Write(outer)
+	fmt.Fprintf(&msg, "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=%s; boundary=\"%s\"\r\n\r\n", micalg, boundary)
+	msg.Write(mimeentity.Multipart(boundary, content, mimeentity.TrimTrailingLineBreaks(sigPart.Bytes())))
+
+	email.Raw = msg.Bytes()
+	return email, nil
+}
+
+// Encrypt returns email with Raw set to a multipart/encrypted message readable
+// only by the holders of the recipients' keys.
+//
+// When signer is not nil the message is signed and encrypted in one pass, as
+// RFC 3156 section 6.2 describes and as most OpenPGP mail clients send it. A
+// nil signer encrypts only.
+//
+// Include the sender's own key among the recipients if the sent copy should
+// stay readable.
+func Encrypt(email gsmail.Email, recipients []*openpgp.Entity, signer *openpgp.Entity) (gsmail.Email, error) {
+	if len(recipients) == 0 {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("%w: no recipients to encrypt to", ErrNoKey))
+	}
+	raw, err := rendered(email)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+	outer, entity := mimeentity.Extract(raw)
+
+	var ciphertext bytes.Buffer
+	pw, err := openpgp.Encrypt(&ciphertext, recipients, signer, &openpgp.FileHints{IsBinary: true}, config)
+	if err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("pgp: encrypt: %w", err))
+	}
+	if _, err := pw.Write(mimeentity.Canonicalize(entity)); err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("pgp: encrypt: %w", err))
+	}
+	if err := pw.Close(); err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("pgp: encrypt: %w", err))
+	}
+
+	boundary, err := mimeentity.Boundary("pgp-")
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	control := []byte("Content-Type: application/pgp-encrypted\r\n" +
+		"Content-Description: PGP/MIME version identification\r\n\r\n" +
+		"Version: 1")
+	var data bytes.Buffer
+	data.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	data.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	data.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	if err := writeArmored(&data, "PGP MESSAGE", ciphertext.Bytes()); err != nil {
+		return gsmail.Email{}, err
+	}
+
+	var msg bytes.Buffer
+	msg.Write(outer)
+	fmt.Fprintf(&msg, "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"%s\"\r\n\r\n", boundary)
+	msg.Write(mimeentity.Multipart(boundary, control, mimeentity.TrimTrailingLineBreaks(data.Bytes())))
+
+	email.Raw = msg.Bytes()
+	return email, nil
+}
+
+// SignInterceptor returns a send interceptor that signs every message with
+// signer before passing it on.
+func SignInterceptor(signer *openpgp.Entity) gsmail.SendInterceptor {
+	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
+		signed, err := Sign(email, signer)
+		if err != nil {
+			return err
+		}
+		return next(ctx, signed)
+	}
+}
+
+// KeyLookup returns the keys a message should be encrypted to, typically by
+// looking up each recipient in a keyring or a key server.
+//
+// It should fail rather than return a partial list: a recipient left out
+// receives a message they cannot open.
+type KeyLookup func(ctx context.Context, email gsmail.Email) ([]*openpgp.Entity, error)
+
+// EncryptInterceptor returns a send interceptor that encrypts every message to
+// the keys lookup returns for it, signing it with signer in the same pass
+// when signer is not nil.
+func EncryptInterceptor(lookup KeyLookup, signer *openpgp.Entity) gsmail.SendInterceptor {
+	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
+		keys, err := lookup(ctx, email)
+		if err != nil {
+			return fmt.Errorf("pgp: key lookup: %w", err)
+		}
+		encrypted, err := Encrypt(email, keys, signer)
+		if err != nil {
+			return err
+		}
+		return next(ctx, encrypted)
+	}
+}
+
+// rendered returns the message an operation applies to: Raw if a previous step
+// produced it, otherwise the Email rendered as usual.
+func rendered(email gsmail.Email) ([]byte, error) {
+	if len(email.Raw) > 0 {
+		return email.Raw, nil
+	}
+	raw, err := gsmail.RenderMessage(email)
+	if err != nil {
+		return nil, gsmail.NonRetryable(fmt.Errorf("pgp: render: %w", err))
+	}
+	return raw, nil
+}
+
+// micalgOf reads the hash a binary signature was made with and names it as
+// the micalg parameter of RFC 3156 section 5 expects.
+func micalgOf(sig []byte) (string, error) {
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return "", fmt.Errorf("pgp: read signature: %w", err)
+	}
+	s, ok := p.(*packet.Signature)
+	if !ok {
+		return "", fmt.Errorf("pgp: unexpected packet %T in signature", p)
+	}
+	switch s.Hash {
+	case crypto.SHA256:
+		return "pgp-sha256", nil
+	case crypto.SHA384:
+		return "pgp-sha384", nil
+	case crypto.SHA512:
+		return "pgp-sha512", nil
+	case crypto.SHA224:
+		return "pgp-sha224", nil
+	case crypto.SHA3_256:
+		return "pgp-sha3-256", nil
+	case crypto.SHA3_512:
+		return "pgp-sha3-512", nil
+	}
+	return "pgp-" + strings.ToLower(strings.ReplaceAll(s.Hash.String(), "-", "")), nil
+}
+
+// writeArmored writes data as an ASCII-armored block with CRLF line endings.
+func writeArmored(b *bytes.Buffer, blockType string, data []byte) error {
+	var out bytes.Buffer
+	w, err := armor.Encode(&out, blockType, nil)
+	if err != nil {
+		return gsmail.NonRetryable(fmt.Errorf("pgp: armor: %w", err))
+	}
+	if _, err := w.Write(data); err != nil {
+		return gsmail.NonRetryable(fmt.Errorf("pgp: armor: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		return gsmail.NonRetryable(fmt.Errorf("pgp: armor: %w", err))
+	}
+	b.Write(mimeentity.Canonicalize(out.Bytes()))
+	b.WriteString("\r\n")
+	return nil
+}
@@ -0,0 +1,180 @@
+package pgp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/gsmailtest"
+)
+
+func newKey(t *testing.T, name, address string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", address, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func testEmail() gsmail.Email {
+	return gsmail.Email{
+		From:     "alice@example.com",
+		To:       []string{"bob@example.com"},
+		Subject:  "Contract draft",
+		Body:     []byte("Draft attached.\n"),
+		HTMLBody: []byte("<p>Draft attached.</p>"),
+		Attachments: []gsmail.Attachment{{
+			Filename:    "draft.txt",
+			ContentType: "text/plain",
+			Data:        []byte("clause 1\n"),
+		}},
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+
+	signed, err := Sign(testEmail(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(signed.Raw, []byte(`protocol="application/pgp-signature"; micalg=pgp-`)) {
+		t.Fatalf("expected an RFC 3156 multipart/signed message, got:\n%s", signed.Raw)
+	}
+
+	got, err := gsmail.ParseRawEmailWithOptions(signed.Raw, gsmail.ParseOptions{
+		Unwrappers: []gsmail.Unwrapper{Unwrapper{Keyring: openpgp.EntityList{alice}}},
+	})
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if got.Subject != "Contract draft" || string(got.HTMLBody) != "<p>Draft attached.</p>" {
+		t.Errorf("subject = %q, html = %q", got.Subject, got.HTMLBody)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "draft.txt" {
+		t.Errorf("attachments = %+v", got.Attachments)
+	}
+	want := gsmail.Protection{Scheme: Scheme, Signed: true, Signer: "alice@example.com"}
+	if len(got.Protections) != 1 || got.Protections[0] != want {
+		t.Errorf("protections = %+v, want [%+v]", got.Protections, want)
+	}
+
+	// Stores that rewrite CRLF to LF must not break the signature.
+	lf := bytes.ReplaceAll(signed.Raw, []byte("\r\n"), []byte("\n"))
+	if _, _, ok, err := (Unwrapper{Keyring: openpgp.EntityList{alice}}).Unwrap(lf); !ok || err != nil {
+		t.Errorf("LF copy: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSignatureRejectsTamperingAndUnknownKeys(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+	mallory := newKey(t, "Mallory", "mallory@example.com")
+
+	signed, err := Sign(gsmail.Email{
+		From: "alice@example.com",
+		To:   []string{"bob@example.com"},
+		Body: []byte("Pay account 1111.\n"),
+	}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is base64; "MTExMS4K" is "1111.\n" and "OTk5OS4K" is "9999.\n".
+	tampered := bytes.Replace(signed.Raw, []byte("MTExMS4K"), []byte("OTk5OS4K"), 1)
+	if bytes.Equal(tampered, signed.Raw) {
+		t.Fatal("test did not alter the signed content")
+	}
+	if _, _, _, err := (Unwrapper{Keyring: openpgp.EntityList{alice}}).Unwrap(tampered); !errors.Is(err, ErrVerify) {
+		t.Errorf("tampered: expected ErrVerify, got %v", err)
+	}
+	if _, _, _, err := (Unwrapper{Keyring: openpgp.EntityList{mallory}}).Unwrap(signed.Raw); !errors.Is(err, ErrVerify) {
+		t.Errorf("unknown signer: expected ErrVerify, got %v", err)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+	bob := newKey(t, "Bob", "bob@example.com")
+
+	for _, tc := range []struct {
+		name   string
+		signer *openpgp.Entity
+	}{
+		{"encrypt only", nil},
+		{"sign and encrypt", alice},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encrypted, err := Encrypt(testEmail(), []*openpgp.Entity{bob}, tc.signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(encrypted.Raw, []byte("draft.txt")) {
+				t.Fatal("the encrypted message leaks the content")
+			}
+			if !bytes.Contains(encrypted.Raw, []byte("Subject: Contract draft")) {
+				t.Error("the outer header fields should stay readable")
+			}
+
+			got, err := gsmail.ParseRawEmailWithOptions(encrypted.Raw, gsmail.ParseOptions{
+				Unwrappers: []gsmail.Unwrapper{Unwrapper{Keyring: openpgp.EntityList{bob, alice}}},
+			})
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if string(got.HTMLBody) != "<p>Draft attached.</p>" {
+				t.Errorf("html body = %q", got.HTMLBody)
+			}
+			want := gsmail.Protection{Scheme: Scheme, Encrypted: true}
+			if tc.signer != nil {
+				want.Signed, want.Signer = true, "alice@example.com"
+			}
+			if len(got.Protections) != 1 || got.Protections[0] != want {
+				t.Errorf("protections = %+v, want [%+v]", got.Protections, want)
+			}
+		})
+	}
+}
+
+// A message encrypted for someone else is recognised and refused, not passed
+// through as though it were unprotected.
+func TestEncryptedForSomeoneElse(t *testing.T) {
+	bob := newKey(t, "Bob", "bob@example.com")
+	carol := newKey(t, "Carol", "carol@example.com")
+
+	encrypted, err := Encrypt(testEmail(), []*openpgp.Entity{bob}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, ok, err := Unwrapper{Keyring: openpgp.EntityList{carol}}.Unwrap(encrypted.Raw)
+	if err == nil || ok {
+		t.Errorf("expected an error, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestInterceptorSignsAndEncrypts(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+	bob := newKey(t, "Bob", "bob@example.com")
+
+	recorder := gsmailtest.NewSender()
+	sender := gsmail.WrapSender(recorder, EncryptInterceptor(
+		func(context.Context, gsmail.Email) ([]*openpgp.Entity, error) {
+			return []*openpgp.Entity{bob}, nil
+		}, alice))
+	if err := sender.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := recorder.MustLast(t)
+	if !strings.Contains(string(sent.Raw), `multipart/encrypted; protocol="application/pgp-encrypted"`) {
+		t.Fatalf("expected a multipart/encrypted message, got:\n%s", sent.Raw)
+	}
+	if len(sent.To) != 1 || sent.To[0] != "bob@example.com" {
+		t.Error("the address fields must survive encryption; the sender needs them for the envelope")
+	}
+}
@@ -0,0 +1,140 @@
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// Unwrapper verifies and decrypts OpenPGP/MIME messages. Pass it to
+// gsmail.ParseRawEmailWithOptions:
+//
+//	email, err := gsmail.ParseRawEmailWithOptions(raw, gsmail.ParseOptions{
+//		Unwrappers: []gsmail.Unwrapper{pgp.Unwrapper{Keyring: keys}},
+//	})
+//
+// It recognises multipart/signed with an application/pgp-signature part and
+// multipart/encrypted with application/pgp-encrypted, including encrypted
+// messages that were signed in the same pass.
+//
+// A signature proves the message was signed by a key in Keyring; it does not
+// prove that key belongs to the person in From. Compare Protection.Signer
+// with From if that matters, as mail clients do.
+type Unwrapper struct {
+	// Keyring holds the public keys signatures are verified against and the
+	// decrypted private keys messages are decrypted with. A signature by a
+	// key not in it does not verify.
+	Keyring openpgp.KeyRing
+}
+
+var (
+	// ErrVerify is returned when a signature does not verify or was made by a
+	// key that is not in the keyring.
+	ErrVerify = errors.New("pgp: signature verification failed")
+	// ErrMalformed is returned for a message that claims to be OpenPGP/MIME
+	// but does not have the structure RFC 3156 requires.
+	ErrMalformed = errors.New("pgp: malformed message")
+)
+
+// Unwrap implements gsmail.Unwrapper.
+func (u Unwrapper) Unwrap(raw []byte) ([]byte, gsmail.Protection, bool, error) {
+	header, body := mimeentity.Split(raw)
+	mediaType, params, err := mime.ParseMediaType(mimeentity.Header(header).Get("Content-Type"))
+	if err != nil {
+		return nil, gsmail.Protection{}, false, nil
+	}
+
+	protocol := strings.ToLower(params["protocol"])
+	switch {
+	case mediaType == "multipart/signed" && protocol == "application/pgp-signature":
+		return u.verifyDetached(raw, body, params["boundary"])
+	case mediaType == "multipart/encrypted" && protocol == "application/pgp-encrypted":
+		return u.decrypt(raw, body, params["boundary"])
+	}
+	return nil, gsmail.Protection{}, false, nil
+}
+
+// verifyDetached checks a multipart/signed message and returns the message
+// that was signed.
+func (u Unwrapper) verifyDetached(raw, body []byte, boundary string) ([]byte, gsmail.Protection, bool, error) {
+	parts, err := mimeentity.Parts(body, boundary)
+	if err != nil || len(parts) != 2 {
+		return nil, gsmail.Protection{}, false, ErrMalformed
+	}
+	content := parts[0]
+	_, sig := mimeentity.Split(parts[1])
+
+	// RFC 3156 signs the canonical CRLF form, so a message whose line endings
+	// were rewritten in storage still verifies.
+	signer, err := openpgp.CheckArmoredDetachedSignature(u.Keyring, bytes.NewReader(mimeentity.Canonicalize(content)), bytes.NewReader(sig), nil)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("%w: %v", ErrVerify, err)
+	}
+
+	outer, _ := mimeentity.Extract(raw)
+	return mimeentity.Join(outer, content), gsmail.Protection{Scheme: Scheme, Signed: true, Signer: identityOf(signer)}, true, nil
+}
+
+// decrypt opens a multipart/encrypted message, verifying the signature inside
+// it when there is one.
+func (u Unwrapper) decrypt(raw, body []byte, boundary string) ([]byte, gsmail.Protection, bool, error) {
+	parts, err := mimeentity.Parts(body, boundary)
+	if err != nil || len(parts) != 2 {
+		return nil, gsmail.Protection{}, false, ErrMalformed
+	}
+	_, data := mimeentity.Split(parts[1])
+
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, u.Keyring, nil, nil)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("pgp: decrypt: %w", err)
+	}
+	// The signature and the integrity check are only evaluated once the body
+	// has been read to the end, so nothing read is trusted before then.
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("pgp: decrypt: %w", err)
+	}
+
+	layer := gsmail.Protection{Scheme: Scheme, Encrypted: true}
+	if md.IsSigned {
+		if md.SignedBy == nil {
+			return nil, gsmail.Protection{}, false, fmt.Errorf("%w: signed by unknown key %X", ErrVerify, md.SignedByKeyId)
+		}
+		if md.SignatureError != nil {
+			return nil, gsmail.Protection{}, false, fmt.Errorf("%w: %v", ErrVerify, md.SignatureError)
+		}
+		layer.Signed = true
+		layer.Signer = identityOf(md.SignedBy.Entity)
+	}
+
+	outer, _ := mimeentity.Extract(raw)
+	return mimeentity.Join(outer, content), layer, true, nil
+}
+
+// identityOf names a key by the email address of its primary identity, or the
+// whole user ID when it has none.
+func identityOf(e *openpgp.Entity) string {
+	if e == nil {
+		return ""
+	}
+	id := e.PrimaryIdentity()
+	if id == nil || id.UserId == nil {
+		return ""
+	}
+	if id.UserId.Email != "" {
+		return id.UserId.Email
+	}
+	return id.Name
+}
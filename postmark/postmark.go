@@ -7,7 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/gsoultan/gsmail"
@@ -76,11 +76,24 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	if err := gsmail.RejectEnvelope("postmark", email); err != nil {
 		return err
 	}
+	if err := gsmail.RejectRaw("postmark", email); err != nil {
+		return err
+	}
+	if err := gsmail.RejectAMP("postmark", email); err != nil {
+		return err
+	}
+	email = gsmail.WithPlainText(email)
+	// The API cannot add a part to multipart/alternative, so an invitation
+	// travels as a text/calendar attachment.
+	email, err := gsmail.CalendarAsAttachment(email)
+	if err != nil {
+		return err
+	}
 	reqBody := postmarkRequest{
 		From:          gsmail.FormatAddress(email.From),
-		To:            gsmail.FormatAddresses(email.To),
-		Cc:            gsmail.FormatAddresses(email.Cc),
-		Bcc:           gsmail.FormatAddresses(email.Bcc),
+		To:            strings.Join(gsmail.FormatMailboxes(email.To), ", "),
+		Cc:            strings.Join(gsmail.FormatMailboxes(email.Cc), ", "),
+		Bcc:           strings.Join(gsmail.FormatMailboxes(email.Bcc), ", "),
 		Subject:       email.Subject,
 		ReplyTo:       gsmail.FormatAddress(email.ReplyTo),
 		MessageStream: p.MessageStream,
@@ -96,21 +109,28 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	}
 
 	for _, att := range email.Attachments {
+		// The API takes each attachment whole, so a streamed one is read in
+		// full here.
+		data, err := att.Bytes()
+		if err != nil {
+			return gsmail.NonRetryable(fmt.Errorf("postmark: %w", err))
+		}
 		reqBody.Attachments = append(reqBody.Attachments, attachment{
 			Name:        att.Filename,
-			Content:     base64.StdEncoding.EncodeToString(att.Data),
+			Content:     base64.StdEncoding.EncodeToString(data),
 			ContentType: att.ContentType,
 			ContentID:   att.ContentID,
 		})
 	}
 
-	// Custom headers (List-Unsubscribe, In-Reply-To, X-*).
-	hdrs, err := gsmail.CustomHeaders(email.Headers)
+	// Custom headers (List-Unsubscribe, In-Reply-To, X-*). Postmark takes a
+	// list, so repeated fields and their order survive.
+	fields, err := gsmail.CustomHeaderFields(email)
 	if err != nil {
 		return err
 	}
-	for _, name := range sortedNames(hdrs) {
-		reqBody.Headers = append(reqBody.Headers, header{Name: name, Value: hdrs[name]})
+	for _, f := range fields {
+		reqBody.Headers = append(reqBody.Headers, header{Name: f.Name, Value: f.Value})
 	}
 
 	// Marshal once: the payload does not change between attempts.
@@ -143,20 +163,6 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	})
 }
 
-// sortedNames returns map keys in a stable order so the marshalled request is
-// byte-identical for the same input.
-func sortedNames(m map[string]string) []string {
-	if len(m) == 0 {
-		return nil
-	}
-	names := make([]string, 0, len(m))
-	for name := range m {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-	return names
-}
-
 // Ping checks the connection to Postmark by querying server information.
 func (p *Sender) Ping(ctx context.Context) error {
 	return gsmail.Retry(ctx, p.GetRetryConfig(), func() error {
@@ -177,3 +183,25 @@ func (p *Sender) Ping(ctx context.Context) error {
 		return nil
 	})
 }
+
+// postmarkLimits are Postmark's: 10 MB per message including attachments
+// after encoding, 50 recipients across To, Cc and Bcc, and the attachment
+// types it refuses.
+var postmarkLimits = gsmail.Limits{
+	MaxMessageSize: 10_000_000,
+	MaxRecipients:  50,
+	ForbiddenExtensions: []string{
+		".bat", ".bin", ".chm", ".com", ".cpl", ".crt", ".exe", ".hlp", ".hta", ".inf", ".ins", ".isp",
+		".jse", ".lnk", ".mdb", ".msc", ".msi", ".msp", ".mst", ".pcd", ".pif", ".reg", ".scr", ".sct",
+		".shs", ".vba", ".vbe", ".vbs", ".wsf", ".wsh", ".wsl",
+	},
+}
+
+// Limits returns what Postmark accepts in one message.
+func (p *Sender) Limits() gsmail.Limits { return postmarkLimits }
+
+// Preflight reports, without calling Postmark, whether it would refuse email
+// for exceeding its limits. Use gsmail.PreflightInterceptor to apply it.
+func (p *Sender) Preflight(email gsmail.Email) error {
+	return p.Limits().Check("postmark", email)
+}
@@ -2,6 +2,7 @@ package postmark
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -39,3 +40,58 @@ func TestPostmarkSender_Send(t *testing.T) {
 		t.Fatalf("Send failed: %v", err)
 	}
 }
+
+// Postmark has no field for an AMP body, so the message is refused rather
+// than sent as its HTML alone.
+func TestPostmarkRejectsAMP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request was made")
+	}))
+	defer server.Close()
+
+	sender := NewSender("test-token")
+	sender.BaseURL = server.URL
+	err := sender.Send(context.Background(), gsmail.Email{
+		From:     "sender@example.com",
+		To:       []string{"receiver@example.com"},
+		HTMLBody: []byte("<p>Hi</p>"),
+		AMPBody:  []byte("<!doctype html>"),
+	})
+	if !errors.Is(err, gsmail.ErrAMPUnsupported) || gsmail.IsRetryable(err) {
+		t.Errorf("expected a permanent ErrAMPUnsupported, got %v", err)
+	}
+}
+
+// Preflight refuses what Postmark would, without a request, and
+// PreflightInterceptor applies it.
+func TestPostmarkPreflight(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := NewSender("test-token")
+	p.BaseURL = server.URL
+	sender := gsmail.WrapSender(p, gsmail.PreflightInterceptor(p))
+
+	to := make([]string, 51)
+	for i := range to {
+		to[i] = "r@example.com"
+	}
+	for name, e := range map[string]gsmail.Email{
+		"recipients": {From: "s@example.com", To: to, Body: []byte("x")},
+		"forbidden":  {From: "s@example.com", To: []string{"r@example.com"}, Attachments: []gsmail.Attachment{{Filename: "run.vbs", Data: []byte("x")}}},
+	} {
+		if err := sender.Send(context.Background(), e); !errors.Is(err, gsmail.ErrLimitExceeded) || gsmail.IsRetryable(err) {
+			t.Errorf("%s: expected a non-retryable ErrLimitExceeded, got %v", name, err)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("%d requests were made for refused messages", calls)
+	}
+	if err := sender.Send(context.Background(), gsmail.Email{From: "s@example.com", To: []string{"r@example.com"}, Body: []byte("x")}); err != nil || calls != 1 {
+		t.Errorf("err %v, calls %d", err, calls)
+	}
+}
@@ -0,0 +1,135 @@
+package gsmail
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Protection describes one cryptographic layer that was removed from a message
+// while it was parsed: a signature that verified, or an encryption that was
+// decrypted.
+type Protection struct {
+	// Scheme names the format of the layer, such as "S/MIME".
+	Scheme string
+	// Signed is true when the layer carried a signature and it verified. A
+	// signature that does not verify is an error, never a Protection.
+	Signed bool
+	// Encrypted is true when the layer was encrypted and has been decrypted.
+	Encrypted bool
+	// Signer identifies who signed the layer, as the scheme names them: the
+	// email address or common name from an S/MIME certificate. It is empty
+	// for an encryption-only layer.
+	Signer string
+}
+
+// Unwrapper removes one layer of protection from a message.
+//
+// Unwrap is given a complete message and reports ok as false when the message
+// is not protected in a way it understands, so several Unwrappers for
+// different schemes can be offered together. When it does understand the
+// message it returns the complete message that was inside -- with the outer
+// header fields put back, so it parses like any other -- and a description of
+// the layer it removed.
+//
+// A layer that is recognised but cannot be removed -- a signature that does
+// not verify, a message encrypted to someone else -- is an error. Returning
+// ok as false instead would hand the caller the wrapper and let them mistake
+// it for an unprotected message.
+type Unwrapper interface {
+	Unwrap(raw []byte) (inner []byte, layer Protection, ok bool, err error)
+}
+
+// ParseOptions configures ParseRawEmailWithOptions.
+type ParseOptions struct {
+	// Unwrappers are tried in order on the message, and again on whatever each
+	// one returns, until none of them recognises the result. A message that
+	// was signed and then encrypted is therefore unwrapped by a single parse.
+	Unwrappers []Unwrapper
+
+	// RetainRaw keeps the message as it arrived: Email.Raw holds a copy of
+	// the bytes given to the parse, before any Unwrapper, and
+	// Email.Structure maps the entities in them.
+	//
+	// A message parsed this way re-emits exactly, since Raw is what every
+	// transport that carries MIME sends in place of the typed fields: it can
+	// be archived, attached by ForwardAsAttachment as it arrived, verified
+	// against its DKIM-Signature later, or resent unmodified through the SMTP
+	// sender to the addresses in Envelope. The typed fields are still filled
+	// in for reading, but editing them changes nothing that is sent; clear
+	// Raw first to send the edited message instead.
+	RetainRaw bool
+
+	// DecodeTNEF unpacks the winmail.dat attachments Outlook sends when set
+	// to use Rich Text: the files inside become ordinary Attachments, and
+	// the body inside fills Body or HTMLBody when the message has none of
+	// its own. See DecodeTNEF for what is recovered.
+	DecodeTNEF bool
+
+	// KeepTNEF keeps each decoded winmail.dat among the Attachments beside
+	// what was unpacked from it, for a caller that forwards or archives it
+	// as received. A winmail.dat that does not decode is always kept.
+	KeepTNEF bool
+}
+
+// maxProtectionLayers bounds how many layers a single parse removes. Real mail
+// has one or two; the bound only stops a message that unwraps to itself from
+// looping forever.
+const maxProtectionLayers = 8
+
+// ErrTooManyLayers is returned when a message is nested in more layers of
+// protection than ParseRawEmailWithOptions is prepared to remove.
+var ErrTooManyLayers = errors.New("gsmail: message has too many layers of protection")
+
+// ParseRawEmailWithOptions parses a raw message like ParseRawEmail, first
+// removing any protection the configured Unwrappers recognise. The layers
+// removed are recorded in Email.Protections, outermost first.
+//
+// With no Unwrappers and the other options unset it is exactly
+// ParseRawEmail. A protected message parsed without the means to unwrap it
+// still parses: a signed message yields its content with the signature as an
+// attachment, and an encrypted one yields nothing but the encrypted
+// attachment.
+func ParseRawEmailWithOptions(raw []byte, opts ParseOptions) (Email, error) {
+	source := raw
+	var layers []Protection
+	for depth := 0; ; depth++ {
+		inner, layer, ok, err := unwrapOnce(raw, opts.Unwrappers)
+		if err != nil {
+			return Email{}, err
+		}
+		if !ok {
+			break
+		}
+		if depth == maxProtectionLayers {
+			return Email{}, ErrTooManyLayers
+		}
+		layers = append(layers, layer)
+		raw = inner
+	}
+
+	email, err := ParseRawEmail(raw)
+	email.Protections = layers
+	if opts.DecodeTNEF && err == nil {
+		decodeTNEFAttachments(&email, opts.KeepTNEF)
+	}
+	if opts.RetainRaw && err == nil {
+		// A copy, so a caller reusing its read buffer cannot change a
+		// message that is meant to be the one that arrived.
+		email.Raw = bytes.Clone(source)
+		email.Structure = parseStructure(email.Raw, 0, len(email.Raw), 0)
+	}
+	return email, err
+}
+
+func unwrapOnce(raw []byte, unwrappers []Unwrapper) ([]byte, Protection, bool, error) {
+	for _, u := range unwrappers {
+		inner, layer, ok, err := u.Unwrap(raw)
+		if err != nil {
+			return nil, Protection{}, false, err
+		}
+		if ok {
+			return inner, layer, true, nil
+		}
+	}
+	return nil, Protection{}, false, nil
+}
@@ -86,6 +86,24 @@ type AddressValidator interface {
 	Validate(ctx context.Context, email string) error
 }
 
+// Preflighter is implemented by senders that can tell, before any network
+// call, that their service will refuse a message: one too large, with too
+// many recipients or with an attachment type it forbids. Like
+// AddressValidator it is not part of Sender; PreflightInterceptor applies it.
+type Preflighter interface {
+	Preflight(email Email) error
+}
+
+// PreflightFunc adapts a function to Preflighter, for limits of your own:
+//
+//	gsmail.PreflightFunc(func(e gsmail.Email) error {
+//		return gsmail.Limits{MaxMessageSize: 20 << 20}.Check("smtp", e)
+//	})
+type PreflightFunc func(email Email) error
+
+// Preflight calls f(email).
+func (f PreflightFunc) Preflight(email Email) error { return f(email) }
+
 // BaseProvider implements common logic for all providers.
 //
 // The zero value is ready to use and retries with DefaultRetryConfig. Do not
@@ -277,3 +295,16 @@ func RejectEnvelope(provider string, email Email) error {
 	}
 	return NonRetryable(fmt.Errorf("%s: %w", provider, ErrEnvelopeUnsupported))
 }
+
+// ErrRawUnsupported is returned by providers that build their request from the
+// typed fields of an Email and so cannot transmit Email.Raw.
+var ErrRawUnsupported = errors.New("gsmail: this provider cannot send a pre-rendered message; Email.Raw needs a transport that sends MIME, such as SMTP or SES")
+
+// RejectRaw reports the error a provider should return when a message sets
+// Email.Raw and the transport cannot send it verbatim.
+func RejectRaw(provider string, email Email) error {
+	if len(email.Raw) == 0 {
+		return nil
+	}
+	return NonRetryable(fmt.Errorf("%s: %w", provider, ErrRawUnsupported))
+}
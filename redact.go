@@ -0,0 +1,230 @@
+package gsmail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// AddressRedaction is how a Redactor renders an email address.
+type AddressRedaction int
+
+const (
+	// AddressOmit leaves addresses out. It is the zero value.
+	AddressOmit AddressRedaction = iota
+	// AddressHash replaces an address with a keyed hash of it, so the same
+	// recipient can be followed through the logs without being named.
+	AddressHash
+	// AddressHashLocalPart hashes the part before the '@' and keeps the
+	// domain, so delivery problems at one provider still stand out.
+	AddressHashLocalPart
+	// AddressDomain keeps only the domain, as "*@example.com".
+	AddressDomain
+	// AddressVerbatim records the address as it was given, display name
+	// included.
+	AddressVerbatim
+)
+
+// SubjectRedaction is how a Redactor renders a subject line.
+type SubjectRedaction int
+
+const (
+	// SubjectOmit leaves the subject out. It is the zero value.
+	SubjectOmit SubjectRedaction = iota
+	// SubjectMask keeps the reply and forward markers, the white space and
+	// the punctuation, and replaces every letter and digit with '*': enough to
+	// tell a reply from a new message, and one template from another by
+	// shape, without the words.
+	SubjectMask
+	// SubjectHash replaces the subject with a keyed hash of it, so messages
+	// with the same subject can be grouped.
+	SubjectHash
+	// SubjectVerbatim records the subject as it is.
+	SubjectVerbatim
+)
+
+// BodyRedaction is how a Redactor renders a message body.
+type BodyRedaction int
+
+const (
+	// BodyOmit leaves the body out; only its size is recorded. It is the
+	// zero value.
+	BodyOmit BodyRedaction = iota
+	// BodyVerbatim records the plain text body, or the HTML body when there
+	// is no plain text. It is meant for debugging against test data.
+	BodyVerbatim
+)
+
+// Redactor decides which personal data of a message the logging and tracing
+// interceptors record, and in what form: LoggerInterceptor,
+// RedactingLoggerInterceptor and the interceptors of gsmail/otelgs all render
+// a message through one, so a privacy review has one place to look.
+//
+// The zero Redactor records no personal data at all: addresses, subject and
+// body are omitted, and only counts and sizes remain. Bcc addresses are left
+// out unless Bcc asks for them. Sizes and the error a
+// send returned are always recorded; an error quoting a recipient, as some
+// SMTP servers' replies do, is not redacted.
+type Redactor struct {
+	// Addresses is how From, To, Cc and, with Bcc set, Bcc are rendered.
+	Addresses AddressRedaction
+
+	// Bcc records the Bcc addresses as Addresses renders the others. Without
+	// it they are left out, since the message hides them from every other
+	// recipient; Recipients still counts them.
+	Bcc bool
+
+	// Subject is how the subject line is rendered.
+	Subject SubjectRedaction
+
+	// Body is how the body is rendered.
+	Body BodyRedaction
+
+	// Key is the HMAC-SHA256 key of AddressHash, AddressHashLocalPart and
+	// SubjectHash. It must be secret and long enough not to be guessed: an
+	// unkeyed hash of an address is undone by hashing a list of candidates.
+	// While Key is empty those policies omit the value instead.
+	Key []byte
+}
+
+// VerboseRedactor returns the Redactor of VerboseLoggerInterceptor and
+// otelgs.VerboseSendInterceptor: addresses and subject as they are, except
+// Bcc, and no body.
+func VerboseRedactor() Redactor {
+	return Redactor{Addresses: AddressVerbatim, Subject: SubjectVerbatim}
+}
+
+// RedactedEmail is what a Redactor lets through of a message. A field that
+// the Redactor omits is empty.
+type RedactedEmail struct {
+	From        string
+	To, Cc, Bcc []string
+	Subject     string
+	Body        string
+	Recipients  int // the number of To, Cc and Bcc addresses
+	Attachments int
+	BodyBytes   int // the size of the plain text and HTML bodies together
+}
+
+// Redact renders email according to r.
+func (r Redactor) Redact(email Email) RedactedEmail {
+	out := RedactedEmail{
+		From:        r.RedactAddress(email.From),
+		To:          r.RedactAddresses(email.To),
+		Cc:          r.RedactAddresses(email.Cc),
+		Subject:     r.RedactSubject(email.Subject),
+		Recipients:  len(email.To) + len(email.Cc) + len(email.Bcc),
+		Attachments: len(email.Attachments),
+		BodyBytes:   len(email.Body) + len(email.HTMLBody),
+	}
+	if r.Bcc {
+		out.Bcc = r.RedactAddresses(email.Bcc)
+	}
+	if r.Body == BodyVerbatim {
+		out.Body = string(email.Body)
+		if out.Body == "" {
+			out.Body = string(email.HTMLBody)
+		}
+	}
+	return out
+}
+
+// RedactAddress renders one address, or returns "" when r omits addresses.
+func (r Redactor) RedactAddress(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	switch r.Addresses {
+	case AddressVerbatim:
+		return addr
+	case AddressDomain:
+		if _, domain := splitRedactAddress(addr); domain != "" {
+			return "*@" + domain
+		}
+		return "*"
+	case AddressHash:
+		local, domain := splitRedactAddress(addr)
+		if domain != "" {
+			local += "@" + domain
+		}
+		return r.hash(local)
+	case AddressHashLocalPart:
+		local, domain := splitRedactAddress(addr)
+		h := r.hash(local)
+		if h == "" || domain == "" {
+			return h
+		}
+		return h + "@" + domain
+	}
+	return ""
+}
+
+// RedactAddresses renders each of addrs, or returns nil when r omits addresses.
+func (r Redactor) RedactAddresses(addrs []string) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	var out []string
+	for _, a := range addrs {
+		if s := r.RedactAddress(a); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// RedactSubject renders a subject line, or returns "" when r omits it.
+func (r Redactor) RedactSubject(subject string) string {
+	if subject == "" {
+		return ""
+	}
+	switch r.Subject {
+	case SubjectVerbatim:
+		return subject
+	case SubjectHash:
+		return r.hash(subject)
+	case SubjectMask:
+		s := strings.Join(strings.Fields(subject), " ")
+		base := ThreadSubject(s)
+		markers := s[:len(s)-len(base)]
+		return markers + strings.Map(func(c rune) rune {
+			if unicode.IsLetter(c) || unicode.IsDigit(c) {
+				return '*'
+			}
+			return c
+		}, base)
+	}
+	return ""
+}
+
+// hash returns the first 16 hex digits of the HMAC of s under r.Key, or ""
+// when there is no key.
+func (r Redactor) hash(s string) string {
+	if len(r.Key) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.Key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// splitRedactAddress returns the local part and the domain of addr, without
+// a display name and in lower case, so one mailbox always hashes the same.
+func splitRedactAddress(addr string) (local, domain string) {
+	bare := strings.TrimSpace(addr)
+	if parsed, err := ParseAddress(addr); err == nil && parsed.Address != "" {
+		bare = parsed.Address
+	}
+	bare = strings.ToLower(bare)
+	at := strings.LastIndexByte(bare, '@')
+	if at < 0 {
+		return bare, ""
+	}
+	domain = bare[at+1:]
+	if ascii, err := DomainToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return bare[:at], domain
+}
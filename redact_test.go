@@ -0,0 +1,159 @@
+package gsmail
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func redactEmail() Email {
+	return Email{
+		From:     "Alice Sender <Alice.Sender@Example.com>",
+		To:       []string{"bob@example.com", "carol@bücher.example"},
+		Cc:       []string{"dave@example.org"},
+		Bcc:      []string{"erin@example.net"},
+		Subject:  "Re: Your invoice 42",
+		Body:     []byte("Dear Bob"),
+		HTMLBody: []byte("<p>Dear Bob</p>"),
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	key := []byte("a secret key of reasonable length")
+	hashOf := func(s string) string { return Redactor{Key: key}.hash(s) }
+
+	tests := []struct {
+		name    string
+		r       Redactor
+		from    string
+		to      []string
+		subject string
+		body    string
+	}{
+		{name: "zero value omits everything", r: Redactor{}},
+		{
+			name:    "verbose",
+			r:       VerboseRedactor(),
+			from:    "Alice Sender <Alice.Sender@Example.com>",
+			to:      []string{"bob@example.com", "carol@bücher.example"},
+			subject: "Re: Your invoice 42",
+		},
+		{
+			name:    "hash",
+			r:       Redactor{Addresses: AddressHash, Subject: SubjectHash, Key: key},
+			from:    hashOf("alice.sender@example.com"),
+			to:      []string{hashOf("bob@example.com"), hashOf("carol@xn--bcher-kva.example")},
+			subject: hashOf("Re: Your invoice 42"),
+		},
+		{
+			name:    "hash local part and mask subject",
+			r:       Redactor{Addresses: AddressHashLocalPart, Subject: SubjectMask, Key: key},
+			from:    hashOf("alice.sender") + "@example.com",
+			to:      []string{hashOf("bob") + "@example.com", hashOf("carol") + "@xn--bcher-kva.example"},
+			subject: "Re: **** ******* **",
+		},
+		{
+			name: "hash without a key omits",
+			r:    Redactor{Addresses: AddressHash, Subject: SubjectHash},
+		},
+		{
+			name: "domains and body",
+			r:    Redactor{Addresses: AddressDomain, Body: BodyVerbatim},
+			from: "*@example.com",
+			to:   []string{"*@example.com", "*@xn--bcher-kva.example"},
+			body: "Dear Bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.Redact(redactEmail())
+			if got.From != tt.from {
+				t.Errorf("From = %q, want %q", got.From, tt.from)
+			}
+			if !reflect.DeepEqual(got.To, tt.to) {
+				t.Errorf("To = %q, want %q", got.To, tt.to)
+			}
+			if got.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", got.Subject, tt.subject)
+			}
+			if got.Body != tt.body {
+				t.Errorf("Body = %q, want %q", got.Body, tt.body)
+			}
+			if got.Bcc != nil {
+				t.Errorf("Bcc = %q, want it left out", got.Bcc)
+			}
+			if got.Recipients != 4 || got.Attachments != 0 || got.BodyBytes != 23 {
+				t.Errorf("counts = %d, %d, %d", got.Recipients, got.Attachments, got.BodyBytes)
+			}
+		})
+	}
+
+	withBcc := VerboseRedactor()
+	withBcc.Bcc = true
+	if got := withBcc.Redact(redactEmail()).Bcc; !reflect.DeepEqual(got, []string{"erin@example.net"}) {
+		t.Errorf("Bcc with Bcc set = %q", got)
+	}
+
+	// One mailbox hashes the same however it is written, and differently
+	// under another key.
+	r := Redactor{Addresses: AddressHash, Key: key}
+	if a, b := r.RedactAddress("BOB@example.com"), r.RedactAddress("Bob <bob@EXAMPLE.com>"); a != b {
+		t.Errorf("hashes differ: %s, %s", a, b)
+	}
+	other := Redactor{Addresses: AddressHash, Key: []byte("another key")}
+	if r.RedactAddress("bob@example.com") == other.RedactAddress("bob@example.com") {
+		t.Error("the hash should depend on the key")
+	}
+}
+
+func TestRedactingLoggerInterceptor(t *testing.T) {
+	send := func(r Redactor) string {
+		var line string
+		logFn := func(format string, args ...any) { line = fmt.Sprintf(format, args...) }
+		s := WrapSender(&recordingSender{}, RedactingLoggerInterceptor(logFn, r))
+		if err := s.Send(context.Background(), redactEmail()); err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+
+	line := send(Redactor{})
+	if !strings.HasPrefix(line, "gsmail: send recipients=4 attachments=0 bytes=23 duration=") {
+		t.Errorf("line = %q", line)
+	}
+	for _, secret := range []string{"alice", "bob", "carol", "dave", "erin", "invoice", "Dear"} {
+		if strings.Contains(strings.ToLower(line), strings.ToLower(secret)) {
+			t.Errorf("personal data %q logged: %q", secret, line)
+		}
+	}
+
+	line = send(Redactor{Addresses: AddressDomain, Subject: SubjectMask, Bcc: true})
+	for _, want := range []string{
+		"from=*@example.com",
+		"to=[*@example.com *@xn--bcher-kva.example]",
+		"cc=[*@example.org]",
+		"bcc=[*@example.net]",
+		`subject="Re: **** ******* **"`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %q lacks %q", line, want)
+		}
+	}
+
+	var verbose string
+	s := WrapSender(&recordingSender{}, VerboseLoggerInterceptor(func(format string, args ...any) {
+		verbose = fmt.Sprintf(format, args...)
+	}))
+	if err := s.Send(context.Background(), redactEmail()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(verbose, "bob@example.com") || !strings.Contains(verbose, `subject="Re: Your invoice 42"`) {
+		t.Errorf("verbose line = %q", verbose)
+	}
+	if strings.Contains(verbose, "erin") || strings.Contains(verbose, "bcc=") {
+		t.Errorf("verbose line discloses Bcc: %q", verbose)
+	}
+}
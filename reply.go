@@ -0,0 +1,334 @@
+package gsmail
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Reply returns a reply to e from from, addressed to e's Reply-To or, when it
+// has none, its From.
+//
+// The reply is threaded: In-Reply-To names e's Message-ID, and References
+// extends e's own chain with it, which is what every client groups a
+// conversation by. The subject gains "Re: " unless it already starts with
+// it. Body quotes e's text -- generated from its HTML when it has no text --
+// under an attribution line, and HTMLBody does the same with e's HTML when it
+// has some, keeping the inline images that HTML refers to. Write the answer
+// in front of the quote:
+//
+//	reply := received.Reply("support@example.com")
+//	reply.Body = append([]byte("Fixed in 2.4.1.\r\n"), reply.Body...)
+//
+// e is typically a message from ParseRawEmail or a receiver, which retain the
+// Message-ID and References the threading needs. A message without a
+// Message-ID produces a reply that is addressed and quoted but not threaded.
+func (e Email) Reply(from string) Email {
+	to := e.ReplyTo
+	if strings.TrimSpace(to) == "" {
+		to = e.From
+	}
+	r := e.replyTo(from)
+	r.To = usableAddresses(replyMailboxes(to))
+	return r
+}
+
+// ReplyAll is Reply addressed to everyone on e: its Reply-To or From, and its
+// To, in To, and its Cc in Cc. from is left out, and so is any address listed
+// twice. Bcc recipients are not copied; they were hidden from the others
+// and a reply must not reveal them.
+func (e Email) ReplyAll(from string) Email {
+	first := e.ReplyTo
+	if strings.TrimSpace(first) == "" {
+		first = e.From
+	}
+
+	seen := make(map[string]bool)
+	if a, err := ParseEmailAddress(from); err == nil && a != nil {
+		seen[strings.ToLower(a.Address)] = true
+	}
+	keep := func(list []string) []string {
+		var out []string
+		for _, s := range usableAddresses(list) {
+			a, err := ParseEmailAddress(s)
+			if err != nil || a == nil {
+				continue
+			}
+			if key := strings.ToLower(a.Address); !seen[key] {
+				seen[key] = true
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	r := e.replyTo(from)
+	r.To = keep(append(replyMailboxes(first), e.To...))
+	r.Cc = keep(e.Cc)
+	if len(r.To) == 0 {
+		// A reply to one's own message: the others are still the audience.
+		r.To = usableAddresses(e.To)
+	}
+	return r
+}
+
+// replyTo builds the parts shared by Reply and ReplyAll.
+func (e Email) replyTo(from string) Email {
+	r := Email{
+		From:    from,
+		Subject: prefixSubject("Re:", e.Subject, "re:"),
+	}
+	e.thread(&r, true)
+
+	attribution := e.Header("Date")
+	if attribution != "" {
+		attribution = "On " + attribution + ", " + e.From + " wrote:"
+	} else {
+		attribution = e.From + " wrote:"
+	}
+
+	text := e.Body
+	if len(text) == 0 && len(e.HTMLBody) > 0 {
+		text = HTMLToText(e.HTMLBody)
+	}
+	var body bytes.Buffer
+	body.WriteString("\r\n\r\n")
+	body.WriteString(attribution)
+	body.WriteString("\r\n")
+	quoteText(&body, text)
+	r.Body = body.Bytes()
+
+	if len(e.HTMLBody) > 0 {
+		var h bytes.Buffer
+		h.WriteString("<br><br><div>")
+		h.WriteString(html.EscapeString(attribution))
+		h.WriteString(`</div><blockquote type="cite" style="margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex">`)
+		h.Write(htmlBodyContent(e.HTMLBody))
+		h.WriteString("</blockquote>")
+		r.HTMLBody = h.Bytes()
+		r.Attachments = inlineAttachments(e.Attachments)
+	}
+	return r
+}
+
+// Forward returns e forwarded from from to the given recipients, with e's
+// header fields and bodies reproduced below a "Forwarded message" line and
+// its attachments carried along, as a mail client's Forward does. As with
+// Reply, write the covering note in front of Body and HTMLBody.
+//
+// A forward is not a reply, so it sets References but not In-Reply-To: it
+// stays associated with the conversation without claiming to answer it.
+func (e Email) Forward(from string, to ...string) Email {
+	f := Email{
+		From:    from,
+		To:      usableAddresses(to),
+		Subject: prefixSubject("Fwd:", e.Subject, "fwd:", "fw:"),
+	}
+	e.thread(&f, false)
+
+	fields := e.forwardedFields()
+
+	text := e.Body
+	if len(text) == 0 && len(e.HTMLBody) > 0 {
+		text = HTMLToText(e.HTMLBody)
+	}
+	var body bytes.Buffer
+	body.WriteString("\r\n\r\n---------- Forwarded message ----------\r\n")
+	for _, kv := range fields {
+		body.WriteString(kv[0] + ": " + kv[1] + "\r\n")
+	}
+	body.WriteString("\r\n")
+	body.Write(text)
+	f.Body = body.Bytes()
+
+	if len(e.HTMLBody) > 0 {
+		var h bytes.Buffer
+		h.WriteString("<br><br><div>---------- Forwarded message ----------<br>")
+		for _, kv := range fields {
+			h.WriteString(kv[0] + ": " + html.EscapeString(kv[1]) + "<br>")
+		}
+		h.WriteString("</div><br>")
+		h.Write(htmlBodyContent(e.HTMLBody))
+		f.HTMLBody = h.Bytes()
+	}
+	f.Attachments = append([]Attachment(nil), e.Attachments...)
+	return f
+}
+
+// ForwardAsAttachment returns a message from from to the given recipients
+// that carries e whole, as a message/rfc822 attachment, which keeps its
+// header fields and structure intact for the recipient to open.
+//
+// When e has Raw -- it was rendered or signed and kept, or parsed with
+// ParseOptions.RetainRaw -- those bytes are attached unchanged. Otherwise e
+// is rendered, which means a message parsed without RetainRaw is attached as
+// this package renders it, not as it arrived.
+func (e Email) ForwardAsAttachment(from string, to ...string) (Email, error) {
+	raw := e.Raw
+	if len(raw) == 0 {
+		var err error
+		if raw, err = RenderMessage(e); err != nil {
+			return Email{}, fmt.Errorf("forward as attachment: %w", err)
+		}
+	}
+	name := strings.TrimSpace(e.Subject)
+	if name == "" {
+		name = "forwarded message"
+	}
+	f := Email{
+		From:    from,
+		To:      usableAddresses(to),
+		Subject: prefixSubject("Fwd:", e.Subject, "fwd:", "fw:"),
+		Attachments: []Attachment{{
+			Filename:    name + ".eml",
+			ContentType: "message/rfc822",
+			Data:        raw,
+		}},
+	}
+	e.thread(&f, false)
+	return f, nil
+}
+
+// maxReferences bounds the References chain a reply carries. RFC 5322 lets it
+// grow with the thread, but some servers reject a very long header; keeping
+// the first identifier and the most recent ones is what clients do, and is
+// enough to thread by.
+const maxReferences = 20
+
+// thread sets References on r, and In-Reply-To when r is a reply to e.
+//
+// A parent with no References but an In-Reply-To holding a single identifier
+// is treated as if that were its References (RFC 5322 section 3.6.4).
+func (e Email) thread(r *Email, reply bool) {
+	id := e.MessageID()
+	if id == "" {
+		return
+	}
+	parent := "<" + id + ">"
+
+	refs := strings.Fields(e.Header("References"))
+	if len(refs) == 0 {
+		if irt := strings.Fields(e.Header("In-Reply-To")); len(irt) == 1 {
+			refs = irt
+		}
+	}
+	refs = append(refs, parent)
+	if len(refs) > maxReferences {
+		refs = append(refs[:1:1], refs[len(refs)-maxReferences+1:]...)
+	}
+
+	if reply {
+		r.SetHeader("In-Reply-To", parent)
+	}
+	r.SetHeader("References", strings.Join(refs, " "))
+}
+
+// forwardedFields are the header fields a forwarded message is introduced by.
+func (e Email) forwardedFields() [][2]string {
+	fields := [][2]string{{"From", e.From}}
+	if date := e.Header("Date"); date != "" {
+		fields = append(fields, [2]string{"Date", date})
+	}
+	fields = append(fields, [2]string{"Subject", e.Subject})
+	if len(e.To) > 0 {
+		fields = append(fields, [2]string{"To", strings.Join(e.To, ", ")})
+	}
+	if len(e.Cc) > 0 {
+		fields = append(fields, [2]string{"Cc", strings.Join(e.Cc, ", ")})
+	}
+	return fields
+}
+
+// prefixSubject puts prefix in front of subject unless it already starts with
+// one of the existing spellings, so a long thread reads "Re: x" rather than
+// "Re: Re: Re: x".
+func prefixSubject(prefix, subject string, existing ...string) string {
+	trimmed := strings.TrimSpace(subject)
+	lower := strings.ToLower(trimmed)
+	for _, p := range existing {
+		if strings.HasPrefix(lower, p) {
+			return trimmed
+		}
+	}
+	if trimmed == "" {
+		return prefix
+	}
+	return prefix + " " + trimmed
+}
+
+// quoteText writes text with each line marked as quoted. Lines already quoted
+// gain another level without a space, as mail clients nest them.
+func quoteText(w *bytes.Buffer, text []byte) {
+	text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n"))
+	text = bytes.TrimRight(text, "\n")
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		switch {
+		case len(line) == 0:
+			w.WriteString(">")
+		case line[0] == '>':
+			w.WriteString(">")
+		default:
+			w.WriteString("> ")
+		}
+		w.Write(line)
+		w.WriteString("\r\n")
+	}
+}
+
+// htmlBodyContent returns what lies inside the body element of an HTML
+// document, or the whole of it when it is a fragment, so a quoted message
+// does not nest one html element inside another.
+func htmlBodyContent(doc []byte) []byte {
+	start := indexFold(doc, "<body")
+	if start < 0 {
+		return doc
+	}
+	open := bytes.IndexByte(doc[start:], '>')
+	if open < 0 {
+		return doc
+	}
+	start += open + 1
+	end := lastIndexFold(doc, "</body")
+	if end < start {
+		end = len(doc)
+	}
+	return doc[start:end]
+}
+
+// inlineAttachments keeps the attachments quoted HTML refers to by cid:.
+func inlineAttachments(atts []Attachment) []Attachment {
+	var out []Attachment
+	for _, att := range atts {
+		if att.ContentID != "" {
+			out = append(out, att)
+		}
+	}
+	return out
+}
+
+// replyMailboxes splits a Reply-To or From that names several mailboxes,
+// which both may, into one entry each as FormatMailboxes writes them. One
+// naming a single mailbox is kept as it is written.
+func replyMailboxes(entry string) []string {
+	if mailboxes, ok := parseMailboxes(entry); ok && len(mailboxes) > 1 {
+		return FormatMailboxes([]string{entry})
+	}
+	return []string{entry}
+}
+
+// usableAddresses drops the entries of list that cannot be used as an
+// address, such as the empty From of a malformed message.
+func usableAddresses(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s = usableAddress(s); s == "" {
+			continue
+		}
+		if a, err := ParseEmailAddress(s); err != nil || a == nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
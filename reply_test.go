@@ -0,0 +1,269 @@
+package gsmail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// received is a message as a receiver hands it over: parsed, with its
+// Message-ID and thread retained.
+func received(t *testing.T) Email {
+	t.Helper()
+	e, err := ParseRawEmail([]byte("From: Ana <ana@example.com>\r\n" +
+		"To: support@example.com, bo@example.com\r\n" +
+		"Cc: Carol <carol@example.com>\r\n" +
+		"Subject: Printer on fire\r\n" +
+		"Date: Mon, 12 Oct 2026 09:30:00 +0000\r\n" +
+		"Message-ID: <m2@example.com>\r\n" +
+		"References: <m0@example.com> <m1@example.com>\r\n" +
+		"In-Reply-To: <m1@example.com>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"It is on fire.\r\n> earlier quote\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestReply(t *testing.T) {
+	r := received(t).Reply("support@example.com")
+
+	if len(r.To) != 1 || r.To[0] != "Ana <ana@example.com>" {
+		t.Errorf("to = %q", r.To)
+	}
+	if r.Subject != "Re: Printer on fire" {
+		t.Errorf("subject = %q", r.Subject)
+	}
+	if got := r.Header("In-Reply-To"); got != "<m2@example.com>" {
+		t.Errorf("In-Reply-To = %q", got)
+	}
+	if got := r.Header("References"); got != "<m0@example.com> <m1@example.com> <m2@example.com>" {
+		t.Errorf("References = %q", got)
+	}
+	want := "\r\n\r\nOn Mon, 12 Oct 2026 09:30:00 +0000, Ana <ana@example.com> wrote:\r\n" +
+		"> It is on fire.\r\n" +
+		">> earlier quote\r\n"
+	if string(r.Body) != want {
+		t.Errorf("body = %q, want %q", r.Body, want)
+	}
+
+	// Replying to the reply does not stack prefixes.
+	if again := r.Reply("ana@example.com"); again.Subject != "Re: Printer on fire" {
+		t.Errorf("subject = %q", again.Subject)
+	}
+}
+
+func TestReplyPrefersReplyTo(t *testing.T) {
+	e := received(t)
+	e.ReplyTo = "tickets@example.com"
+	if r := e.Reply("support@example.com"); len(r.To) != 1 || r.To[0] != "tickets@example.com" {
+		t.Errorf("to = %q", r.To)
+	}
+}
+
+func TestReplyToSeveralMailboxes(t *testing.T) {
+	e := received(t)
+	e.ReplyTo = "b@example.com, c@example.com"
+	if r := e.Reply("support@example.com"); strings.Join(r.To, ", ") != "b@example.com, c@example.com" {
+		t.Errorf("Reply to = %q", r.To)
+	}
+	r := e.ReplyAll("support@example.com")
+	if !strings.HasPrefix(strings.Join(r.To, ", "), "b@example.com, c@example.com, ") {
+		t.Errorf("ReplyAll to = %q", r.To)
+	}
+}
+
+func TestReplyAll(t *testing.T) {
+	r := received(t).ReplyAll("Support <SUPPORT@example.com>")
+	if strings.Join(r.To, ", ") != "Ana <ana@example.com>, bo@example.com" {
+		t.Errorf("to = %q", r.To)
+	}
+	if len(r.Cc) != 1 || r.Cc[0] != `"Carol" <carol@example.com>` {
+		t.Errorf("cc = %q", r.Cc)
+	}
+	if len(r.Bcc) != 0 {
+		t.Errorf("bcc = %q", r.Bcc)
+	}
+}
+
+// A parent with only In-Reply-To still threads; one with no Message-ID is
+// answered without threading headers rather than with empty ones.
+func TestReplyThreadingFallbacks(t *testing.T) {
+	e := Email{From: "a@example.com", Subject: "x", Headers: map[string]string{
+		"Message-Id":  "<c@example.com>",
+		"In-Reply-To": "<p@example.com>",
+	}}
+	if got := e.Reply("b@example.com").Header("References"); got != "<p@example.com> <c@example.com>" {
+		t.Errorf("References = %q", got)
+	}
+
+	r := Email{From: "a@example.com", Subject: "x"}.Reply("b@example.com")
+	if len(r.Headers) != 0 {
+		t.Errorf("headers = %v", r.Headers)
+	}
+}
+
+func TestReferencesAreBounded(t *testing.T) {
+	var refs []string
+	for i := 0; i < 40; i++ {
+		refs = append(refs, "<r"+strings.Repeat("x", i)+"@example.com>")
+	}
+	e := Email{From: "a@example.com", Headers: map[string]string{
+		"Message-Id": "<last@example.com>",
+		"References": strings.Join(refs, " "),
+	}}
+	got := strings.Fields(e.Reply("b@example.com").Header("References"))
+	if len(got) != maxReferences || got[0] != refs[0] || got[len(got)-1] != "<last@example.com>" {
+		t.Errorf("References = %d ids, first %q, last %q", len(got), got[0], got[len(got)-1])
+	}
+}
+
+func TestReplyQuotesHTML(t *testing.T) {
+	e := Email{
+		From:     "ana@example.com",
+		Subject:  "Logo",
+		HTMLBody: []byte(`<html><head><style>p{}</style></head><body class="x"><p>See <img src="cid:logo"></p></body></html>`),
+		Attachments: []Attachment{
+			{Filename: "logo.png", ContentID: "logo", Data: []byte("png")},
+			{Filename: "report.pdf", Data: []byte("pdf")},
+		},
+	}
+	r := e.Reply("b@example.com")
+	if !bytes.Contains(r.HTMLBody, []byte(`<blockquote type="cite"`)) || !bytes.Contains(r.HTMLBody, []byte(`<p>See <img src="cid:logo"></p></blockquote>`)) {
+		t.Errorf("html = %s", r.HTMLBody)
+	}
+	if bytes.Contains(r.HTMLBody, []byte("<head>")) {
+		t.Error("the quoted document's head was nested in the reply")
+	}
+	if !bytes.Contains(r.Body, []byte("> See")) {
+		t.Errorf("text quote generated from HTML missing: %q", r.Body)
+	}
+	if len(r.Attachments) != 1 || r.Attachments[0].ContentID != "logo" {
+		t.Errorf("attachments = %+v", r.Attachments)
+	}
+}
+
+// Text before <body> whose lower case has another length, such as "Ⱥ", must
+// not shift where the body is found.
+func TestReplyQuotesHTMLWithCaseChangingText(t *testing.T) {
+	e := Email{
+		From:     "ana@example.com",
+		HTMLBody: []byte("<title>" + strings.Repeat("Ⱥ", 100) + "</title><BODY><p>Quoted ȺȾ</p></BODY>"),
+	}
+	r := e.Reply("b@example.com")
+	if !bytes.Contains(r.HTMLBody, []byte("<p>Quoted ȺȾ</p></blockquote>")) || bytes.Contains(r.HTMLBody, []byte("<title>")) {
+		t.Errorf("html = %s", r.HTMLBody)
+	}
+}
+
+func TestForward(t *testing.T) {
+	e := received(t)
+	e.Attachments = []Attachment{{Filename: "photo.jpg", Data: []byte("jpg")}}
+
+	f := e.Forward("support@example.com", "facilities@example.com")
+	if f.Subject != "Fwd: Printer on fire" || len(f.To) != 1 || f.To[0] != "facilities@example.com" {
+		t.Errorf("subject %q, to %q", f.Subject, f.To)
+	}
+	if f.Header("In-Reply-To") != "" || !strings.HasSuffix(f.Header("References"), "<m2@example.com>") {
+		t.Errorf("headers = %v", f.Headers)
+	}
+	for _, want := range []string{"---------- Forwarded message ----------", "Subject: Printer on fire", "Date: Mon, 12 Oct 2026", "It is on fire."} {
+		if !bytes.Contains(f.Body, []byte(want)) {
+			t.Errorf("body lacks %q:\n%s", want, f.Body)
+		}
+	}
+	if len(f.Attachments) != 1 {
+		t.Errorf("attachments = %+v", f.Attachments)
+	}
+}
+
+func TestForwardAsAttachment(t *testing.T) {
+	f, err := received(t).ForwardAsAttachment("support@example.com", "facilities@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := RenderMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var attached *multipart.Part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(p.Header.Get("Content-Type"), "message/rfc822") {
+			attached = p
+			break
+		}
+	}
+	if attached == nil {
+		t.Fatalf("no message/rfc822 part in:\n%s", msg)
+	}
+	// RFC 2046 forbids base64 for message/rfc822.
+	if enc := attached.Header.Get("Content-Transfer-Encoding"); enc != "7bit" {
+		t.Errorf("Content-Transfer-Encoding = %q", enc)
+	}
+	inner, err := mail.ReadMessage(attached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.Header.Get("Subject") != "Printer on fire" {
+		t.Errorf("attached subject = %q", inner.Header.Get("Subject"))
+	}
+}
+
+func TestForwardAsAttachmentCanonicalizesLineEndings(t *testing.T) {
+	lf := "From: Ana <ana@example.com>\nTo: support@example.com\nSubject: Stored\nMessage-ID: <lf@example.com>\n\nKept with LF\nline endings.\n"
+	e, err := ParseRawEmailWithOptions([]byte(lf), ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := e.ForwardAsAttachment("support@example.com", "facilities@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := RenderMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(msg, []byte("\n")) - bytes.Count(msg, []byte("\r\n")); n != 0 {
+		t.Errorf("%d bare LFs in:\n%s", n, msg)
+	}
+
+	// An attachment read through Open is not base64 either.
+	opened := Attachment{
+		Filename:    "stored.eml",
+		ContentType: "message/rfc822",
+		Open:        func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(lf)), nil },
+	}
+	msg, err = RenderMessage(Email{From: "a@example.com", To: []string{"b@example.com"}, Body: []byte("x"), Attachments: []Attachment{opened}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(msg, []byte("Content-Transfer-Encoding: 7bit\r\n")) || !bytes.Contains(msg, []byte("\r\nKept with LF\r\nline endings.\r\n")) {
+		t.Errorf("opened message/rfc822 not written as it is:\n%s", msg)
+	}
+
+	// A line neither 7bit nor 8bit allows is refused rather than sent.
+	long := Attachment{Filename: "long.eml", ContentType: "message/rfc822", Data: []byte("Subject: x\r\n\r\n" + strings.Repeat("a", 999) + "\r\n")}
+	if _, err := RenderMessage(Email{From: "a@example.com", To: []string{"b@example.com"}, Body: []byte("x"), Attachments: []Attachment{long}}); err == nil || IsRetryable(err) {
+		t.Errorf("overlong line: %v", err)
+	}
+}
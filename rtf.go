@@ -0,0 +1,262 @@
+package gsmail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+)
+
+// Compressed RTF types (MS-OXRTFCP section 2.1.3.1).
+const (
+	rtfCompressed   = 0x75465A4C // "LZFu"
+	rtfUncompressed = 0x414C454D // "MELA"
+)
+
+// rtfDictionary is what the compressor's dictionary starts out holding, so
+// the RTF prologue every message begins with compresses to references.
+const rtfDictionary = `{\rtf1\ansi\mac\deff0\deftab720{\fonttbl;}{\f0\fnil \froman \fswiss \fmodern \fscript \fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\colortbl\red0\green0\blue0` +
+	"\r\n" + `\par \pard\plain\f0\fs20\b\i\u\tab\tx`
+
+// decompressRTF expands PR_RTF_COMPRESSED (MS-OXRTFCP), the form Outlook
+// stores a Rich Text body in.
+func decompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, ErrInvalidTNEF
+	}
+	compSize := binary.LittleEndian.Uint32(data)
+	rawSize := binary.LittleEndian.Uint32(data[4:])
+	body := data[16:]
+	// compSize counts the header fields after itself.
+	if compSize >= 12 && uint64(compSize-12) < uint64(len(body)) {
+		body = body[:compSize-12]
+	}
+
+	switch binary.LittleEndian.Uint32(data[8:]) {
+	case rtfUncompressed:
+		if uint64(rawSize) < uint64(len(body)) {
+			body = body[:rawSize]
+		}
+		return bytes.Clone(body), nil
+	case rtfCompressed:
+	default:
+		return nil, ErrInvalidTNEF
+	}
+
+	var dict [4096]byte
+	write := copy(dict[:], rtfDictionary)
+	out := make([]byte, 0, min(int(rawSize), 8*len(body)))
+	put := func(b byte) {
+		out = append(out, b)
+		dict[write] = b
+		write = (write + 1) % len(dict)
+	}
+
+	for i := 0; i < len(body); {
+		control := body[i]
+		i++
+		for bit := 0; bit < 8; bit++ {
+			if control&(1<<bit) == 0 {
+				if i >= len(body) {
+					return out, nil
+				}
+				put(body[i])
+				i++
+				continue
+			}
+			if i+2 > len(body) {
+				return nil, ErrInvalidTNEF
+			}
+			ref := binary.BigEndian.Uint16(body[i:])
+			i += 2
+			// A reference to the write position itself marks the end.
+			offset, length := int(ref>>4), int(ref&0xF)+2
+			if offset == write {
+				return out, nil
+			}
+			for k := 0; k < length; k++ {
+				put(dict[(offset+k)%len(dict)])
+			}
+			if len(out) > maxPartSize {
+				return nil, ErrInvalidTNEF
+			}
+		}
+	}
+	return out, nil
+}
+
+// rtfDestinations are the groups that hold document metadata rather than
+// text, and whose content is therefore never output.
+var rtfDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true,
+	"pict": true, "object": true, "fldinst": true, "filetbl": true,
+	"header": true, "headerl": true, "headerr": true, "headerf": true,
+	"footer": true, "footerl": true, "footerr": true, "footerf": true,
+	"listtable": true, "listoverridetable": true, "revtbl": true,
+	"rsidtbl": true, "generator": true, "themedata": true,
+	"colorschememapping": true, "latentstyles": true, "datastore": true,
+	"xmlnstbl": true, "pntext": true, "pntxta": true, "pntxtb": true,
+}
+
+// rtfSymbols are the control words that stand for a character.
+var rtfSymbols = map[string]string{
+	"par": "\r\n", "line": "\r\n", "tab": "\t",
+	"lquote": "‘", "rquote": "’",
+	"ldblquote": "“", "rdblquote": "”",
+	"bullet": "•", "endash": "–", "emdash": "—",
+	"enspace": "\u2002", "emspace": "\u2003",
+}
+
+// rtfGroup is the state an RTF group inherits from its parent and loses at
+// its closing brace.
+type rtfGroup struct {
+	skip     bool // inside a destination that is never output
+	suppress bool // \htmlrtf: RTF-only rendering of the HTML
+	tag      bool // inside \*\htmltag: the original HTML
+	uc       int  // fallback characters after \uN
+}
+
+// rtfToHTML recovers the HTML an HTML body was encapsulated from when Outlook
+// stored it as RTF (MS-OXRTFEX): the original markup is in \*\htmltag groups
+// and in the text outside \htmlrtf, while what lies inside \htmlrtf only
+// renders it for RTF readers. It reports false for RTF that is not
+// encapsulated HTML.
+func rtfToHTML(rtf []byte) ([]byte, bool) {
+	header, _, _ := bytes.Cut(rtf, []byte(`{\*\htmltag`))
+	if !bytes.Contains(header, []byte(`\fromhtml1`)) {
+		return nil, false
+	}
+
+	var (
+		out        bytes.Buffer
+		pending    []byte // text in charset, not yet decoded
+		charset    = "windows-1252"
+		state      = rtfGroup{uc: 1}
+		stack      []rtfGroup
+		groupStart bool
+		starred    bool
+		fallback   int
+	)
+	flush := func() {
+		if len(pending) > 0 {
+			out.Write(toUTF8(pending, charset))
+			pending = pending[:0]
+		}
+	}
+	visible := func() bool {
+		if fallback > 0 {
+			fallback--
+			return false
+		}
+		return !state.skip && !state.suppress
+	}
+	emitByte := func(b byte) {
+		if visible() {
+			pending = append(pending, b)
+		}
+	}
+	emit := func(s string) {
+		if visible() {
+			flush()
+			out.WriteString(s)
+		}
+	}
+
+	for i := 0; i < len(rtf); {
+		c := rtf[i]
+		switch {
+		case c == '{':
+			stack = append(stack, state)
+			groupStart, starred = true, false
+			i++
+			continue
+		case c == '}':
+			if len(stack) > 0 {
+				state, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+			i++
+		case c == '\r' || c == '\n':
+			i++
+			continue
+		case c != '\\':
+			emitByte(c)
+			i++
+		case i+1 >= len(rtf):
+			i++
+		case isASCIILetter(rtf[i+1]):
+			j := i + 1
+			for j < len(rtf) && isASCIILetter(rtf[j]) {
+				j++
+			}
+			word := string(rtf[i+1 : j])
+			k := j
+			if k < len(rtf) && rtf[k] == '-' {
+				k++
+			}
+			for k < len(rtf) && rtf[k] >= '0' && rtf[k] <= '9' {
+				k++
+			}
+			param, err := strconv.Atoi(string(rtf[j:k]))
+			hasParam := err == nil
+			if k < len(rtf) && rtf[k] == ' ' {
+				k++
+			}
+			i = k
+
+			if groupStart {
+				switch {
+				case starred && word == "htmltag":
+					state.tag, state.suppress = true, false
+				case starred || rtfDestinations[word]:
+					state.skip = true
+				}
+			}
+			switch word {
+			case "htmlrtf":
+				state.suppress = !hasParam || param != 0
+			case "ansicpg":
+				flush()
+				charset = codePageCharset(uint32(param))
+			case "uc":
+				state.uc = param
+			case "u":
+				if param < 0 {
+					param += 0x10000
+				}
+				emit(string(rune(param)))
+				fallback = state.uc
+			default:
+				if s, ok := rtfSymbols[word]; ok {
+					emit(s)
+				}
+			}
+		case rtf[i+1] == '*':
+			starred = true
+			i += 2
+			continue
+		case rtf[i+1] == '\'':
+			if i+4 <= len(rtf) {
+				if b, err := strconv.ParseUint(string(rtf[i+2:i+4]), 16, 8); err == nil {
+					emitByte(byte(b))
+				}
+			}
+			i += 4
+		case rtf[i+1] == '\r' || rtf[i+1] == '\n':
+			emit("\r\n")
+			i += 2
+		case rtf[i+1] == '~':
+			emit("\u00a0")
+			i += 2
+		case rtf[i+1] == '_':
+			emit("-")
+			i += 2
+		case rtf[i+1] == '\\' || rtf[i+1] == '{' || rtf[i+1] == '}':
+			emitByte(rtf[i+1])
+			i += 2
+		default:
+			i += 2
+		}
+		groupStart, starred = false, false
+	}
+	flush()
+	return out.Bytes(), true
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gsoultan/gsmail"
@@ -76,6 +77,19 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	if err := gsmail.RejectEnvelope("sendgrid", email); err != nil {
 		return err
 	}
+	if err := gsmail.RejectRaw("sendgrid", email); err != nil {
+		return err
+	}
+	email = gsmail.WithPlainText(email)
+	// The API cannot add a part to multipart/alternative, so an invitation
+	// travels as a text/calendar attachment.
+	email, err := gsmail.CalendarAsAttachment(email)
+	if err != nil {
+		return err
+	}
+	if err := gsmail.CheckAMP(email); err != nil {
+		return err
+	}
 	reqBody, err := p.buildRequest(email)
 	if err != nil {
 		return err
@@ -121,15 +135,17 @@ func (p *Sender) buildRequest(email gsmail.Email) (sendgridRequest, error) {
 		req.ReplyTo = &addr
 	}
 
+	// The API takes one object per mailbox, so a group is sent as its
+	// members.
 	pers := personalization{}
-	for _, to := range email.To {
-		pers.To = append(pers.To, parseAddress(to))
+	for _, to := range gsmail.Mailboxes(email.To) {
+		pers.To = append(pers.To, address{Email: to.Address, Name: to.Name})
 	}
-	for _, cc := range email.Cc {
-		pers.Cc = append(pers.Cc, parseAddress(cc))
+	for _, cc := range gsmail.Mailboxes(email.Cc) {
+		pers.Cc = append(pers.Cc, address{Email: cc.Address, Name: cc.Name})
 	}
-	for _, bcc := range email.Bcc {
-		pers.Bcc = append(pers.Bcc, parseAddress(bcc))
+	for _, bcc := range gsmail.Mailboxes(email.Bcc) {
+		pers.Bcc = append(pers.Bcc, address{Email: bcc.Address, Name: bcc.Name})
 	}
 	req.Personalizations = []personalization{pers}
 
@@ -146,6 +162,13 @@ func (p *Sender) buildRequest(email gsmail.Email) (sendgridRequest, error) {
 			Value: string(email.Body),
 		})
 	}
+	// The AMP part goes between the two, as in a rendered message.
+	if len(email.AMPBody) > 0 {
+		req.Content = append(req.Content, content{
+			Type:  "text/x-amp-html",
+			Value: string(email.AMPBody),
+		})
+	}
 	if len(email.HTMLBody) > 0 {
 		req.Content = append(req.Content, content{
 			Type:  "text/html",
@@ -167,8 +190,14 @@ func (p *Sender) buildRequest(email gsmail.Email) (sendgridRequest, error) {
 		if att.ContentID != "" {
 			disposition = "inline"
 		}
+		// The API takes each attachment whole, so a streamed one is read in
+		// full here.
+		data, err := att.Bytes()
+		if err != nil {
+			return sendgridRequest{}, gsmail.NonRetryable(fmt.Errorf("sendgrid: %w", err))
+		}
 		req.Attachments = append(req.Attachments, attachment{
-			Content:     base64.StdEncoding.EncodeToString(att.Data),
+			Content:     base64.StdEncoding.EncodeToString(data),
 			Type:        att.ContentType,
 			Filename:    att.Filename,
 			Disposition: disposition,
@@ -178,17 +207,31 @@ func (p *Sender) buildRequest(email gsmail.Email) (sendgridRequest, error) {
 
 	// Custom headers (List-Unsubscribe, In-Reply-To, X-*). The reserved names
 	// SendGrid generates itself are filtered out, mirroring BuildMessage.
-	hdrs, err := gsmail.CustomHeaders(email.Headers)
+	//
+	// The API takes a JSON object, one value per name, so a repeated field
+	// is refused rather than cut down to one of its values.
+	fields, err := gsmail.CustomHeaderFields(email)
 	if err != nil {
 		return sendgridRequest{}, err
 	}
-	req.Headers = hdrs
+	for _, f := range fields {
+		if req.Headers == nil {
+			req.Headers = make(map[string]string, len(fields))
+		}
+		for name := range req.Headers {
+			if strings.EqualFold(name, f.Name) {
+				return sendgridRequest{}, gsmail.NonRetryable(
+					fmt.Errorf("sendgrid: header %q is repeated, and the API takes one value per name", f.Name))
+			}
+		}
+		req.Headers[f.Name] = f.Value
+	}
 
 	return req, nil
 }
 
 func parseAddress(s string) address {
-	if a, err := gsmail.ParseEmailAddress(s); err == nil && a != nil {
+	if a, err := gsmail.ParseAddress(s); err == nil && !a.IsGroup() {
 		return address{Email: a.Address, Name: a.Name}
 	}
 	return address{Email: s}
@@ -213,3 +256,20 @@ func (p *Sender) Ping(ctx context.Context) error {
 		return nil
 	})
 }
+
+// sendgridLimits are SendGrid's: 30 MB per message including attachments
+// after encoding, and 1000 recipients, which is also the personalization
+// limit since every recipient is one entry of a single personalization.
+var sendgridLimits = gsmail.Limits{
+	MaxMessageSize: 30_000_000,
+	MaxRecipients:  1000,
+}
+
+// Limits returns what SendGrid accepts in one message.
+func (p *Sender) Limits() gsmail.Limits { return sendgridLimits }
+
+// Preflight reports, without calling SendGrid, whether it would refuse email
+// for exceeding its limits. Use gsmail.PreflightInterceptor to apply it.
+func (p *Sender) Preflight(email gsmail.Email) error {
+	return p.Limits().Check("sendgrid", email)
+}
@@ -3,9 +3,12 @@ package sendgrid
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gsoultan/gsmail"
 )
@@ -51,3 +54,124 @@ func TestSendGridSender_Send(t *testing.T) {
 		t.Fatalf("Send failed: %v", err)
 	}
 }
+
+// An invitation cannot be an alternative part through the API, so it has to
+// arrive as a text/calendar attachment carrying its method.
+func TestSendGridCalendarInvite(t *testing.T) {
+	var got sendgridRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := NewSender("test-key")
+	sender.BaseURL = server.URL
+	sender.Client = server.Client()
+
+	err := sender.Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"receiver@example.com"},
+		Subject: "Planning",
+		Body:    []byte("See you there"),
+		Calendar: &gsmail.CalendarEvent{
+			UID:   "planning-1@example.com",
+			Start: time.Date(2026, time.November, 2, 14, 0, 0, 0, time.UTC),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("expected the invitation as an attachment, got %+v", got.Attachments)
+	}
+	if att := got.Attachments[0]; att.Filename != "invite.ics" || !strings.Contains(att.Type, "method=REQUEST") {
+		t.Errorf("attachment = %q %q", att.Filename, att.Type)
+	}
+}
+
+// The AMP part is sent as content of its own type, between the text and
+// the HTML, and an invalid one is refused before the request.
+func TestSendGridAMPBody(t *testing.T) {
+	var got sendgridRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := NewSender("test-key")
+	sender.BaseURL = server.URL
+	sender.Client = server.Client()
+
+	email := gsmail.Email{
+		From:     "sender@example.com",
+		To:       []string{"receiver@example.com"},
+		Subject:  "RSVP",
+		Body:     []byte("Hi"),
+		HTMLBody: []byte("<p>Hi</p>"),
+		AMPBody:  []byte(ampDocument),
+	}
+	if err := sender.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	var types []string
+	for _, c := range got.Content {
+		types = append(types, c.Type)
+	}
+	if strings.Join(types, ",") != "text/plain,text/x-amp-html,text/html" || got.Content[1].Value != ampDocument {
+		t.Errorf("content types %v", types)
+	}
+
+	email.HTMLBody = nil
+	if err := sender.Send(context.Background(), email); !errors.Is(err, gsmail.ErrInvalidAMP) {
+		t.Errorf("AMP without HTML: %v", err)
+	}
+}
+
+const ampDocument = `<!doctype html><html ⚡4email><head><meta charset="utf-8">` +
+	`<script async src="https://cdn.ampproject.org/v0.js"></script>` +
+	`<style amp4email-boilerplate>body{visibility:hidden}</style></head><body>Hi</body></html>`
+
+// The API's headers object holds one value per name, so a repeated field is
+// refused before the request is made rather than sent with one value missing.
+func TestSendGridRepeatedHeader(t *testing.T) {
+	email := gsmail.Email{
+		From: "sender@example.com",
+		To:   []string{"receiver@example.com"},
+		Body: []byte("Hello"),
+	}
+	email.AddHeader("Comments", "one")
+	if _, err := NewSender("test-key").buildRequest(email); err != nil {
+		t.Fatalf("a single field should be accepted: %v", err)
+	}
+	email.AddHeader("comments", "two")
+	_, err := NewSender("test-key").buildRequest(email)
+	if err == nil || gsmail.IsRetryable(err) {
+		t.Errorf("expected a non-retryable error, got %v", err)
+	}
+}
+
+// The API takes a mailbox per object, so a group is sent as its members,
+// with their display names.
+func TestSendGridGroupRecipients(t *testing.T) {
+	req, err := NewSender("test-key").buildRequest(gsmail.Email{
+		From: `"Doe, John" <j@example.com>`,
+		To:   []string{"Team: Ana <a@example.com>, b@example.com;"},
+		Body: []byte("Hello"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := req.Personalizations[0].To
+	if len(to) != 2 || to[0].Name != "Ana" || to[0].Email != "a@example.com" || to[1].Email != "b@example.com" {
+		t.Errorf("to = %+v", to)
+	}
+	if req.From.Name != "Doe, John" || req.From.Email != "j@example.com" {
+		t.Errorf("from = %+v", req.From)
+	}
+}
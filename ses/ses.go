@@ -1,6 +1,7 @@
 package ses
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"sync"
@@ -103,16 +104,24 @@ func (p *Sender) Ping(ctx context.Context) error {
 //
 // A message is sent through the SES "simple" content API when it can be
 // expressed that way. Anything that cannot be — attachments, both a text and
-// an HTML body, custom headers, or DKIM signing configured on this sender — is
-// rendered locally and sent as a raw MIME message instead, so no part of the
-// Email is silently dropped.
+// an HTML body, custom headers, a calendar invitation, an AMP body, or DKIM
+// signing configured on this sender — is rendered locally and sent as a raw MIME
+// message instead, so no part of the Email is silently dropped. A message
+// that is already rendered (Email.Raw) always takes the raw path.
 func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	if err := gsmail.RejectEnvelope("ses", email); err != nil {
 		return err
 	}
-	needsRaw := len(email.Attachments) > 0 ||
+	// Filled in before choosing a path: a generated text part makes two
+	// bodies, which only the raw path can carry.
+	email = gsmail.WithPlainText(email)
+	needsRaw := len(email.Raw) > 0 ||
+		len(email.Attachments) > 0 ||
 		(len(email.Body) > 0 && len(email.HTMLBody) > 0) ||
 		len(email.Headers) > 0 ||
+		len(email.HeaderFields) > 0 ||
+		email.Calendar != nil ||
+		len(email.AMPBody) > 0 ||
 		p.DKIMConfig != nil
 
 	if needsRaw {
@@ -121,12 +130,13 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	return p.sendSimple(ctx, email)
 }
 
-// destination builds the SES envelope shared by both send paths.
+// destination builds the SES envelope shared by both send paths. It lists
+// mailboxes, so a group contributes its members.
 func destination(email gsmail.Email) *types.Destination {
 	return &types.Destination{
-		ToAddresses:  gsmail.FormatAddressList(email.To),
-		CcAddresses:  gsmail.FormatAddressList(email.Cc),
-		BccAddresses: gsmail.FormatAddressList(email.Bcc),
+		ToAddresses:  gsmail.FormatMailboxes(email.To),
+		CcAddresses:  gsmail.FormatMailboxes(email.Cc),
+		BccAddresses: gsmail.FormatMailboxes(email.Bcc),
 	}
 }
 
@@ -162,24 +172,25 @@ func (p *Sender) sendSimple(ctx context.Context, email gsmail.Email) error {
 func (p *Sender) sendRaw(ctx context.Context, email gsmail.Email) error {
 	// Render once, outside the retry loop, so every attempt carries the same
 	// Date and Message-ID and a DKIM signature is computed only once.
-	var raw []byte
-	err := gsmail.WithMessage(email, func(msg []byte) error {
-		if p.DKIMConfig != nil {
-			signed, signErr := gsmail.SignDKIM(msg, *p.DKIMConfig)
-			if signErr != nil {
-				return gsmail.NonRetryable(fmt.Errorf("dkim sign: %w", signErr))
-			}
-			raw = signed
-			return nil
-		}
-		// The slice handed to us is only valid until this callback returns.
-		raw = make([]byte, len(msg))
-		copy(raw, msg)
-		return nil
-	})
-	if err != nil {
+	//
+	// The SES API takes the raw message as one byte slice, so it has to exist
+	// in memory once. It is rendered straight into that slice, with
+	// attachments streamed from Attachment.Open, rather than into a pooled
+	// buffer that is then copied: the copy doubled the peak for every large
+	// attachment.
+	var buf bytes.Buffer
+	if err := gsmail.RenderMessageTo(&buf, email); err != nil {
 		return err
 	}
+	raw := buf.Bytes()
+
+	if p.DKIMConfig != nil {
+		signed, err := gsmail.SignDKIM(raw, *p.DKIMConfig)
+		if err != nil {
+			return gsmail.NonRetryable(fmt.Errorf("dkim sign: %w", err))
+		}
+		raw = signed
+	}
 
 	return p.send(ctx, &sesv2.SendEmailInput{
 		Destination: destination(email),
@@ -199,3 +210,29 @@ func (p *Sender) send(ctx context.Context, input *sesv2.SendEmailInput) error {
 		return nil
 	})
 }
+
+// sesLimits are the SES v2 quotas: 40 MB per message including attachments
+// after encoding, 50 recipients, and the attachment types SES refuses.
+var sesLimits = gsmail.Limits{
+	MaxMessageSize: 40_000_000,
+	MaxRecipients:  50,
+	ForbiddenExtensions: []string{
+		".ade", ".adp", ".app", ".asp", ".bas", ".bat", ".cer", ".chm", ".cmd", ".com", ".cpl", ".crt",
+		".csh", ".der", ".exe", ".fxp", ".gadget", ".hlp", ".hta", ".inf", ".ins", ".isp", ".its", ".js",
+		".jse", ".ksh", ".lib", ".lnk", ".mad", ".maf", ".mag", ".mam", ".maq", ".mar", ".mas", ".mat",
+		".mau", ".mav", ".maw", ".mda", ".mdb", ".mde", ".mdt", ".mdw", ".mdz", ".msc", ".msh", ".msh1",
+		".msh2", ".mshxml", ".msh1xml", ".msh2xml", ".msi", ".msp", ".mst", ".ops", ".pcd", ".pif", ".plg",
+		".prf", ".prg", ".reg", ".scf", ".scr", ".sct", ".shb", ".shs", ".sys", ".ps1", ".ps1xml", ".ps2",
+		".ps2xml", ".psc1", ".psc2", ".tmp", ".url", ".vb", ".vbe", ".vbs", ".vps", ".vsmacros", ".vss",
+		".vst", ".vsw", ".ws", ".wsc", ".wsf", ".wsh", ".xnk",
+	},
+}
+
+// Limits returns what SES accepts in one message.
+func (p *Sender) Limits() gsmail.Limits { return sesLimits }
+
+// Preflight reports, without calling SES, whether it would refuse email for
+// exceeding its limits. Use gsmail.PreflightInterceptor to apply it.
+func (p *Sender) Preflight(email gsmail.Email) error {
+	return p.Limits().Check("ses", email)
+}
@@ -115,6 +115,28 @@ func TestAttachmentsForceRaw(t *testing.T) {
 	}
 }
 
+// The simple API has no AMP field; the raw path carries the part.
+func TestAMPBodyForcesRaw(t *testing.T) {
+	s, got := newSender(t)
+
+	email := base()
+	email.Body = nil
+	email.HTMLBody = []byte("<p>hello</p>")
+	email.AMPBody = []byte(`<!doctype html><html amp4email><head><meta charset="utf-8">` +
+		`<script async src="https://cdn.ampproject.org/v0.js"></script>` +
+		`<style amp4email-boilerplate>body{visibility:hidden}</style></head><body>hello</body></html>`)
+
+	if err := s.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got.Content.Raw == nil {
+		t.Fatal("expected the raw content path for an AMP body")
+	}
+	if !strings.Contains(string(got.Content.Raw.Data), "Content-Type: text/x-amp-html") {
+		t.Errorf("AMP part missing from the raw message:\n%s", got.Content.Raw.Data)
+	}
+}
+
 // DKIMConfig used to apply only on the raw branch, so a plain message went out
 // silently unsigned.
 func TestDKIMConfigForcesRawAndSigns(t *testing.T) {
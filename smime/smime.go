@@ -0,0 +1,213 @@
+// Package smime signs and encrypts outgoing mail with S/MIME, and verifies and
+// decrypts it on the way in.
+//
+// Sign and Encrypt take a gsmail.Email and return one whose Raw field holds
+// the protected message. The rest of the Email is left as it was, because the
+// sender still needs the addresses for the envelope; Raw is what goes on the
+// wire. That makes them composable with anything that also produces an Email,
+// and usable from a send pipeline through SignInterceptor and
+// EncryptInterceptor:
+//
+//	sender := gsmail.WrapSender(smtpSender,
+//		smime.SignInterceptor(signer),    // outermost, so it runs first
+//		smime.EncryptInterceptor(lookup), // encrypts the signed message
+//	)
+//
+// Only transports that send a rendered message carry the result -- SMTP and
+// SES. The API providers build their request from the typed fields, which
+// would discard the signature, so they refuse a message with Raw set.
+//
+// On the receiving side, Unwrapper plugs into gsmail.ParseRawEmailWithOptions.
+package smime
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// Scheme is the value of gsmail.Protection.Scheme for layers this package
+// removes.
+const Scheme = "S/MIME"
+
+// Signer is the identity a message is signed with.
+type Signer struct {
+	// Certificate is the signing certificate. Its email address should match
+	// the From address, or receiving clients will warn that the message was
+	// signed by someone else.
+	Certificate *x509.Certificate
+	// Key is the private key for Certificate. An RSA or ECDSA key from
+	// crypto/rsa or crypto/ecdsa works, as does anything implementing
+	// crypto.Signer, such as a key held in an HSM.
+	Key crypto.Signer
+	// Chain holds the intermediate certificates between Certificate and a
+	// root. They are embedded in the signature so a recipient who has only
+	// the root can still verify it.
+	Chain []*x509.Certificate
+}
+
+// ErrNoCertificate is returned when a Signer has no certificate or key, or
+// when Encrypt is given no recipients.
+var ErrNoCertificate = errors.New("smime: no certificate")
+
+// Sign returns email with Raw set to a multipart/signed message (RFC 8551):
+// the rendered message, unchanged, beside a detached PKCS#7 signature over it.
+//
+// A detached signature leaves the content readable by clients that do not
+// understand S/MIME; they show the signature as an attachment called
+// smime.p7s. If email already has Raw set, that message is the one signed.
+//
+// The header fields outside the MIME entity -- From, To, Subject and the rest
+// -- are not covered by the signature, as is usual for S/MIME.
+//
+// Errors are permanent: retrying will not make a bad key sign.
+func Sign(email gsmail.Email, signer Signer) (gsmail.Email, error) {
+	if signer.Certificate == nil || signer.Key == nil {
+		return gsmail.Email{}, gsmail.NonRetryable(ErrNoCertificate)
+	}
+	raw, err := rendered(email)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	outer, entity := mimeentity.Extract(raw)
+	content := mimeentity.Canonicalize(mimeentity.TrimTrailingLineBreaks(entity))
+
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("smime: sign: %w", err))
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(signer.Certificate, signer.Key, signer.Chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("smime: sign: %w", err))
+	}
+	sd.Detach()
+	der, err := sd.Finish()
+	if err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("smime: sign: %w", err))
+	}
+
+	boundary, err := mimeentity.Boundary("smime-")
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	var sig bytes.Buffer
+	sig.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	sig.WriteString("Content-Transfer-Encoding: base64\r\n")
+	sig.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	writeBase64(&sig, der)
+
+	var msg bytes.Buffer
+	msg.Write(outer)
+	fmt.Fprintf(&msg, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"%s\"\r\n\r\n", boundary)
+	msg.Write(mimeentity.Multipart(boundary, content, mimeentity.TrimTrailingLineBreaks(sig.Bytes())))
+
+	email.Raw = msg.Bytes()
+	return email, nil
+}
+
+// Encrypt returns email with Raw set to an application/pkcs7-mime
+// enveloped-data message that only the holders of recipients' keys can read.
+//
+// Include the sender's own certificate if the sent copy should stay readable:
+// nobody else can decrypt it, the sender included. Every certificate must
+// carry an RSA key; the content is encrypted with AES-256-CBC, which every
+// current S/MIME client reads.
+//
+// To sign and encrypt, Sign first and Encrypt the result, so the signature is
+// protected too and a recipient can verify it only after decrypting.
+func Encrypt(email gsmail.Email, recipients []*x509.Certificate) (gsmail.Email, error) {
+	if len(recipients) == 0 {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("%w: no recipients to encrypt to", ErrNoCertificate))
+	}
+	raw, err := rendered(email)
+	if err != nil {
+		return gsmail.Email{}, err
+	}
+
+	outer, entity := mimeentity.Extract(raw)
+	der, err := envelope(mimeentity.Canonicalize(entity), recipients)
+	if err != nil {
+		return gsmail.Email{}, gsmail.NonRetryable(fmt.Errorf("smime: encrypt: %w", err))
+	}
+
+	var msg bytes.Buffer
+	msg.Write(outer)
+	msg.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n")
+	msg.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	writeBase64(&msg, der)
+
+	email.Raw = msg.Bytes()
+	return email, nil
+}
+
+// SignInterceptor returns a send interceptor that signs every message with
+// signer before passing it on.
+func SignInterceptor(signer Signer) gsmail.SendInterceptor {
+	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
+		signed, err := Sign(email, signer)
+		if err != nil {
+			return err
+		}
+		return next(ctx, signed)
+	}
+}
+
+// CertificateLookup returns the certificates a message should be encrypted to,
+// typically by looking up each recipient in a directory.
+//
+// It should fail rather than return a partial list: a recipient left out
+// receives a message they cannot open.
+type CertificateLookup func(ctx context.Context, email gsmail.Email) ([]*x509.Certificate, error)
+
+// EncryptInterceptor returns a send interceptor that encrypts every message to
+// the certificates lookup returns for it.
+func EncryptInterceptor(lookup CertificateLookup) gsmail.SendInterceptor {
+	return func(ctx context.Context, email gsmail.Email, next func(context.Context, gsmail.Email) error) error {
+		certs, err := lookup(ctx, email)
+		if err != nil {
+			return fmt.Errorf("smime: certificate lookup: %w", err)
+		}
+		encrypted, err := Encrypt(email, certs)
+		if err != nil {
+			return err
+		}
+		return next(ctx, encrypted)
+	}
+}
+
+// rendered returns the message an operation applies to: Raw if a previous step
+// produced it, otherwise the Email rendered as usual.
+func rendered(email gsmail.Email) ([]byte, error) {
+	if len(email.Raw) > 0 {
+		return email.Raw, nil
+	}
+	raw, err := gsmail.RenderMessage(email)
+	if err != nil {
+		return nil, gsmail.NonRetryable(fmt.Errorf("smime: render: %w", err))
+	}
+	return raw, nil
+}
+
+// writeBase64 writes data base64 encoded in 76-character lines, as RFC 2045
+// requires of a transfer encoding.
+func writeBase64(b *bytes.Buffer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		b.WriteString(enc[:76])
+		b.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,261 @@
+package smime
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/gsmailtest"
+)
+
+type identity struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// newIdentity issues a self-signed certificate for address, and a pool that
+// trusts it.
+func newIdentity(t *testing.T, address string) identity {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: address},
+		EmailAddresses:        []string{address},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return identity{cert: cert, key: key, pool: pool}
+}
+
+func (id identity) signer() Signer { return Signer{Certificate: id.cert, Key: id.key} }
+
+func testEmail() gsmail.Email {
+	return gsmail.Email{
+		From:     "alice@example.com",
+		To:       []string{"bob@example.com"},
+		Subject:  "Quarterly figures",
+		Body:     []byte("Figures attached.\n"),
+		HTMLBody: []byte("<p>Figures attached.</p>"),
+		Attachments: []gsmail.Attachment{{
+			Filename:    "q3.csv",
+			ContentType: "text/csv",
+			Data:        []byte("region,total\nnorth,42\n"),
+		}},
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	alice := newIdentity(t, "alice@example.com")
+
+	signed, err := Sign(testEmail(), alice.signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(signed.Raw, []byte("multipart/signed")) {
+		t.Fatalf("expected a multipart/signed message, got:\n%s", signed.Raw)
+	}
+	if signed.From != "alice@example.com" || len(signed.To) != 1 {
+		t.Error("the address fields must survive signing; the sender needs them for the envelope")
+	}
+
+	got, err := gsmail.ParseRawEmailWithOptions(signed.Raw, gsmail.ParseOptions{
+		Unwrappers: []gsmail.Unwrapper{Unwrapper{Roots: alice.pool}},
+	})
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if got.Subject != "Quarterly figures" {
+		t.Errorf("subject = %q", got.Subject)
+	}
+	if string(got.HTMLBody) != "<p>Figures attached.</p>" {
+		t.Errorf("html body = %q", got.HTMLBody)
+	}
+	// The signature was consumed by verification; only the real attachment
+	// should remain.
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "q3.csv" {
+		t.Errorf("attachments = %+v", got.Attachments)
+	}
+	want := gsmail.Protection{Scheme: Scheme, Signed: true, Signer: "alice@example.com"}
+	if len(got.Protections) != 1 || got.Protections[0] != want {
+		t.Errorf("protections = %+v, want [%+v]", got.Protections, want)
+	}
+}
+
+// Some stores rewrite CRLF to LF. The signature is over the canonical form, so
+// the message must still verify once read back.
+func TestSignedMessageSurvivesLineEndingRewrite(t *testing.T) {
+	alice := newIdentity(t, "alice@example.com")
+	signed, err := Sign(testEmail(), alice.signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lf := bytes.ReplaceAll(signed.Raw, []byte("\r\n"), []byte("\n"))
+	if _, _, ok, err := (Unwrapper{Roots: alice.pool}).Unwrap(lf); !ok || err != nil {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSignatureRejectsTampering(t *testing.T) {
+	alice := newIdentity(t, "alice@example.com")
+	signed, err := Sign(gsmail.Email{
+		From:    "alice@example.com",
+		To:      []string{"bob@example.com"},
+		Subject: "Payment",
+		Body:    []byte("Pay account 1111.\n"),
+	}, alice.signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is base64; "MTExMS4K" is "1111.\n" and "OTk5OS4K" is "9999.\n".
+	tampered := bytes.Replace(signed.Raw, []byte("MTExMS4K"), []byte("OTk5OS4K"), 1)
+	if bytes.Equal(tampered, signed.Raw) {
+		t.Fatal("test did not alter the signed content")
+	}
+	_, err = gsmail.ParseRawEmailWithOptions(tampered, gsmail.ParseOptions{
+		Unwrappers: []gsmail.Unwrapper{Unwrapper{Roots: alice.pool}},
+	})
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("expected ErrVerify, got %v", err)
+	}
+}
+
+func TestSignatureFromUntrustedSigner(t *testing.T) {
+	mallory := newIdentity(t, "mallory@example.com")
+	alice := newIdentity(t, "alice@example.com")
+
+	signed, err := Sign(testEmail(), mallory.signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = Unwrapper{Roots: alice.pool}.Unwrap(signed.Raw)
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("a certificate that does not chain to a trusted root must not verify, got %v", err)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	bob := newIdentity(t, "bob@example.com")
+
+	encrypted, err := Encrypt(testEmail(), []*x509.Certificate{bob.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted.Raw, []byte("q3.csv")) {
+		t.Fatal("the encrypted message contains the plaintext")
+	}
+	if !bytes.Contains(encrypted.Raw, []byte("Subject: Quarterly figures")) {
+		t.Error("the outer header fields should stay readable")
+	}
+
+	got, err := gsmail.ParseRawEmailWithOptions(encrypted.Raw, gsmail.ParseOptions{
+		Unwrappers: []gsmail.Unwrapper{Unwrapper{Certificate: bob.cert, Key: bob.key}},
+	})
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if string(got.Body) != "Figures attached.\n" && string(got.Body) != "Figures attached.\r\n" {
+		t.Errorf("body = %q", got.Body)
+	}
+	if len(got.Protections) != 1 || !got.Protections[0].Encrypted {
+		t.Errorf("protections = %+v", got.Protections)
+	}
+}
+
+// Without a key the message still parses, as a mail client without one shows
+// it: an opaque smime.p7m attachment and nothing else.
+func TestEncryptedMessageWithoutKey(t *testing.T) {
+	bob := newIdentity(t, "bob@example.com")
+	encrypted, err := Encrypt(testEmail(), []*x509.Certificate{bob.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := gsmail.ParseRawEmail(encrypted.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.Body) != 0 {
+		t.Errorf("an encrypted message has no readable body, got %q", plain.Body)
+	}
+	if len(plain.Attachments) != 1 || plain.Attachments[0].Filename != "smime.p7m" {
+		t.Errorf("attachments = %+v", plain.Attachments)
+	}
+
+	_, _, _, err = Unwrapper{Roots: bob.pool}.Unwrap(encrypted.Raw)
+	if !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestEncryptRejectsNoRecipients(t *testing.T) {
+	_, err := Encrypt(testEmail(), nil)
+	if !errors.Is(err, ErrNoCertificate) || gsmail.IsRetryable(err) {
+		t.Errorf("expected a permanent ErrNoCertificate, got %v", err)
+	}
+}
+
+// Signing and then encrypting through the interceptors yields a message a
+// single parse opens and verifies, reporting both layers outermost first.
+func TestInterceptorsSignThenEncrypt(t *testing.T) {
+	alice := newIdentity(t, "alice@example.com")
+	bob := newIdentity(t, "bob@example.com")
+
+	recorder := gsmailtest.NewSender()
+	sender := gsmail.WrapSender(recorder,
+		SignInterceptor(alice.signer()),
+		EncryptInterceptor(func(context.Context, gsmail.Email) ([]*x509.Certificate, error) {
+			return []*x509.Certificate{bob.cert}, nil
+		}),
+	)
+	if err := sender.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := recorder.MustLast(t)
+	if !strings.Contains(string(sent.Raw), "smime-type=enveloped-data") {
+		t.Fatalf("the outermost layer should be the encryption, got:\n%s", sent.Raw)
+	}
+
+	got, err := gsmail.ParseRawEmailWithOptions(sent.Raw, gsmail.ParseOptions{
+		Unwrappers: []gsmail.Unwrapper{Unwrapper{Certificate: bob.cert, Key: bob.key, Roots: alice.pool}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Protections) != 2 || !got.Protections[0].Encrypted || got.Protections[1].Signer != "alice@example.com" {
+		t.Errorf("protections = %+v", got.Protections)
+	}
+	if string(got.HTMLBody) != "<p>Figures attached.</p>" {
+		t.Errorf("html body = %q", got.HTMLBody)
+	}
+}
@@ -0,0 +1,191 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/gsoultan/gsmail"
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// Unwrapper verifies and decrypts S/MIME messages. Pass it to
+// gsmail.ParseRawEmailWithOptions:
+//
+//	email, err := gsmail.ParseRawEmailWithOptions(raw, gsmail.ParseOptions{
+//		Unwrappers: []gsmail.Unwrapper{smime.Unwrapper{Certificate: cert, Key: key}},
+//	})
+//
+// It recognises multipart/signed with a detached signature, and
+// application/pkcs7-mime carrying either signed or enveloped data, in both
+// the registered and the older x-pkcs7 spellings.
+//
+// A signature proves the message was signed by the holder of the certificate
+// in Protection.Signer; it does not prove that person is the one in From.
+// Compare the two if that matters, as mail clients do.
+type Unwrapper struct {
+	// Certificate and Key decrypt messages encrypted to Certificate. Only RSA
+	// keys are supported. Leave them nil to verify signatures only; an
+	// encrypted message is then an error.
+	Certificate *x509.Certificate
+	Key         crypto.PrivateKey
+
+	// Roots are the certificate authorities a signer's certificate must chain
+	// to. Nil means the system pool.
+	Roots *x509.CertPool
+}
+
+var (
+	// ErrVerify is returned when a signature does not verify, or its
+	// certificate does not chain to a trusted root.
+	ErrVerify = errors.New("smime: signature verification failed")
+	// ErrNoKey is returned for an encrypted message when the Unwrapper has no
+	// certificate and key to decrypt it with.
+	ErrNoKey = errors.New("smime: message is encrypted and no decryption key is configured")
+	// ErrMalformed is returned for a message that claims to be S/MIME but
+	// does not have the structure S/MIME requires.
+	ErrMalformed = errors.New("smime: malformed message")
+)
+
+// Unwrap implements gsmail.Unwrapper.
+func (u Unwrapper) Unwrap(raw []byte) ([]byte, gsmail.Protection, bool, error) {
+	header, body := mimeentity.Split(raw)
+	fields := mimeentity.Header(header)
+
+	mediaType, params, err := mime.ParseMediaType(fields.Get("Content-Type"))
+	if err != nil {
+		return nil, gsmail.Protection{}, false, nil
+	}
+
+	switch {
+	case mediaType == "multipart/signed" && isPKCS7Signature(params["protocol"]):
+		return u.verifyDetached(raw, body, params["boundary"])
+	case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+		der, err := decodeBase64(fields.Get("Content-Transfer-Encoding"), body)
+		if err != nil {
+			return nil, gsmail.Protection{}, false, err
+		}
+		return u.openOpaque(raw, der)
+	}
+	return nil, gsmail.Protection{}, false, nil
+}
+
+// verifyDetached checks a multipart/signed message and returns the message
+// that was signed.
+func (u Unwrapper) verifyDetached(raw, body []byte, boundary string) ([]byte, gsmail.Protection, bool, error) {
+	parts, err := mimeentity.Parts(body, boundary)
+	if err != nil || len(parts) != 2 {
+		return nil, gsmail.Protection{}, false, ErrMalformed
+	}
+	content := parts[0]
+
+	sigHeader, sigBody := mimeentity.Split(parts[1])
+	der, err := decodeBase64(mimeentity.Header(sigHeader).Get("Content-Transfer-Encoding"), sigBody)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, err
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	// The signature is over the canonical form, so a message whose line
+	// endings were rewritten in storage still verifies.
+	p7.Content = mimeentity.Canonicalize(content)
+
+	signer, err := u.verify(p7)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, err
+	}
+	outer, _ := mimeentity.Extract(raw)
+	return mimeentity.Join(outer, content), gsmail.Protection{Scheme: Scheme, Signed: true, Signer: signer}, true, nil
+}
+
+// openOpaque handles application/pkcs7-mime, which carries either signed data
+// with the content inside it or enveloped data.
+func (u Unwrapper) openOpaque(raw, der []byte) ([]byte, gsmail.Protection, bool, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	outer, _ := mimeentity.Extract(raw)
+
+	if len(p7.Signers) > 0 {
+		signer, err := u.verify(p7)
+		if err != nil {
+			return nil, gsmail.Protection{}, false, err
+		}
+		return mimeentity.Join(outer, p7.Content), gsmail.Protection{Scheme: Scheme, Signed: true, Signer: signer}, true, nil
+	}
+
+	if u.Certificate == nil || u.Key == nil {
+		return nil, gsmail.Protection{}, false, ErrNoKey
+	}
+	content, err := p7.Decrypt(u.Certificate, u.Key)
+	if err != nil {
+		return nil, gsmail.Protection{}, false, fmt.Errorf("smime: decrypt: %w", err)
+	}
+	return mimeentity.Join(outer, content), gsmail.Protection{Scheme: Scheme, Encrypted: true}, true, nil
+}
+
+// verify checks every signature on p7 and the signer's chain of trust,
+// returning who signed.
+func (u Unwrapper) verify(p7 *pkcs7.PKCS7) (string, error) {
+	roots := u.Roots
+	if roots == nil {
+		var err error
+		if roots, err = x509.SystemCertPool(); err != nil {
+			return "", fmt.Errorf("smime: load system roots: %w", err)
+		}
+	}
+	if err := p7.VerifyWithChain(roots); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrVerify, err)
+	}
+	return signerName(p7.GetOnlySigner()), nil
+}
+
+// signerName names a signer the way mail clients do: by the email address in
+// the certificate, or its common name when it has none.
+func signerName(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.CommonName
+}
+
+func isPKCS7Signature(protocol string) bool {
+	p := strings.ToLower(protocol)
+	return p == "application/pkcs7-signature" || p == "application/x-pkcs7-signature"
+}
+
+// decodeBase64 decodes a body sent with the given transfer encoding. S/MIME
+// bodies are binary, so base64 is all that is accepted besides an unencoded
+// binary body.
+func decodeBase64(encoding string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		compact := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, body)
+		out := make([]byte, base64.StdEncoding.DecodedLen(len(compact)))
+		n, err := base64.StdEncoding.Decode(out, compact)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return out[:n], nil
+	case "", "binary", "8bit", "7bit":
+		return body, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected transfer encoding %q", ErrMalformed, encoding)
+}
@@ -14,14 +14,21 @@ import (
 
 // capture records what a single SMTP conversation asked the server to do.
 type capture struct {
-	rcptTo []string
-	data   string
+	mailFrom string
+	rcptTo   []string
+	data     string
 }
 
 // serveOnce answers one SMTP conversation and records RCPT TO and the message
 // body, which is what distinguishes the envelope from the headers.
 func serveOnce(t *testing.T) (host string, port int, got *capture, done chan struct{}) {
 	t.Helper()
+	return serveOnceWith(t)
+}
+
+// serveOnceWith is serveOnce from a server advertising the given extensions.
+func serveOnceWith(t *testing.T, extensions ...string) (host string, port int, got *capture, done chan struct{}) {
+	t.Helper()
 
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -56,8 +63,12 @@ func serveOnce(t *testing.T) (host string, port int, got *capture, done chan str
 			switch {
 			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
 				_ = writer.PrintfLine("250-localhost")
+				for _, ext := range extensions {
+					_ = writer.PrintfLine("250-%s", ext)
+				}
 				_ = writer.PrintfLine("250 OK")
 			case strings.HasPrefix(line, "MAIL FROM:"):
+				got.mailFrom = strings.TrimPrefix(line, "MAIL FROM:")
 				_ = writer.PrintfLine("250 OK")
 			case strings.HasPrefix(line, "RCPT TO:"):
 				addr := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<> ")
@@ -119,6 +130,30 @@ func TestWithoutEnvelopeEveryNamedAddressIsDelivered(t *testing.T) {
 	}
 }
 
+// A group is a way of writing the To header; its members are the recipients.
+func TestGroupIsDeliveredToItsMembers(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	err := NewSender(host, port, "", "", false).Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{`"Sales, EU": Ana <a@example.com>, b@example.com;`},
+		Bcc:     []string{"undisclosed-recipients:;", "c@example.com"},
+		Subject: "Hi",
+		Body:    []byte("hello"),
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-done
+
+	if strings.Join(got.rcptTo, " ") != "a@example.com b@example.com c@example.com" {
+		t.Errorf("RCPT TO %v", got.rcptTo)
+	}
+	if to := headerLine(got.data, "To"); to != `"Sales, EU": "Ana" <a@example.com>, b@example.com;` {
+		t.Errorf("To header = %q", to)
+	}
+}
+
 // The point of the field: one copy per recipient, with the headers still
 // describing the whole audience. Without this, showing a Cc list would deliver
 // a copy to every Cc address for every recipient.
@@ -192,3 +227,36 @@ func TestAnEmptyEnvelopeWithNoAddressesIsRejected(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+// A message parsed with RetainRaw is redirected as it arrived: the envelope
+// names the new recipient, and the bytes are the original's, header fields
+// the typed ones do not model included.
+func TestRetainedMessageIsResentUnmodified(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	original := "Received: from mx.example.net by mail.example.com\r\n" +
+		"From: Ana <ana@example.com>\r\n" +
+		"To: support@example.com\r\n" +
+		"Subject: Printer\r\n" +
+		"Message-ID: <m1@example.com>\r\n" +
+		"X-Odd:   spacing kept\r\n" +
+		"\r\n" +
+		"It is on fire.\r\n"
+	email, err := gsmail.ParseRawEmailWithOptions([]byte(original), gsmail.ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email.Envelope = []string{"facilities@example.com"}
+
+	if err := NewSender(host, port, "", "", false).Send(context.Background(), email); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-done
+
+	if len(got.rcptTo) != 1 || got.rcptTo[0] != "facilities@example.com" {
+		t.Errorf("envelope = %v", got.rcptTo)
+	}
+	if want := strings.ReplaceAll(original, "\r\n", "\n"); got.data != want {
+		t.Errorf("message changed in transit:\n%s\nwant:\n%s", got.data, want)
+	}
+}
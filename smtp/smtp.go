@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/smtp"
 	"net/textproto"
@@ -113,66 +114,94 @@ func (p *Sender) Send(ctx context.Context, email gsmail.Email) error {
 	if len(email.Envelope) > 0 {
 		recipients = append(recipients, email.Envelope...)
 	} else {
+		// A group in a header field is delivered to its members.
 		recipients = make([]string, 0, len(email.To)+len(email.Cc)+len(email.Bcc))
-		recipients = append(recipients, email.To...)
-		recipients = append(recipients, email.Cc...)
-		recipients = append(recipients, email.Bcc...)
+		for _, list := range [][]string{email.To, email.Cc, email.Bcc} {
+			for _, a := range gsmail.Mailboxes(list) {
+				recipients = append(recipients, a.Address)
+			}
+		}
 	}
 	if len(recipients) == 0 {
 		return gsmail.NonRetryable(fmt.Errorf("smtp: message has no recipients"))
 	}
+	env := envelope{from: email.From, to: recipients, smtputf8: needsSMTPUTF8(email, recipients)}
 
-	// The message is rendered once, outside the retry loop, so every attempt
-	// carries the same Date and Message-ID. WithMessage propagates a render
-	// failure (an invalid header name, a pinned Content-Type that conflicts
-	// with a multipart body) instead of sending a truncated message.
+	// Without DKIM the message is streamed into the DATA command, so an
+	// attachment backed by Attachment.Open goes from its source to the socket
+	// without ever being held whole. It is prepared once, outside the retry
+	// loop, so every attempt carries the same Date and Message-ID even though
+	// each one renders afresh.
+	//
+	// A DKIM signature covers the body hash, which needs the whole message,
+	// so that path renders once into memory and sends the signed bytes.
+	// WithMessage propagates a render failure (an invalid header name, a
+	// pinned Content-Type that conflicts with a multipart body) instead of
+	// sending a truncated message.
+	if p.DKIMConfig == nil {
+		msg, err := gsmail.PrepareMessage(email)
+		if err != nil {
+			return err
+		}
+		return p.sendMessage(ctx, addr, env, msg)
+	}
 	return gsmail.WithMessage(email, func(msg []byte) error {
-		if p.DKIMConfig != nil {
-			signed, err := gsmail.SignDKIM(msg, *p.DKIMConfig)
-			if err != nil {
-				return gsmail.NonRetryable(fmt.Errorf("dkim sign: %w", err))
-			}
-			msg = signed
+		signed, err := gsmail.SignDKIM(msg, *p.DKIMConfig)
+		if err != nil {
+			return gsmail.NonRetryable(fmt.Errorf("dkim sign: %w", err))
 		}
+		return p.sendMessage(ctx, addr, env, rawMessage(signed))
+	})
+}
 
-		return gsmail.Retry(ctx, p.GetRetryConfig(), func() error {
-			if p.Pool != nil {
-				client, err := p.Pool.Get(ctx)
-				if err != nil {
-					return err
-				}
-				err = p.sendOnClient(client, email.From, recipients, msg)
-				p.Pool.Put(client, err)
+// rawMessage is an already rendered message. Unlike a bytes.Reader it can be
+// written any number of times, which a retry needs.
+type rawMessage []byte
+
+func (m rawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+// sendMessage delivers msg with retries, over the pool when one is enabled.
+func (p *Sender) sendMessage(ctx context.Context, addr string, env envelope, msg io.WriterTo) error {
+	return gsmail.Retry(ctx, p.GetRetryConfig(), func() error {
+		if p.Pool != nil {
+			client, err := p.Pool.Get(ctx)
+			if err != nil {
 				return err
 			}
+			err = p.sendOnClient(client, env, msg)
+			p.Pool.Put(client, err)
+			return err
+		}
 
-			// Build auth on demand so a rotating token is refreshed per attempt.
-			var auth smtp.Auth
-			var isOAuth bool
-			if p.AuthMethod == gsmail.AuthXOAUTH2 || p.AuthMethod == gsmail.AuthOAUTHBEARER {
-				isOAuth = true
-				if p.TokenSource == nil {
-					return gsmail.NonRetryable(fmt.Errorf("oauth2 token source is nil"))
-				}
-				tok, err := p.TokenSource(ctx)
-				if err != nil {
-					return fmt.Errorf("token source: %w", err)
-				}
-				if p.AuthMethod == gsmail.AuthXOAUTH2 {
-					auth = gsmail.NewXOAUTH2Auth(p.Username, tok)
-				} else {
-					auth = gsmail.NewOAuthBearerAuth(p.Username, tok)
-				}
-			} else if p.Username != "" {
-				auth = smtp.PlainAuth("", p.Username, p.Password, p.Host)
+		// Build auth on demand so a rotating token is refreshed per attempt.
+		var auth smtp.Auth
+		var isOAuth bool
+		if p.AuthMethod == gsmail.AuthXOAUTH2 || p.AuthMethod == gsmail.AuthOAUTHBEARER {
+			isOAuth = true
+			if p.TokenSource == nil {
+				return gsmail.NonRetryable(fmt.Errorf("oauth2 token source is nil"))
 			}
-
-			if p.SSL {
-				return p.sendWithSSL(ctx, addr, auth, email.From, recipients, msg)
+			tok, err := p.TokenSource(ctx)
+			if err != nil {
+				return fmt.Errorf("token source: %w", err)
 			}
+			if p.AuthMethod == gsmail.AuthXOAUTH2 {
+				auth = gsmail.NewXOAUTH2Auth(p.Username, tok)
+			} else {
+				auth = gsmail.NewOAuthBearerAuth(p.Username, tok)
+			}
+		} else if p.Username != "" {
+			auth = smtp.PlainAuth("", p.Username, p.Password, p.Host)
+		}
+
+		if p.SSL {
+			return p.sendWithSSL(ctx, addr, auth, env, msg)
+		}
 
-			return p.sendPlain(ctx, addr, auth, email.From, recipients, msg, isOAuth)
-		})
+		return p.sendPlain(ctx, addr, auth, env, msg, isOAuth)
 	})
 }
 
@@ -291,21 +320,59 @@ func classify(err error) error {
 	return err
 }
 
-func (p *Sender) sendOnClient(client *smtp.Client, from string, to []string, msg []byte) error {
-	f, _ := gsmail.ParseEmailAddress(from)
-	if f != nil {
-		from = f.Address
+// envelope is who a message is from and to in the SMTP transaction, and
+// whether it needs SMTPUTF8 to be carried at all.
+type envelope struct {
+	from     string
+	to       []string
+	smtputf8 bool
+}
+
+// needsSMTPUTF8 reports whether the message names an address with a
+// non-ASCII local part, in its envelope or in a header. Such an address has
+// no ASCII form, so the whole transaction must be SMTPUTF8.
+func needsSMTPUTF8(email gsmail.Email, recipients []string) bool {
+	for _, addr := range append([]string{email.From, email.ReplyTo}, recipients...) {
+		if gsmail.RequiresSMTPUTF8(addr) {
+			return true
+		}
+	}
+	for _, a := range gsmail.Mailboxes(append(append([]string(nil), email.To...), email.Cc...)) {
+		if gsmail.RequiresSMTPUTF8(a.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+// envelopeAddress is the addr-spec of s as it goes in MAIL FROM or RCPT TO,
+// with an internationalized domain in its ASCII form.
+func envelopeAddress(s string) string {
+	if a, _ := gsmail.ParseEmailAddress(s); a != nil {
+		s = a.Address
+	}
+	if ascii, err := gsmail.ASCIIAddress(s); err == nil {
+		return ascii
+	}
+	return s
+}
+
+func (p *Sender) sendOnClient(client *smtp.Client, env envelope, msg io.WriterTo) error {
+	// Checked before MAIL, so a server that cannot carry the message is told
+	// nothing rather than left with a half-open transaction. Client.Mail adds
+	// the SMTPUTF8 parameter itself whenever the server offers it.
+	if env.smtputf8 {
+		if ok, _ := client.Extension("SMTPUTF8"); !ok {
+			return gsmail.NonRetryable(fmt.Errorf("smtp: %s: %w", p.Host, gsmail.ErrSMTPUTF8Required))
+		}
 	}
 
-	if err := client.Mail(from); err != nil {
+	if err := client.Mail(envelopeAddress(env.from)); err != nil {
 		return classify(fmt.Errorf("smtp mail from: %w", err))
 	}
 
-	for _, t := range to {
-		rcpt := t
-		if a, _ := gsmail.ParseEmailAddress(t); a != nil {
-			rcpt = a.Address
-		}
+	for _, t := range env.to {
+		rcpt := envelopeAddress(t)
 		if err := client.Rcpt(rcpt); err != nil {
 			return classify(fmt.Errorf("smtp rcpt to %s: %w", t, err))
 		}
@@ -314,14 +381,17 @@ func (p *Sender) sendOnClient(client *smtp.Client, from string, to []string, msg
 	return p.writeData(client, msg)
 }
 
-func (p *Sender) writeData(client *smtp.Client, msg []byte) error {
+func (p *Sender) writeData(client *smtp.Client, msg io.WriterTo) error {
 	w, err := client.Data()
 	if err != nil {
 		return classify(fmt.Errorf("smtp data: %w", err))
 	}
 
-	if _, err = w.Write(msg); err != nil {
-		_ = w.Close()
+	// Closing the DATA writer is what commits the message, so a render that
+	// fails part-way must not reach it: the server would accept a truncated
+	// message. Breaking the connection instead makes the server discard it.
+	if _, err = msg.WriteTo(w); err != nil {
+		_ = client.Close()
 		return fmt.Errorf("write message: %w", err)
 	}
 
@@ -332,7 +402,7 @@ func (p *Sender) writeData(client *smtp.Client, msg []byte) error {
 	return nil
 }
 
-func (p *Sender) authenticateAndSend(client *smtp.Client, auth smtp.Auth, from string, to []string, msg []byte) error {
+func (p *Sender) authenticateAndSend(client *smtp.Client, auth smtp.Auth, env envelope, msg io.WriterTo) error {
 	if auth != nil {
 		if ok, _ := client.Extension("AUTH"); !ok {
 			return fmt.Errorf("smtp server does not support AUTH")
@@ -342,10 +412,10 @@ func (p *Sender) authenticateAndSend(client *smtp.Client, auth smtp.Auth, from s
 		}
 	}
 
-	return p.sendOnClient(client, from, to, msg)
+	return p.sendOnClient(client, env, msg)
 }
 
-func (p *Sender) sendPlain(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte, requireTLS bool) error {
+func (p *Sender) sendPlain(ctx context.Context, addr string, auth smtp.Auth, env envelope, msg io.WriterTo, requireTLS bool) error {
 	host, client, err := p.dial(ctx, addr, false)
 	if err != nil {
 		return err
@@ -364,7 +434,7 @@ func (p *Sender) sendPlain(ctx context.Context, addr string, auth smtp.Auth, fro
 		return fmt.Errorf("oauth2 requires TLS; enable SSL/STARTTLS or AllowInsecureAuth for testing")
 	}
 
-	if err = p.authenticateAndSend(client, auth, from, to, msg); err != nil {
+	if err = p.authenticateAndSend(client, auth, env, msg); err != nil {
 		return err
 	}
 
@@ -402,17 +472,31 @@ func (p *Sender) dial(ctx context.Context, addr string, useSSL bool) (string, *s
 	return host, client, nil
 }
 
-func (p *Sender) sendWithSSL(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+func (p *Sender) sendWithSSL(ctx context.Context, addr string, auth smtp.Auth, env envelope, msg io.WriterTo) error {
 	_, client, err := p.dial(ctx, addr, true)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	if err = p.authenticateAndSend(client, auth, from, to, msg); err != nil {
+	if err = p.authenticateAndSend(client, auth, env, msg); err != nil {
 		return err
 	}
 
 	_ = client.Quit()
 	return nil
 }
+
+// Limits returns what any SMTP server can be relied on to accept: header
+// lines within RFC 5322's 998 characters. A server's size and recipient
+// limits are its own and are learned only on connecting; set them with
+// gsmail.PreflightFunc where they are known.
+func (p *Sender) Limits() gsmail.Limits {
+	return gsmail.Limits{MaxHeaderLine: gsmail.MaxHeaderLine}
+}
+
+// Preflight reports, without connecting, whether email breaks Limits. Use
+// gsmail.PreflightInterceptor to apply it.
+func (p *Sender) Preflight(email gsmail.Email) error {
+	return p.Limits().Check("smtp", email)
+}
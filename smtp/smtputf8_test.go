@@ -0,0 +1,80 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// An internationalized domain has an ASCII form every server accepts, so it
+// needs nothing from the server.
+func TestIDNDomainIsSentInASCII(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	err := NewSender(host, port, "", "", false).Send(context.Background(), gsmail.Email{
+		From:    "Jörg <joerg@bücher.de>",
+		To:      []string{"user@例子.测试"},
+		Subject: "Hi",
+		Body:    []byte("hello"),
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-done
+
+	if got.mailFrom != "<joerg@xn--bcher-kva.de>" {
+		t.Errorf("MAIL FROM:%s", got.mailFrom)
+	}
+	if len(got.rcptTo) != 1 || got.rcptTo[0] != "user@xn--fsqu00a.xn--0zwm56d" {
+		t.Errorf("RCPT TO %v", got.rcptTo)
+	}
+	if to := headerLine(got.data, "To"); to != "user@xn--fsqu00a.xn--0zwm56d" {
+		t.Errorf("To header = %q", to)
+	}
+}
+
+func TestUTF8LocalPartNegotiatesSMTPUTF8(t *testing.T) {
+	host, port, got, done := serveOnceWith(t, "SMTPUTF8")
+
+	err := NewSender(host, port, "", "", false).Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"用户@例子.测试"},
+		Subject: "Hi",
+		Body:    []byte("hello"),
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-done
+
+	if !strings.HasSuffix(got.mailFrom, " SMTPUTF8") {
+		t.Errorf("MAIL FROM:%s lacks the SMTPUTF8 parameter", got.mailFrom)
+	}
+	if len(got.rcptTo) != 1 || got.rcptTo[0] != "用户@例子.测试" {
+		t.Errorf("RCPT TO %v", got.rcptTo)
+	}
+}
+
+// Without SMTPUTF8 a non-ASCII local part cannot be sent at all, and a retry
+// will not change the server's extensions.
+func TestUTF8LocalPartWithoutSMTPUTF8Fails(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	err := NewSender(host, port, "", "", false).Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"bob@example.com"},
+		Cc:      []string{"用户@例子.测试"},
+		Subject: "Hi",
+		Body:    []byte("hello"),
+	})
+	<-done
+	if !errors.Is(err, gsmail.ErrSMTPUTF8Required) || gsmail.IsRetryable(err) {
+		t.Fatalf("expected a non-retryable ErrSMTPUTF8Required, got %v", err)
+	}
+	if got.mailFrom != "" {
+		t.Errorf("a transaction was started: MAIL FROM:%s", got.mailFrom)
+	}
+}
@@ -0,0 +1,89 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// An attachment backed by Open is streamed into DATA. The opener has to be
+// called, and what it yields has to arrive intact.
+func TestSendStreamsOpenedAttachment(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	payload := bytes.Repeat([]byte("streamed-attachment-"), 4096)
+	opened := 0
+	err := NewSender(host, port, "", "", false).Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com"},
+		Subject: "Report",
+		Body:    []byte("see attached"),
+		Attachments: []gsmail.Attachment{{
+			Filename:    "report.bin",
+			ContentType: "application/octet-stream",
+			Open: func() (io.ReadCloser, error) {
+				opened++
+				return io.NopCloser(bytes.NewReader(payload)), nil
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-done
+
+	if opened != 1 {
+		t.Errorf("opener called %d times, want 1", opened)
+	}
+	parsed, err := gsmail.ParseRawEmail([]byte(strings.ReplaceAll(got.data, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("parse delivered message: %v", err)
+	}
+	if len(parsed.Attachments) != 1 || !bytes.Equal(parsed.Attachments[0].Data, payload) {
+		t.Fatalf("attachment did not survive the stream")
+	}
+}
+
+// A stream that breaks part-way must not be committed: ending DATA would
+// deliver a truncated message the recipient cannot tell from a complete one.
+func TestSendDoesNotCommitBrokenStream(t *testing.T) {
+	host, port, got, done := serveOnce(t)
+
+	// serveOnce answers one conversation, so the retry a read error earns
+	// would dial a server that never replies.
+	s := NewSender(host, port, "", "", false)
+	s.SetRetryConfig(gsmail.RetryConfig{MaxRetries: 0})
+	err := s.Send(context.Background(), gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com"},
+		Subject: "Report",
+		Body:    []byte("see attached"),
+		Attachments: []gsmail.Attachment{{
+			Filename: "report.bin",
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(io.MultiReader(
+					strings.NewReader(base64.StdEncoding.EncodeToString([]byte("partial"))),
+					errReader{},
+				)), nil
+			},
+		}},
+	})
+	if err == nil {
+		t.Fatal("expected the broken stream to fail the send")
+	}
+	<-done
+
+	if got.data != "" {
+		t.Errorf("server committed a message from a broken stream:\n%s", got.data)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("disk went away") }
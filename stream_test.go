@@ -0,0 +1,120 @@
+package gsmail_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gsoultan/gsmail"
+)
+
+// RenderMessageTo and RenderMessage must agree on everything but the values a
+// render generates, and a streamed attachment must decode to its source.
+func TestRenderMessageToStreamsAttachment(t *testing.T) {
+	fsys := fstest.MapFS{"docs/report.pdf": {Data: bytes.Repeat([]byte("%PDF"), 10000)}}
+	email := gsmail.Email{
+		From:        "sender@example.com",
+		To:          []string{"to@example.com"},
+		Subject:     "Streamed",
+		Body:        []byte("plain"),
+		HTMLBody:    []byte("<p>html</p>"),
+		Attachments: []gsmail.Attachment{gsmail.FSAttachment(fsys, "docs/report.pdf")},
+	}
+
+	var buf bytes.Buffer
+	if err := gsmail.RenderMessageTo(&buf, email); err != nil {
+		t.Fatalf("RenderMessageTo: %v", err)
+	}
+	parsed, err := gsmail.ParseRawEmail(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseRawEmail: %v", err)
+	}
+
+	if len(parsed.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(parsed.Attachments))
+	}
+	att := parsed.Attachments[0]
+	if att.Filename != "report.pdf" {
+		t.Errorf("filename = %q, want report.pdf", att.Filename)
+	}
+	if att.ContentType != "application/pdf" {
+		t.Errorf("content type = %q, want application/pdf", att.ContentType)
+	}
+	if !bytes.Equal(att.Data, fsys["docs/report.pdf"].Data) {
+		t.Error("streamed attachment did not round-trip")
+	}
+	if string(parsed.Body) != "plain" || string(parsed.HTMLBody) != "<p>html</p>" {
+		t.Errorf("bodies did not round-trip: %q / %q", parsed.Body, parsed.HTMLBody)
+	}
+}
+
+// A prepared message is written once per attempt. Every write must produce the
+// same bytes, or a retry would carry a different Message-ID than the attempt
+// the receiver may already have accepted.
+func TestPreparedMessageIsStableAcrossWrites(t *testing.T) {
+	opened := 0
+	msg, err := gsmail.PrepareMessage(gsmail.Email{
+		From:    "sender@example.com",
+		To:      []string{"to@example.com"},
+		Subject: "Stable",
+		Body:    []byte("body"),
+		Attachments: []gsmail.Attachment{{
+			Filename: "a.txt",
+			Open: func() (io.ReadCloser, error) {
+				opened++
+				return io.NopCloser(bytes.NewReader([]byte("content"))), nil
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("PrepareMessage: %v", err)
+	}
+
+	var first, second bytes.Buffer
+	n, err := msg.WriteTo(&first)
+	if err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if n != int64(first.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, first.Len())
+	}
+	if _, err := msg.WriteTo(&second); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("two writes of one PreparedMessage differ")
+	}
+	if opened != 2 {
+		t.Errorf("opener called %d times, want once per write", opened)
+	}
+}
+
+func TestPrepareMessageRejectsInvalidHeaderName(t *testing.T) {
+	_, err := gsmail.PrepareMessage(gsmail.Email{
+		From:    "sender@example.com",
+		Headers: map[string]string{"Bad Name": "x"},
+	})
+	if err == nil || gsmail.IsRetryable(err) {
+		t.Fatalf("got %v, want a permanent error", err)
+	}
+}
+
+// A file that does not exist will not exist on the next attempt either.
+func TestMissingAttachmentFileIsPermanent(t *testing.T) {
+	err := gsmail.RenderMessageTo(io.Discard, gsmail.Email{
+		From:        "sender@example.com",
+		To:          []string{"to@example.com"},
+		Body:        []byte("body"),
+		Attachments: []gsmail.Attachment{gsmail.FileAttachment("testdata/does-not-exist.pdf")},
+	})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want fs.ErrNotExist", err)
+	}
+	if gsmail.IsRetryable(err) {
+		t.Error("a missing attachment file was reported as retryable")
+	}
+}
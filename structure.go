@@ -0,0 +1,136 @@
+package gsmail
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"github.com/gsoultan/gsmail/internal/mimeentity"
+)
+
+// MIMEPart describes one entity of a message retained by
+// ParseRawEmailWithOptions with RetainRaw set: where it lies in Email.Raw and
+// what its header says it is.
+//
+// It is a map of the bytes, not a copy of them. Bytes, Body and Content read
+// the entity out of the message it was parsed from, so nothing is held twice
+// and what they return is exactly what arrived.
+type MIMEPart struct {
+	// Header is every header field of the entity, in order and unfolded,
+	// trace and Content-* fields included.
+	Header HeaderFields
+	// MediaType is the lower-cased media type from Content-Type, and Params
+	// its parameters. An entity without a usable Content-Type is text/plain,
+	// as RFC 2045 says.
+	MediaType string
+	Params    map[string]string
+	// Encoding is the lower-cased Content-Transfer-Encoding, "7bit" when the
+	// entity does not name one.
+	Encoding string
+	// Start, BodyStart and End are offsets into Email.Raw: the entity's header
+	// begins at Start, its body at BodyStart, and it ends at End. A multipart
+	// child ends before the line break that belongs to the next delimiter.
+	Start, BodyStart, End int
+	// Parts are the entities of a multipart body in order, or the message
+	// carried whole by an unencoded message/rfc822 part.
+	Parts []*MIMEPart
+}
+
+// Bytes returns the entity, header and body, exactly as it appears in msg,
+// which must be the Email.Raw the part was parsed from.
+func (p *MIMEPart) Bytes(msg []byte) []byte {
+	if !p.within(msg) {
+		return nil
+	}
+	return msg[p.Start:p.End]
+}
+
+// Body returns the entity's body as it appears in msg, still in its transfer
+// encoding.
+func (p *MIMEPart) Body(msg []byte) []byte {
+	if !p.within(msg) {
+		return nil
+	}
+	return msg[p.BodyStart:p.End]
+}
+
+// Content returns the entity's body with its transfer encoding removed, under
+// the same size limit ParseRawEmail applies to a part.
+func (p *MIMEPart) Content(msg []byte) ([]byte, error) {
+	return decodePart(bytes.NewReader(p.Body(msg)), p.Encoding)
+}
+
+// Walk calls fn for p and each entity below it, depth first in the order they
+// appear. Returning false from fn skips the entities below that one.
+func (p *MIMEPart) Walk(fn func(*MIMEPart) bool) {
+	if !fn(p) {
+		return
+	}
+	for _, child := range p.Parts {
+		child.Walk(fn)
+	}
+}
+
+func (p *MIMEPart) within(msg []byte) bool {
+	return 0 <= p.Start && p.Start <= p.BodyStart && p.BodyStart <= p.End && p.End <= len(msg)
+}
+
+// parseStructure maps the entity at msg[start:end] and, below maxMultipartDepth,
+// the entities inside it.
+//
+// It never fails. The message has already been through ParseRawEmail, so a
+// fault here is one that parse tolerated too -- a multipart body without its
+// closing delimiter, say -- and the entity is then described without its
+// children rather than not at all.
+func parseStructure(msg []byte, start, end, depth int) *MIMEPart {
+	header, body := splitEntity(msg[start:end])
+	p := &MIMEPart{
+		Header:    unfoldedFields(header, false),
+		Start:     start,
+		BodyStart: end - len(body),
+		End:       end,
+		MediaType: "text/plain",
+		Encoding:  "7bit",
+	}
+	if mediaType, params, err := mime.ParseMediaType(p.Header.Get("Content-Type")); err == nil {
+		p.MediaType, p.Params = mediaType, params
+	}
+	if enc := strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))); enc != "" {
+		p.Encoding = enc
+	}
+	if depth >= maxMultipartDepth {
+		return p
+	}
+
+	switch {
+	case strings.HasPrefix(p.MediaType, "multipart/"):
+		spans, err := mimeentity.PartSpans(msg[p.BodyStart:end], p.Params["boundary"])
+		if err != nil {
+			return p
+		}
+		for _, sp := range spans {
+			p.Parts = append(p.Parts, parseStructure(msg, p.BodyStart+sp[0], p.BodyStart+sp[1], depth+1))
+		}
+	case p.MediaType == "message/rfc822" && (p.Encoding == "7bit" || p.Encoding == "8bit" || p.Encoding == "binary"):
+		p.Parts = []*MIMEPart{parseStructure(msg, p.BodyStart, end, depth+1)}
+	}
+	return p
+}
+
+// splitEntity is mimeentity.Split for an entity that may have no header
+// fields at all, which a multipart child may: it then starts with the blank
+// line, and everything after it is body.
+func splitEntity(entity []byte) (header, body []byte) {
+	switch {
+	case bytes.HasPrefix(entity, []byte("\r\n")):
+		return nil, entity[2:]
+	case bytes.HasPrefix(entity, []byte("\n")):
+		return nil, entity[1:]
+	}
+	header, body = mimeentity.Split(entity)
+	if body == nil {
+		// No blank line: all header, and the body is empty at the end.
+		body = entity[len(entity):]
+	}
+	return header, body
+}
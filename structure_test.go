@@ -0,0 +1,115 @@
+package gsmail
+
+import (
+	"bytes"
+	"testing"
+)
+
+// retainedRaw is a message with the features a re-render would lose: an
+// odd boundary, unusual spacing, an encoded part, a header-less part and a
+// message carried inside another.
+const retainedRaw = "Received: from mx.example.net\r\n" +
+	"From: Ana <ana@example.com>\r\n" +
+	"To: bo@example.com\r\n" +
+	"Subject:   spaced\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"preamble\r\n" +
+	"--b1\r\n" +
+	"\r\n" +
+	"plain, no header\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Disposition: attachment; filename=\"a.bin\"\r\n" +
+	"\r\n" +
+	"aGVsbG8=\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: inner@example.com\r\n" +
+	"Subject: inner\r\n" +
+	"\r\n" +
+	"inner body\r\n" +
+	"--b1--\r\n" +
+	"epilogue\r\n"
+
+func TestRetainRaw(t *testing.T) {
+	src := []byte(retainedRaw)
+	e, err := ParseRawEmailWithOptions(src, ParseOptions{RetainRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src[0] = 'X'
+	if string(e.Raw) != retainedRaw {
+		t.Error("Raw is not a copy of the message as it arrived")
+	}
+	msg, err := RenderMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != retainedRaw {
+		t.Errorf("re-emitted message differs:\n%s", msg)
+	}
+	if len(e.Attachments) == 0 || e.Subject != "spaced" {
+		t.Errorf("typed fields not filled in: subject %q, %d attachments", e.Subject, len(e.Attachments))
+	}
+
+	root := e.Structure
+	if root == nil || root.MediaType != "multipart/mixed" || len(root.Parts) != 3 {
+		t.Fatalf("structure = %+v", root)
+	}
+	if root.Header.Get("Received") == "" || !bytes.Equal(root.Bytes(e.Raw), e.Raw) {
+		t.Error("the root entity is not the whole message")
+	}
+
+	bare := root.Parts[0]
+	if bare.MediaType != "text/plain" || len(bare.Header) != 0 || string(bare.Body(e.Raw)) != "plain, no header" {
+		t.Errorf("header-less part = %+v, body %q", bare, bare.Body(e.Raw))
+	}
+
+	att := root.Parts[1]
+	if att.Encoding != "base64" || string(att.Body(e.Raw)) != "aGVsbG8=" {
+		t.Errorf("attachment = %+v, body %q", att, att.Body(e.Raw))
+	}
+	if data, err := att.Content(e.Raw); err != nil || string(data) != "hello" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+
+	inner := root.Parts[2]
+	if len(inner.Parts) != 1 || inner.Parts[0].Header.Get("Subject") != "inner" {
+		t.Fatalf("message/rfc822 part = %+v", inner)
+	}
+
+	var types []string
+	root.Walk(func(p *MIMEPart) bool {
+		types = append(types, p.MediaType)
+		return true
+	})
+	if len(types) != 5 {
+		t.Errorf("walk visited %q", types)
+	}
+}
+
+// Without the option nothing is retained, so a parsed message still renders
+// from its fields as before.
+func TestRetainRawIsOptIn(t *testing.T) {
+	e, err := ParseRawEmailWithOptions([]byte(retainedRaw), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Raw != nil || e.Structure != nil {
+		t.Error("retained without RetainRaw")
+	}
+}
+
+// A truncated multipart body is still mapped, without the children that
+// cannot be found.
+func TestStructureTolerant(t *testing.T) {
+	raw := []byte("Content-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\ntext")
+	p := parseStructure(raw, 0, len(raw), 0)
+	if p.MediaType != "multipart/mixed" || len(p.Parts) != 0 || p.BodyStart != bytes.Index(raw, []byte("--x")) {
+		t.Errorf("structure = %+v", p)
+	}
+}
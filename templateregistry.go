@@ -0,0 +1,220 @@
+package gsmail
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ErrTemplateNotFound is returned by TemplateRegistry.Render for a name the
+// registry has no template for.
+var ErrTemplateNotFound = errors.New("gsmail: template not found")
+
+// TemplateOptions configures NewTemplateRegistry.
+type TemplateOptions struct {
+	// Layout is the base name of the layout files, without extension.
+	// Empty means "layout": layout.html wraps every HTML body and layout.txt
+	// every plain text one. A registry without them renders each body on its
+	// own.
+	Layout string
+
+	// Partials is the directory whose .html and .txt files are shared by
+	// every template of the same kind, each under its path within the
+	// directory without extension: partials/header.html is {{template
+	// "header" .}}. Empty means "partials".
+	Partials string
+
+	// HTMLFuncs and TextFuncs are the custom functions of the HTML and of the
+	// plain text and subject templates. The registry compiles its templates
+	// once, so the HTMLFuncs and TextFuncs of an Email are not used.
+	HTMLFuncs htmltemplate.FuncMap
+	TextFuncs template.FuncMap
+}
+
+// TemplateRegistry holds the templates of a directory, compiled once, and
+// renders them into an Email by name. It is safe for concurrent use.
+//
+// A template is up to three files sharing a name: name.subject, a text
+// template for the subject line; name.html, the HTML body; and name.txt, the
+// plain text body. The name is the path within the directory without the
+// extension, so emails/welcome.html is "emails/welcome". Other files are
+// ignored, so images and stylesheets may sit beside the templates.
+//
+// A body is the "content" template of its layout: layout.html calls
+// {{template "content" .}} where the body goes, and may call partials and
+// declare {{block}}s that a body overrides with {{define}}.
+type TemplateRegistry struct {
+	templates map[string]*registeredTemplate
+}
+
+// registeredTemplate is one name's compiled files; a missing file is nil.
+type registeredTemplate struct {
+	subject *template.Template
+	html    *htmltemplate.Template
+	text    *template.Template
+}
+
+// contentTemplate is the name a body is parsed under within its layout.
+const contentTemplate = "content"
+
+// NewTemplateRegistry parses every template in fsys, such as an embed.FS or
+// os.DirFS, and returns a registry of them. A template that does not parse is
+// reported with its path.
+func NewTemplateRegistry(fsys fs.FS, opts TemplateOptions) (*TemplateRegistry, error) {
+	layout, partials := opts.Layout, opts.Partials
+	if layout == "" {
+		layout = "layout"
+	}
+	if partials == "" {
+		partials = "partials"
+	}
+
+	var pages []string
+	htmlBase := htmltemplate.New("").Funcs(opts.HTMLFuncs)
+	textBase := template.New("").Funcs(opts.TextFuncs)
+	htmlEntry, textEntry := contentTemplate, contentTemplate
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := path.Ext(p)
+		name := strings.TrimSuffix(p, ext)
+		if ext != ".html" && ext != ".txt" && ext != ".subject" {
+			return nil
+		}
+		var define string
+		switch {
+		case ext != ".subject" && strings.HasPrefix(p, partials+"/"):
+			define = strings.TrimPrefix(name, partials+"/")
+		case ext != ".subject" && name == layout:
+			define = layout
+		default:
+			pages = append(pages, p)
+			return nil
+		}
+
+		src, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if ext == ".html" {
+			_, err = htmlBase.New(define).Parse(string(src))
+		} else {
+			_, err = textBase.New(define).Parse(string(src))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if define == layout {
+			if ext == ".html" {
+				htmlEntry = layout
+			} else {
+				textEntry = layout
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gsmail: templates: %w", err)
+	}
+
+	r := &TemplateRegistry{templates: make(map[string]*registeredTemplate)}
+	for _, p := range pages {
+		ext := path.Ext(p)
+		name := strings.TrimSuffix(p, ext)
+		src, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("gsmail: templates: %w", err)
+		}
+		t := r.templates[name]
+		if t == nil {
+			t = &registeredTemplate{}
+			r.templates[name] = t
+		}
+
+		switch ext {
+		case ".subject":
+			t.subject, err = template.New(name).Funcs(opts.TextFuncs).Parse(string(src))
+		case ".html":
+			var set *htmltemplate.Template
+			if set, err = htmlBase.Clone(); err == nil {
+				if _, err = set.New(contentTemplate).Parse(string(src)); err == nil {
+					t.html = set.Lookup(htmlEntry)
+				}
+			}
+		case ".txt":
+			var set *template.Template
+			if set, err = textBase.Clone(); err == nil {
+				if _, err = set.New(contentTemplate).Parse(string(src)); err == nil {
+					t.text = set.Lookup(textEntry)
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gsmail: templates: %s: %w", p, err)
+		}
+	}
+	return r, nil
+}
+
+// Names returns the names of the registry's templates, sorted.
+func (r *TemplateRegistry) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render executes the template called name with data into email: the subject
+// into Subject, the HTML body into HTMLBody and the plain text body into Body.
+// A field the template has no file for is left as it is.
+//
+// The HTML body is converted as email's OutlookCompatible and InlineCSS ask,
+// as SetHTMLBody converts it, and with AutoPlainText set, a template without a
+// plain text body gets one generated from the HTML. The subject is put on one
+// line, so data cannot start another header.
+func (r *TemplateRegistry) Render(email *Email, name string, data any) error {
+	t := r.templates[name]
+	if t == nil {
+		return NonRetryable(fmt.Errorf("%w: %q", ErrTemplateNotFound, name))
+	}
+
+	var subject, html, text []byte
+	var err error
+	if t.subject != nil {
+		if subject, err = executeTemplate(t.subject, data, "subject"); err != nil {
+			return fmt.Errorf("gsmail: render %s: %w", name, err)
+		}
+	}
+	if t.html != nil {
+		if html, err = executeTemplate(t.html, data, "html"); err != nil {
+			return fmt.Errorf("gsmail: render %s: %w", name, err)
+		}
+	}
+	if t.text != nil {
+		if text, err = executeTemplate(t.text, data, "text"); err != nil {
+			return fmt.Errorf("gsmail: render %s: %w", name, err)
+		}
+	}
+
+	if t.subject != nil {
+		email.Subject = strings.Join(strings.Fields(string(subject)), " ")
+	}
+	if t.html != nil {
+		email.setRenderedHTML(html)
+	}
+	if t.text != nil {
+		email.Body = text
+	}
+	return nil
+}
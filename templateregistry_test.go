@@ -0,0 +1,125 @@
+package gsmail
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"text/template"
+)
+
+func testTemplateFS() fstest.MapFS {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+	return fstest.MapFS{
+		"layout.html":            file(`<html><head><title>{{block "title" .}}Example{{end}}</title></head><body>{{template "header" .}}{{template "content" .}}{{template "footer" .}}</body></html>`),
+		"layout.txt":             file("{{template \"content\" .}}\n-- \n{{template \"signature\" .}}"),
+		"partials/header.html":   file(`<h1>Example</h1>`),
+		"partials/footer.html":   file(`<p>{{.Name | shout}}, you are receiving this email because you signed up.</p>`),
+		"partials/signature.txt": file("The Example team"),
+		"welcome.subject":        file("Welcome,\r\n{{.Name}}\nBcc: someone@example.com"),
+		"welcome.html":           file(`{{define "title"}}Welcome{{end}}<p>Hello {{.Name}}</p>`),
+		"welcome.txt":            file("Hello {{.Name | shout}}"),
+		"billing/invoice.html":   file(`<p>Invoice {{.Number}}</p>`),
+		"logo.png":               file("\x89PNG"),
+	}
+}
+
+func testRegistry(t *testing.T) *TemplateRegistry {
+	t.Helper()
+	r, err := NewTemplateRegistry(testTemplateFS(), TemplateOptions{
+		HTMLFuncs: htmltemplate.FuncMap{"shout": strings.ToUpper},
+		TextFuncs: template.FuncMap{"shout": strings.ToUpper},
+	})
+	if err != nil {
+		t.Fatalf("NewTemplateRegistry: %v", err)
+	}
+	return r
+}
+
+func TestTemplateRegistry(t *testing.T) {
+	r := testRegistry(t)
+	if got, want := r.Names(), []string{"billing/invoice", "welcome"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+
+	var email Email
+	if err := r.Render(&email, "welcome", map[string]string{"Name": "<Ann>"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Welcome, <Ann> Bcc: someone@example.com"; email.Subject != want {
+		t.Errorf("Subject = %q, want %q", email.Subject, want)
+	}
+	wantHTML := `<html><head><title>Welcome</title></head><body><h1>Example</h1><p>Hello &lt;Ann&gt;</p><p>&lt;ANN&gt;, you are receiving this email because you signed up.</p></body></html>`
+	if string(email.HTMLBody) != wantHTML {
+		t.Errorf("HTMLBody = %s\nwant %s", email.HTMLBody, wantHTML)
+	}
+	if want := "Hello <ANN>\n-- \nThe Example team"; string(email.Body) != want {
+		t.Errorf("Body = %q, want %q", email.Body, want)
+	}
+
+	// Only the HTML body: the subject is kept, and AutoPlainText makes the
+	// text.
+	email = Email{Subject: "Your invoice", AutoPlainText: true}
+	if err := r.Render(&email, "billing/invoice", map[string]any{"Name": "Ann", "Number": 42}); err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "Your invoice" || !strings.Contains(string(email.HTMLBody), "<title>Example</title>") {
+		t.Errorf("got subject %q, html %s", email.Subject, email.HTMLBody)
+	}
+	if !strings.Contains(string(email.Body), "Invoice 42") {
+		t.Errorf("Body = %q, want text generated from the HTML", email.Body)
+	}
+
+	err := r.Render(&email, "missing", nil)
+	if !errors.Is(err, ErrTemplateNotFound) || IsRetryable(err) {
+		t.Errorf("Render(missing) = %v, want a non-retryable ErrTemplateNotFound", err)
+	}
+}
+
+func TestTemplateRegistryWithoutLayout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mail/partials/sig.txt": {Data: []byte("Bye")},
+		"mail/note.txt":         {Data: []byte("Note {{.}}. {{template \"sig\"}}")},
+		"mail/broken.html":      {Data: []byte("<p>{{.Name</p>")},
+	}
+	if _, err := NewTemplateRegistry(fsys, TemplateOptions{}); err == nil || !strings.Contains(err.Error(), "mail/broken.html") {
+		t.Errorf("NewTemplateRegistry = %v, want the broken template's path", err)
+	}
+
+	delete(fsys, "mail/broken.html")
+	r, err := NewTemplateRegistry(fsys, TemplateOptions{Partials: "mail/partials"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var email Email
+	if err := r.Render(&email, "mail/note", 1); err != nil {
+		t.Fatal(err)
+	}
+	if string(email.Body) != "Note 1. Bye" || email.HTMLBody != nil {
+		t.Errorf("Body = %q, HTMLBody = %q", email.Body, email.HTMLBody)
+	}
+}
+
+func TestTemplateRegistryConcurrent(t *testing.T) {
+	r := testRegistry(t)
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := strings.Repeat("x", i)
+			var email Email
+			if err := r.Render(&email, "welcome", map[string]string{"Name": name}); err != nil {
+				t.Error(err)
+				return
+			}
+			if !strings.Contains(string(email.Body), "Hello "+strings.ToUpper(name)+"\n") {
+				t.Errorf("Body = %q", email.Body)
+			}
+		}()
+	}
+	wg.Wait()
+}
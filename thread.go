@@ -0,0 +1,367 @@
+package gsmail
+
+import (
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thread is one message of a conversation and the replies to it, as
+// BuildThreads arranges them.
+type Thread struct {
+	// Email is the message, or nil for one that is missing: a message the
+	// others refer to that was not among those threaded, or a common root
+	// for messages grouped only by subject. It holds its Children together
+	// all the same.
+	Email *Email
+
+	// ID is the Message-ID the node stands for, without angle brackets. For a
+	// message without one it is the MessageIdentity the message was threaded
+	// by instead, and for a subject group it is empty.
+	ID string
+
+	// Children are the replies, oldest first.
+	Children []*Thread
+}
+
+// Walk calls fn for t and every thread below it, depth first and oldest
+// first, with the depth below t: 0 for t itself, 1 for its replies.
+func (t *Thread) Walk(fn func(t *Thread, depth int)) {
+	t.walk(fn, 0)
+}
+
+func (t *Thread) walk(fn func(t *Thread, depth int), depth int) {
+	fn(t, depth)
+	for _, c := range t.Children {
+		c.walk(fn, depth+1)
+	}
+}
+
+// BuildThreads arranges emails into conversations with Jamie Zawinski's
+// threading algorithm, the one most mail clients use, and returns the root of
+// each, oldest first.
+//
+// A message is placed under the one its In-Reply-To or the last entry of its
+// References names, and References also links the earlier messages of the
+// chain to each other, so a conversation holds together when some of its
+// messages are missing. A message with no Message-ID is threaded by its
+// MessageIdentity. Conversations that still have separate roots are then
+// merged when their subjects agree once "Re:", "Fwd:" and their translations
+// are removed -- the only clue some clients leave.
+//
+// The emails are copied; the Threads do not point into the slice. Siblings are
+// ordered by their Date header and then by their position in emails.
+func BuildThreads(emails []Email) []*Thread {
+	b := threadBuilder{table: make(map[string]*threadNode, len(emails))}
+	for i := range emails {
+		b.add(emails[i], i)
+	}
+
+	var roots []*threadNode
+	for _, n := range b.nodes {
+		if n.parent == nil {
+			roots = append(roots, n)
+		}
+	}
+	roots = pruneThreads(roots, true)
+	roots = groupThreadsBySubject(roots)
+
+	threads := make([]*Thread, 0, len(roots))
+	for _, n := range sortThreads(roots) {
+		threads = append(threads, n.thread())
+	}
+	return threads
+}
+
+// threadNode is the JWZ container: a message, or the place of a missing one.
+type threadNode struct {
+	email    *Email
+	id       string
+	parent   *threadNode
+	children []*threadNode
+	date     time.Time
+	order    int
+}
+
+// threadBuilder holds the id table of one BuildThreads call.
+type threadBuilder struct {
+	table map[string]*threadNode
+	nodes []*threadNode // in the order created, for a stable result
+}
+
+// node returns the node for key, creating an empty one.
+func (b *threadBuilder) node(key, id string) *threadNode {
+	if n := b.table[key]; n != nil {
+		return n
+	}
+	n := &threadNode{id: id, order: len(b.nodes)}
+	b.table[key] = n
+	b.nodes = append(b.nodes, n)
+	return n
+}
+
+func (b *threadBuilder) add(email Email, index int) {
+	id, source := email.MessageIdentity()
+	key := id
+	if source != IdentityMessageID {
+		// Kept apart from Message-IDs, which another message could name.
+		key = "\x00" + string(source) + "\x00" + id
+	}
+	if n := b.table[key]; n != nil && n.email != nil || source == IdentityNone {
+		// A repeated Message-ID is a different message, or the same one
+		// fetched twice; either way it gets a node of its own.
+		key = "\x00" + strconv.Itoa(index) + "\x00" + key
+	}
+	n := b.node(key, id)
+	n.email = &email
+	if date, err := mail.ParseDate(email.Header("Date")); err == nil {
+		n.date = date
+	}
+
+	// Link the References chain, each to the next, without breaking a link
+	// an earlier message made.
+	var parent *threadNode
+	for _, ref := range threadReferences(email) {
+		r := b.node(ref, ref)
+		if parent != nil && r.parent == nil && parent.canAdopt(r) {
+			parent.adopt(r)
+		}
+		parent = r
+	}
+
+	// The message's own parent is the last reference, whatever an earlier
+	// message's References claimed.
+	if n.parent != nil {
+		n.parent.remove(n)
+	}
+	if parent != nil && parent.canAdopt(n) {
+		parent.adopt(n)
+	}
+}
+
+// threadReferences lists the Message-IDs email descends from, oldest first:
+// References, and In-Reply-To's first identifier if References lacks it.
+func threadReferences(email Email) []string {
+	refs := messageIDs(email.Header("References"))
+	if irt := messageIDs(email.Header("In-Reply-To")); len(irt) > 0 {
+		found := false
+		for _, r := range refs {
+			found = found || r == irt[0]
+		}
+		if !found {
+			refs = append(refs, irt[0])
+		}
+	}
+	return refs
+}
+
+// messageIDs extracts the <...> identifiers of a header, without the
+// brackets. A header without any is read as identifiers separated by spaces,
+// as some mailers write them.
+func messageIDs(header string) []string {
+	var ids []string
+	for rest := header; ; {
+		lt := strings.IndexByte(rest, '<')
+		if lt < 0 {
+			break
+		}
+		gt := strings.IndexByte(rest[lt:], '>')
+		if gt < 0 {
+			break
+		}
+		if id := strings.TrimSpace(rest[lt+1 : lt+gt]); id != "" {
+			ids = append(ids, id)
+		}
+		rest = rest[lt+gt+1:]
+	}
+	if len(ids) == 0 && !strings.ContainsAny(header, "<>") {
+		ids = strings.Fields(header)
+	}
+	return ids
+}
+
+// canAdopt reports whether child may become a child of n without making a
+// loop.
+func (n *threadNode) canAdopt(child *threadNode) bool {
+	for p := n; p != nil; p = p.parent {
+		if p == child {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *threadNode) adopt(children ...*threadNode) {
+	for _, c := range children {
+		c.parent = n
+	}
+	n.children = append(n.children, children...)
+}
+
+func (n *threadNode) remove(child *threadNode) {
+	for i, c := range n.children {
+		if c == child {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			break
+		}
+	}
+	child.parent = nil
+}
+
+// pruneThreads drops empty nodes without children and replaces those with
+// children by the children themselves, except at the root, where an empty
+// node with several children is what keeps them one conversation.
+func pruneThreads(nodes []*threadNode, root bool) []*threadNode {
+	var out []*threadNode
+	for _, n := range nodes {
+		n.children = pruneThreads(n.children, false)
+		if n.email == nil {
+			if len(n.children) == 0 {
+				continue
+			}
+			if !root || len(n.children) == 1 {
+				for _, c := range n.children {
+					c.parent = n.parent
+				}
+				out = append(out, n.children...)
+				continue
+			}
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// groupThreadsBySubject merges the roots whose subjects agree.
+func groupThreadsBySubject(roots []*threadNode) []*threadNode {
+	bySubject := make(map[string]*threadNode)
+	for _, n := range roots {
+		subject := n.subject()
+		base := strings.ToLower(ThreadSubject(subject))
+		if base == "" {
+			continue
+		}
+		// Prefer an empty node, then a message that is not a reply, as the
+		// one the others join.
+		old := bySubject[base]
+		if old == nil || old.email != nil && (n.email == nil || isReplySubject(old.subject()) && !isReplySubject(subject)) {
+			bySubject[base] = n
+		}
+	}
+
+	out := roots[:0:0]
+	for _, n := range roots {
+		t := bySubject[strings.ToLower(ThreadSubject(n.subject()))]
+		if t == nil || t == n {
+			out = append(out, n)
+			continue
+		}
+		switch {
+		case t.email == nil && n.email == nil:
+			t.adopt(n.children...)
+		case t.email == nil:
+			t.adopt(n)
+		case isReplySubject(n.subject()) && !isReplySubject(t.subject()):
+			t.adopt(n)
+		default:
+			// t keeps its place among the roots but becomes a new empty
+			// node, holding what it was and n side by side.
+			moved := &threadNode{email: t.email, id: t.id, date: t.date, order: t.order}
+			moved.adopt(t.children...)
+			t.email, t.id, t.children = nil, "", nil
+			t.adopt(moved, n)
+		}
+	}
+	return out
+}
+
+// subject is the subject of n's message, or of its first child's.
+func (n *threadNode) subject() string {
+	if n.email != nil {
+		return n.email.Subject
+	}
+	if len(n.children) > 0 {
+		return n.children[0].subject()
+	}
+	return ""
+}
+
+// sortThreads orders nodes and everything below them by date, then by the
+// order they were created in, and returns nodes.
+func sortThreads(nodes []*threadNode) []*threadNode {
+	for _, n := range nodes {
+		sortThreads(n.children)
+		if n.email == nil && len(n.children) > 0 {
+			n.date, n.order = n.children[0].date, n.children[0].order
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if !nodes[i].date.Equal(nodes[j].date) {
+			return nodes[i].date.Before(nodes[j].date)
+		}
+		return nodes[i].order < nodes[j].order
+	})
+	return nodes
+}
+
+func (n *threadNode) thread() *Thread {
+	t := &Thread{Email: n.email, ID: n.id}
+	for _, c := range n.children {
+		t.Children = append(t.Children, c.thread())
+	}
+	return t
+}
+
+// replyPrefixes are the reply and forward markers ThreadSubject removes, in
+// lower case: English and the translations common clients use.
+var replyPrefixes = []string{"re", "fwd", "fw", "aw", "wg", "sv", "vs", "antw", "tr", "rif", "r", "enc", "res"}
+
+// ThreadSubject returns subject without the reply and forward markers in front
+// of it -- "Re:", "Fwd:", "AW:", "SV:", "Re[2]:" and the like, however many --
+// and with runs of white space collapsed: the subject BuildThreads compares.
+func ThreadSubject(subject string) string {
+	s := strings.Join(strings.Fields(subject), " ")
+	for {
+		rest, ok := trimReplyPrefix(s)
+		if !ok {
+			return s
+		}
+		s = rest
+	}
+}
+
+// isReplySubject reports whether subject starts with a reply or forward
+// marker.
+func isReplySubject(subject string) bool {
+	_, ok := trimReplyPrefix(strings.TrimSpace(subject))
+	return ok
+}
+
+// trimReplyPrefix removes one marker from the front of s.
+func trimReplyPrefix(s string) (string, bool) {
+	lower := strings.ToLower(s)
+	for _, p := range replyPrefixes {
+		if !strings.HasPrefix(lower, p) {
+			continue
+		}
+		rest := s[len(p):]
+		// A counter, as in "Re[2]:" or "Re(2):".
+		if len(rest) > 0 && (rest[0] == '[' || rest[0] == '(') {
+			closing := map[byte]byte{'[': ']', '(': ')'}[rest[0]]
+			end := strings.IndexByte(rest, closing)
+			if end < 0 || strings.Trim(rest[1:end], "0123456789") != "" {
+				continue
+			}
+			rest = rest[end+1:]
+		}
+		rest = strings.TrimLeft(rest, " ")
+		colon := strings.TrimPrefix(strings.TrimPrefix(rest, ":"), "：") // or a full-width one
+		if len(colon) == len(rest) {
+			continue
+		}
+		return strings.TrimLeft(colon, " "), true
+	}
+	return s, false
+}
@@ -0,0 +1,148 @@
+package gsmail
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// threadMessage is a received message with the threading headers given.
+func threadMessage(id, subject, date, inReplyTo, references string) Email {
+	e := Email{From: "a@example.com", Subject: subject, Body: []byte(subject + id)}
+	if id != "" {
+		e.SetHeader("Message-ID", "<"+id+">")
+	}
+	if date != "" {
+		e.SetHeader("Date", date)
+	}
+	if inReplyTo != "" {
+		e.SetHeader("In-Reply-To", inReplyTo)
+	}
+	if references != "" {
+		e.SetHeader("References", references)
+	}
+	return e
+}
+
+// threadShape renders threads as indented lines of subject and ID, with "-"
+// for a missing message.
+func threadShape(threads []*Thread) string {
+	var b strings.Builder
+	for _, t := range threads {
+		t.Walk(func(t *Thread, depth int) {
+			label := "-"
+			if t.Email != nil {
+				label = t.Email.Subject
+			}
+			fmt.Fprintf(&b, "%s%s %s\n", strings.Repeat("  ", depth), label, t.ID)
+		})
+	}
+	return b.String()
+}
+
+func TestBuildThreads(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails []Email
+		want   string
+	}{
+		{
+			name: "References and In-Reply-To",
+			emails: []Email{
+				threadMessage("c", "Re: Help", "Mon, 3 Mar 2025 10:00:00 +0000", "<b>", "<a> <b>"),
+				threadMessage("a", "Help", "Mon, 3 Mar 2025 08:00:00 +0000", "", ""),
+				threadMessage("d", "Re: Help", "Mon, 3 Mar 2025 11:00:00 +0000", "<a>", ""),
+				threadMessage("b", "Re: Help", "Mon, 3 Mar 2025 09:00:00 +0000", "<a>", "<a>"),
+			},
+			want: "Help a\n  Re: Help b\n    Re: Help c\n  Re: Help d\n",
+		},
+		{
+			name: "Missing parent holds its replies",
+			emails: []Email{
+				threadMessage("y", "Re: Order", "Tue, 4 Mar 2025 09:00:00 +0000", "", "<x>"),
+				threadMessage("z", "Re: Order", "Tue, 4 Mar 2025 10:00:00 +0000", "", "<x>"),
+			},
+			want: "- x\n  Re: Order y\n  Re: Order z\n",
+		},
+		{
+			name: "Missing parent with one reply is dropped",
+			emails: []Email{
+				threadMessage("y", "Re: Order", "", "<x>", ""),
+			},
+			want: "Re: Order y\n",
+		},
+		{
+			name: "Subject fallback",
+			emails: []Email{
+				threadMessage("2", "AW: Re[2]:  Invoice   42", "Wed, 5 Mar 2025 10:00:00 +0000", "", ""),
+				threadMessage("1", "Invoice 42", "Wed, 5 Mar 2025 09:00:00 +0000", "", ""),
+				threadMessage("3", "Something else", "Wed, 5 Mar 2025 11:00:00 +0000", "", ""),
+			},
+			want: "Invoice 42 1\n  AW: Re[2]:  Invoice   42 2\nSomething else 3\n",
+		},
+		{
+			name: "Same subject without replies",
+			emails: []Email{
+				threadMessage("1", "Weekly report", "Wed, 5 Mar 2025 09:00:00 +0000", "", ""),
+				threadMessage("2", "weekly report", "Wed, 12 Mar 2025 09:00:00 +0000", "", ""),
+			},
+			want: "- \n  Weekly report 1\n  weekly report 2\n",
+		},
+		{
+			name: "Loops are broken",
+			emails: []Email{
+				threadMessage("p", "Loop", "", "<q>", ""),
+				threadMessage("q", "Loop", "", "<p>", ""),
+				threadMessage("s", "Self", "", "<s>", "<s>"),
+			},
+			// The link p made first stands.
+			want: "Loop q\n  Loop p\nSelf s\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := threadShape(BuildThreads(tt.emails)); got != tt.want {
+				t.Errorf("BuildThreads()\n got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildThreadsWithoutMessageIDs(t *testing.T) {
+	first := threadMessage("", "Ticket", "", "", "")
+	first.UID, first.Mailbox = 7, "Support"
+	second := threadMessage("", "Re: Ticket", "", "", "")
+	second.UID, second.Mailbox = 8, "Support"
+	duplicate := threadMessage("a", "Copy", "", "", "")
+
+	threads := BuildThreads([]Email{first, second, duplicate, duplicate})
+	if got, want := threadShape(threads), "Ticket Support/7\n  Re: Ticket Support/8\n- \n  Copy a\n  Copy a\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	emails := []Email{first}
+	threads = BuildThreads(emails)
+	threads[0].Email.Subject = "changed"
+	if emails[0].Subject != "Ticket" {
+		t.Error("BuildThreads should copy the emails")
+	}
+}
+
+func TestThreadSubject(t *testing.T) {
+	tests := map[string]string{
+		"Re: Hello":              "Hello",
+		"RE: Fwd: re:  Hello":    "Hello",
+		"Re[3]: Hello":           "Hello",
+		"SV: Hej":                "Hej",
+		"Re：Hello":               "Hello",
+		"Regarding: the plan":    "Regarding: the plan",
+		"Re: ":                   "",
+		"[list] Re: Hello world": "[list] Re: Hello world",
+	}
+	for in, want := range tests {
+		if got := ThreadSubject(in); got != want {
+			t.Errorf("ThreadSubject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package gsmail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidTNEF is returned by DecodeTNEF for data that is not a TNEF
+// stream, or is one cut short.
+var ErrInvalidTNEF = errors.New("gsmail: invalid TNEF data")
+
+// tnefSignature opens every TNEF stream.
+const tnefSignature = 0x223E9F78
+
+// TNEF attribute IDs, without the type in their high 16 bits, as listed in
+// MS-OXTNEF section 2.1.3.
+const (
+	attBody           = 0x800C
+	attAttachData     = 0x800F
+	attAttachTitle    = 0x8010
+	attAttachRenddata = 0x9002
+	attMsgProps       = 0x9003
+	attAttachment     = 0x9005
+	attOemCodepage    = 0x9007
+)
+
+// MAPI property IDs read from the message and attachment property blocks.
+const (
+	prBody                = 0x1000
+	prRTFCompressed       = 0x1009
+	prBodyHTML            = 0x1013
+	prDisplayName         = 0x3001
+	prAttachDataBin       = 0x3701
+	prAttachFilename      = 0x3704
+	prAttachLongFilename  = 0x3707
+	prAttachMimeTag       = 0x370E
+	prAttachContentID     = 0x3712
+	prInternetCodepage    = 0x3FDE
+	mapiNamedPropertyBase = 0x8000
+)
+
+// MAPI property types whose values are variable-length.
+const (
+	ptObject  = 0x000D
+	ptString8 = 0x001E
+	ptUnicode = 0x001F
+	ptBinary  = 0x0102
+	mvFlag    = 0x1000
+)
+
+// IsTNEF reports whether a is a TNEF attachment: the winmail.dat Outlook
+// sends in place of the message when it is set to use Rich Text, which only
+// Outlook can read. It goes by the content, so a renamed or mislabelled one
+// is recognised too.
+func IsTNEF(a Attachment) bool {
+	return len(a.Data) >= 4 && binary.LittleEndian.Uint32(a.Data) == tnefSignature
+}
+
+// DecodeTNEF unpacks a TNEF stream into an Email holding what it carries:
+// the embedded files as Attachments, the plain text body as Body and the
+// HTML one as HTMLBody. The other fields are left empty; TNEF repeats the
+// headers of the message it came in, and those are already parsed.
+//
+// Outlook stores an HTML body either as HTML or as RTF that encapsulates it;
+// both come back as HTMLBody. A body written as Rich Text in the first place
+// has no HTML form and is returned as an attachment named body.rtf, so
+// nothing the sender wrote is lost. Attached Outlook items -- an embedded
+// message or OLE object -- have no file form and are left out.
+//
+// ParseOptions.DecodeTNEF applies this to every TNEF attachment of a parsed
+// message.
+func DecodeTNEF(data []byte) (Email, error) {
+	r := tnefReader{data: data}
+	if r.u32() != tnefSignature {
+		return Email{}, ErrInvalidTNEF
+	}
+	r.u16() // legacy attachment key
+
+	var (
+		email       Email
+		codepage    uint32 = 1252
+		bodyText    []byte
+		messageMAPI mapiProps
+		attachments []*tnefAttachment
+	)
+	current := func() *tnefAttachment {
+		if len(attachments) == 0 {
+			attachments = append(attachments, &tnefAttachment{})
+		}
+		return attachments[len(attachments)-1]
+	}
+
+	for r.err == nil && r.remaining() > 0 {
+		level := r.byte()
+		id := r.u32() & 0xFFFF
+		value := r.bytes(r.u32())
+		r.u16() // checksum
+		if r.err != nil {
+			return Email{}, ErrInvalidTNEF
+		}
+
+		switch {
+		case id == attOemCodepage && len(value) >= 4:
+			codepage = binary.LittleEndian.Uint32(value)
+		case id == attBody:
+			bodyText = value
+		case id == attMsgProps:
+			props, err := readMAPIProps(value)
+			if err != nil {
+				return Email{}, err
+			}
+			messageMAPI = props
+		case level == 2 && id == attAttachRenddata:
+			attachments = append(attachments, &tnefAttachment{})
+		case level == 2 && id == attAttachTitle:
+			current().title = value
+		case level == 2 && id == attAttachData:
+			current().data = value
+		case level == 2 && id == attAttachment:
+			props, err := readMAPIProps(value)
+			if err != nil {
+				return Email{}, err
+			}
+			current().props = props
+		}
+	}
+	if r.err != nil {
+		return Email{}, ErrInvalidTNEF
+	}
+
+	oem := codePageCharset(codepage)
+	for _, a := range attachments {
+		if att, ok := a.attachment(oem); ok {
+			email.Attachments = append(email.Attachments, att)
+		}
+	}
+
+	if text, ok := messageMAPI.text(prBody, oem); ok {
+		email.Body = text
+	} else if len(bodyText) > 0 {
+		email.Body = toUTF8(bytes.TrimRight(bodyText, "\x00"), oem)
+	}
+
+	if html, ok := messageMAPI[prBodyHTML]; ok {
+		email.HTMLBody = toUTF8(html.value, codePageCharset(messageMAPI.long(prInternetCodepage, 0)))
+	} else if compressed, ok := messageMAPI[prRTFCompressed]; ok {
+		rtf, err := decompressRTF(compressed.value)
+		if err != nil {
+			return Email{}, err
+		}
+		if html, ok := rtfToHTML(rtf); ok {
+			email.HTMLBody = html
+		} else if !bytes.Contains(rtf, []byte(`\fromtext`)) {
+			// RTF made from the plain text body adds nothing to it; RTF
+			// written as such is the body in its only complete form.
+			email.Attachments = append(email.Attachments, Attachment{
+				Filename:    "body.rtf",
+				ContentType: "application/rtf",
+				Data:        rtf,
+			})
+		}
+	}
+	return email, nil
+}
+
+// decodeTNEFAttachments replaces the TNEF attachments of email with what
+// they carry, keeping the originals too when keep is set. A body the message
+// already has is left alone: Outlook sends the plain text one as a MIME part
+// beside the winmail.dat. One that fails to decode stays as it came.
+func decodeTNEFAttachments(email *Email, keep bool) {
+	var attachments []Attachment
+	for _, a := range email.Attachments {
+		if !IsTNEF(a) {
+			attachments = append(attachments, a)
+			continue
+		}
+		inner, err := DecodeTNEF(a.Data)
+		if err != nil || keep {
+			attachments = append(attachments, a)
+		}
+		if err != nil {
+			continue
+		}
+		attachments = append(attachments, inner.Attachments...)
+		if len(email.Body) == 0 {
+			email.Body = inner.Body
+		}
+		if len(email.HTMLBody) == 0 {
+			email.HTMLBody = inner.HTMLBody
+		}
+	}
+	email.Attachments = attachments
+}
+
+// tnefAttachment collects the attributes of one attachment, which arrive
+// one at a time after the attAttachRenddata that starts it.
+type tnefAttachment struct {
+	title []byte
+	data  []byte
+	props mapiProps
+}
+
+func (a *tnefAttachment) attachment(oem string) (Attachment, bool) {
+	data := a.data
+	if data == nil {
+		bin, ok := a.props[prAttachDataBin]
+		if !ok || bin.typ != ptBinary {
+			return Attachment{}, false
+		}
+		data = bin.value
+	}
+
+	// The long filename is the one the sender saw; the title and
+	// PR_ATTACH_FILENAME may be its 8.3 form.
+	var filename string
+	for _, id := range []uint16{prAttachLongFilename, prDisplayName} {
+		if name, ok := a.props.text(id, oem); ok && len(name) > 0 {
+			filename = string(name)
+			break
+		}
+	}
+	if filename == "" && len(a.title) > 0 {
+		filename = string(toUTF8(bytes.TrimRight(a.title, "\x00"), oem))
+	}
+	if filename == "" {
+		if name, ok := a.props.text(prAttachFilename, oem); ok {
+			filename = string(name)
+		}
+	}
+	if filename == "" {
+		filename = "attachment"
+	}
+
+	var contentType string
+	if tag, ok := a.props.text(prAttachMimeTag, oem); ok {
+		contentType = string(tag)
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var contentID string
+	if cid, ok := a.props.text(prAttachContentID, oem); ok {
+		contentID = strings.Trim(string(cid), "<>")
+	}
+
+	return Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		ContentID:   contentID,
+		Data:        data,
+	}, true
+}
+
+// mapiProp is a single MAPI property; of a multi-valued one only the first
+// value is kept, since none of those read here are multi-valued.
+type mapiProp struct {
+	typ   uint16
+	value []byte
+}
+
+type mapiProps map[uint16]mapiProp
+
+// text returns a string property as UTF-8. PT_STRING8 values are in the
+// code page the stream declares.
+func (p mapiProps) text(id uint16, oem string) ([]byte, bool) {
+	prop, ok := p[id]
+	if !ok {
+		return nil, false
+	}
+	switch prop.typ {
+	case ptUnicode:
+		text := toUTF8(prop.value, "utf-16le")
+		return bytes.TrimRight(text, "\x00"), true
+	case ptString8:
+		return toUTF8(bytes.TrimRight(prop.value, "\x00"), oem), true
+	}
+	return nil, false
+}
+
+func (p mapiProps) long(id uint16, fallback uint32) uint32 {
+	if prop, ok := p[id]; ok && len(prop.value) >= 4 {
+		return binary.LittleEndian.Uint32(prop.value)
+	}
+	return fallback
+}
+
+// readMAPIProps reads the property block of attMsgProps or attAttachment
+// (MS-OXTNEF section 2.1.3.4). Named properties are skipped: their IDs are
+// only meaningful with the GUID and name they are mapped from, and none of
+// them matter for the content.
+func readMAPIProps(data []byte) (mapiProps, error) {
+	r := tnefReader{data: data}
+	count := r.u32()
+	if int64(count) > int64(r.remaining()/4) {
+		return nil, ErrInvalidTNEF
+	}
+	props := make(mapiProps, count)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		typ, id := r.u16(), r.u16()
+		if id >= mapiNamedPropertyBase {
+			r.bytes(16) // property set GUID
+			if r.u32() == 0 {
+				r.u32() // numeric name
+			} else {
+				r.padded(r.u32()) // string name
+			}
+		}
+
+		base := typ &^ mvFlag
+		variable := base == ptString8 || base == ptUnicode || base == ptBinary || base == ptObject
+		values := uint32(1)
+		if typ&mvFlag != 0 || variable {
+			values = r.u32()
+			if int64(values) > int64(r.remaining()/4)+1 {
+				return nil, ErrInvalidTNEF
+			}
+		}
+
+		var first []byte
+		for j := uint32(0); j < values && r.err == nil; j++ {
+			var value []byte
+			if variable {
+				value = r.padded(r.u32())
+			} else {
+				size := mapiFixedSize(base)
+				if size == 0 {
+					return nil, ErrInvalidTNEF
+				}
+				value = r.bytes(uint32(size))
+			}
+			if j == 0 {
+				first = value
+			}
+		}
+		if base == ptObject && len(first) >= 16 {
+			first = first[16:] // interface identifier
+		}
+		if id < mapiNamedPropertyBase {
+			props[id] = mapiProp{typ: base, value: first}
+		}
+	}
+	if r.err != nil {
+		return nil, ErrInvalidTNEF
+	}
+	return props, nil
+}
+
+// mapiFixedSize is the space a fixed-size MAPI value takes in a TNEF
+// property block, where values are padded to four bytes; zero for a type
+// it does not know, after which nothing more can be read.
+func mapiFixedSize(typ uint16) int {
+	switch typ {
+	case 0x0001, 0x0002, 0x0003, 0x0004, 0x000A, 0x000B:
+		// PT_NULL, PT_SHORT, PT_LONG, PT_FLOAT, PT_ERROR, PT_BOOLEAN
+		return 4
+	case 0x0005, 0x0006, 0x0007, 0x0014, 0x0040:
+		// PT_DOUBLE, PT_CURRENCY, PT_APPTIME, PT_I8, PT_SYSTIME
+		return 8
+	case 0x0048:
+		// PT_CLSID
+		return 16
+	}
+	return 0
+}
+
+// codePageCharset names the charset of a Windows code page, for toUTF8.
+func codePageCharset(cp uint32) string {
+	switch {
+	case cp == 65001:
+		return "utf-8"
+	case cp == 1200:
+		return "utf-16le"
+	case cp == 20127:
+		return "us-ascii"
+	case cp >= 28591 && cp <= 28606:
+		return "iso-8859-" + strconv.Itoa(int(cp-28590))
+	case cp == 50220 || cp == 50221 || cp == 50222:
+		return "iso-2022-jp"
+	case cp == 51932:
+		return "euc-jp"
+	case cp == 20866:
+		return "koi8-r"
+	case cp == 874, cp >= 1250 && cp <= 1258:
+		return "windows-" + strconv.Itoa(int(cp))
+	case cp == 0:
+		return ""
+	}
+	return "cp" + strconv.Itoa(int(cp))
+}
+
+// tnefReader reads little-endian values, remembering the first read past
+// the end instead of failing each one, so a parse checks once.
+type tnefReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tnefReader) remaining() int { return len(r.data) }
+
+func (r *tnefReader) bytes(n uint32) []byte {
+	if r.err != nil || uint64(n) > uint64(len(r.data)) {
+		r.err = ErrInvalidTNEF
+		r.data = nil
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// padded reads n bytes followed by the padding to a multiple of four.
+func (r *tnefReader) padded(n uint32) []byte {
+	b := r.bytes(n)
+	if pad := (4 - n%4) % 4; pad != 0 && len(r.data) >= int(pad) {
+		r.data = r.data[pad:]
+	}
+	return b
+}
+
+func (r *tnefReader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *tnefReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tnefReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
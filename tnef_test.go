@@ -0,0 +1,218 @@
+package gsmail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// tnefBuilder writes TNEF streams the way Outlook lays them out, so tests
+// can describe a winmail.dat by its attributes.
+type tnefBuilder struct{ bytes.Buffer }
+
+func newTNEF() *tnefBuilder {
+	b := &tnefBuilder{}
+	_ = binary.Write(b, binary.LittleEndian, uint32(tnefSignature))
+	_ = binary.Write(b, binary.LittleEndian, uint16(0x0001))
+	return b
+}
+
+func (b *tnefBuilder) attr(level byte, id uint32, data []byte) *tnefBuilder {
+	b.WriteByte(level)
+	_ = binary.Write(b, binary.LittleEndian, id)
+	_ = binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	var sum uint16
+	for _, c := range data {
+		sum += uint16(c)
+	}
+	_ = binary.Write(b, binary.LittleEndian, sum)
+	return b
+}
+
+// mapiBlock encodes a property block holding variable-length properties,
+// each given as its type, ID and value.
+func mapiBlock(props ...any) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(props)/3))
+	for i := 0; i < len(props); i += 3 {
+		typ, id, value := props[i].(uint16), props[i+1].(uint16), props[i+2].([]byte)
+		_ = binary.Write(&b, binary.LittleEndian, typ)
+		_ = binary.Write(&b, binary.LittleEndian, id)
+		_ = binary.Write(&b, binary.LittleEndian, uint32(1))
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(value)))
+		b.Write(value)
+		b.Write(make([]byte, (4-len(value)%4)%4))
+	}
+	return b.Bytes()
+}
+
+func utf16z(s string) []byte {
+	var b bytes.Buffer
+	for _, u := range utf16.Encode([]rune(s + "\x00")) {
+		_ = binary.Write(&b, binary.LittleEndian, u)
+	}
+	return b.Bytes()
+}
+
+// uncompressedRTF wraps rtf as PR_RTF_COMPRESSED of the stored kind.
+func uncompressedRTF(rtf string) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(rtf)+12))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(rtf)))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(rtfUncompressed))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString(rtf)
+	return b.Bytes()
+}
+
+// The example from MS-OXRTFCP section 4.1.
+func TestDecompressRTF(t *testing.T) {
+	compressed := []byte{
+		0x2d, 0x00, 0x00, 0x00, 0x2b, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5, 0xc7, 0xa7,
+		0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42, 0x32, 0x0a, 0xf3, 0x20,
+		0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0, 0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f,
+		0xa0,
+	}
+	got, err := decompressRTF(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := decompressRTF(compressed[:20]); err == nil {
+		t.Error("a truncated stream should be an error")
+	}
+}
+
+func TestRTFToHTML(t *testing.T) {
+	rtf := `{\rtf1\ansi\ansicpg1252\fromhtml1 \deff0{\fonttbl{\f0\fswiss Arial;}}` +
+		`{\*\htmltag19 <html>}{\*\htmltag34 <body>}` +
+		`\htmlrtf {\b \htmlrtf0 Caf\'e9 \u8364?{\*\htmltag84 &amp;} \{ok\}\htmlrtf }\htmlrtf0 ` +
+		`{\*\htmltag64 <br>}\par ` +
+		`{\*\htmltag35 </body>}{\*\htmltag27 </html>}}`
+	html, ok := rtfToHTML([]byte(rtf))
+	if !ok {
+		t.Fatal("encapsulated HTML not recognised")
+	}
+	if want := "<html><body>Café €&amp; {ok}<br>\r\n</body></html>"; string(html) != want {
+		t.Errorf("got %q, want %q", html, want)
+	}
+
+	if _, ok := rtfToHTML([]byte(`{\rtf1\ansi hello}`)); ok {
+		t.Error("plain RTF is not encapsulated HTML")
+	}
+}
+
+func TestDecodeTNEF(t *testing.T) {
+	rtf := `{\rtf1\ansi\fromhtml1 {\*\htmltag19 <p>}Hi{\*\htmltag27 </p>}}`
+	data := newTNEF().
+		attr(1, 0x00069007, []byte{0xE4, 0x04, 0, 0}). // code page 1252
+		attr(1, 0x0006800C, []byte("Hi\x00")).
+		attr(1, 0x00069003, mapiBlock(uint16(ptBinary), uint16(prRTFCompressed), uncompressedRTF(rtf))).
+		attr(2, 0x00069002, make([]byte, 14)).
+		attr(2, 0x00018010, []byte("REPORT~1.PDF\x00")).
+		attr(2, 0x0006800F, []byte("%PDF-1.4")).
+		attr(2, 0x00069005, mapiBlock(
+			uint16(ptUnicode), uint16(prAttachLongFilename), utf16z("Quarterly report.pdf"),
+		)).
+		attr(2, 0x00069002, make([]byte, 14)).
+		attr(2, 0x00018010, []byte("caf\xe9.txt\x00")).
+		attr(2, 0x0006800F, []byte("menu")).
+		Bytes()
+
+	email, err := DecodeTNEF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(email.Body) != "Hi" || string(email.HTMLBody) != "<p>Hi</p>" {
+		t.Errorf("Body %q, HTMLBody %q", email.Body, email.HTMLBody)
+	}
+	if len(email.Attachments) != 2 {
+		t.Fatalf("got %d attachments", len(email.Attachments))
+	}
+	if a := email.Attachments[0]; a.Filename != "Quarterly report.pdf" || a.ContentType != "application/pdf" || string(a.Data) != "%PDF-1.4" {
+		t.Errorf("first attachment %q %q %q", a.Filename, a.ContentType, a.Data)
+	}
+	// The title is in the stream's code page.
+	if a := email.Attachments[1]; a.Filename != "café.txt" || string(a.Data) != "menu" {
+		t.Errorf("second attachment %q %q", a.Filename, a.Data)
+	}
+
+	// A body written as Rich Text has no HTML form and is kept as a file.
+	plain := newTNEF().
+		attr(1, 0x00069003, mapiBlock(uint16(ptBinary), uint16(prRTFCompressed), uncompressedRTF(`{\rtf1\ansi {\b bold}}`))).
+		Bytes()
+	email, err = DecodeTNEF(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(email.HTMLBody) != 0 || len(email.Attachments) != 1 || email.Attachments[0].Filename != "body.rtf" {
+		t.Errorf("rich text body: HTMLBody %q, attachments %v", email.HTMLBody, email.Attachments)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("PK\x03\x04"), data[:len(data)-3]} {
+		if _, err := DecodeTNEF(bad); !errors.Is(err, ErrInvalidTNEF) {
+			t.Errorf("expected ErrInvalidTNEF, got %v", err)
+		}
+	}
+}
+
+func TestParseOptionsDecodeTNEF(t *testing.T) {
+	winmail := newTNEF().
+		attr(1, 0x00069003, mapiBlock(uint16(ptBinary), uint16(prBodyHTML), []byte("<p>Agenda</p>"))).
+		attr(2, 0x00069002, make([]byte, 14)).
+		attr(2, 0x00018010, []byte("agenda.docx\x00")).
+		attr(2, 0x0006800F, []byte("docx")).
+		Bytes()
+	raw := "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: Agenda\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Agenda\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/ms-tnef; name=winmail.dat\r\n" +
+		"Content-Disposition: attachment; filename=winmail.dat\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(winmail) + "\r\n" +
+		"--b--\r\n"
+
+	// Off by default: the attachment is what arrived.
+	email, err := ParseRawEmailWithOptions([]byte(raw), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Attachments) != 1 || !IsTNEF(email.Attachments[0]) || len(email.HTMLBody) != 0 {
+		t.Fatalf("parse without DecodeTNEF changed the message: %+v", email.Attachments)
+	}
+
+	email, err = ParseRawEmailWithOptions([]byte(raw), ParseOptions{DecodeTNEF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(email.Body)) != "Agenda" || string(email.HTMLBody) != "<p>Agenda</p>" {
+		t.Errorf("Body %q, HTMLBody %q", email.Body, email.HTMLBody)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "agenda.docx" {
+		t.Errorf("attachments %+v", email.Attachments)
+	}
+
+	email, err = ParseRawEmailWithOptions([]byte(raw), ParseOptions{DecodeTNEF: true, KeepTNEF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Attachments) != 2 || email.Attachments[0].Filename != "winmail.dat" || email.Attachments[1].Filename != "agenda.docx" {
+		t.Errorf("KeepTNEF attachments %+v", email.Attachments)
+	}
+}
@@ -70,18 +70,8 @@ func (e *Email) SetOneClickUnsubscribe(targets ...string) error {
 // HasOneClickUnsubscribe reports whether the message carries the complete
 // header pair. Either header alone does not satisfy RFC 8058.
 func (e *Email) HasOneClickUnsubscribe() bool {
-	if e.Headers == nil {
-		return false
-	}
-	var unsub, post string
-	for name, value := range e.Headers {
-		switch strings.ToLower(name) {
-		case "list-unsubscribe":
-			unsub = value
-		case "list-unsubscribe-post":
-			post = value
-		}
-	}
+	unsub := e.Header("List-Unsubscribe")
+	post := e.Header("List-Unsubscribe-Post")
 	return unsub != "" && strings.EqualFold(strings.TrimSpace(post), ListUnsubscribePostValue)
 }
 
@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
@@ -22,9 +24,11 @@ import (
 	"sync"
 	"time"
 	"unsafe"
+
+	"github.com/gsoultan/gsmail/internal/mimeentity"
 )
 
-var emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}$`)
+var emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.(?:[a-z]{2,}|xn--[a-z0-9\-]+)$`)
 
 const (
 	// maxBufferSize bounds which pooled buffers are worth keeping. It has to
@@ -308,6 +312,9 @@ func isDisposableDomain(domain string, set map[string]struct{}) bool {
 	if set == nil {
 		set = defaultDisposableDomains
 	}
+	if ascii, err := DomainToASCII(domain); err == nil {
+		domain = ascii
+	}
 	_, exists := set[strings.ToLower(domain)]
 	return exists
 }
@@ -325,11 +332,33 @@ func IsDisposableEmail(email string) bool {
 
 // IsValidEmail checks if the given string is a valid email address.
 // It uses a fast regex check and common sense length limits.
+//
+// An internationalized address is valid when its local part is made of
+// letters, marks and digits in any script besides the ASCII characters
+// accepted anyway, and its domain is valid once converted to ASCII: both
+// "用户@例子.广告" and "user@bücher.de" are.
 func IsValidEmail(email string) bool {
 	if len(email) < 3 || len(email) > 254 {
 		return false
 	}
-	return emailRegex.MatchString(strings.ToLower(email))
+	if isASCIIText(email) {
+		return emailRegex.MatchString(strings.ToLower(email))
+	}
+	i := strings.LastIndexByte(email, '@')
+	if i < 1 {
+		return false
+	}
+	local := email[:i]
+	domain, err := DomainToASCII(email[i+1:])
+	if err != nil {
+		return false
+	}
+	if isASCIIText(local) {
+		return emailRegex.MatchString(strings.ToLower(local + "@" + domain))
+	}
+	// The pattern only covers an ASCII local part, so a stand-in is checked
+	// against it with the domain.
+	return isValidLocalPart(local) && emailRegex.MatchString(strings.ToLower("x@"+domain))
 }
 
 // Validation errors. They are all non-retryable: retrying will not change the
@@ -391,9 +420,9 @@ type Validator struct {
 
 func (v *Validator) resolver() Resolver {
 	if v.Resolver != nil {
-		return v.Resolver
+		return asciiResolver{v.Resolver}
 	}
-	return net.DefaultResolver
+	return asciiResolver{net.DefaultResolver}
 }
 
 func (v *Validator) dialer() *net.Dialer {
@@ -480,10 +509,23 @@ func (v *Validator) probe(ctx context.Context, addr, email string) error {
 	}
 	defer client.Close()
 
+	rcpt, asciiErr := ASCIIAddress(email)
 	commands := []func() error{
 		func() error { return client.Hello(v.helo()) },
+		func() error {
+			// A non-ASCII local part can only be probed with SMTPUTF8,
+			// which client.Mail requests when the server offers it.
+			if asciiErr == nil {
+				return nil
+			}
+			if ok, _ := client.Extension("SMTPUTF8"); !ok {
+				return asciiErr
+			}
+			rcpt = email
+			return nil
+		},
 		func() error { return client.Mail(v.MailFrom) },
-		func() error { return client.Rcpt(email) },
+		func() error { return client.Rcpt(rcpt) },
 	}
 
 	for _, cmd := range commands {
@@ -509,8 +551,9 @@ func ParseRawEmail(raw []byte) (Email, error) {
 		return Email{}, fmt.Errorf("read message: %w", err)
 	}
 
-	dec := new(mime.WordDecoder)
-	subject, _ := dec.DecodeHeader(msg.Header.Get("Subject"))
+	// Header text is transcoded to UTF-8 first, whatever charset its
+	// encoded-words or raw bytes are in; see charset.go.
+	subject := headerText(msg.Header.Get("Subject"))
 
 	// Sanitise at the boundary. A malformed header can carry a bare CR --
 	// net/mail accepts "To:0\r0" -- and once that value is inside an Email it
@@ -519,9 +562,9 @@ func ParseRawEmail(raw []byte) (Email, error) {
 	// way out, but relying on every consumer to re-check is how one of them
 	// eventually does not.
 	email := Email{
-		From:    sanitizeHeaderValue(msg.Header.Get("From")),
+		From:    sanitizeHeaderValue(decodedAddress(msg.Header.Get("From"))),
 		Subject: sanitizeHeaderValue(subject),
-		ReplyTo: sanitizeHeaderValue(msg.Header.Get("Reply-To")),
+		ReplyTo: sanitizeHeaderValue(decodedAddress(msg.Header.Get("Reply-To"))),
 	}
 
 	if to := msg.Header.Get("To"); to != "" {
@@ -529,6 +572,8 @@ func ParseRawEmail(raw []byte) (Email, error) {
 	}
 
 	email.Headers = parsedHeaders(msg.Header)
+	email.parsedHeaders = maps.Clone(email.Headers)
+	email.HeaderFields = parsedHeaderFields(raw)
 
 	if cc := msg.Header.Get("Cc"); cc != "" {
 		email.Cc = parseAddressList(cc)
@@ -544,15 +589,61 @@ func ParseRawEmail(raw []byte) (Email, error) {
 		return parseFallbackBody(email, msg.Body), nil
 	}
 
-	if strings.HasPrefix(mediaType, "multipart/") {
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
 		err = parseMultipart(&email, msg.Body, params["boundary"], 0)
-	} else {
+	case isPKCS7Mime(mediaType):
+		// An encrypted or opaque-signed message is one binary entity. Read as
+		// a body it would be DER bytes presented as text; as an attachment it
+		// is what a mail client shows when it has no key to open it.
+		var data []byte
+		data, err = decodePart(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+		email.Attachments = append(email.Attachments, Attachment{
+			Filename:    "smime.p7m",
+			ContentType: contentType,
+			Data:        data,
+		})
+	case mediaType == "text/calendar":
+		var data []byte
+		data, err = decodePart(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+		if ev := receivedCalendar(data, email.From); err == nil && ev != nil {
+			email.Calendar = ev
+		} else {
+			email.Body = data
+		}
+	default:
 		email.Body, err = decodePart(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+		if strings.HasPrefix(mediaType, "text/") {
+			email.Body = toUTF8(email.Body, params["charset"])
+		}
 	}
 
+	// An invitation that arrived only as a file is still an invitation.
+	if err == nil && email.Calendar == nil {
+		email.Calendar = attachedCalendar(email.Attachments, email.From)
+	}
+	if email.Calendar != nil {
+		email.Attachments = withoutCalendarFiles(email.Attachments, email.Calendar)
+	}
+	// Likewise an AMP part that could not be sent again stays a file: the
+	// validator is stricter than some senders, and no HTML part to fall back
+	// to is something only a sender can fix.
+	if len(email.AMPBody) > 0 && CheckAMP(email) != nil {
+		email.Attachments = append(email.Attachments, Attachment{
+			ContentType: `text/x-amp-html; charset="UTF-8"`,
+			Data:        email.AMPBody,
+		})
+		email.AMPBody = nil
+	}
 	return email, err
 }
 
+// isPKCS7Mime reports whether a media type is an S/MIME envelope, in either
+// the registered spelling or the x- one older clients still send.
+func isPKCS7Mime(mediaType string) bool {
+	return mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime"
+}
+
 const (
 	// maxParsedHeaders bounds how many header fields are carried onto an
 	// Email. A legitimate message with a long Received chain rarely exceeds a
@@ -625,17 +716,17 @@ var traceHeaders = map[string]struct{}{
 
 // parseAddressList splits an address header into individual addresses.
 // It uses the RFC 5322 parser so that quoted display names containing commas
-// (for example `"Doe, John" <j@example.com>`) are not split apart.
+// (for example `"Doe, John" <j@example.com>`) are not split apart. A group
+// contributes its members, since each Email entry names a recipient.
 func parseAddressList(s string) []string {
-	if addrs, err := mail.ParseAddressList(s); err == nil {
+	s = eightBitText(s)
+	if addrs, err := ParseAddressList(s); err == nil {
 		out := make([]string, 0, len(addrs))
 		for _, a := range addrs {
-			formatted := a.Address
-			if a.Name != "" {
-				formatted = a.String()
-			}
-			if entry := usableAddress(formatted); entry != "" {
-				out = append(out, entry)
+			for _, m := range a.Mailboxes() {
+				if entry := usableAddress(m.String()); entry != "" {
+					out = append(out, entry)
+				}
 			}
 		}
 		return out
@@ -670,7 +761,8 @@ func usableAddress(s string) string {
 
 func parseFallbackBody(email Email, r io.Reader) Email {
 	body, _ := readLimited(r)
-	email.Body = body
+	// The Content-Type could not be read, so neither could its charset.
+	email.Body = toUTF8(body, "")
 	return email
 }
 
@@ -716,8 +808,8 @@ func parseMultipart(email *Email, r io.Reader, boundary string, depth int) error
 
 func processPart(email *Email, part *multipart.Part, depth int) error {
 	contentType := part.Header.Get("Content-Type")
-	mediaType, params, _ := mime.ParseMediaType(contentType)
-	disposition, dispParams, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	mediaType, params, _ := parseParams(contentType)
+	disposition, dispParams, _ := parseParams(part.Header.Get("Content-Disposition"))
 	contentID := strings.Trim(part.Header.Get("Content-ID"), "<>")
 
 	if strings.HasPrefix(mediaType, "multipart/") {
@@ -732,6 +824,16 @@ func processPart(email *Email, part *multipart.Part, depth int) error {
 	filename := dispParams["filename"]
 	isAttachment := disposition == "attachment" || disposition == "inline" || filename != ""
 
+	// The text/calendar alternative of an invitation is the event itself,
+	// not a file. One that does not parse, or that could not be rendered
+	// again, stays an attachment, so nothing is lost.
+	if mediaType == "text/calendar" && !isAttachment && email.Calendar == nil {
+		if ev := receivedCalendar(data, email.From); ev != nil {
+			email.Calendar = ev
+			return nil
+		}
+	}
+
 	if isAttachment {
 		if filename == "" {
 			filename = "attachment"
@@ -746,12 +848,17 @@ func processPart(email *Email, part *multipart.Part, depth int) error {
 	}
 
 	if mediaType == "text/plain" {
-		email.Body = data
+		email.Body = toUTF8(data, params["charset"])
 		return nil
 	}
 
 	if mediaType == "text/html" {
-		email.HTMLBody = data
+		email.HTMLBody = toUTF8(data, params["charset"])
+		return nil
+	}
+
+	if mediaType == "text/x-amp-html" {
+		email.AMPBody = toUTF8(data, params["charset"])
 		return nil
 	}
 
@@ -815,10 +922,18 @@ func messageIDDomain(from string) string {
 	if i < 0 || i == len(s)-1 {
 		return fallback
 	}
-	if !isSafeMessageIDDomain(s[i+1:]) {
+	domain := s[i+1:]
+	if !isASCIIText(domain) {
+		ascii, err := DomainToASCII(domain)
+		if err != nil {
+			return fallback
+		}
+		domain = ascii
+	}
+	if !isSafeMessageIDDomain(domain) {
 		return fallback
 	}
-	return s[i+1:]
+	return domain
 }
 
 // isAddrSeparator reports whether r cannot appear inside a bare addr-spec.
@@ -882,18 +997,16 @@ func FormatAddressList(addresses []string) []string {
 }
 
 // FormatAddress ensures an email address is properly formatted (e.g., quotes
-// names with special characters). It returns "" for an address containing CR
-// or LF, so that untrusted input cannot inject additional headers.
+// names with special characters), as Address.Encode does; a group stays a
+// group. It returns "" for an address containing CR or LF, so that untrusted
+// input cannot inject additional headers.
 func FormatAddress(s string) string {
 	if strings.ContainsAny(s, "\r\n") {
 		return ""
 	}
 	s = sanitizeHeaderValue(s)
-	if a, err := ParseEmailAddress(s); err == nil && a != nil {
-		if a.Name == "" {
-			return a.Address
-		}
-		return a.String()
+	if a, err := ParseAddress(s); err == nil {
+		return a.Encode()
 	}
 	return s
 }
@@ -903,9 +1016,10 @@ func FormatAddress(s string) string {
 var ErrIllegalAddress = errors.New("gsmail: address contains an illegal character")
 
 // ParseEmailAddress parses an email address that can be in the form of "Name <email@example.com>" or just "email@example.com".
+// It is ParseAddress for a single mailbox, in net/mail's type.
 //
 // An address carrying a control character is rejected outright. The lenient
-// fallback below exists for real headers that net/mail will not accept -- an
+// fallback exists for real headers that net/mail will not accept -- an
 // unquoted comma in a display name, say -- but it used to hand back whatever
 // sat between the angle brackets, so "<0\n0>" produced an address containing a
 // newline. Callers put that straight into a header or an SMTP command, and
@@ -915,30 +1029,11 @@ func ParseEmailAddress(s string) (*mail.Address, error) {
 	if s == "" {
 		return nil, nil
 	}
-	if strings.ContainsFunc(s, isIllegalHeaderRune) {
-		return nil, ErrIllegalAddress
-	}
-	a, err := mail.ParseAddress(s)
-	if err == nil {
-		return a, nil
-	}
-
-	// Try manual parsing if standard parser fails (e.g., name with comma not quoted)
-	s = strings.TrimSpace(s)
-	if strings.HasSuffix(s, ">") {
-		idx := strings.LastIndex(s, "<")
-		if idx >= 0 {
-			name := strings.TrimSpace(s[:idx])
-			email := strings.TrimSpace(s[idx+1 : len(s)-1])
-			// If name is quoted, unquote it so a.String() can quote it properly later
-			if name != "" && len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
-				name = name[1 : len(name)-1]
-			}
-			return &mail.Address{Name: name, Address: email}, nil
-		}
+	a, err := parseMailbox(s)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, err
+	return &mail.Address{Name: a.Name, Address: a.Address}, nil
 }
 
 // SanitizeHeaderValue strips every character that must not appear in an
@@ -1201,6 +1296,35 @@ func writeMIMEBase64(w io.Writer, b []byte) error {
 	return enc.Close()
 }
 
+// copyMIMEBase64 is writeMIMEBase64 for a stream. A read error is reported as
+// such rather than as a write failure, since it is the source that broke.
+func copyMIMEBase64(w io.Writer, r io.Reader) error {
+	wrapped := &base64MIMEWriter{w: w}
+	enc := base64.NewEncoder(base64.StdEncoding, wrapped)
+
+	bufPtr := getBuffer()
+	defer putBuffer(bufPtr)
+	buf := (*bufPtr)[:cap(*bufPtr)]
+
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err := enc.Write(buf[:n]); err != nil {
+				_ = enc.Close()
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			_ = enc.Close()
+			return fmt.Errorf("read attachment: %w", rerr)
+		}
+	}
+	return enc.Close()
+}
+
 // ErrConflictingContentType is returned when rendering a message and the caller has
 // already written a Content-Type header but the message needs a multipart
 // container. Emitting the body anyway would produce an unparseable message.
@@ -1233,6 +1357,112 @@ func WithMessage(email Email, fn func(msg []byte) error) error {
 	return fn(*bufPtr)
 }
 
+// RenderMessageTo renders email to w as it goes, with the same guarantees as
+// RenderMessage.
+//
+// Nothing is buffered: bodies and attachments are base64-encoded straight into
+// w, and an attachment with Open set is read from its stream. A 20 MB PDF
+// therefore costs a few kilobytes of encoder state rather than 20 MB for the
+// file plus 27 MB for its encoding. If w fails part-way the message written so
+// far is truncated, so w should be something that can be abandoned, such as
+// an SMTP DATA writer.
+func RenderMessageTo(w io.Writer, email Email) error {
+	return writeMessage(w, email, generatedFields{}, nil)
+}
+
+// PreparedMessage is an Email whose generated values -- Date, Message-ID and
+// the MIME boundaries -- have been fixed, so it renders to the same bytes every
+// time it is written.
+//
+// Rendering once and sending the bytes keeps those values stable across
+// retries, but only by holding the whole message. A PreparedMessage keeps them
+// stable while still streaming, which is what a retried send of a large
+// message needs: a receiver that accepted the first attempt and lost the
+// reply sees the same Message-ID again and can discard the duplicate.
+type PreparedMessage struct {
+	email Email
+	gen   generatedFields
+}
+
+// PrepareMessage fixes the generated values of email. The Email is held by
+// value, but its slices and maps are shared, so the caller must not modify
+// them while the PreparedMessage is in use.
+//
+// The header names are checked here rather than on first write. A streamed
+// render that fails has already started a DATA command, so anything that can
+// be refused before the connection is opened should be.
+func PrepareMessage(email Email) (*PreparedMessage, error) {
+	for name := range email.Headers {
+		if !isValidHeaderName(name) {
+			return nil, NonRetryable(fmt.Errorf("gsmail: invalid header name %q", name))
+		}
+	}
+	for _, f := range email.HeaderFields {
+		if !isValidHeaderName(f.Name) {
+			return nil, NonRetryable(fmt.Errorf("gsmail: invalid header name %q", f.Name))
+		}
+	}
+	if email.Calendar != nil && len(email.Raw) == 0 {
+		if err := email.Calendar.validate(email.From); err != nil {
+			return nil, err
+		}
+	}
+	if len(email.Raw) == 0 {
+		if err := CheckAMP(email); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	return &PreparedMessage{
+		email: email,
+		gen: generatedFields{
+			date:        now.Format(time.RFC1123Z),
+			stamp:       now,
+			messageID:   generateMessageID(email.From),
+			boundary:    randomBoundary(),
+			altBoundary: randomBoundary(),
+		},
+	}, nil
+}
+
+// Email returns the message the PreparedMessage was built from.
+func (m *PreparedMessage) Email() Email { return m.email }
+
+// WriteTo renders the message to w. It implements io.WriterTo.
+func (m *PreparedMessage) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := writeMessage(cw, m.email, m.gen, nil)
+	return cw.n, err
+}
+
+// countingWriter records how many bytes reached the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// generatedFields are the values a render invents rather than reads from the
+// Email. An empty field is generated afresh on each render.
+type generatedFields struct {
+	date        string
+	stamp       time.Time // DTSTAMP of a calendar event
+	messageID   string
+	boundary    string
+	altBoundary string
+}
+
+// randomBoundary returns a fresh MIME boundary of the form multipart.Writer
+// would choose for itself.
+func randomBoundary() string {
+	return multipart.NewWriter(io.Discard).Boundary()
+}
+
 // buildMessage builds the full RFC 5322 email message into the provided
 // buffer. See RenderMessage for the sanitisation guarantees.
 //
@@ -1241,24 +1471,49 @@ func WithMessage(email Email, fn func(msg []byte) error) error {
 // and WithMessage express the two things a caller actually wants -- a message
 // they keep, and a message they only read.
 func buildMessage(bufPtr *[]byte, email Email) error {
-	writer := newBufferWriter(bufPtr)
-	var werr error
-
 	// Whatever the caller already had in the buffer. RenderMessage and
 	// WithMessage both start from empty, so this is normally zero and every
-	// prefix check below short-circuits.
+	// prefix check short-circuits.
 	//
 	// writeHeader used to call HasHeader against the *whole growing buffer* on
 	// every single header, which made header emission quadratic: 64 custom
 	// headers cost 12x a bare message. Only the caller's prefix can hold a
 	// header we did not write ourselves, so that is all we scan; duplicates of
-	// our own output are tracked with the flags below.
-	prefixLen := len(*bufPtr)
-	hasPrefixHeader := func(key string) bool {
-		if prefixLen == 0 {
-			return false
+	// our own output are tracked in writeMessage.
+	var hasPrefixHeader func(key string) bool
+	if prefixLen := len(*bufPtr); prefixLen > 0 {
+		hasPrefixHeader = func(key string) bool {
+			return hasHeader((*bufPtr)[:prefixLen], key)
 		}
-		return hasHeader((*bufPtr)[:prefixLen], key)
+	}
+	return writeMessage(newBufferWriter(bufPtr), email, generatedFields{}, hasPrefixHeader)
+}
+
+// writeMessage renders email to writer. hasPrefixHeader, when non-nil, reports
+// headers the destination already carries, which are then not written again.
+func writeMessage(writer io.Writer, email Email, gen generatedFields, hasPrefixHeader func(key string) bool) error {
+	// A pre-rendered message is already final; re-rendering any part of it
+	// would break whatever signature it carries.
+	if len(email.Raw) > 0 {
+		_, err := writer.Write(email.Raw)
+		return err
+	}
+	email = WithPlainText(email)
+
+	// Rendered before the first header, so an invalid event fails the send
+	// instead of truncating a message already streaming.
+	calendar, err := calendarFor(email, gen.stamp)
+	if err != nil {
+		return err
+	}
+	if err := CheckAMP(email); err != nil {
+		return err
+	}
+
+	var werr error
+
+	if hasPrefixHeader == nil {
+		hasPrefixHeader = func(string) bool { return false }
 	}
 
 	write := func(s string) {
@@ -1304,38 +1559,44 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 	// emitted so the loop below does not append a second copy.
 	var wroteDate, wroteMessageID bool
 	if !hasPrefixHeader("Date") {
-		writeHeader("Date", time.Now().Format(time.RFC1123Z))
+		if gen.date == "" {
+			gen.date = time.Now().Format(time.RFC1123Z)
+		}
+		writeHeader("Date", gen.date)
 		wroteDate = true
 	}
 
 	if !hasPrefixHeader("Message-ID") {
-		writeHeader("Message-ID", generateMessageID(email.From))
+		if gen.messageID == "" {
+			gen.messageID = generateMessageID(email.From)
+		}
+		writeHeader("Message-ID", gen.messageID)
 		wroteMessageID = true
 	}
 
-	// Caller supplied headers (List-Unsubscribe, In-Reply-To, X-*, ...).
-	for _, name := range sortedHeaderNames(email.Headers) {
-		lower := strings.ToLower(name)
-		if _, reserved := reservedHeaders[lower]; reserved {
-			continue
-		}
-		if _, trace := traceHeaders[lower]; trace {
-			continue
-		}
+	// Caller supplied headers (List-Unsubscribe, In-Reply-To, X-*, ...),
+	// filtered and validated by the function the API-backed providers use, so
+	// every transport agrees on what is sent and on what is refused. An
+	// invalid name is permanent: it will not become legal on a retry.
+	fields, err := CustomHeaderFields(email)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		lower := strings.ToLower(f.Name)
 		if (lower == "date" && wroteDate) || (lower == "message-id" && wroteMessageID) {
 			continue
 		}
-		if !isValidHeaderName(name) {
-			// Permanent: the name will not become legal on a retry. Marked
-			// explicitly so the SMTP and SES paths agree with CustomHeaders,
-			// which the API-backed providers use.
-			return NonRetryable(fmt.Errorf("gsmail: invalid header name %q", name))
-		}
-		writeHeader(name, encodeHeader(email.Headers[name]))
+		writeHeader(f.Name, f.Value)
 	}
 
-	hasAttachments := len(email.Attachments) > 0
+	// An invitation is an alternative rendering of the message and is also
+	// attached, so it always makes a multipart/mixed with an alternative.
+	hasCalendar := calendar != nil
+	hasAttachments := len(email.Attachments) > 0 || hasCalendar
 	hasBothBodies := len(email.Body) > 0 && len(email.HTMLBody) > 0
+	hasAMP := len(email.AMPBody) > 0
+	hasAlternative := hasBothBodies || hasCalendar || hasAMP
 
 	// Determine the main body to use if only one is provided
 	mainBody := email.Body
@@ -1345,7 +1606,7 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 		isHTML = true
 	}
 
-	if !hasAttachments && !hasBothBodies {
+	if !hasAttachments && !hasAlternative {
 		// Simple message - use base64 encoding so Unicode (emoji, etc.) is preserved through transport and Outlook
 		if !hasPrefixHeader("Content-Type") {
 			if isHTML {
@@ -1379,6 +1640,11 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 	}
 
 	mw := multipart.NewWriter(writer)
+	if gen.boundary != "" {
+		if err := mw.SetBoundary(gen.boundary); err != nil {
+			return err
+		}
+	}
 	if hasAttachments {
 		writeHeader("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
 	} else {
@@ -1390,13 +1656,16 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 	}
 
 	// Write bodies
-	if hasBothBodies {
+	if hasAlternative {
 		amw := mw
 		if hasAttachments {
 			// multipart/alternative inside multipart/mixed
 			altHeader := make(textproto.MIMEHeader)
 			// We need a new boundary for the alternative part
-			altBoundary := multipart.NewWriter(io.Discard).Boundary()
+			altBoundary := gen.altBoundary
+			if altBoundary == "" {
+				altBoundary = randomBoundary()
+			}
 			altHeader.Set("Content-Type", "multipart/alternative; boundary="+altBoundary)
 			part, err := mw.CreatePart(altHeader)
 			if err != nil {
@@ -1408,11 +1677,38 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 			}
 		}
 
-		if err := writeBodyPart(amw, "text/plain", email.Body); err != nil {
-			return err
+		if hasBothBodies || hasAMP {
+			if len(email.Body) > 0 {
+				if err := writeBodyPart(amw, "text/plain", email.Body); err != nil {
+					return err
+				}
+			}
+			// Before the HTML rather than after it, although clients
+			// prefer the last alternative they understand: some that do
+			// not understand AMP still show the last part of the
+			// alternative, and Gmail finds the AMP part wherever it is.
+			if hasAMP {
+				if err := writeBodyPart(amw, "text/x-amp-html", email.AMPBody); err != nil {
+					return err
+				}
+			}
+			if err := writeBodyPart(amw, "text/html", email.HTMLBody); err != nil {
+				return err
+			}
+		} else if len(mainBody) > 0 {
+			contentType := "text/plain"
+			if isHTML {
+				contentType = "text/html"
+			}
+			if err := writeBodyPart(amw, contentType, mainBody); err != nil {
+				return err
+			}
 		}
-		if err := writeBodyPart(amw, "text/html", email.HTMLBody); err != nil {
-			return err
+		// Last, because clients prefer the last alternative they understand.
+		if hasCalendar {
+			if err := writeCalendarPart(amw, email.Calendar, calendar); err != nil {
+				return err
+			}
 		}
 
 		if hasAttachments {
@@ -1436,6 +1732,17 @@ func buildMessage(bufPtr *[]byte, email Email) error {
 			return err
 		}
 	}
+	if hasCalendar {
+		// The same event again as a file, for clients that do not read the
+		// alternative and for saving into another calendar.
+		if err := writeAttachmentPart(mw, Attachment{
+			Filename:    calendarFilename,
+			ContentType: "application/ics",
+			Data:        calendar,
+		}); err != nil {
+			return err
+		}
+	}
 
 	if err := mw.Close(); err != nil {
 		return err
@@ -1456,6 +1763,18 @@ func writeBodyPart(mw *multipart.Writer, mediaType string, body []byte) error {
 	return writeMIMEBase64(part, body)
 }
 
+func writeCalendarPart(mw *multipart.Writer, ev *CalendarEvent, ics []byte) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", calendarContentType(ev))
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return writeMIMEBase64(part, ics)
+}
+
 func writeAttachmentPart(mw *multipart.Writer, att Attachment) error {
 	header := make(textproto.MIMEHeader)
 
@@ -1464,6 +1783,9 @@ func writeAttachmentPart(mw *multipart.Writer, att Attachment) error {
 		contentType = "application/octet-stream"
 	}
 	header.Set("Content-Type", contentType)
+	if isMessageRFC822(contentType) {
+		return writeMessagePart(mw, header, att)
+	}
 	header.Set("Content-Transfer-Encoding", "base64")
 
 	kind := "attachment"
@@ -1478,6 +1800,27 @@ func writeAttachmentPart(mw *multipart.Writer, att Attachment) error {
 	}
 	header.Set("Content-Disposition", formatDisposition(kind, att.Filename))
 
+	// Open the stream before writing the part header, so a missing file does
+	// not leave a half-written part behind in a buffered render.
+	if att.Open != nil {
+		rc, err := att.Reader()
+		if err != nil {
+			// A file that is not there, or not readable, will not appear on
+			// a retry. Anything else is the opener's to classify.
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				return NonRetryable(err)
+			}
+			return err
+		}
+		defer rc.Close()
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		return copyMIMEBase64(part, rc)
+	}
+
 	part, err := mw.CreatePart(header)
 	if err != nil {
 		return err
@@ -1485,6 +1828,76 @@ func writeAttachmentPart(mw *multipart.Writer, att Attachment) error {
 	return writeMIMEBase64(part, att.Data)
 }
 
+func isMessageRFC822(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "message/rfc822"
+}
+
+// writeMessagePart attaches a whole message. RFC 2046 forbids base64 for
+// message/rfc822, and clients that honour it show a base64 message as an
+// opaque file instead of opening it, so the message is written as it is, with
+// its line endings made CRLF, and labelled 7bit or 8bit by what it contains.
+// A message that neither label covers -- a line longer than 998 bytes, a NUL
+// or a bare CR -- cannot be attached that way and is refused.
+func writeMessagePart(mw *multipart.Writer, header textproto.MIMEHeader, att Attachment) error {
+	data, err := att.Bytes()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			return NonRetryable(err)
+		}
+		return err
+	}
+	// Stored messages, in a Maildir or a .eml file, usually end lines in LF
+	// alone, which SMTP servers refuse in DATA.
+	data = mimeentity.Canonicalize(data)
+	encoding, err := messagePartEncoding(data)
+	if err != nil {
+		return NonRetryable(fmt.Errorf("gsmail: attachment %q: %w", att.Filename, err))
+	}
+	header.Set("Content-Transfer-Encoding", encoding)
+	header.Set("Content-Disposition", formatDisposition("attachment", att.Filename))
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		_, err = part.Write([]byte("\r\n"))
+	}
+	return err
+}
+
+// messagePartEncoding returns "7bit" or "8bit" for a message with CRLF line
+// endings, or why it is neither.
+func messagePartEncoding(data []byte) (string, error) {
+	encoding := "7bit"
+	for len(data) > 0 {
+		line := data
+		if i := bytes.Index(data, []byte("\r\n")); i >= 0 {
+			line, data = data[:i], data[i+2:]
+		} else {
+			data = nil
+		}
+		if len(line) > 998 {
+			return "", fmt.Errorf("message/rfc822 line of %d bytes exceeds 998", len(line))
+		}
+		for _, c := range line {
+			switch {
+			case c == 0:
+				return "", errors.New("message/rfc822 contains a NUL byte")
+			case c == '\r':
+				return "", errors.New("message/rfc822 contains a bare CR")
+			case c >= 0x80:
+				encoding = "8bit"
+			}
+		}
+	}
+	return encoding, nil
+}
+
 // sortedHeaderNames returns the map keys in a stable order so that rendering
 // the same Email twice produces byte-identical output (important for DKIM).
 func sortedHeaderNames(h map[string]string) []string {